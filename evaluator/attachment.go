@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/smugmug/godynamo/types/attributevalue"
+)
+
+// Kinds of attachment. Images may be shown to anyone who can see the
+// problem; the others are never served. Test inputs and support files are
+// copied next to the code in the sandbox. Expected outputs stay in the
+// evaluator, which compares them with what the program printed.
+const (
+	AttachmentImage          = "image"
+	AttachmentTestInput      = "test_input"
+	AttachmentExpectedOutput = "expected_output"
+	AttachmentSupportFile    = "support_file"
+)
+
+var (
+	attachmentKinds = []string{
+		AttachmentImage, AttachmentTestInput, AttachmentExpectedOutput, AttachmentSupportFile,
+	}
+
+	// Attachment names become filenames in the sandbox.
+	attachmentNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+	// Markdown refers to image attachments as ![alt](attachment:name).
+	attachmentReferenceRegexp = regexp.MustCompile(`\]\(attachment:([A-Za-z0-9_][A-Za-z0-9_.-]*)\)`)
+)
+
+// In a TOML Path is the file to upload, relative to the TOML. Once stored,
+// Key is its content-addressed key in the blob store.
+type attachment struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Path string `json:"path,omitempty"`
+	Key  string `json:"key,omitempty"`
+	Size int    `json:"size,omitempty"`
+}
+
+func (p *Problem) GetAttachment(name string) (attachment, bool) {
+	for _, a := range p.Attachments {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return attachment{}, false
+}
+
+// Drops every attachment but images, for responses to users.
+func (p *Problem) HideSandboxAttachments() {
+	images := make([]attachment, 0)
+	for _, a := range p.Attachments {
+		if a.Kind == AttachmentImage {
+			images = append(images, a)
+		}
+	}
+	p.Attachments = images
+}
+
+func (p *Problem) validateAttachments() error {
+	names := make(map[string]bool)
+	for _, a := range p.Attachments {
+		if !attachmentNameRegexp.MatchString(a.Name) || names[a.Name] {
+			return ProblemValidationError{p.Id, fmt.Sprintf("attachment names must be unique filenames, got '%s'", a.Name)}
+		}
+		if strings.HasPrefix(a.Name, stdioFilePrefix) {
+			return ProblemValidationError{p.Id, fmt.Sprintf("attachment names must not start with '%s', got '%s'", stdioFilePrefix, a.Name)}
+		}
+		names[a.Name] = true
+		if !containsString(attachmentKinds, a.Kind) {
+			return ProblemValidationError{p.Id, fmt.Sprintf("attachment '%s' has unknown kind '%s'", a.Name, a.Kind)}
+		}
+		if a.Path == "" && a.Key == "" {
+			return ProblemValidationError{p.Id, fmt.Sprintf("attachment '%s' needs a path or a key", a.Name)}
+		}
+	}
+	for _, test_case := range p.TestData {
+		references := []struct{ name, kind string }{
+			{test_case.InputFile, AttachmentTestInput},
+			{test_case.OutputFile, AttachmentExpectedOutput},
+		}
+		for _, reference := range references {
+			if reference.name == "" {
+				continue
+			}
+			if a, present := p.GetAttachment(reference.name); present == false || a.Kind != reference.kind {
+				return ProblemValidationError{p.Id, fmt.Sprintf("TestData '%s' refers to '%s', which is not a %s attachment",
+					test_case.Name, reference.name, reference.kind)}
+			}
+		}
+	}
+	return nil
+}
+
+// Uploads every attachment that still has a local Path to the blob store
+// and replaces the Path with its Key. Unchanged files get the same key, so
+// re-loading a problem doesn't duplicate them.
+func StoreAttachments(logger *log.Logger, problem *Problem) error {
+	logger.Printf("StoreAttachments entry. problem.Id: %s", problem.Id)
+	defer logger.Printf("StoreAttachments exit.")
+	for i, a := range problem.Attachments {
+		if a.Path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(a.Path)
+		if err != nil {
+			logger.Printf("failed to read attachment %s: %s", a.Name, err)
+			return err
+		}
+		key, err := StoreBlob(logger, data)
+		if err != nil {
+			return err
+		}
+		problem.Attachments[i].Key = key
+		problem.Attachments[i].Size = len(data)
+		problem.Attachments[i].Path = ""
+	}
+	return nil
+}
+
+// Stores data under its content-addressed key and returns the key.
+func StoreBlob(logger *log.Logger, data []byte) (string, error) {
+	key := "sha256/" + sha256Hex(data)
+	if err := blobStore.Put(key, data); err != nil {
+		logger.Printf("failed to put blob %s: %s", key, err)
+		return "", err
+	}
+	return key, nil
+}
+
+// Reads an attachment from its local Path, for problems that haven't been
+// stored yet, or from the blob store.
+func ReadAttachment(logger *log.Logger, a attachment) ([]byte, error) {
+	if a.Path != "" {
+		return ioutil.ReadFile(a.Path)
+	}
+	data, err := blobStore.Get(a.Key)
+	if err != nil {
+		logger.Printf("failed to get attachment %s with key %s: %s", a.Name, a.Key, err)
+	}
+	return data, err
+}
+
+// The files to copy into the sandbox next to the code: the test inputs and
+// support files, and for problems with TestData the files the stdio harness
+// reads.
+func SandboxFiles(logger *log.Logger, problem *Problem) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if len(problem.TestData) > 0 {
+		files = stdioSandboxFiles(problem)
+	}
+	for _, a := range problem.Attachments {
+		if a.Kind != AttachmentTestInput && a.Kind != AttachmentSupportFile {
+			continue
+		}
+		data, err := ReadAttachment(logger, a)
+		if err != nil {
+			return nil, err
+		}
+		files[a.Name] = data
+	}
+	return files, nil
+}
+
+// The files custom runs get: only support files, since test inputs are
+// hidden test data.
+func SupportFiles(logger *log.Logger, problem *Problem) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, a := range problem.Attachments {
+		if a.Kind != AttachmentSupportFile {
+			continue
+		}
+		data, err := ReadAttachment(logger, a)
+		if err != nil {
+			return nil, err
+		}
+		files[a.Name] = data
+	}
+	return files, nil
+}
+
+// Whether problem has test data users mustn't see: test input or expected
+// output attachments, or TestData cases that aren't samples. TestData is
+// stored with the unit test, so it is read from unit_test.
+func hasHiddenTestData(problem *Problem, unit_test *Problem) bool {
+	for _, a := range problem.Attachments {
+		if a.Kind == AttachmentTestInput || a.Kind == AttachmentExpectedOutput {
+			return true
+		}
+	}
+	for _, test_case := range unit_test.TestData {
+		if !test_case.Sample {
+			return true
+		}
+	}
+	return false
+}
+
+// Points attachment: references in markdown at the attachment endpoint.
+func resolveAttachmentReferences(problem_id string, markdown string) string {
+	return attachmentReferenceRegexp.ReplaceAllString(markdown,
+		fmt.Sprintf("](/evaluator/get_problem_attachment/%s/$1)", problem_id))
+}
+
+func attachmentsToAttributeValue(attachments []attachment) (*attributevalue.AttributeValue, error) {
+	av := attributevalue.NewAttributeValue()
+	for _, a := range attachments {
+		encoded, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		av.L = append(av.L, &attributevalue.AttributeValue{S: string(encoded)})
+	}
+	return av, nil
+}
+
+func attributeValueToAttachments(av *attributevalue.AttributeValue) ([]attachment, error) {
+	attachments := make([]attachment, 0, len(av.L))
+	for _, encoded := range av.L {
+		var a attachment
+		if err := json.Unmarshal([]byte(encoded.S), &a); err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
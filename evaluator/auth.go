@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	RoleRegular  = "regular"
+	RoleAuthor   = "author"
+	RoleReviewer = "reviewer"
+	RoleAdmin    = "admin"
+)
+
+// Shortest -session-secret-file accepted, in bytes.
+const minSessionSecretLength = 32
+
+var (
+	// Must use the same secret as the store in user_data, which sets the
+	// persona-session cookie on login. Both services sit behind the same
+	// domain. Set by loadSessionStore.
+	store *sessions.CookieStore
+)
+
+// Reads the secret that signs session cookies from filepath.
+func loadSessionStore(filepath string) error {
+	contents, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	secret := strings.TrimSpace(string(contents))
+	if len(secret) < minSessionSecretLength {
+		return fmt.Errorf("session secret in %s is shorter than %d bytes", filepath, minSessionSecretLength)
+	}
+	store = sessions.NewCookieStore([]byte(secret))
+	return nil
+}
+
+// Returns the user ID and role stored in the persona-session cookie. ok is
+// false if the user is not logged in.
+func getSessionUser(r *http.Request) (user_id string, role string, ok bool) {
+	session, _ := store.Get(r, "persona-session")
+	if user_id, ok = session.Values["user_id"].(string); !ok {
+		return
+	}
+	role, _ = session.Values["role"].(string)
+	return
+}
+
+func hasRole(role string, roles ...string) bool {
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes a 401 or 403 and returns false unless the request comes from a
+// logged in user with one of roles.
+func requireRole(w http.ResponseWriter, r *http.Request, response map[string]interface{}, roles ...string) (string, bool) {
+	user_id, role, ok := getSessionUser(r)
+	if !ok {
+		response["error"] = "user does not have a valid secure cookie set."
+		logger.Printf(response["error"].(string))
+		w.WriteHeader(401)
+		return "", false
+	}
+	if !hasRole(role, roles...) {
+		response["error"] = "user role '" + role + "' is not permitted."
+		logger.Printf("user %s: %s", user_id, response["error"])
+		w.WriteHeader(403)
+		return user_id, false
+	}
+	return user_id, true
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Problem attachments are too large for DynamoDB items, so they live in a
+// blob store and items only hold their keys.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+type BlobNotFoundError struct {
+	Key string
+}
+
+func (e BlobNotFoundError) Error() string {
+	return fmt.Sprintf("blob with key '%s' not found", e.Key)
+}
+
+// Set up in main from the -blob-store flags.
+var blobStore BlobStore
+
+func NewBlobStore(kind string, directory string, endpoint string, bucket string, region string) (BlobStore, error) {
+	switch kind {
+	case "local":
+		return &LocalBlobStore{Root: directory}, nil
+	case "s3":
+		if bucket == "" {
+			return nil, fmt.Errorf("the s3 blob store needs a bucket")
+		}
+		credentials := awsCredentialsFromEnvironment()
+		return &S3BlobStore{
+			Endpoint:        strings.TrimSuffix(endpoint, "/"),
+			Bucket:          bucket,
+			Region:          region,
+			AccessKeyId:     credentials.AccessKeyId,
+			SecretAccessKey: credentials.SecretAccessKey,
+			SessionToken:    credentials.SessionToken,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown blob store '%s'", kind)
+}
+
+// Stores each blob as a file under Root.
+type LocalBlobStore struct {
+	Root string
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	path := filepath.Join(s.Root, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(path, filepath.Clean(s.Root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key '%s'", key)
+	}
+	return path, nil
+}
+
+func (s *LocalBlobStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write then rename so that readers never see a partial blob.
+	f, err := ioutil.TempFile(filepath.Dir(path), ".blob")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *LocalBlobStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, BlobNotFoundError{key}
+	}
+	return data, err
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Talks to S3, or anything that speaks its API such as a local MinIO, with
+// path-style URLs and AWS Signature Version 4.
+type S3BlobStore struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func (s *S3BlobStore) Put(key string, data []byte) error {
+	resp, err := s.do("PUT", key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("S3 PUT %s returned HTTP %d: %s", key, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Get(key string) ([]byte, error) {
+	resp, err := s.do("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, BlobNotFoundError{key}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("S3 GET %s returned HTTP %d: %s", key, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+func (s *S3BlobStore) Delete(key string) error {
+	resp, err := s.do("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("S3 DELETE %s returned HTTP %d: %s", key, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) do(method string, key string, data []byte) (*http.Response, error) {
+	canonical_uri := "/" + s3URIEncode(s.Bucket) + "/" + s3URIEncode(key)
+	u, err := url.Parse(s.Endpoint + canonical_uri)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	signAWSv4(request, "s3", s.Region, awsCredentials{s.AccessKeyId, s.SecretAccessKey, s.SessionToken},
+		canonical_uri, data, time.Now().UTC())
+	client := &http.Client{Timeout: 60 * time.Second}
+	return client.Do(request)
+}
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Read from the environment, like the AWS CLI and SDKs do.
+func awsCredentialsFromEnvironment() awsCredentials {
+	return awsCredentials{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Signs a request to service, e.g. "s3" or "sqs", with AWS Signature
+// Version 4, see
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func signAWSv4(request *http.Request, service string, region string, credentials awsCredentials,
+	canonical_uri string, data []byte, now time.Time) {
+	amz_date := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload_hash := sha256Hex(data)
+
+	request.Header.Set("X-Amz-Date", amz_date)
+	request.Header.Set("X-Amz-Content-Sha256", payload_hash)
+	canonical_headers := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		request.URL.Host, payload_hash, amz_date)
+	signed_headers := "host;x-amz-content-sha256;x-amz-date"
+	if credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+		canonical_headers += fmt.Sprintf("x-amz-security-token:%s\n", credentials.SessionToken)
+		signed_headers += ";x-amz-security-token"
+	}
+	canonical_request := strings.Join([]string{
+		request.Method, canonical_uri, "", canonical_headers, signed_headers, payload_hash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amz_date, scope, sha256Hex([]byte(canonical_request)),
+	}, "\n")
+	signing_key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	signing_key = hmacSHA256(signing_key, region)
+	signing_key = hmacSHA256(signing_key, service)
+	signing_key = hmacSHA256(signing_key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signing_key, string_to_sign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyId, scope, signed_headers, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Percent-encodes everything but unreserved characters and '/', as S3
+// expects in the canonical URI.
+func s3URIEncode(s string) string {
+	var buffer bytes.Buffer
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			buffer.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buffer, "%%%02X", b)
+		}
+	}
+	return buffer.String()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Passed as the version to LRUCache.Get to accept an entry of any version.
+const anyVersion = -1
+
+// A fixed-size, least-recently-used cache safe for concurrent use. Each
+// entry carries the version of the problem it was read from, so callers that
+// know the current version can refuse older entries. Entries older than TTL
+// are only returned by GetStale; a TTL of zero never expires them.
+type LRUCache struct {
+	Name     string
+	Capacity int
+	TTL      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	version int
+	stored  time.Time
+}
+
+type CacheStats struct {
+	Name          string `json:"name"`
+	Size          int    `json:"size"`
+	Capacity      int    `json:"capacity"`
+	Hits          int64  `json:"hits"`
+	Misses        int64  `json:"misses"`
+	StaleHits     int64  `json:"stale_hits"`
+	Evictions     int64  `json:"evictions"`
+	Invalidations int64  `json:"invalidations"`
+}
+
+func NewLRUCache(name string, capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		Name:     name,
+		Capacity: capacity,
+		TTL:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Returns the entry for key if it is fresh and, unless version is
+// anyVersion, of that version.
+func (c *LRUCache) Get(key string, version int) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, present := c.entries[key]
+	if present == false {
+		c.stats.Misses += 1
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if (version != anyVersion && entry.version != version) || c.expired(entry) {
+		c.stats.Misses += 1
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits += 1
+	return entry.value, true
+}
+
+// Returns the entry for key however old it is, for when the database can't
+// be reached.
+func (c *LRUCache) GetStale(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, present := c.entries[key]
+	if present == false {
+		return nil, false
+	}
+	c.stats.StaleHits += 1
+	return element.Value.(*cacheEntry).value, true
+}
+
+// Stores value under key unless a newer version is already cached, evicting
+// the least recently used entry if the cache is full.
+func (c *LRUCache) Put(key string, value interface{}, version int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, present := c.entries[key]; present == true {
+		entry := element.Value.(*cacheEntry)
+		if entry.version > version {
+			return
+		}
+		entry.value = value
+		entry.version = version
+		entry.stored = time.Now()
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key, value, version, time.Now()})
+	for c.order.Len() > c.Capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions += 1
+	}
+}
+
+// Drops every entry whose key starts with prefix; an empty prefix drops
+// everything.
+func (c *LRUCache) InvalidatePrefix(prefix string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(element)
+			delete(c.entries, key)
+			c.stats.Invalidations += 1
+		}
+	}
+}
+
+func (c *LRUCache) Invalidate(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, present := c.entries[key]; present == true {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.stats.Invalidations += 1
+	}
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Name = c.Name
+	stats.Size = c.order.Len()
+	stats.Capacity = c.Capacity
+	return stats
+}
+
+func (c *LRUCache) expired(entry *cacheEntry) bool {
+	return c.TTL > 0 && time.Since(entry.stored) > c.TTL
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		stored  time.Duration
+		version int
+		get     int
+		found   bool
+	}{
+		{"same version", 0, 0, 3, 3, true},
+		{"any version", 0, 0, 3, anyVersion, true},
+		{"other version", 0, 0, 3, 4, false},
+		{"fresh", time.Minute, 30 * time.Second, 3, 3, true},
+		{"expired", time.Minute, 2 * time.Minute, 3, 3, false},
+		{"no TTL never expires", 0, 24 * time.Hour, 3, 3, true},
+	}
+	for _, test := range tests {
+		c := NewLRUCache("test", 10, test.ttl)
+		c.Put("a", "value", test.version)
+		c.entries["a"].Value.(*cacheEntry).stored = time.Now().Add(-test.stored)
+		value, found := c.Get("a", test.get)
+		if found != test.found {
+			t.Errorf("%s: found = %v, want %v", test.name, found, test.found)
+		}
+		if found && value != "value" {
+			t.Errorf("%s: value = %v, want value", test.name, value)
+		}
+		// Stale reads ignore version and TTL.
+		if _, found := c.GetStale("a"); !found {
+			t.Errorf("%s: GetStale found nothing", test.name)
+		}
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	tests := []struct {
+		name string
+		// Keys put, or read when prefixed with "get ", in order.
+		ops       []string
+		present   []string
+		absent    []string
+		evictions int64
+	}{
+		{"under capacity", []string{"a", "b"}, []string{"a", "b"}, nil, 0},
+		{"oldest evicted", []string{"a", "b", "c", "d"}, []string{"b", "c", "d"}, []string{"a"}, 1},
+		{"read keeps entry", []string{"a", "b", "c", "get a", "d"}, []string{"a", "c", "d"}, []string{"b"}, 1},
+		{"put again keeps entry", []string{"a", "b", "c", "a", "d"}, []string{"a", "c", "d"}, []string{"b"}, 1},
+	}
+	for _, test := range tests {
+		c := NewLRUCache("test", 3, 0)
+		for _, op := range test.ops {
+			if len(op) > 4 && op[:4] == "get " {
+				c.Get(op[4:], anyVersion)
+			} else {
+				c.Put(op, op, 1)
+			}
+		}
+		for _, key := range test.present {
+			if _, found := c.Get(key, anyVersion); !found {
+				t.Errorf("%s: %s was evicted", test.name, key)
+			}
+		}
+		for _, key := range test.absent {
+			if _, found := c.Get(key, anyVersion); found {
+				t.Errorf("%s: %s wasn't evicted", test.name, key)
+			}
+		}
+		if evictions := c.Stats().Evictions; evictions != test.evictions {
+			t.Errorf("%s: evictions = %d, want %d", test.name, evictions, test.evictions)
+		}
+	}
+}
+
+func TestLRUCachePutKeepsNewerVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   int
+		second  int
+		version int
+		value   string
+	}{
+		{"newer replaces", 1, 2, 2, "second"},
+		{"same replaces", 2, 2, 2, "second"},
+		{"older is ignored", 2, 1, 2, "first"},
+	}
+	for _, test := range tests {
+		c := NewLRUCache("test", 10, 0)
+		c.Put("a", "first", test.first)
+		c.Put("a", "second", test.second)
+		value, found := c.Get("a", test.version)
+		if !found || value != test.value {
+			t.Errorf("%s: Get = %v, %v, want %s", test.name, value, found, test.value)
+		}
+	}
+}
+
+func TestLRUCacheInvalidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		remaining  int
+		invalidate int64
+	}{
+		{"one problem", "two_sum#", 1, 2},
+		{"everything", "", 0, 3},
+		{"nothing matches", "missing#", 3, 0},
+	}
+	for _, test := range tests {
+		c := NewLRUCache("test", 10, 0)
+		c.Put("two_sum#python", 1, 1)
+		c.Put("two_sum#java", 2, 1)
+		c.Put("fizz_buzz#python", 3, 1)
+		c.InvalidatePrefix(test.prefix)
+		stats := c.Stats()
+		if stats.Size != test.remaining {
+			t.Errorf("%s: size = %d, want %d", test.name, stats.Size, test.remaining)
+		}
+		if stats.Invalidations != test.invalidate {
+			t.Errorf("%s: invalidations = %d, want %d", test.name, stats.Invalidations, test.invalidate)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/smugmug/godynamo/types/attributevalue"
+	"github.com/smugmug/godynamo/types/item"
+)
+
+const (
+	// Every listed problem_summary item carries catalogue = catalogueAll so
+	// that the whole catalogue can be read from a GSI in sorted order with a
+	// Query. Unlisted problems omit it and so drop out of the sparse GSIs.
+	catalogueAll = "all"
+
+	defaultCatalogueLimit = 20
+	maxCatalogueLimit     = 100
+	cataloguePageSize     = 50
+	// Pages of the GSI one request may read while looking for matches. If
+	// they run out the response has fewer problems and a cursor to go on.
+	catalogueMaxPages = 10
+)
+
+var (
+	InvalidCatalogueCursor = errors.New("Invalid catalogue cursor.")
+
+	// Sort order name to the GSI on problem_summary that provides it.
+	catalogueIndexes = map[string]catalogueIndex{
+		"newest":     {"catalogue-creation_date-index", "catalogue", "creation_date", false},
+		"title":      {"catalogue-title_sort-index", "catalogue", "title_sort", true},
+		"difficulty": {"catalogue-difficulty_rank-index", "catalogue", "difficulty_rank", true},
+		"acceptance": {"catalogue-acceptance_rate-index", "catalogue", "acceptance_rate", false},
+	}
+
+	// Used instead of the "newest" index when filtering by category, so we
+	// only read that category's partition. catalogue_category mirrors category
+	// but, like catalogue, is only set on listed problems.
+	categoryNewestIndex = catalogueIndex{"catalogue_category-creation_date-index", "catalogue_category", "creation_date", false}
+
+	difficultyRanks = map[string]int{
+		"easy":   1,
+		"medium": 2,
+		"hard":   3,
+	}
+)
+
+type catalogueIndex struct {
+	Name      string
+	HashKey   string
+	RangeKey  string
+	Ascending bool
+}
+
+// Filters, sort order and pagination for get_problem_summaries. Filters that
+// the chosen index can't serve are sent to DynamoDB as a FilterExpression,
+// except the title search, which looks at every translation and so is
+// applied to each page as it is read.
+type CatalogueQuery struct {
+	Category   string
+	Language   string
+	Difficulty string
+	Tags       []string
+	Title      string
+	Sort       string
+	Cursor     string
+	Limit      int
+}
+
+func ParseCatalogueQuery(values url.Values) (CatalogueQuery, error) {
+	cq := CatalogueQuery{
+		Category:   values.Get("category"),
+		Language:   values.Get("language"),
+		Difficulty: values.Get("difficulty"),
+		Tags:       values["tag"],
+		Title:      strings.ToLower(strings.TrimSpace(values.Get("q"))),
+		Sort:       values.Get("sort"),
+		Cursor:     values.Get("cursor"),
+		Limit:      defaultCatalogueLimit,
+	}
+	if cq.Sort == "" {
+		cq.Sort = "newest"
+	}
+	if _, present := catalogueIndexes[cq.Sort]; present == false {
+		return cq, fmt.Errorf("unknown sort '%s'", cq.Sort)
+	}
+	if cq.Difficulty != "" {
+		if _, present := difficultyRanks[cq.Difficulty]; present == false {
+			return cq, fmt.Errorf("unknown difficulty '%s'", cq.Difficulty)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return cq, fmt.Errorf("invalid limit '%s'", limit)
+		}
+		if value > maxCatalogueLimit {
+			value = maxCatalogueLimit
+		}
+		cq.Limit = value
+	}
+	return cq, nil
+}
+
+func (cq *CatalogueQuery) index() catalogueIndex {
+	if cq.Category != "" && cq.Sort == "newest" {
+		return categoryNewestIndex
+	}
+	return catalogueIndexes[cq.Sort]
+}
+
+func (cq *CatalogueQuery) hashValue() string {
+	if cq.index().HashKey == "catalogue_category" {
+		return cq.Category
+	}
+	return catalogueAll
+}
+
+// The FilterExpression for the filters the index doesn't serve, with its
+// attribute names and values, or "" if there are none.
+func (cq *CatalogueQuery) filterExpression() (string, map[string]string, attributevalue.AttributeValueMap) {
+	conditions := []string{}
+	names := make(map[string]string)
+	values := make(attributevalue.AttributeValueMap)
+	if cq.Category != "" && cq.index().HashKey != "catalogue_category" {
+		conditions = append(conditions, "#category = :category")
+		names["#category"] = "category"
+		values[":category"] = &attributevalue.AttributeValue{S: cq.Category}
+	}
+	if cq.Language != "" {
+		conditions = append(conditions, "contains(#supported_languages, :language)")
+		names["#supported_languages"] = "supported_languages"
+		values[":language"] = &attributevalue.AttributeValue{S: cq.Language}
+	}
+	if cq.Difficulty != "" {
+		conditions = append(conditions, "#difficulty = :difficulty")
+		names["#difficulty"] = "difficulty"
+		values[":difficulty"] = &attributevalue.AttributeValue{S: cq.Difficulty}
+	}
+	for i, tag := range cq.Tags {
+		conditions = append(conditions, fmt.Sprintf("contains(#tags, :tag%d)", i))
+		names["#tags"] = "tags"
+		values[fmt.Sprintf(":tag%d", i)] = &attributevalue.AttributeValue{S: tag}
+	}
+	return strings.Join(conditions, " AND "), names, values
+}
+
+func (cq *CatalogueQuery) Matches(problem *Problem) bool {
+	if cq.Category != "" && problem.Category != cq.Category {
+		return false
+	}
+	if cq.Language != "" && !containsString(problem.SupportedLanguages, cq.Language) {
+		return false
+	}
+	if cq.Difficulty != "" && problem.Difficulty != cq.Difficulty {
+		return false
+	}
+	for _, tag := range cq.Tags {
+		if !containsString(problem.Tags, tag) {
+			return false
+		}
+	}
+	if cq.Title != "" && !titleMatches(problem, cq.Title) {
+		return false
+	}
+	return true
+}
+
+// Matches the title in any locale, so a search in Spanish finds problems
+// whose own title is English.
+func titleMatches(problem *Problem, title string) bool {
+	if strings.Contains(strings.ToLower(problem.Title), title) {
+		return true
+	}
+	for _, t := range problem.Translations {
+		if strings.Contains(strings.ToLower(t.Title), title) {
+			return true
+		}
+	}
+	return false
+}
+
+// A cursor is the primary and index key of the last problem returned,
+// which is exactly what DynamoDB wants as ExclusiveStartKey for the next page.
+func encodeCatalogueCursor(i item.Item, index catalogueIndex) (string, error) {
+	key := make(map[string]*attributevalue.AttributeValue)
+	for _, name := range []string{"id", index.HashKey, index.RangeKey} {
+		value, present := i[name]
+		if present == false {
+			return "", fmt.Errorf("item is missing key attribute %s", name)
+		}
+		key[name] = value
+	}
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+func decodeCatalogueCursor(cursor string, index catalogueIndex) (attributevalue.AttributeValueMap, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, InvalidCatalogueCursor
+	}
+	key := make(attributevalue.AttributeValueMap)
+	if err := json.Unmarshal(decoded, &key); err != nil {
+		return nil, InvalidCatalogueCursor
+	}
+	for _, name := range []string{"id", index.HashKey, index.RangeKey} {
+		if _, present := key[name]; present == false {
+			return nil, InvalidCatalogueCursor
+		}
+	}
+	return key, nil
+}
+
+// Problems without a difficulty sort last.
+func difficultyRank(difficulty string) int {
+	rank, present := difficultyRanks[difficulty]
+	if present == false {
+		return len(difficultyRanks) + 1
+	}
+	return rank
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -11,7 +11,9 @@ import (
 	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 	"github.com/smugmug/godynamo/endpoints/list_tables"
 	"github.com/smugmug/godynamo/types/attributedefinition"
+	"github.com/smugmug/godynamo/types/globalsecondaryindex"
 	"github.com/smugmug/godynamo/types/keydefinition"
+	"github.com/smugmug/godynamo/types/projection"
 )
 
 func CreateTables() (err error) {
@@ -27,6 +29,10 @@ func CreateTables() (err error) {
 		log.Printf("could not create unit_test table: %s", err)
 		return
 	}
+	if err = createProblemHistoryTable("problem_history"); err != nil {
+		log.Printf("could not create problem_history table: %s", err)
+		return
+	}
 	return
 }
 
@@ -43,6 +49,10 @@ func DeleteTables() (err error) {
 		log.Printf("could not delete unit_test table: %s", err)
 		return
 	}
+	if err = deleteTable("problem_history"); err != nil {
+		log.Printf("could not delete problem_history table: %s", err)
+		return
+	}
 	return
 }
 
@@ -131,6 +141,31 @@ func createProblemSummaryTable(table_name string) (err error) {
 	create1.KeySchema = append(create1.KeySchema,
 		keydefinition.KeyDefinition{AttributeName: "id", KeyType: ep.HASH})
 
+	// GSIs backing catalogue queries, see catalogue.go.
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "catalogue", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "catalogue_category", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "creation_date", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "title_sort", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "difficulty_rank", AttributeType: ep.N},
+		attributedefinition.AttributeDefinition{AttributeName: "acceptance_rate", AttributeType: ep.N})
+	indexes := []catalogueIndex{categoryNewestIndex}
+	for _, sort := range []string{"newest", "title", "difficulty", "acceptance"} {
+		indexes = append(indexes, catalogueIndexes[sort])
+	}
+	for _, index := range indexes {
+		gsi := globalsecondaryindex.NewGlobalSecondaryIndex()
+		gsi.IndexName = index.Name
+		gsi.KeySchema = append(gsi.KeySchema,
+			keydefinition.KeyDefinition{AttributeName: index.HashKey, KeyType: ep.HASH})
+		gsi.KeySchema = append(gsi.KeySchema,
+			keydefinition.KeyDefinition{AttributeName: index.RangeKey, KeyType: ep.RANGE})
+		gsi.Projection.ProjectionType = projection.ALL
+		gsi.ProvisionedThroughput.ReadCapacityUnits = 5
+		gsi.ProvisionedThroughput.WriteCapacityUnits = 1
+		create1.GlobalSecondaryIndexes = append(create1.GlobalSecondaryIndexes, *gsi)
+	}
+
 	// Prepare JSON request
 	_, create_json_err := json.Marshal(create1)
 	if create_json_err != nil {
@@ -260,3 +295,60 @@ func createUnitTestTable(table_name string) (err error) {
 
 	return
 }
+
+func createProblemHistoryTable(table_name string) (err error) {
+	log.Printf("createProblemHistoryTable() entry.")
+	defer log.Printf("createProblemHistoryTable() exit.")
+
+	var (
+		code   int
+		body   []byte
+		exists bool
+	)
+
+	if exists, err = doesTableExist(table_name); err != nil {
+		log.Printf("unable to check for table existence")
+		return
+	}
+	if exists == true {
+		log.Printf("table %s already exists.", table_name)
+		return
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 5
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "id", AttributeType: ep.S})
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "version", AttributeType: ep.N})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "id", KeyType: ep.HASH})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "version", KeyType: ep.RANGE})
+
+	// Prepare JSON request
+	_, create_json_err := json.Marshal(create1)
+	if create_json_err != nil {
+		log.Printf("%v\n", create_json_err)
+		return err
+	}
+
+	// Execute JSON request
+	body, code, err = create1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		log.Printf("create failed %d %v %s\n", code, err, string(body))
+		return err
+	}
+
+	log.Printf("checking for ACTIVE status for table...")
+	_, poll_err := desc.PollTableStatus(table_name, desc.ACTIVE, 100)
+	if poll_err != nil {
+		log.Printf("poll1:%v\n", poll_err)
+		return err
+	}
+
+	return
+}
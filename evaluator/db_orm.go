@@ -10,53 +10,186 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	ep "github.com/smugmug/godynamo/endpoint"
 	get "github.com/smugmug/godynamo/endpoints/get_item"
 	put "github.com/smugmug/godynamo/endpoints/put_item"
+	"github.com/smugmug/godynamo/endpoints/query"
 	scan "github.com/smugmug/godynamo/endpoints/scan"
+	update "github.com/smugmug/godynamo/endpoints/update_item"
 	"github.com/smugmug/godynamo/types/attributevalue"
+	"github.com/smugmug/godynamo/types/condition"
+	"github.com/smugmug/godynamo/types/item"
 )
 
+// Reads the whole problem_summary table, following LastEvaluatedKey. Prefer
+// QueryProblemSummaries for anything user-facing.
 func GetProblemSummaries(logger *log.Logger) ([]Problem, error) {
 	logger.Printf("db_orm.GetProblemSummaries() entry.")
 	defer logger.Printf("db_orm.GetProblemSummaries() exit.")
 
-	s := scan.NewScan()
-	tablename := "problem_summary"
-	s.TableName = tablename
+	problems := make([]Problem, 0)
+	var exclusive_start_key attributevalue.AttributeValueMap
+	for {
+		s := scan.NewScan()
+		tablename := "problem_summary"
+		s.TableName = tablename
+		if exclusive_start_key != nil {
+			s.ExclusiveStartKey = exclusive_start_key
+		}
 
-	body, code, err := s.EndpointReq()
-	if err != nil || code != http.StatusOK {
-		logger.Printf("scan failed %d %v %s\n", code, err, body)
-		return nil, err
-	}
+		body, code, err := s.EndpointReq()
+		if err != nil || code != http.StatusOK {
+			logger.Printf("scan failed %d %v %s\n", code, err, body)
+			if err == nil {
+				err = fmt.Errorf("scan of problem_summary returned HTTP %d", code)
+			}
+			return nil, err
+		}
 
-	// Response is the full response from DynamoDB; Item and ConsumedCapacity
-	// DyanmoDB does e.g. {"S": "foobar"} for strings etc. in Item.
+		// Response is the full response from DynamoDB; Item and ConsumedCapacity
+		// DyanmoDB does e.g. {"S": "foobar"} for strings etc. in Item.
 
-	var resp scan.Response
-	um_err := json.Unmarshal([]byte(body), &resp)
-	if um_err != nil {
-		e := fmt.Sprintf("unmarshal Response: %v", um_err)
-		logger.Printf("%s\n", e)
-		return make([]Problem, 0), um_err
-	}
+		var resp scan.Response
+		um_err := json.Unmarshal([]byte(body), &resp)
+		if um_err != nil {
+			e := fmt.Sprintf("unmarshal Response: %v", um_err)
+			logger.Printf("%s\n", e)
+			return make([]Problem, 0), um_err
+		}
 
-	problems, err := ItemsToProblems(logger, resp.Items)
-	if err != nil {
-		logger.Printf("error while converting items to problems: %s", err)
-		return problems, err
+		page, err := ItemsToProblems(logger, resp.Items)
+		if err != nil {
+			logger.Printf("error while converting items to problems: %s", err)
+			return problems, err
+		}
+		problems = append(problems, page...)
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		exclusive_start_key = resp.LastEvaluatedKey
 	}
 
 	return problems, nil
 }
 
+// Returns one page of problem summaries matching cq, in the order of the
+// GSI for cq.Sort, and the cursor for the next page ("" if this was the
+// last page).
+func QueryProblemSummaries(logger *log.Logger, cq CatalogueQuery) ([]Problem, string, error) {
+	logger.Printf("db_orm.QueryProblemSummaries() entry. cq: %+v", cq)
+	defer logger.Printf("db_orm.QueryProblemSummaries() exit.")
+
+	var (
+		problems            = make([]Problem, 0)
+		index               = cq.index()
+		exclusive_start_key attributevalue.AttributeValueMap
+		err                 error
+	)
+	if cq.Cursor != "" {
+		if exclusive_start_key, err = decodeCatalogueCursor(cq.Cursor, index); err != nil {
+			return problems, "", err
+		}
+	}
+
+	filter_expression, filter_names, filter_values := cq.filterExpression()
+	for pages := 1; ; pages++ {
+		q := query.NewQuery()
+		q.TableName = "problem_summary"
+		q.IndexName = index.Name
+		q.Select = ep.SELECT_ALL
+		q.Limit = cataloguePageSize
+		ascending := index.Ascending
+		q.ScanIndexForward = &ascending
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: cq.hashValue()}
+		kc.ComparisonOperator = query.OP_EQ
+		q.KeyConditions[index.HashKey] = kc
+		if filter_expression != "" {
+			q.FilterExpression = filter_expression
+			q.ExpressionAttributeNames = filter_names
+			q.ExpressionAttributeValues = filter_values
+		}
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+
+		body, code, err := q.EndpointReq()
+		if err != nil || code != http.StatusOK {
+			logger.Printf("query failed %d %v %s\n", code, err, body)
+			if err == nil {
+				err = fmt.Errorf("query of %s returned HTTP %d", index.Name, code)
+			}
+			return problems, "", err
+		}
+		var resp query.Response
+		if um_err := json.Unmarshal([]byte(body), &resp); um_err != nil {
+			logger.Printf("unmarshal Response: %v", um_err)
+			return problems, "", um_err
+		}
+
+		for i, item := range resp.Items {
+			problem, err := ItemToProblem(logger, "", item)
+			if err != nil {
+				logger.Printf("error while converting item to problem: %s", err)
+				return problems, "", err
+			}
+			if !cq.Matches(&problem) {
+				continue
+			}
+			problems = append(problems, problem)
+			if len(problems) < cq.Limit {
+				continue
+			}
+			if i == len(resp.Items)-1 && len(resp.LastEvaluatedKey) == 0 {
+				return problems, "", nil
+			}
+			cursor, err := encodeCatalogueCursor(item, index)
+			if err != nil {
+				logger.Printf("failed to encode catalogue cursor: %s", err)
+				return problems, "", err
+			}
+			return problems, cursor, nil
+		}
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		if pages == catalogueMaxPages {
+			logger.Printf("read %d pages of %s, returning %d problems early", pages, index.Name, len(problems))
+			cursor, err := encodeCatalogueCursor(item.Item(resp.LastEvaluatedKey), index)
+			if err != nil {
+				logger.Printf("failed to encode catalogue cursor: %s", err)
+				return problems, "", err
+			}
+			return problems, cursor, nil
+		}
+		exclusive_start_key = resp.LastEvaluatedKey
+	}
+	return problems, "", nil
+}
+
 func GetProblemSummary(logger *log.Logger, problem_id string) (Problem, error) {
 	logger.Printf("db_orm.GetProblemSummary() entry. problem_id: %s", problem_id)
 	defer logger.Printf("db_orm.GetProblemSummary() exit.")
 	var problem Problem
 
+	i, err := getProblemSummaryItem(logger, problem_id)
+	if err != nil {
+		return problem, err
+	}
+	problem, err = ItemToProblem(logger, problem_id, i)
+	if err != nil {
+		logger.Printf("error while converting item to problem: %s", err)
+		return problem, err
+	}
+
+	return problem, nil
+}
+
+func getProblemSummaryItem(logger *log.Logger, problem_id string) (item.Item, error) {
 	get1 := get.NewGetItem()
 	get1.TableName = "problem_summary"
 	get1.Key["id"] = &attributevalue.AttributeValue{
@@ -64,7 +197,10 @@ func GetProblemSummary(logger *log.Logger, problem_id string) (Problem, error) {
 	body, code, err := get1.EndpointReq()
 	if err != nil || code != http.StatusOK {
 		logger.Printf("get failed %d %v %s\n", code, err, body)
-		return problem, err
+		if err == nil {
+			err = fmt.Errorf("get of problem_summary returned HTTP %d", code)
+		}
+		return nil, err
 	}
 
 	// Response is the full response from DynamoDB; Item and ConsumedCapacity
@@ -73,16 +209,161 @@ func GetProblemSummary(logger *log.Logger, problem_id string) (Problem, error) {
 	um_err := json.Unmarshal([]byte(body), resp)
 	if um_err != nil {
 		logger.Printf("failed to unmarshal DynamoDB response (%s): %s", resp, um_err)
-		return problem, um_err
+		return nil, um_err
+	}
+	return resp.Item, nil
+}
+
+// Sets the share of judged submissions to problem_id that were accepted, as
+// user_data counts them. It's only in problem_summary, and doesn't change
+// the problem's version.
+func UpdateProblemAcceptanceRate(logger *log.Logger, problem_id string, acceptance_rate float64) error {
+	logger.Printf("db_orm.UpdateProblemAcceptanceRate() entry. problem_id: %s, acceptance_rate: %f",
+		problem_id, acceptance_rate)
+	defer logger.Printf("db_orm.UpdateProblemAcceptanceRate() exit.")
+
+	update1 := update.NewUpdateItem()
+	update1.TableName = "problem_summary"
+	update1.Key["id"] = &attributevalue.AttributeValue{S: problem_id}
+	update1.ConditionExpression = "attribute_exists(id)"
+	update1.UpdateExpression = "SET acceptance_rate = :rate"
+	update1.ExpressionAttributeValues[":rate"] = &attributevalue.AttributeValue{
+		N: strconv.FormatFloat(acceptance_rate, 'f', -1, 64)}
+
+	body, code, err := update1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("update failed %d %v %s\n", code, err, body)
+		if strings.Contains(string(body), "ConditionalCheckFailedException") {
+			return ProblemNotFoundError{problem_id}
+		}
+		if err == nil {
+			err = fmt.Errorf("update of problem_summary returned HTTP %d", code)
+		}
+		return err
+	}
+	InvalidateProblem(logger, problem_id)
+	return nil
+}
+
+// Sets the attributes the catalogue GSIs are keyed on for every summary
+// missing them, i.e. summaries written before the catalogue existed. Run by
+// -backfill-catalogue after adding the GSIs to an existing table. Returns the
+// number of summaries updated.
+func BackfillCatalogue(logger *log.Logger) (int, error) {
+	logger.Printf("db_orm.BackfillCatalogue() entry.")
+	defer logger.Printf("db_orm.BackfillCatalogue() exit.")
+
+	updated := 0
+	var exclusive_start_key attributevalue.AttributeValueMap
+	for {
+		s := scan.NewScan()
+		s.TableName = "problem_summary"
+		if exclusive_start_key != nil {
+			s.ExclusiveStartKey = exclusive_start_key
+		}
+		body, code, err := s.EndpointReq()
+		if err != nil || code != http.StatusOK {
+			logger.Printf("scan failed %d %v %s\n", code, err, body)
+			if err == nil {
+				err = fmt.Errorf("scan of problem_summary returned HTTP %d", code)
+			}
+			return updated, err
+		}
+		var resp scan.Response
+		if um_err := json.Unmarshal([]byte(body), &resp); um_err != nil {
+			logger.Printf("unmarshal Response: %v", um_err)
+			return updated, um_err
+		}
+		for _, i := range resp.Items {
+			changed, err := backfillCatalogueItem(logger, i)
+			if err != nil {
+				return updated, err
+			}
+			if changed {
+				updated += 1
+			}
+		}
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		exclusive_start_key = resp.LastEvaluatedKey
 	}
+	return updated, nil
+}
 
-	problem, err = ItemToProblem(logger, problem_id, resp.Item)
+// Brings one summary's catalogue attributes in line with the rest of it, and
+// returns whether that needed an update. The update is conditional on the
+// version, so it never overwrites a newer put.
+func backfillCatalogueItem(logger *log.Logger, i item.Item) (bool, error) {
+	problem, err := ItemToProblem(logger, "", i)
 	if err != nil {
 		logger.Printf("error while converting item to problem: %s", err)
-		return problem, err
+		return false, err
+	}
+	want := map[string]*attributevalue.AttributeValue{
+		"title_sort":      {S: strings.ToLower(problem.Title)},
+		"difficulty_rank": {N: strconv.Itoa(difficultyRank(problem.Difficulty))},
+	}
+	if _, present := i["acceptance_rate"]; present == false {
+		want["acceptance_rate"] = &attributevalue.AttributeValue{N: "0"}
+	}
+	listed := problem.State == StatePublished
+	if listed {
+		want["catalogue"] = &attributevalue.AttributeValue{S: catalogueAll}
+		// Empty strings can't key a GSI.
+		if problem.Category != "" {
+			want["catalogue_category"] = &attributevalue.AttributeValue{S: problem.Category}
+		}
 	}
 
-	return problem, nil
+	update1 := update.NewUpdateItem()
+	update1.TableName = "problem_summary"
+	update1.Key["id"] = &attributevalue.AttributeValue{S: problem.Id}
+	update1.ConditionExpression = "#version = :version"
+	update1.ExpressionAttributeNames["#version"] = "version"
+	update1.ExpressionAttributeValues[":version"] = &attributevalue.AttributeValue{N: strconv.Itoa(problem.Version)}
+	sets := []string{}
+	removes := []string{}
+	for _, name := range []string{"title_sort", "difficulty_rank", "acceptance_rate", "catalogue", "catalogue_category"} {
+		value, wanted := want[name]
+		existing, present := i[name]
+		if wanted && present && existing.S == value.S && existing.N == value.N {
+			continue
+		}
+		if wanted {
+			sets = append(sets, fmt.Sprintf("#%s = :%s", name, name))
+			update1.ExpressionAttributeNames["#"+name] = name
+			update1.ExpressionAttributeValues[":"+name] = value
+		} else if present && !listed {
+			removes = append(removes, "#"+name)
+			update1.ExpressionAttributeNames["#"+name] = name
+		}
+	}
+	if len(sets) == 0 && len(removes) == 0 {
+		return false, nil
+	}
+	logger.Printf("backfilling catalogue attributes of %s: set %v, remove %v", problem.Id, sets, removes)
+	if len(sets) > 0 {
+		update1.UpdateExpression = "SET " + strings.Join(sets, ", ")
+	}
+	if len(removes) > 0 {
+		update1.UpdateExpression = strings.TrimSpace(update1.UpdateExpression + " REMOVE " + strings.Join(removes, ", "))
+	}
+	body, code, err := update1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("update failed %d %v %s\n", code, err, body)
+		if strings.Contains(string(body), "ConditionalCheckFailedException") {
+			// A newer version was put since it was read, and that put set
+			// the attributes.
+			return false, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("update of problem_summary returned HTTP %d", code)
+		}
+		return false, err
+	}
+	InvalidateProblem(logger, problem.Id)
+	return true, nil
 }
 
 func GetProblemDetails(logger *log.Logger, problem_id string, language string) (Problem, error) {
@@ -120,6 +401,64 @@ func GetProblemDetails(logger *log.Logger, problem_id string, language string) (
 
 }
 
+// Historical versions live in problem_history, keyed by <problem_id>#<language>
+// and version. An item there carries the description, initial code and unit
+// test exactly as they were when that version was loaded.
+func GetProblemDetailsAtVersion(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	logger.Printf("db_orm.GetProblemDetailsAtVersion() entry. problem_id: %s, language: %s, version: %d",
+		problem_id, language, version)
+	defer logger.Printf("db_orm.GetProblemDetailsAtVersion() exit.")
+
+	problem, err := getProblemHistory(logger, problem_id, language, version)
+	if err != nil {
+		return problem, err
+	}
+	// Never expose the unit test through problem details.
+	problem.UnitTest = nil
+	return problem, nil
+}
+
+func GetProblemUnitTestAtVersion(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	logger.Printf("db_orm.GetProblemUnitTestAtVersion() entry. problem_id: %s, language: %s, version: %d",
+		problem_id, language, version)
+	defer logger.Printf("db_orm.GetProblemUnitTestAtVersion() exit.")
+	return getProblemHistory(logger, problem_id, language, version)
+}
+
+func getProblemHistory(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	var problem Problem
+
+	get1 := get.NewGetItem()
+	get1.TableName = "problem_history"
+	id := fmt.Sprintf("%s#%s", problem_id, language)
+	get1.Key["id"] = &attributevalue.AttributeValue{
+		S: id}
+	get1.Key["version"] = &attributevalue.AttributeValue{
+		N: strconv.Itoa(version)}
+	body, code, err := get1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("get failed %d %v %s\n", code, err, body)
+		return problem, err
+	}
+
+	// Response is the full response from DynamoDB; Item and ConsumedCapacity
+	// DyanmoDB does e.g. {"S": "foobar"} for strings etc. in Item.
+	resp := get.NewResponse()
+	um_err := json.Unmarshal([]byte(body), resp)
+	if um_err != nil {
+		logger.Printf("failed to unmarshal DynamoDB response (%v): %s", resp, um_err)
+		return problem, um_err
+	}
+
+	problem, err = ItemToProblem(logger, id, resp.Item)
+	if err != nil {
+		logger.Printf("error while converting item to problem: %s", err)
+		return problem, err
+	}
+
+	return problem, nil
+}
+
 func GetProblemUnitTest(logger *log.Logger, problem_id string, language string) (Problem, error) {
 	logger.Printf("db_orm.GetProblemUnitTest() entry. problem_id: %s, language: %s", problem_id, language)
 	defer logger.Printf("db_orm.GetProblemUnitTest() exit.")
@@ -154,12 +493,107 @@ func GetProblemUnitTest(logger *log.Logger, problem_id string, language string)
 	return problem, nil
 }
 
+// Reassembles a complete Problem, as it would have been parsed from TOML,
+// from problem_summary, problem_details and unit_test. unit_test also holds
+// the TestData.
+func GetFullProblem(logger *log.Logger, problem_id string) (*Problem, error) {
+	logger.Printf("db_orm.GetFullProblem() entry. problem_id: %s", problem_id)
+	defer logger.Printf("db_orm.GetFullProblem() exit.")
+
+	problem, err := GetProblemSummary(logger, problem_id)
+	if err != nil {
+		return nil, err
+	}
+	problem.Description = make(map[string]description)
+	problem.InitialCode = make(map[string]initial_code)
+	problem.UnitTest = make(map[string]unit_test)
+	if problem.Translations == nil {
+		problem.Translations = make(map[string]translation)
+	}
+	for _, language := range problem.SupportedLanguages {
+		details, err := GetProblemDetails(logger, problem_id, language)
+		if err != nil {
+			logger.Printf("failed to get problem details for language %s: %s", language, err)
+			return nil, err
+		}
+		if value, present := details.Description[language]; present == true {
+			problem.Description[language] = value
+		}
+		if value, present := details.InitialCode[language]; present == true {
+			problem.InitialCode[language] = value
+		}
+		problem.Hints = details.Hints
+		for locale, details_translation := range details.Translations {
+			t := problem.Translations[locale]
+			t.Hints = details_translation.Hints
+			if value, present := details_translation.Description[language]; present == true {
+				if t.Description == nil {
+					t.Description = make(map[string]description)
+				}
+				t.Description[language] = value
+			}
+			problem.Translations[locale] = t
+		}
+		unit_test, err := GetProblemUnitTest(logger, problem_id, language)
+		if err != nil {
+			logger.Printf("failed to get unit test for language %s: %s", language, err)
+			return nil, err
+		}
+		if value, present := unit_test.UnitTest[language]; present == true {
+			problem.UnitTest[language] = value
+		}
+		if len(unit_test.TestData) > 0 {
+			problem.TestData = unit_test.TestData
+		}
+	}
+	return &problem, nil
+}
+
+// Validates a single problem the same way LoadProblems does and puts it.
+// The version must be newer than any existing version of the problem.
+func ValidateAndPutProblem(logger *log.Logger, problem *Problem) error {
+	logger.Printf("db_orm.ValidateAndPutProblem() entry. problem.Id: %s", problem.Id)
+	defer logger.Printf("db_orm.ValidateAndPutProblem() exit.")
+
+	existing_problems, err := GetProblemSummaries(logger)
+	if err != nil {
+		logger.Printf("problem getting existing problems: %s", err)
+		return err
+	}
+	known_ids := make(map[string]bool)
+	for _, existing_problem := range existing_problems {
+		known_ids[existing_problem.Id] = true
+		if existing_problem.Id == problem.Id && existing_problem.Version >= problem.Version {
+			return ProblemValidationError{problem.Id, fmt.Sprintf(
+				"version %d is not newer than existing version %d", problem.Version, existing_problem.Version)}
+		}
+	}
+	if err := ValidateProblems([]*Problem{problem}, known_ids); err != nil {
+		logger.Printf("problem failed validation: %s", err)
+		return err
+	}
+	return putProblem(logger, problem)
+}
+
 func LoadProblems(logger *log.Logger) error {
 	problems, err := ParseProblems()
 	if err != nil {
 		logger.Printf("problem parsing problems: %s", err)
 		return err
 	}
+	existing_problems, err := GetProblemSummaries(logger)
+	if err != nil {
+		logger.Printf("problem getting existing problems: %s", err)
+		return err
+	}
+	known_ids := make(map[string]bool)
+	for _, existing_problem := range existing_problems {
+		known_ids[existing_problem.Id] = true
+	}
+	if err = ValidateProblems(problems, known_ids); err != nil {
+		logger.Printf("problems failed validation: %s", err)
+		return err
+	}
 	if err = PutProblems(logger, problems); err != nil {
 		logger.Printf("error whilst putting problems: %s", err)
 		return err
@@ -179,7 +613,13 @@ func PutProblems(logger *log.Logger, problems []*Problem) error {
 func putProblem(logger *log.Logger, problem *Problem) error {
 	logger.Printf("db_orm.putProblem() entry. problem.Id: %s", problem.Id)
 	defer logger.Printf("db_orm.putProblem() exit.")
+	// Even a failed put may have written some tables.
+	defer InvalidateProblem(logger, problem.Id)
 
+	if err := StoreAttachments(logger, problem); err != nil {
+		logger.Printf("failed to store attachments: %s", err)
+		return err
+	}
 	if err := putProblemIntoProblemSummary(logger, problem, "problem_summary"); err != nil {
 		logger.Printf("failed to put problem into problem_summary: %s", err)
 		return err
@@ -192,6 +632,10 @@ func putProblem(logger *log.Logger, problem *Problem) error {
 		logger.Printf("failed to put problem into unit_test: %s", err)
 		return err
 	}
+	if err := putProblemIntoProblemHistory(logger, problem, "problem_history"); err != nil {
+		logger.Printf("failed to put problem into problem_history: %s", err)
+		return err
+	}
 	return nil
 }
 
@@ -215,17 +659,116 @@ func putProblemIntoProblemSummary(logger *log.Logger, problem *Problem, table_na
 	}
 	if same == true {
 		log.Printf("current problem is same version as existing problem, skip.")
-		return nil
+		// It may still predate the catalogue attributes.
+		existing_item, err := getProblemSummaryItem(logger, problem.Id)
+		if err != nil {
+			return err
+		}
+		_, err = backfillCatalogueItem(logger, existing_item)
+		return err
 	}
 	log.Printf("current problem is newer than existing problem, continue.")
 
+	// acceptance_rate is maintained from submissions and the review fields by
+	// the workflow, not from the TOML, so carry them over from the existing
+	// item. A TOML without a State keeps the existing one; new problems
+	// loaded from the repo are published, since the repo is reviewed.
+	if existing_problem, err := GetProblemSummary(logger, problem.Id); err == nil {
+		problem.AcceptanceRate = existing_problem.AcceptanceRate
+		problem.SubmittedBy = existing_problem.SubmittedBy
+		problem.Approvals = existing_problem.Approvals
+		if problem.State == "" {
+			problem.State = existing_problem.State
+		}
+	}
+	if problem.State == "" {
+		problem.State = StatePublished
+	}
+
 	put1 := put.NewPutItem()
 	put1.TableName = table_name
 
 	put1.Item["id"] = &attributevalue.AttributeValue{S: problem.Id}
 	put1.Item["version"] = &attributevalue.AttributeValue{N: strconv.Itoa(problem.Version)}
 	put1.Item["title"] = &attributevalue.AttributeValue{S: problem.Title}
+	put1.Item["title_sort"] = &attributevalue.AttributeValue{S: strings.ToLower(problem.Title)}
 	put1.Item["category"] = &attributevalue.AttributeValue{S: problem.Category}
+	put1.Item["state"] = &attributevalue.AttributeValue{S: problem.State}
+	put1.Item["locale"] = &attributevalue.AttributeValue{S: problem.GetLocale()}
+	// DynamoDB does not allow empty maps.
+	if title_translations := titleTranslationsToAttributeValue(problem.Translations); len(title_translations.M) > 0 {
+		put1.Item["title_translations"] = title_translations
+	}
+	if problem.State == StatePublished {
+		put1.Item["catalogue"] = &attributevalue.AttributeValue{S: catalogueAll}
+		// Empty strings can't key a GSI.
+		if problem.Category != "" {
+			put1.Item["catalogue_category"] = &attributevalue.AttributeValue{S: problem.Category}
+		}
+	}
+	if problem.SubmittedBy != "" {
+		put1.Item["submitted_by"] = &attributevalue.AttributeValue{S: problem.SubmittedBy}
+	}
+	if len(problem.Approvals) > 0 {
+		approvals, err := approvalsToAttributeValue(problem.Approvals)
+		if err != nil {
+			log.Printf("failed to serialize approvals: %s", err)
+			return err
+		}
+		put1.Item["approvals"] = approvals
+	}
+	if len(problem.Attachments) > 0 {
+		attachments, err := attachmentsToAttributeValue(problem.Attachments)
+		if err != nil {
+			log.Printf("failed to serialize attachments: %s", err)
+			return err
+		}
+		put1.Item["attachments"] = attachments
+	}
+	if problem.Difficulty != "" {
+		put1.Item["difficulty"] = &attributevalue.AttributeValue{S: problem.Difficulty}
+	}
+	put1.Item["difficulty_rank"] = &attributevalue.AttributeValue{N: strconv.Itoa(difficultyRank(problem.Difficulty))}
+	// DynamoDB does not allow empty sets.
+	if len(problem.Tags) > 0 {
+		tags := attributevalue.NewAttributeValue()
+		for _, tag := range problem.Tags {
+			tags.InsertSS(tag)
+		}
+		put1.Item["tags"] = tags
+	}
+	if problem.TimeLimitSeconds > 0 {
+		put1.Item["time_limit_seconds"] = &attributevalue.AttributeValue{N: strconv.FormatFloat(problem.TimeLimitSeconds, 'f', -1, 64)}
+	}
+	if problem.MemoryLimitMB > 0 {
+		put1.Item["memory_limit_mb"] = &attributevalue.AttributeValue{N: strconv.Itoa(problem.MemoryLimitMB)}
+	}
+	if problem.EstimatedMinutes > 0 {
+		put1.Item["estimated_minutes"] = &attributevalue.AttributeValue{N: strconv.Itoa(problem.EstimatedMinutes)}
+	}
+	if len(problem.Prerequisites) > 0 {
+		prerequisites := attributevalue.NewAttributeValue()
+		for _, id := range problem.Prerequisites {
+			prerequisites.InsertSS(id)
+		}
+		put1.Item["prerequisites"] = prerequisites
+	}
+	if len(problem.Related) > 0 {
+		related := attributevalue.NewAttributeValue()
+		for _, id := range problem.Related {
+			related.InsertSS(id)
+		}
+		put1.Item["related"] = related
+	}
+	if len(problem.Authors) > 0 {
+		authors := attributevalue.NewAttributeValue()
+		for _, author := range problem.Authors {
+			authors.L = append(authors.L, &attributevalue.AttributeValue{S: author})
+		}
+		put1.Item["authors"] = authors
+	}
+	put1.Item["acceptance_rate"] = &attributevalue.AttributeValue{
+		N: strconv.FormatFloat(problem.AcceptanceRate, 'f', -1, 64)}
 	av := attributevalue.NewAttributeValue()
 	for _, language := range problem.SupportedLanguages {
 		av.InsertSS(language)
@@ -290,6 +833,10 @@ func putProblemIntoProblemDetails(logger *log.Logger, problem *Problem, table_na
 		}
 		put1.Item["description"] = &attributevalue.AttributeValue{
 			B: compressed_description}
+		if err := putLocalisedStatement(logger, problem, language, put1.Item); err != nil {
+			log.Printf("failed to encode translations for language %s, problem.Id %s!", language, problem.Id)
+			continue
+		}
 		initial_code, present := problem.InitialCode[language]
 		if present == true {
 			compressed_initial_code, err := compressToBase64(logger, initial_code.Code)
@@ -354,6 +901,87 @@ func putProblemIntoUnitTest(logger *log.Logger, problem *Problem, table_name str
 			put1.Item["unit_test"] = &attributevalue.AttributeValue{
 				B: compressed_unit_test}
 		}
+		if len(problem.TestData) > 0 {
+			compressed_test_data, err := encodeTestData(logger, problem.TestData)
+			if err != nil {
+				logger.Printf("failed to encode test_data for problem.Id %s!", problem.Id)
+				return err
+			}
+			put1.Item["test_data"] = &attributevalue.AttributeValue{B: compressed_test_data}
+		}
+		body, code, err := put1.EndpointReq()
+		if err != nil || code != http.StatusOK {
+			logger.Printf("put failed %d %v %s\n", code, err, body)
+			return err
+		}
+	}
+	return nil
+}
+
+// Every version of a problem is kept in problem_history so that solutions
+// judged against an old unit test can be traced back to it. Re-putting the
+// same version simply overwrites the identical item.
+func putProblemIntoProblemHistory(logger *log.Logger, problem *Problem, table_name string) error {
+	logger.Printf("db_orm.putProblemIntoProblemHistory() entry. problem.Id: %s, "+
+		"problem.Version: %s, table_name: %s",
+		problem.Id, strconv.Itoa(problem.Version), table_name)
+	defer logger.Printf("putProblemIntoProblemHistory() exit.")
+
+	for _, language := range problem.SupportedLanguages {
+		logger.Printf("handling language: %s", language)
+
+		put1 := put.NewPutItem()
+		put1.TableName = table_name
+
+		put1.Item["id"] = &attributevalue.AttributeValue{
+			S: fmt.Sprintf("%s#%s", problem.Id, language)}
+		put1.Item["version"] = &attributevalue.AttributeValue{
+			N: strconv.Itoa(problem.Version)}
+		put1.Item["title"] = &attributevalue.AttributeValue{S: problem.Title}
+		put1.Item["category"] = &attributevalue.AttributeValue{S: problem.Category}
+		if problem.LastUpdatedDate != nil {
+			put1.Item["last_updated_date"] = &attributevalue.AttributeValue{
+				S: problem.LastUpdatedDate.Format(time.RFC3339)}
+		}
+		if description, present := problem.GetDescription(language); present == true {
+			compressed_description, err := compressToBase64(logger, description)
+			if err != nil {
+				logger.Printf("failed to compress description for language %s, problem.Id %s!", language, problem.Id)
+				return err
+			}
+			put1.Item["description"] = &attributevalue.AttributeValue{
+				B: compressed_description}
+		}
+		if err := putLocalisedStatement(logger, problem, language, put1.Item); err != nil {
+			logger.Printf("failed to encode translations for language %s, problem.Id %s!", language, problem.Id)
+			return err
+		}
+		if initial_code, present := problem.InitialCode[language]; present == true {
+			compressed_initial_code, err := compressToBase64(logger, initial_code.Code)
+			if err != nil {
+				logger.Printf("failed to compress initial_code for language %s, problem.Id %s!", language, problem.Id)
+				return err
+			}
+			put1.Item["initial_code"] = &attributevalue.AttributeValue{
+				B: compressed_initial_code}
+		}
+		if unit_test, present := problem.UnitTest[language]; present == true {
+			compressed_unit_test, err := compressToBase64(logger, unit_test.Code)
+			if err != nil {
+				logger.Printf("failed to compress unit_test for language %s, problem.Id %s!", language, problem.Id)
+				return err
+			}
+			put1.Item["unit_test"] = &attributevalue.AttributeValue{
+				B: compressed_unit_test}
+		}
+		if len(problem.TestData) > 0 {
+			compressed_test_data, err := encodeTestData(logger, problem.TestData)
+			if err != nil {
+				logger.Printf("failed to encode test_data for problem.Id %s!", problem.Id)
+				return err
+			}
+			put1.Item["test_data"] = &attributevalue.AttributeValue{B: compressed_test_data}
+		}
 		body, code, err := put1.EndpointReq()
 		if err != nil || code != http.StatusOK {
 			logger.Printf("put failed %d %v %s\n", code, err, body)
@@ -363,6 +991,27 @@ func putProblemIntoUnitTest(logger *log.Logger, problem *Problem, table_name str
 	return nil
 }
 
+// Adds the locale, hints and translations for language to a problem_details
+// or problem_history item.
+func putLocalisedStatement(logger *log.Logger, problem *Problem, language string, put_item item.Item) error {
+	put_item["locale"] = &attributevalue.AttributeValue{S: problem.GetLocale()}
+	if len(problem.Hints) > 0 {
+		hints := attributevalue.NewAttributeValue()
+		for _, hint := range problem.Hints {
+			hints.L = append(hints.L, &attributevalue.AttributeValue{S: hint})
+		}
+		put_item["hints"] = hints
+	}
+	if len(problem.Translations) > 0 {
+		compressed_translations, err := encodeTranslations(logger, problem, language)
+		if err != nil {
+			return err
+		}
+		put_item["translations"] = &attributevalue.AttributeValue{B: compressed_translations}
+	}
+	return nil
+}
+
 func isProblemNewer(problem *Problem, id string, table_name string) (bool, error) {
 	log.Printf("db_orm.isProblemNewer entry. problem.Id: %s, id: %s, problem.Version: %s, "+
 		"table_name: %s", problem.Id, id, strconv.Itoa(problem.Version), table_name)
@@ -8,6 +8,9 @@ curl -X OPTIONS -H "Content-Type: application/json" --compressed \
 curl -X GET -H "Content-Type: application/json" --compressed \
     http://localhost:8081/evaluator/get_problem_summaries
 
+curl -X GET -H "Content-Type: application/json" --compressed \
+    "http://localhost:8081/evaluator/get_problem_summaries?language=python&tag=strings&sort=title&limit=10"
+
 curl -X GET -H "Content-Type: application/json" --compressed \
     http://localhost:8081/evaluator/get_problem_summary/fizz_buzz
 
@@ -18,6 +21,13 @@ curl -X GET -H "Content-Type: application/json" --compressed \
 curl -X GET -H "Content-Type: application/json" --compressed \
     http://localhost:8081/evaluator/get_problem_details/fizz_buzz/python
 
+curl -X GET -H "Content-Type: application/json" --compressed \
+    http://localhost:8081/evaluator/get_problem_details/fizz_buzz/python?version=27
+
+// Admin/author only; needs the persona-session cookie from user_data.
+curl -X POST -H "Content-Type: application/toml" --compressed -b cookies.txt \
+    --data-binary @problems/fizz_buzz.toml http://localhost:8081/evaluator/admin/problems
+
 curl -X OPTIONS -H "Content-Type: application/json" --compressed \
     --data-binary @foo.py http://localhost:8081/evaluator/evaluate/fizz_buzz/python
 
@@ -32,6 +42,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -39,13 +50,46 @@ import (
 )
 
 var (
-	logger               = getLogger("logger")
-	letters              = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
-	usage                = "CRUD for problems, evaluate problem solutions with unit tests."
-	loadProblems         = flag.Bool("load-problems", false, "Load problems to DynamoDB.")
-	recreateTables       = flag.Bool("recreate-tables", false, "Delete then create tables in DynamoDB")
-	checkProblemFilepath = flag.String("check-problem-filepath", "", "Check problem at specific filepath")
-	checkProblemLanguage = flag.String("check-problem-language", "", "Check problem using specific language")
+	logger                  = getLogger("logger")
+	letters                 = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+	usage                   = "CRUD for problems, evaluate problem solutions with unit tests."
+	loadProblems            = flag.Bool("load-problems", false, "Load problems to DynamoDB.")
+	recreateTables          = flag.Bool("recreate-tables", false, "Delete then create tables in DynamoDB")
+	sessionSecretFile       = flag.String("session-secret-file", "/etc/session-secret", "File with the secret that signs session cookies; user_data must use the same one")
+	backfillCatalogue       = flag.Bool("backfill-catalogue", false, "Set the catalogue attributes on problem summaries written before the catalogue GSIs")
+	checkProblemFilepath    = flag.String("check-problem-filepath", "", "Check problem at specific filepath")
+	checkProblemLanguage    = flag.String("check-problem-language", "", "Check problem using specific language")
+	importPackage           = flag.String("import-package", "", "Import the Kattis problem package at this directory into ./problems")
+	exportPackage           = flag.String("export-package", "", "Export the problem TOML at this filepath as a Kattis problem package")
+	packageDirectory        = flag.String("package-directory", "", "Directory to export a Kattis problem package into, by default the problem id")
+	blobStoreKind           = flag.String("blob-store", "local", "Where problem attachments are stored: local or s3")
+	blobStoreDirectory      = flag.String("blob-store-directory", "./blobs", "Directory of the local blob store")
+	s3Endpoint              = flag.String("s3-endpoint", "https://s3.amazonaws.com", "S3 endpoint of the s3 blob store, e.g. http://localhost:9000 for MinIO")
+	s3Bucket                = flag.String("s3-bucket", "", "Bucket of the s3 blob store")
+	s3Region                = flag.String("s3-region", "us-east-1", "Region of the s3 blob store")
+	cacheSize               = flag.Int("cache-size", 1000, "Entries in each in-memory problem cache")
+	cacheTTL                = flag.Duration("cache-ttl", time.Minute, "How long cached problem summaries and catalogue pages are used before DynamoDB is read again")
+	runners                 = flag.String("runners", "http://backend.runsomecode.com", "Comma-separated base URLs of the runner backends")
+	runnerTimeout           = flag.Duration("runner-timeout", time.Minute, "Deadline of one request to a runner, including its queue")
+	runnerDeadline          = flag.Duration("runner-deadline", 2*time.Minute, "Deadline of a run across all retries")
+	runnerHealthInterval    = flag.Duration("runner-health-interval", 10*time.Second, "How often runner backends are pinged")
+	runnerRegistryToken     = flag.String("runner-registry-token", "", "Shared secret runners send to register themselves; registration is off without one")
+	runnerHeartbeatInterval = flag.Duration("runner-heartbeat-interval", 10*time.Second, "How often registered runners are told to send heartbeats")
+	runnerEvictionTimeout   = flag.Duration("runner-eviction-timeout", 45*time.Second, "How long a registered runner may go without a heartbeat before it is evicted")
+	dispatch                = flag.String("dispatch", "push", "How runs reach runners: push, posting to them, or queue, putting them on a job queue runners pull from")
+	jobQueueKind            = flag.String("job-queue", "memory", "Job queue for -dispatch queue: memory or sqs")
+	sqsQueueURLPrefix       = flag.String("sqs-queue-url-prefix", "", "Prefix of the sqs job queue URLs, e.g. http://localhost:9324/000000000000/evaluator-jobs- for ElasticMQ")
+	sqsRegion               = flag.String("sqs-region", "us-east-1", "Region of the sqs job queue")
+	jobVisibilityTimeout    = flag.Duration("job-visibility-timeout", 90*time.Second, "How long a runner has to finish a job before it is given to another")
+	jobMaxAttempts          = flag.Int("job-max-attempts", 3, "Attempts at a job before it is dead-lettered")
+	internalToken           = flag.String("internal-token", "", "Shared secret user_data sends to evaluate with a priority above practice")
+	runSlots                = flag.Int("run-slots", 0, "Runs in flight at once; 0 for the healthy runners' total capacity")
+	maxQueueWait            = flag.Duration("max-queue-wait", 2*time.Minute, "How long a run may wait for a runner slot")
+	verdictCacheSize        = flag.Int("verdict-cache-size", 10000, "Verdicts of earlier runs kept for identical submissions; 0 switches the cache off")
+	verdictCacheTTL         = flag.Duration("verdict-cache-ttl", 24*time.Hour, "How long a cached verdict is used")
+	runRateLimit            = flag.Int("run-rate-limit", 10, "Custom runs a user may make per -run-rate-window; 0 for no limit")
+	runRateWindow           = flag.Duration("run-rate-window", time.Minute, "Window of -run-rate-limit")
+	trustedProxiesList      = flag.String("trusted-proxies", "127.0.0.1,::1", "Comma-separated addresses and CIDR ranges of proxies whose X-Forwarded-For is believed")
 )
 
 func getLogger(prefix string) *log.Logger {
@@ -66,6 +110,40 @@ func main() {
 	logger.Println("main() entry.")
 	flag.Parse()
 
+	var err error
+	blobStore, err = NewBlobStore(*blobStoreKind, *blobStoreDirectory, *s3Endpoint, *s3Bucket, *s3Region)
+	if err != nil {
+		logger.Fatalf("failed to set up blob store: %s", err)
+	}
+
+	InitializeCaches(*cacheSize, *cacheTTL)
+	InitializeVerdictCache(*verdictCacheSize, *verdictCacheTTL)
+	runnerClient = NewRunnerClient(strings.Split(*runners, ","), *runnerTimeout, *runnerDeadline)
+	runRateLimiter = NewRateLimiter(*runRateLimit, *runRateWindow)
+	trustedProxies, err = ParseTrustedProxies(*trustedProxiesList)
+	if err != nil {
+		logger.Fatalf("invalid -trusted-proxies: %s", err)
+	}
+	runScheduler = NewRunScheduler(fleetSlots)
+	if *runSlots > 0 {
+		runScheduler.Slots = func() int { return *runSlots }
+	}
+	switch *dispatch {
+	case "push":
+	case "queue":
+		queue, err := NewJobQueue(*jobQueueKind, *sqsQueueURLPrefix, *sqsRegion)
+		if err != nil {
+			logger.Fatalf("failed to set up job queue: %s", err)
+		}
+		jobDispatcher = NewJobDispatcher(queue, *jobVisibilityTimeout, *jobMaxAttempts, *runnerDeadline)
+		if *runSlots == 0 {
+			logger.Printf("run slots are the pull workers that claimed a job in the last %s; runners must send a worker ID, or set -run-slots",
+				*jobVisibilityTimeout+maxClaimWait)
+		}
+	default:
+		logger.Fatalf("unknown -dispatch '%s'", *dispatch)
+	}
+
 	if *checkProblemFilepath != "" {
 		if *checkProblemLanguage == "" {
 			logger.Printf("Both check-problem-filepath and check-problem-language are required.")
@@ -75,6 +153,16 @@ func main() {
 		return
 	}
 
+	if *importPackage != "" {
+		ImportPackage(*importPackage)
+		return
+	}
+
+	if *exportPackage != "" {
+		ExportPackage(*exportPackage, *packageDirectory)
+		return
+	}
+
 	Initialize()
 
 	if *recreateTables {
@@ -88,7 +176,21 @@ func main() {
 		return
 	}
 
+	if *backfillCatalogue {
+		updated, err := BackfillCatalogue(logger)
+		if err != nil {
+			logger.Fatalf("failed to backfill catalogue: %s", err)
+		}
+		logger.Printf("backfilled catalogue attributes of %d problems", updated)
+		return
+	}
+
+	if err := loadSessionStore(*sessionSecretFile); err != nil {
+		logger.Fatalf("failed to load session secret: %s", err)
+	}
 	rand.Seed(time.Now().UTC().UnixNano())
+	go runnerClient.HealthCheck(*runnerHealthInterval, make(chan struct{}))
+	go runnerClient.EvictionLoop(*runnerHeartbeatInterval, *runnerEvictionTimeout, make(chan struct{}))
 	r := mux.NewRouter()
 
 	r.HandleFunc("/evaluator/get_problem_summaries",
@@ -97,8 +199,58 @@ func main() {
 		MakeGzipHandler(getProblemSummary)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/evaluator/get_problem_details/{problem_id:[a-z0-9_]+}/{language:[a-z0-9_]+}",
 		MakeGzipHandler(getProblemDetails)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/get_problem_attachment/{problem_id:[a-z0-9_]+}/{name:[A-Za-z0-9_][A-Za-z0-9_.-]*}",
+		MakeGzipHandler(getProblemAttachment)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/evaluator/evaluate/{problem_id:[a-z0-9_]+}/{language:[a-z0-9_]+}",
 		MakeGzipHandler(evaluate)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/run/{problem_id:[a-z0-9_]+}/{language:[a-z0-9_]+}",
+		MakeGzipHandler(runCustom)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/playground/run",
+		MakeGzipHandler(runPlayground)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/playground/languages",
+		MakeGzipHandler(getPlaygroundLanguages)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/playground/{id:[a-f0-9]+}",
+		MakeGzipHandler(getPlaygroundSnippet)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/queue",
+		MakeGzipHandler(getQueueStats)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/queue/{ticket:[A-Za-z0-9_-]+}",
+		MakeGzipHandler(getQueuePosition)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems",
+		MakeGzipHandler(adminPutProblem)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/attachments",
+		MakeGzipHandler(adminPutAttachment)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems/{problem_id:[a-z0-9_]+}",
+		MakeGzipHandler(adminGetProblem)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems/{problem_id:[a-z0-9_]+}/unit_test/{language:[a-z0-9_]+}",
+		MakeGzipHandler(adminPutUnitTest)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems/{problem_id:[a-z0-9_]+}/bump_version",
+		MakeGzipHandler(adminBumpVersion)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems",
+		MakeGzipHandler(adminListProblems)).Methods("GET")
+	r.HandleFunc("/evaluator/admin/problems/{problem_id:[a-z0-9_]+}/acceptance_rate",
+		MakeGzipHandler(adminPutAcceptanceRate)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/problems/{problem_id:[a-z0-9_]+}/{action:submit|approve|request_changes|publish|retire|republish|revise}",
+		MakeGzipHandler(adminTransitionProblem)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/cache",
+		MakeGzipHandler(adminGetCacheStats)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/cache/invalidate",
+		MakeGzipHandler(adminInvalidateCache)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/runners",
+		MakeGzipHandler(adminGetRunners)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/evaluator/runners/register",
+		MakeGzipHandler(registerRunner)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/runners/heartbeat",
+		MakeGzipHandler(runnerHeartbeat)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/runners/deregister",
+		MakeGzipHandler(deregisterRunner)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/jobs/claim",
+		MakeGzipHandler(claimJob)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/jobs/complete",
+		MakeGzipHandler(completeJob)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/jobs/fail",
+		MakeGzipHandler(failJob)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/evaluator/admin/jobs",
+		MakeGzipHandler(adminGetJobs)).Methods("GET", "OPTIONS")
 	http.Handle("/", r)
 
 	graceful.Run("localhost:8081", 10*time.Second, r)
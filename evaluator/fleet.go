@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Runners started with -registry register themselves with the evaluator
+// rather than being listed in -runners, then send a heartbeat every
+// heartbeat interval. A registered runner that misses heartbeats for the
+// eviction timeout is dropped from the pool; it registers again when it
+// comes back. Runners listed in -runners are never evicted.
+
+// Sent by the runner to /evaluator/runners/register and, with only URL and
+// InFlight, to /evaluator/runners/heartbeat.
+type RunnerRegistration struct {
+	URL       string   `json:"url"`
+	Languages []string `json:"languages"`
+	Capacity  int      `json:"capacity"`
+	Version   string   `json:"version"`
+	InFlight  int      `json:"in_flight"`
+}
+
+type RunnerNotRegisteredError struct {
+	URL string
+}
+
+func (e RunnerNotRegisteredError) Error() string {
+	return fmt.Sprintf("runner '%s' is not registered", e.URL)
+}
+
+type RunnerRegistrationError struct {
+	URL    string
+	Reason string
+}
+
+func (e RunnerRegistrationError) Error() string {
+	return fmt.Sprintf("runner '%s' can't register: %s", e.URL, e.Reason)
+}
+
+func (reg *RunnerRegistration) validate() error {
+	parsed, err := url.Parse(reg.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return RunnerRegistrationError{reg.URL, "url must be an absolute http or https URL"}
+	}
+	if len(reg.Languages) == 0 {
+		return RunnerRegistrationError{reg.URL, "languages is empty"}
+	}
+	if reg.Capacity < 1 {
+		return RunnerRegistrationError{reg.URL, "capacity must be at least 1"}
+	}
+	return nil
+}
+
+// Adds the runner to the pool, or updates it if it is already there, e.g.
+// after a restart with a new version.
+func (c *RunnerClient) Register(reg RunnerRegistration) error {
+	if err := reg.validate(); err != nil {
+		return err
+	}
+	c.AddBackend(reg.URL)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	b := c.backend(reg.URL)
+	now := time.Now()
+	b.Languages = reg.Languages
+	b.Capacity = reg.Capacity
+	b.Version = reg.Version
+	b.Registered = true
+	b.RegisteredAt = now
+	b.LastHeartbeat = now
+	return nil
+}
+
+func (c *RunnerClient) Heartbeat(runner_url string, in_flight int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	b := c.backend(runner_url)
+	if b == nil || !b.Registered {
+		return RunnerNotRegisteredError{runner_url}
+	}
+	b.LastHeartbeat = time.Now()
+	b.RunnerInFlight = in_flight
+	return nil
+}
+
+// Called by runners shutting down, so that no more work is sent to them.
+func (c *RunnerClient) Deregister(runner_url string) error {
+	c.mutex.Lock()
+	b := c.backend(runner_url)
+	if b == nil || !b.Registered {
+		c.mutex.Unlock()
+		return RunnerNotRegisteredError{runner_url}
+	}
+	b.Registered = false
+	static := b.static
+	c.mutex.Unlock()
+	if !static {
+		c.RemoveBackend(runner_url)
+	}
+	return nil
+}
+
+// Drops registered runners whose last heartbeat is older than timeout and
+// returns their URLs.
+func (c *RunnerClient) EvictDead(timeout time.Duration) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	evicted := make([]string, 0)
+	alive := make([]*RunnerBackend, 0, len(c.backends))
+	for _, b := range c.backends {
+		if b.Registered && !b.static && time.Since(b.LastHeartbeat) > timeout {
+			evicted = append(evicted, b.URL)
+			continue
+		}
+		alive = append(alive, b)
+	}
+	c.backends = alive
+	return evicted
+}
+
+// Evicts dead runners every interval until stop is closed.
+func (c *RunnerClient) EvictionLoop(interval time.Duration, timeout time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, runner_url := range c.EvictDead(timeout) {
+				logger.Printf("evicted runner %s after no heartbeat for %s", runner_url, timeout)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Callers hold the mutex.
+func (c *RunnerClient) backend(runner_url string) *RunnerBackend {
+	runner_url = strings.TrimSuffix(runner_url, "/")
+	for _, b := range c.backends {
+		if b.URL == runner_url {
+			return b
+		}
+	}
+	return nil
+}
+
+type LanguageCapacity struct {
+	Language string `json:"language"`
+	Runners  int    `json:"runners"`
+	Healthy  int    `json:"healthy"`
+	Capacity int    `json:"capacity"`
+	InFlight int    `json:"in_flight"`
+}
+
+// Per language, how many runners can run it and how many runs they can
+// take at once. Runners listed in -runners haven't said what they run, so
+// they are counted under "*".
+func (c *RunnerClient) FleetCapacity() []LanguageCapacity {
+	by_language := make(map[string]*LanguageCapacity)
+	for _, b := range c.Status() {
+		languages := b.Languages
+		if len(languages) == 0 {
+			languages = []string{"*"}
+		}
+		for _, language := range languages {
+			lc, present := by_language[language]
+			if present == false {
+				lc = &LanguageCapacity{Language: language}
+				by_language[language] = lc
+			}
+			lc.Runners += 1
+			lc.InFlight += b.InFlight
+			if b.Healthy {
+				lc.Healthy += 1
+				lc.Capacity += b.capacity()
+			}
+		}
+	}
+	capacities := make([]LanguageCapacity, 0, len(by_language))
+	for _, lc := range by_language {
+		capacities = append(capacities, *lc)
+	}
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i].Language < capacities[j].Language })
+	return capacities
+}
+
+// Runners prove they are ours with the -runner-registry-token the evaluator
+// was started with. Without one, registration is switched off.
+func checkRunnerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	given := r.Header.Get("X-Runner-Token")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Problems can declare the function to implement once, with a Signature and
+// a list of TestCases, instead of writing a unit test per language. The unit
+// test and initial code for every language we have a template for are then
+// generated, so adding a language doesn't mean rewriting the tests.
+//
+// Types are int (32 bit), float, bool, string and arrays of those written as
+// e.g. int[] or string[][]. Args and expected values are JSON. Strings and
+// arrays may be null.
+
+// The languages we have function harness templates for.
+var functionHarnessLanguages = []string{"python", "java", "javascript"}
+
+// Floats are compared with this tolerance.
+const floatTolerance = "1e-6"
+
+type signature struct {
+	Function string            `json:"function"`
+	Returns  string            `json:"returns"`
+	Params   []parameter       `json:"params,omitempty"`
+	Names    map[string]string `json:"names,omitempty"`
+}
+
+type parameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Args is a JSON array with one value per parameter, Expected a JSON value.
+type function_case struct {
+	Args     string `json:"args"`
+	Expected string `json:"expected"`
+}
+
+// The function name in language, which may be overridden with e.g.
+// names.java = "pairSumsToK" to follow the language's naming convention.
+func (s *signature) FunctionName(language string) string {
+	if name, present := s.Names[language]; present == true {
+		return name
+	}
+	return s.Function
+}
+
+func isArrayType(t string) bool {
+	return strings.HasSuffix(t, "[]")
+}
+
+func elementType(t string) string {
+	return strings.TrimSuffix(t, "[]")
+}
+
+func isValidType(t string) bool {
+	for isArrayType(t) {
+		t = elementType(t)
+	}
+	switch t {
+	case "int", "float", "bool", "string":
+		return true
+	}
+	return false
+}
+
+func decodeJSONValue(s string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Checks that value, decoded with decodeJSONValue, has type t.
+func checkValue(value interface{}, t string) error {
+	if value == nil {
+		if isArrayType(t) || t == "string" {
+			return nil
+		}
+		return fmt.Errorf("null is not a %s", t)
+	}
+	if isArrayType(t) {
+		values, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a %s", value, t)
+		}
+		for _, element := range values {
+			if err := checkValue(element, elementType(t)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch t {
+	case "int":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%v is not an int", value)
+		}
+		if i, err := number.Int64(); err != nil || i < math.MinInt32 || i > math.MaxInt32 {
+			return fmt.Errorf("%v is not a 32-bit int", value)
+		}
+	case "float":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%v is not a float", value)
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v is not a bool", value)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%v is not a string", value)
+		}
+	}
+	return nil
+}
+
+// Checks the signature, and that every test case matches it.
+func (p *Problem) validateFunctionTests() error {
+	if p.Signature == nil {
+		if len(p.TestCases) > 0 {
+			return ProblemValidationError{p.Id, "TestCases need a Signature"}
+		}
+		return nil
+	}
+	if len(p.TestData) > 0 {
+		return ProblemValidationError{p.Id, "a problem can't have both TestData and a Signature"}
+	}
+	if p.Signature.Function == "" || !isValidType(p.Signature.Returns) {
+		return ProblemValidationError{p.Id, "Signature needs a function and a valid returns type"}
+	}
+	for _, param := range p.Signature.Params {
+		if param.Name == "" || !isValidType(param.Type) {
+			return ProblemValidationError{p.Id, fmt.Sprintf("Signature parameter '%s' needs a name and a valid type", param.Name)}
+		}
+	}
+	for i, test_case := range p.TestCases {
+		args, err := decodeJSONValue(test_case.Args)
+		if err != nil {
+			return ProblemValidationError{p.Id, fmt.Sprintf("TestCases[%d].args is not JSON: %s", i, err)}
+		}
+		arg_list, ok := args.([]interface{})
+		if !ok || len(arg_list) != len(p.Signature.Params) {
+			return ProblemValidationError{p.Id, fmt.Sprintf("TestCases[%d].args must be an array of %d values", i, len(p.Signature.Params))}
+		}
+		for j, arg := range arg_list {
+			if err := checkValue(arg, p.Signature.Params[j].Type); err != nil {
+				return ProblemValidationError{p.Id, fmt.Sprintf("TestCases[%d] parameter '%s': %s", i, p.Signature.Params[j].Name, err)}
+			}
+		}
+		expected, err := decodeJSONValue(test_case.Expected)
+		if err != nil {
+			return ProblemValidationError{p.Id, fmt.Sprintf("TestCases[%d].expected is not JSON: %s", i, err)}
+		}
+		if err := checkValue(expected, p.Signature.Returns); err != nil {
+			return ProblemValidationError{p.Id, fmt.Sprintf("TestCases[%d].expected: %s", i, err)}
+		}
+	}
+	return nil
+}
+
+// Fills in the unit test and initial code for every supported language we
+// have a template for, unless the problem already provides them. Does
+// nothing without a valid Signature.
+func (p *Problem) GenerateFunctionTests() {
+	if p.Signature == nil || p.validateFunctionTests() != nil {
+		return
+	}
+	if p.UnitTest == nil {
+		p.UnitTest = make(map[string]unit_test)
+	}
+	if p.InitialCode == nil {
+		p.InitialCode = make(map[string]initial_code)
+	}
+	for _, language := range p.SupportedLanguages {
+		if !containsString(functionHarnessLanguages, language) {
+			continue
+		}
+		if _, present := p.UnitTest[language]; present == false {
+			p.UnitTest[language] = unit_test{Code: functionUnitTest(language, p.Signature, p.TestCases)}
+		}
+		if _, present := p.InitialCode[language]; present == false {
+			p.InitialCode[language] = initial_code{Code: functionInitialCode(language, p.Signature)}
+		}
+	}
+}
+
+// Generates the tests of both kinds, see GenerateStdioTests.
+func (p *Problem) GenerateTests() {
+	p.GenerateStdioTests()
+	p.GenerateFunctionTests()
+}
+
+func functionInitialCode(language string, s *signature) string {
+	var buffer bytes.Buffer
+	names := make([]string, 0, len(s.Params))
+	for _, param := range s.Params {
+		names = append(names, param.Name)
+	}
+	switch language {
+	case "python":
+		fmt.Fprintf(&buffer, "def %s(%s):\n    pass\n", s.FunctionName(language), strings.Join(names, ", "))
+	case "java":
+		params := make([]string, 0, len(s.Params))
+		for _, param := range s.Params {
+			params = append(params, fmt.Sprintf("final %s %s", javaType(param.Type, false), param.Name))
+		}
+		fmt.Fprintf(&buffer, "import java.util.*;\n\npublic class Solution {\n")
+		fmt.Fprintf(&buffer, "    public static %s %s(%s) {\n", javaType(s.Returns, false), s.FunctionName(language), strings.Join(params, ", "))
+		fmt.Fprintf(&buffer, "        return %s;\n    }\n}\n", javaDefaultValue(s.Returns))
+	case "javascript":
+		fmt.Fprintf(&buffer, "exports.%s = function(%s) {\n};\n", s.FunctionName(language), strings.Join(names, ", "))
+	}
+	return buffer.String()
+}
+
+// Test case values were checked by validateFunctionTests, so decoding them
+// again can't fail.
+func decodeFunctionCase(test_case function_case) ([]interface{}, interface{}) {
+	args, _ := decodeJSONValue(test_case.Args)
+	expected, _ := decodeJSONValue(test_case.Expected)
+	return args.([]interface{}), expected
+}
+
+func functionUnitTest(language string, s *signature, cases []function_case) string {
+	var buffer bytes.Buffer
+	name := s.FunctionName(language)
+	switch language {
+	case "python":
+		buffer.WriteString("# -*- coding: utf-8 -*-\nimport unittest\n\nimport foo\n\n\nclass TestSolution(unittest.TestCase):\n")
+		for i, test_case := range cases {
+			args, expected := decodeFunctionCase(test_case)
+			literals := make([]string, 0, len(args))
+			for j, arg := range args {
+				literals = append(literals, pythonLiteral(arg, s.Params[j].Type))
+			}
+			assertion := "assertEqual"
+			if s.Returns == "float" {
+				assertion = "assertAlmostEqual"
+			}
+			fmt.Fprintf(&buffer, "    def test%d(self):\n        self.%s(%s, foo.%s(%s))\n\n",
+				i+1, assertion, pythonLiteral(expected, s.Returns), name, strings.Join(literals, ", "))
+		}
+		buffer.WriteString("if __name__ == '__main__':\n    unittest.main(verbosity=2)\n")
+	case "java":
+		buffer.WriteString("import java.util.*;\nimport org.junit.*;\nimport static org.junit.Assert.*;\n\npublic class SolutionTest {\n")
+		for i, test_case := range cases {
+			args, expected := decodeFunctionCase(test_case)
+			literals := make([]string, 0, len(args))
+			for j, arg := range args {
+				literals = append(literals, javaLiteral(arg, s.Params[j].Type))
+			}
+			tolerance := ""
+			if s.Returns == "float" {
+				tolerance = ", " + floatTolerance
+			}
+			fmt.Fprintf(&buffer, "    @Test\n    public void test%d() {\n        assertEquals(%s, Solution.%s(%s)%s);\n    }\n\n",
+				i+1, javaLiteral(expected, s.Returns), name, strings.Join(literals, ", "), tolerance)
+		}
+		buffer.WriteString("    public static void main(String[] args) {\n        org.junit.runner.JUnitCore.main(\"SolutionTest\");\n    }\n}\n")
+	case "javascript":
+		buffer.WriteString("var foo = require('./foo');\n")
+		for i, test_case := range cases {
+			args, expected := decodeFunctionCase(test_case)
+			literals := make([]string, 0, len(args))
+			for _, arg := range args {
+				literals = append(literals, javascriptLiteral(arg))
+			}
+			call := fmt.Sprintf("foo.%s(%s)", name, strings.Join(literals, ", "))
+			assertion := fmt.Sprintf("test.strictEqual(%s, %s);", call, javascriptLiteral(expected))
+			if s.Returns == "float" {
+				assertion = fmt.Sprintf("test.ok(Math.abs(%s - %s) < %s);", call, javascriptLiteral(expected), floatTolerance)
+			} else if isArrayType(s.Returns) {
+				assertion = fmt.Sprintf("test.deepEqual(%s, %s);", call, javascriptLiteral(expected))
+			}
+			fmt.Fprintf(&buffer, "\nexports.test%d = function(test) {\n  %s\n  test.done();\n};\n", i+1, assertion)
+		}
+	}
+	return buffer.String()
+}
+
+func formatFloat(number json.Number) string {
+	f, _ := number.Float64()
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+func pythonLiteral(value interface{}, t string) string {
+	if value == nil {
+		return "None"
+	}
+	if isArrayType(t) {
+		literals := make([]string, 0)
+		for _, element := range value.([]interface{}) {
+			literals = append(literals, pythonLiteral(element, elementType(t)))
+		}
+		return "[" + strings.Join(literals, ", ") + "]"
+	}
+	switch t {
+	case "int":
+		return value.(json.Number).String()
+	case "float":
+		return formatFloat(value.(json.Number))
+	case "bool":
+		if value.(bool) {
+			return "True"
+		}
+		return "False"
+	}
+	// Non-ASCII is written as is; the test file declares UTF-8.
+	var buffer bytes.Buffer
+	buffer.WriteByte('"')
+	for _, r := range value.(string) {
+		switch {
+		case r == '"' || r == '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		case r == '\n':
+			buffer.WriteString("\\n")
+		case r == '\r':
+			buffer.WriteString("\\r")
+		case r == '\t':
+			buffer.WriteString("\\t")
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buffer, "\\x%02x", r)
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+	buffer.WriteByte('"')
+	return buffer.String()
+}
+
+// Arrays are Lists in Java, so that assertEquals compares them deeply.
+func javaType(t string, boxed bool) string {
+	if isArrayType(t) {
+		return "List<" + javaType(elementType(t), true) + ">"
+	}
+	switch t {
+	case "int":
+		if boxed {
+			return "Integer"
+		}
+		return "int"
+	case "float":
+		if boxed {
+			return "Double"
+		}
+		return "double"
+	case "bool":
+		if boxed {
+			return "Boolean"
+		}
+		return "boolean"
+	}
+	return "String"
+}
+
+func javaDefaultValue(t string) string {
+	switch t {
+	case "int":
+		return "0"
+	case "float":
+		return "0.0"
+	case "bool":
+		return "false"
+	}
+	return "null"
+}
+
+func javaLiteral(value interface{}, t string) string {
+	if value == nil {
+		return "null"
+	}
+	if isArrayType(t) {
+		literals := make([]string, 0)
+		for _, element := range value.([]interface{}) {
+			literals = append(literals, javaLiteral(element, elementType(t)))
+		}
+		return fmt.Sprintf("Arrays.<%s>asList(%s)", javaType(elementType(t), true), strings.Join(literals, ", "))
+	}
+	switch t {
+	case "int":
+		return value.(json.Number).String()
+	case "float":
+		return formatFloat(value.(json.Number))
+	case "bool":
+		return strconv.FormatBool(value.(bool))
+	}
+	return javaStringLiteral(value.(string))
+}
+
+// JSON is valid JavaScript, and json.Marshal escapes U+2028 and U+2029.
+func javascriptLiteral(value interface{}) string {
+	literal, _ := json.Marshal(value)
+	return string(literal)
+}
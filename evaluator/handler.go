@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -47,11 +48,29 @@ func getProblemSummaries(w http.ResponseWriter, r *http.Request) {
 	logger.Println("handler.getProblemSummaries() entry.")
 	defer logger.Println("handler.getProblemSummaries() exit.")
 
-	problems, err := GetProblemSummaries(logger)
+	cq, err := ParseCatalogueQuery(r.URL.Query())
 	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	problems, next_cursor, err := CachedProblemSummaries(logger, cq)
+	if err == InvalidCatalogueCursor {
+		http.Error(w, err.Error(), 400)
+		return
+	} else if err != nil {
 		log.Panic(err)
 	}
-	responseEncoded, _ := json.Marshal(problems)
+	locales := requestLocales(r)
+	for i := range problems {
+		problems[i].HideSandboxAttachments()
+		problems[i].Localise(locales)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	response := map[string]interface{}{
+		"problems":    problems,
+		"next_cursor": next_cursor,
+	}
+	responseEncoded, _ := json.Marshal(response)
 	io.WriteString(w, string(responseEncoded))
 }
 
@@ -67,13 +86,23 @@ func getProblemSummary(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("handler.getProblemSummary() entry. problem_id: %s", problem_id)
 	defer logger.Println("handler.getProblemSummary() exit.")
 
-	problem, err := GetProblemSummary(logger, problem_id)
+	_, role, _ := getSessionUser(r)
+	problem, err := CachedProblemSummary(logger, problem_id)
+	if err == nil && !isProblemVisible(&problem, role) {
+		err = ProblemNotFoundError{problem_id}
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 404)
 		return
 	}
+	problem.HideSandboxAttachments()
+	problem.Localise(requestLocales(r))
+	setContentLanguage(w, problem.Locale)
 	responseEncoded, _ := json.Marshal(problem)
-	io.WriteString(w, string(responseEncoded))
+	// No Last-Modified, since the state and acceptance rate change without a
+	// new version and so without last_updated_date moving. The ETag sees them.
+	writeCacheableResponse(logger, w, r, problem.Version, nil,
+		problem.State == StatePublished, responseEncoded)
 }
 
 func getProblemDetails(w http.ResponseWriter, r *http.Request) {
@@ -90,22 +119,117 @@ func getProblemDetails(w http.ResponseWriter, r *http.Request) {
 		problem_id, language)
 	defer logger.Println("handler.getProblemDetails() exit.")
 
-	problem, err := GetProblemDetails(logger, problem_id, language)
+	var (
+		problem Problem
+		err     error
+	)
+	_, role, _ := getSessionUser(r)
+	summary, err := CachedProblemSummary(logger, problem_id)
+	if err == nil && !isProblemVisible(&summary, role) {
+		err = ProblemNotFoundError{problem_id}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if version_param := r.URL.Query().Get("version"); version_param != "" {
+		version, atoi_err := strconv.Atoi(version_param)
+		if atoi_err != nil {
+			http.Error(w, fmt.Sprintf("invalid version '%s'", version_param), 400)
+			return
+		}
+		problem, err = CachedProblemDetailsAtVersion(logger, problem_id, language, version)
+	} else {
+		problem, err = CachedProblemDetails(logger, problem_id, language, summary.Version)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 404)
 		return
 	}
+	problem.Localise(requestLocales(r))
+	for language, value := range problem.Description {
+		value.Markdown = resolveAttachmentReferences(problem_id, value.Markdown)
+		value.HTML = RenderProblemDescription(logger, problem_id, language, problem.Locale, problem.Version, value.Markdown)
+		problem.Description[language] = value
+	}
+	setContentLanguage(w, problem.Locale)
 	responseEncoded, _ := json.Marshal(problem)
-	io.WriteString(w, string(responseEncoded))
+	// Only historical versions carry their own last_updated_date.
+	last_modified := problem.LastUpdatedDate
+	if last_modified == nil {
+		last_modified = summary.LastUpdatedDate
+	}
+	writeCacheableResponse(logger, w, r, problem.Version, last_modified,
+		summary.State == StatePublished, responseEncoded)
+}
+
+// Serves image attachments of problems the user can see. Other attachments
+// are test data or sandbox files and are never served.
+func getProblemAttachment(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	vars := mux.Vars(r)
+	problem_id := vars["problem_id"]
+	name := vars["name"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler.getProblemAttachment() entry. problem_id: %s, name: %s", problem_id, name)
+	defer logger.Println("handler.getProblemAttachment() exit.")
+
+	_, role, _ := getSessionUser(r)
+	problem, err := CachedProblemSummary(logger, problem_id)
+	if err == nil && !isProblemVisible(&problem, role) {
+		err = ProblemNotFoundError{problem_id}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	a, present := problem.GetAttachment(name)
+	if present == false || a.Kind != AttachmentImage {
+		http.Error(w, fmt.Sprintf("attachment '%s' not found", name), 404)
+		return
+	}
+	data, err := ReadAttachment(logger, a)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	content_type := mime.TypeByExtension(path.Ext(name))
+	if content_type == "" {
+		content_type = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", content_type)
+	// Keys are content addresses, so the blob behind a name only changes
+	// with a new version of the problem.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(data)
 }
 
 type evaluate_struct struct {
 	Code string `json:"code,omitempty"`
+	// Scheduling, see scheduler.go. Ticket is chosen by the client so it
+	// can poll /evaluator/queue/<ticket> while it waits.
+	Priority string `json:"priority,omitempty"`
+	UserId   string `json:"user_id,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
 }
 
 type runner_response_struct struct {
 	Success bool   `json:"success,omitempty"`
 	Output  string `json:"output,omitempty"`
+	// How long the code ran, as the runner measured it.
+	RunSeconds float64 `json:"run_seconds,omitempty"`
+	// Set by the runner: it killed the code for running too long, the code
+	// was killed by a signal, or the runner couldn't set the run up.
+	TimedOut    bool `json:"timed_out,omitempty"`
+	Killed      bool `json:"killed,omitempty"`
+	RunnerError bool `json:"runner_error,omitempty"`
+	// Set by JudgeStdioOutput: a case went over its memory limit. Cases
+	// over their time limit set TimedOut.
+	OutOfMemory bool `json:"out_of_memory,omitempty"`
 }
 
 func evaluate(w http.ResponseWriter, r *http.Request) {
@@ -129,15 +253,32 @@ func evaluate(w http.ResponseWriter, r *http.Request) {
 	defer writeJSONResponse(logger, response, w)
 	response["success"] = false
 
+	// -------------------------------------------------------------------------
+	//   Drafts and problems in review may only be evaluated by staff.
+	// -------------------------------------------------------------------------
+	_, role, _ := getSessionUser(r)
+	summary, err := CachedProblemSummary(logger, problem_id)
+	if err == nil && !isProblemEvaluable(&summary, role) {
+		err = ProblemNotFoundError{problem_id}
+	}
+	if err != nil {
+		msg := fmt.Sprintf("GetProblemSummary threw error: %s", err)
+		response["output"] = msg
+		logger.Printf(msg)
+		w.WriteHeader(404)
+		return
+	}
+
 	// -------------------------------------------------------------------------
 	//   Get unit test.
 	// -------------------------------------------------------------------------
-	problem, err := GetProblemUnitTest(logger, problem_id, language)
+	problem, err := CachedProblemUnitTest(logger, problem_id, language, summary.Version)
 	if err != nil {
 		msg := fmt.Sprintf("GetProblemUnitTest threw error: %s", err)
 		response["output"] = msg
 		logger.Printf(msg)
 		w.WriteHeader(400)
+		return
 	}
 
 	// -------------------------------------------------------------------------
@@ -153,53 +294,121 @@ func evaluate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	runner_response, err := CallRunner(language, t.Code, problem.UnitTest[language].Code)
+	// -------------------------------------------------------------------------
+	//   Work out the run's priority and whose turn it takes.
+	// -------------------------------------------------------------------------
+	trusted := isInternalCaller(r)
+	if t.Priority == "" {
+		t.Priority = defaultRunPriority
+	}
+	ticket, err := NewRunTicket(t.Ticket, t.Priority, runUserId(r, trusted, t.UserId), language)
 	if err != nil {
-		msg := fmt.Sprintf("failed during CallRunner: %s", err)
+		response["output"] = fmt.Sprintf("<%s>", err)
+		w.WriteHeader(400)
+		return
+	}
+	default_rank, _ := runPriorityRank(defaultRunPriority)
+	if ticket.rank < default_rank && !trusted {
+		response["output"] = fmt.Sprintf("<priority '%s' is only for the site itself>", ticket.Priority)
+		w.WriteHeader(403)
+		return
+	}
+
+	// -------------------------------------------------------------------------
+	//   Identical code judged before gets the same verdict, unless rejudged.
+	// -------------------------------------------------------------------------
+	if ticket.Priority != "rejudge" {
+		if verdict, ok := CachedVerdict(&summary, language, t.Code); ok {
+			logger.Printf("identical code was judged against version %d, returning its verdict", summary.Version)
+			response["success"] = verdict.Success
+			response["output"] = verdict.Output
+			response["problem_version"] = summary.Version
+			response["cached"] = true
+			return
+		}
+	}
+	response["cached"] = false
+
+	// -------------------------------------------------------------------------
+	//   Wait for a runner slot and run.
+	// -------------------------------------------------------------------------
+	summary.TestData = problem.TestData
+	files, err := SandboxFiles(logger, &summary)
+	if err != nil {
+		msg := fmt.Sprintf("failed to read attachments: %s", err)
 		logger.Printf(msg)
 		response["output"] = msg
 		w.WriteHeader(500)
 		return
 	}
+	runner_response, ok := scheduleRun(logger, w, response, ticket, language, t.Code,
+		problem.UnitTest[language].Code, files)
+	if !ok {
+		return
+	}
+	if len(summary.TestData) > 0 {
+		judged, err := JudgeStdioOutput(logger, &summary, *runner_response)
+		if err != nil {
+			msg := fmt.Sprintf("failed to judge output: %s", err)
+			logger.Printf(msg)
+			response["output"] = msg
+			w.WriteHeader(500)
+			return
+		}
+		runner_response = &judged
+	}
 	response["success"] = runner_response.Success
 	response["output"] = runner_response.Output
+	response["problem_version"] = problem.Version
+	PutVerdict(&summary, language, t.Code, *runner_response)
 }
 
-func CallRunner(language string, code string, unit_test string) (*runner_response_struct, error) {
-	logger.Printf("CallRunner entry. language: %s", language)
-	defer logger.Printf("CallRunner exit.")
-	data := make(map[string]string)
-	data["code"] = code
-	data["unit_test"] = unit_test
-
-	uri := fmt.Sprintf("http://backend.runsomecode.com/run/%s", language)
-	j, jerr := json.Marshal(data)
-	if jerr != nil {
-		return nil, jerr
-	}
-	request, err := http.NewRequest("POST", uri, bytes.NewBuffer(j))
-	if err != nil {
-		return nil, err
-	}
-	request.Header.Set("Content-Type", "application/json; charset=utf-8")
-	client := &http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, err
+// Waits for a runner slot for ticket and runs the code, filling in the
+// ticket and timings. If that fails it writes the error response and
+// returns false.
+func scheduleRun(logger *log.Logger, w http.ResponseWriter, response map[string]interface{}, ticket *RunTicket,
+	language string, code string, unit_test string, files map[string][]byte) (*runner_response_struct, bool) {
+	err := runScheduler.Acquire(logger, ticket, *maxQueueWait)
+	switch err.(type) {
+	case nil:
+	case TooManyQueuedRunsError:
+		response["output"] = fmt.Sprintf("<%s>", err)
+		w.WriteHeader(429)
+		return nil, false
+	default:
+		logger.Printf("failed to get a runner slot: %s", err)
+		response["output"] = "<all runners are busy, please try again in a minute>"
+		w.WriteHeader(503)
+		return nil, false
 	}
-	defer resp.Body.Close()
+	defer runScheduler.Release(ticket)
+	response["ticket"] = ticket.Id
+	response["queue_wait_seconds"] = ticket.Waited().Seconds()
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New(fmt.Sprintf("HTTP POST not 200: %s\n", resp))
+	runner_response, err := CallRunner(language, code, unit_test, files)
+	if _, ok := err.(RunnerUnavailableError); ok {
+		logger.Printf("failed during CallRunner: %s", err)
+		response["output"] = "<no runner is available right now, please try again in a minute>"
+		w.WriteHeader(503)
+		return nil, false
+	} else if err != nil {
+		msg := fmt.Sprintf("failed during CallRunner: %s", err)
+		logger.Printf(msg)
+		response["output"] = msg
+		w.WriteHeader(500)
+		return nil, false
 	}
+	response["run_seconds"] = runner_response.RunSeconds
+	return runner_response, true
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	var t2 runner_response_struct
-	err = decoder.Decode(&t2)
-	if err != nil {
-		return nil, err
+func CallRunner(language string, code string, unit_test string, files map[string][]byte) (*runner_response_struct, error) {
+	logger.Printf("CallRunner entry. language: %s", language)
+	defer logger.Printf("CallRunner exit.")
+	if jobDispatcher != nil {
+		return jobDispatcher.Run(logger, language, code, unit_test, files)
 	}
-	return &t2, nil
+	return runnerClient.Run(logger, language, code, unit_test, files)
 }
 
 func CheckProblem(filepath string, language string) error {
@@ -212,12 +421,25 @@ func CheckProblem(filepath string, language string) error {
 	}
 	code := problem.Solution[language].Code
 	unit_test := problem.UnitTest[language].Code
-	runner_response, err := CallRunner(language, code, unit_test)
+	files, err := SandboxFiles(logger, problem)
+	if err != nil {
+		log.Printf("failed to read attachments: %s", err)
+		return err
+	}
+	runner_response, err := CallRunner(language, code, unit_test, files)
 	if err != nil {
 		msg := fmt.Sprintf("failed during CallRunner: %s", err)
 		logger.Printf(msg)
 		return nil
 	}
+	if len(problem.TestData) > 0 {
+		judged, err := JudgeStdioOutput(logger, problem, *runner_response)
+		if err != nil {
+			logger.Printf("failed to judge output: %s", err)
+			return err
+		}
+		runner_response = &judged
+	}
 	logger.Printf("runner success: %b", runner_response.Success)
 	logger.Printf("runner output: \n%s", runner_response.Output)
 	return nil
@@ -226,7 +448,7 @@ func CheckProblem(filepath string, language string) error {
 func commonHandlerSetup(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET POST OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, If-Modified-Since")
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 }
@@ -237,3 +459,46 @@ func writeJSONResponse(logger *log.Logger, response map[string]interface{}, w ht
 	responseEncoded, _ := json.Marshal(response)
 	io.WriteString(w, string(responseEncoded))
 }
+
+// Where the run with ticket is in the queue, while the client waits for
+// evaluate to answer.
+func getQueuePosition(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	ticket := mux.Vars(r)["ticket"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler.getQueuePosition() entry. ticket: %s", ticket)
+	defer logger.Println("handler.getQueuePosition() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	position, ok := runScheduler.Position(ticket)
+	if !ok {
+		response["success"] = false
+		response["error"] = "no run with that ticket is waiting or running."
+		w.WriteHeader(404)
+		return
+	}
+	response["success"] = true
+	response["queue"] = position
+}
+
+// How busy the runners are and how long a new run would wait in each class.
+func getQueueStats(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler.getQueueStats() entry.")
+	defer logger.Println("handler.getQueueStats() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = true
+	response["queue"] = runScheduler.Stats()
+}
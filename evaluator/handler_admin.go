@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxProblemUploadBytes    = 1024 * 1024
+	maxAttachmentUploadBytes = 64 * 1024 * 1024
+)
+
+// Decodes a problem from the request body, as TOML if the Content-Type says
+// so, else as JSON.
+func decodeProblemUpload(w http.ResponseWriter, r *http.Request) (*Problem, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxProblemUploadBytes))
+	if err != nil {
+		return nil, err
+	}
+	var problem Problem
+	if strings.Contains(r.Header.Get("Content-Type"), "toml") {
+		if err := DecodeProblemTOML(string(body), &problem); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(body, &problem); err != nil {
+		return nil, err
+	}
+	problem.GenerateTests()
+	return &problem, nil
+}
+
+// Marks problem as a new version, last updated now.
+func bumpProblemVersion(problem *Problem) {
+	now := time.Now().UTC()
+	problem.Version += 1
+	problem.LastUpdatedDate = &now
+}
+
+func writeAdminError(logger *log.Logger, w http.ResponseWriter, response map[string]interface{}, err error) {
+	response["error"] = err.Error()
+	logger.Printf("admin request failed: %s", err)
+	switch err.(type) {
+	case ProblemNotFoundError:
+		w.WriteHeader(404)
+	case ProblemValidationError:
+		w.WriteHeader(400)
+	case ProblemStateError:
+		w.WriteHeader(409)
+	default:
+		w.WriteHeader(500)
+	}
+}
+
+func adminGetProblem(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	problem_id := mux.Vars(r)["problem_id"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminGetProblem() entry. problem_id: %s", problem_id)
+	defer logger.Println("handler_admin.adminGetProblem() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, RoleAdmin, RoleAuthor); !ok {
+		return
+	}
+
+	problem, err := GetFullProblem(logger, problem_id)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["problem"] = problem
+}
+
+func adminPutProblem(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminPutProblem() entry.")
+	defer logger.Println("handler_admin.adminPutProblem() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	user_id, ok := requireRole(w, r, response, RoleAdmin, RoleAuthor)
+	if !ok {
+		return
+	}
+
+	problem, err := decodeProblemUpload(w, r)
+	if err != nil {
+		writeAdminError(logger, w, response, ProblemValidationError{"", fmt.Sprintf("could not decode problem: %s", err)})
+		return
+	}
+	logger.Printf("user %s uploading problem %s version %d", user_id, problem.Id, problem.Version)
+	if err := validateProblemId(problem.Id); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+
+	// Fill in what the TOML loader would otherwise require of the author.
+	existing_problem, err := GetProblemSummary(logger, problem.Id)
+	_, not_found := err.(ProblemNotFoundError)
+	if err != nil && !not_found {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	// State only changes through the workflow: new problems start as drafts
+	// and only drafts can be replaced.
+	if !not_found {
+		if err := checkProblemEditable(&existing_problem); err != nil {
+			writeAdminError(logger, w, response, err)
+			return
+		}
+	}
+	problem.State = StateDraft
+	problem.SubmittedBy = ""
+	problem.Approvals = nil
+	// Paths would be read from the server's disk; attachments must be
+	// uploaded first and referenced by key.
+	for _, a := range problem.Attachments {
+		if a.Path != "" {
+			writeAdminError(logger, w, response, ProblemValidationError{problem.Id,
+				fmt.Sprintf("attachment '%s' must be uploaded and referenced by key, not path", a.Name)})
+			return
+		}
+	}
+	now := time.Now().UTC()
+	if problem.Version == 0 {
+		problem.Version = existing_problem.Version + 1
+	}
+	if problem.CreationDate == nil {
+		problem.CreationDate = existing_problem.CreationDate
+	}
+	if problem.CreationDate == nil {
+		problem.CreationDate = &now
+	}
+	if problem.LastUpdatedDate == nil {
+		problem.LastUpdatedDate = &now
+	}
+
+	if err := ValidateAndPutProblem(logger, problem); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["id"] = problem.Id
+	response["version"] = problem.Version
+}
+
+// Stores the request body in the blob store and returns its key, for use as
+// an attachment key in a problem upload.
+func adminPutAttachment(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminPutAttachment() entry.")
+	defer logger.Println("handler_admin.adminPutAttachment() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, RoleAdmin, RoleAuthor); !ok {
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAttachmentUploadBytes))
+	if err != nil {
+		writeAdminError(logger, w, response, ProblemValidationError{"", fmt.Sprintf("could not read attachment: %s", err)})
+		return
+	}
+	key, err := StoreBlob(logger, data)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["key"] = key
+	response["size"] = len(data)
+}
+
+type unitTestUpdateRequest struct {
+	Code string `json:"code"`
+}
+
+func adminPutUnitTest(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	vars := mux.Vars(r)
+	problem_id := vars["problem_id"]
+	language := vars["language"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminPutUnitTest() entry. problem_id: %s, language: %s", problem_id, language)
+	defer logger.Println("handler_admin.adminPutUnitTest() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, RoleAdmin, RoleAuthor); !ok {
+		return
+	}
+
+	var request unitTestUpdateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProblemUploadBytes)).Decode(&request); err != nil {
+		writeAdminError(logger, w, response, ProblemValidationError{problem_id, fmt.Sprintf("could not decode request: %s", err)})
+		return
+	}
+	problem, err := GetFullProblem(logger, problem_id)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	if err := checkProblemEditable(problem); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	if !containsString(problem.SupportedLanguages, language) {
+		writeAdminError(logger, w, response, ProblemValidationError{problem_id, fmt.Sprintf("language %s is not supported", language)})
+		return
+	}
+	problem.UnitTest[language] = unit_test{Code: request.Code}
+	bumpProblemVersion(problem)
+	if err := ValidateAndPutProblem(logger, problem); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["version"] = problem.Version
+}
+
+func adminBumpVersion(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	problem_id := mux.Vars(r)["problem_id"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminBumpVersion() entry. problem_id: %s", problem_id)
+	defer logger.Println("handler_admin.adminBumpVersion() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, RoleAdmin, RoleAuthor); !ok {
+		return
+	}
+
+	problem, err := GetFullProblem(logger, problem_id)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	if err := checkProblemEditable(problem); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	bumpProblemVersion(problem)
+	if err := ValidateAndPutProblem(logger, problem); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["version"] = problem.Version
+}
+
+type acceptanceRateRequest struct {
+	AcceptanceRate float64 `json:"acceptance_rate"`
+}
+
+// user_data counts submissions, so it sends each problem's acceptance rate
+// here for the catalogue. Only it may, with -internal-token.
+func adminPutAcceptanceRate(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	problem_id := mux.Vars(r)["problem_id"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminPutAcceptanceRate() entry. problem_id: %s", problem_id)
+	defer logger.Println("handler_admin.adminPutAcceptanceRate() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if !isInternalCaller(r) {
+		response["error"] = "only user_data may set acceptance rates."
+		logger.Printf("acceptance rate from a caller without the internal token")
+		w.WriteHeader(403)
+		return
+	}
+
+	var request acceptanceRateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProblemUploadBytes)).Decode(&request); err != nil {
+		writeAdminError(logger, w, response, ProblemValidationError{problem_id, err.Error()})
+		return
+	}
+	if request.AcceptanceRate < 0 || request.AcceptanceRate > 1 {
+		writeAdminError(logger, w, response, ProblemValidationError{problem_id, "acceptance_rate must be between 0 and 1"})
+		return
+	}
+	if err := UpdateProblemAcceptanceRate(logger, problem_id, request.AcceptanceRate); err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+}
+
+type transitionRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// Handles the workflow actions in transitions, e.g. submit, approve, publish.
+func adminTransitionProblem(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	vars := mux.Vars(r)
+	problem_id := vars["problem_id"]
+	action := vars["action"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminTransitionProblem() entry. problem_id: %s, action: %s", problem_id, action)
+	defer logger.Println("handler_admin.adminTransitionProblem() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	user_id, ok := requireRole(w, r, response, staffRoles...)
+	if !ok {
+		return
+	}
+	_, role, _ := getSessionUser(r)
+
+	// The body is optional; only approve and request_changes use a comment.
+	var request transitionRequest
+	json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProblemUploadBytes)).Decode(&request)
+
+	problem, err := TransitionProblem(logger, problem_id, action, user_id, role, request.Comment)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	response["success"] = true
+	response["state"] = problem.State
+	response["approvals"] = problem.Approvals
+}
+
+// Lists problems in every state, optionally filtered with ?state=.
+func adminListProblems(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminListProblems() entry.")
+	defer logger.Println("handler_admin.adminListProblems() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, staffRoles...); !ok {
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	problems, err := GetProblemSummaries(logger)
+	if err != nil {
+		writeAdminError(logger, w, response, err)
+		return
+	}
+	selected := make([]Problem, 0)
+	for _, problem := range problems {
+		if state == "" || problem.State == state {
+			selected = append(selected, problem)
+		}
+	}
+	response["success"] = true
+	response["problems"] = selected
+}
+
+func adminGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminGetCacheStats() entry.")
+	defer logger.Println("handler_admin.adminGetCacheStats() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, staffRoles...); !ok {
+		return
+	}
+	response["success"] = true
+	response["caches"] = AllCacheStats()
+}
+
+// Drops the cached copies of problem_id, or of every problem without it.
+// Other evaluator processes, such as -load-problems, can't invalidate this
+// one's caches, so call this after loading to skip waiting for -cache-ttl.
+func adminInvalidateCache(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	problem_id := r.URL.Query().Get("problem_id")
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_admin.adminInvalidateCache() entry. problem_id: %s", problem_id)
+	defer logger.Println("handler_admin.adminInvalidateCache() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, RoleAdmin); !ok {
+		return
+	}
+	InvalidateProblem(logger, problem_id)
+	response["success"] = true
+}
+
+func adminGetRunners(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminGetRunners() entry.")
+	defer logger.Println("handler_admin.adminGetRunners() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, staffRoles...); !ok {
+		return
+	}
+	response["success"] = true
+	response["runners"] = runnerClient.Status()
+	response["languages"] = runnerClient.FleetCapacity()
+}
+
+// Queue counts and the most recent dead letters, up to ?limit= (default 20).
+func adminGetJobs(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_admin.adminGetJobs() entry.")
+	defer logger.Println("handler_admin.adminGetJobs() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if _, ok := requireRole(w, r, response, staffRoles...); !ok {
+		return
+	}
+	if !requireJobQueue(w, response) {
+		return
+	}
+	limit := 20
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+		limit = value
+	}
+	stats, err := jobDispatcher.Queue.Stats()
+	if err != nil {
+		response["error"] = err.Error()
+		logger.Printf("failed to get job queue stats: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	dead_letters, err := jobDispatcher.Queue.DeadLetters(limit)
+	if err != nil {
+		response["error"] = err.Error()
+		logger.Printf("failed to get dead letters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	response["success"] = true
+	response["queue"] = stats
+	response["waiting"] = jobDispatcher.Waiting()
+	response["workers"] = jobDispatcher.Workers()
+	response["dead_letters"] = dead_letters
+}
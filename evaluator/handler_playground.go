@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type playground_run_struct struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+	Stdin    string `json:"stdin,omitempty"`
+	// Save the snippet and its output under a permalink.
+	Save   bool   `json:"save,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// Playground runs share the custom run rate limit, see allowRun.
+func runPlayground(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_playground.runPlayground() entry.")
+	defer logger.Println("handler_playground.runPlayground() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id := runUserId(r, false, "")
+	var t playground_run_struct
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPlaygroundCodeBytes+2*maxRunInputBytes)).Decode(&t); err != nil {
+		response["output"] = "<could not decode JSON POST request>"
+		logger.Printf("Could not decode JSON POST request")
+		w.WriteHeader(400)
+		return
+	}
+	if len(t.Code) > maxPlaygroundCodeBytes || len(t.Stdin) > maxRunInputBytes {
+		response["output"] = fmt.Sprintf("<code must be at most %d bytes and stdin at most %d bytes>",
+			maxPlaygroundCodeBytes, maxRunInputBytes)
+		w.WriteHeader(400)
+		return
+	}
+	if _, ok := findPlaygroundLanguage(t.Language); !ok {
+		response["output"] = fmt.Sprintf("<no healthy runner runs '%s'>", t.Language)
+		w.WriteHeader(400)
+		return
+	}
+	unit_test, err := playgroundUnitTest(t.Language, t.Stdin)
+	if err != nil {
+		response["output"] = fmt.Sprintf("<%s>", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	ticket, err := NewRunTicket(t.Ticket, defaultRunPriority, user_id, t.Language)
+	if err != nil {
+		response["output"] = fmt.Sprintf("<%s>", err)
+		w.WriteHeader(400)
+		return
+	}
+	if !allowRun(w, response, user_id) {
+		return
+	}
+	runner_response, ok := scheduleRun(logger, w, response, ticket, t.Language, t.Code, unit_test, nil)
+	if !ok {
+		return
+	}
+	response["success"] = runner_response.Success
+	response["output"] = runner_response.Output
+	if !t.Save {
+		return
+	}
+
+	snippet := PlaygroundSnippet{
+		Language: t.Language,
+		Code:     t.Code,
+		Stdin:    t.Stdin,
+		Success:  runner_response.Success,
+		Output:   runner_response.Output,
+	}
+	if session_user_id, _, ok := getSessionUser(r); ok {
+		snippet.UserId = session_user_id
+	}
+	if err := SavePlaygroundSnippet(logger, &snippet); err != nil {
+		// The run itself worked, so still show its output.
+		logger.Printf("failed to save playground snippet: %s", err)
+		response["error"] = "could not save the snippet."
+		return
+	}
+	response["id"] = snippet.Id
+	response["permalink"] = "/evaluator/playground/" + snippet.Id
+}
+
+func getPlaygroundSnippet(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_playground.getPlaygroundSnippet() entry. id: %s", id)
+	defer logger.Println("handler_playground.getPlaygroundSnippet() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+
+	snippet, err := GetPlaygroundSnippet(logger, id)
+	if _, ok := err.(PlaygroundSnippetNotFoundError); ok {
+		response["error"] = err.Error()
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		logger.Printf("failed to get playground snippet %s: %s", id, err)
+		response["error"] = "could not read the snippet."
+		w.WriteHeader(500)
+		return
+	}
+	// Saved snippets never change.
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	response["success"] = true
+	response["snippet"] = snippet
+}
+
+func getPlaygroundLanguages(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_playground.getPlaygroundLanguages() entry.")
+	defer logger.Println("handler_playground.getPlaygroundLanguages() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = true
+	response["languages"] = PlaygroundLanguages()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Custom runs let users debug against their own cases before submitting.
+// The code runs with the problem's support files, either with their input
+// as standard input or, on problems without hidden test data, with their
+// own unit test instead of the problem's. The result is only shown, never
+// recorded or cached, and since these are cheap to send they are rate
+// limited per user.
+
+// Java embeds the input as a string constant, which must stay under 64 KiB.
+const maxRunInputBytes = 16 * 1024
+
+// Code and input or a unit test, JSON-escaped.
+const maxRunBodyBytes = 256 * 1024
+
+// Set up in main from -run-rate-limit and -run-rate-window.
+var runRateLimiter = NewRateLimiter(10, time.Minute)
+
+type run_struct struct {
+	Code string `json:"code"`
+	// At most one of these. Without either the program gets no input.
+	Input    string `json:"input,omitempty"`
+	UnitTest string `json:"unit_test,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
+}
+
+func runCustom(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	vars := mux.Vars(r)
+	problem_id := vars["problem_id"]
+	language := vars["language"]
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_run.runCustom() entry. problem_id: %s, language: %s", problem_id, language)
+	defer logger.Println("handler_run.runCustom() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id := runUserId(r, false, "")
+	_, role, _ := getSessionUser(r)
+	summary, err := CachedProblemSummary(logger, problem_id)
+	if err == nil && !isProblemEvaluable(&summary, role) {
+		err = ProblemNotFoundError{problem_id}
+	}
+	if err != nil {
+		msg := fmt.Sprintf("GetProblemSummary threw error: %s", err)
+		response["output"] = msg
+		logger.Printf(msg)
+		w.WriteHeader(404)
+		return
+	}
+	// Only languages the problem can be evaluated in.
+	problem, err := CachedProblemUnitTest(logger, problem_id, language, summary.Version)
+	if err != nil {
+		msg := fmt.Sprintf("GetProblemUnitTest threw error: %s", err)
+		response["output"] = msg
+		logger.Printf(msg)
+		w.WriteHeader(400)
+		return
+	}
+
+	var t run_struct
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRunBodyBytes)).Decode(&t); err != nil {
+		response["output"] = "<could not decode JSON POST request>"
+		logger.Printf("Could not decode JSON POST request")
+		w.WriteHeader(400)
+		return
+	}
+	if t.Input != "" && t.UnitTest != "" {
+		response["output"] = "<send either input or a unit_test, not both>"
+		w.WriteHeader(400)
+		return
+	}
+	if len(t.Input) > maxRunInputBytes {
+		response["output"] = fmt.Sprintf("<input must be at most %d bytes>", maxRunInputBytes)
+		w.WriteHeader(400)
+		return
+	}
+	// Problems with hidden test data only run code under harnesses we wrote.
+	if t.UnitTest != "" && hasHiddenTestData(&summary, &problem) {
+		response["output"] = "<this problem has hidden test data, so runs can only send input>"
+		w.WriteHeader(403)
+		return
+	}
+	unit_test := t.UnitTest
+	if unit_test == "" {
+		var ok bool
+		if unit_test, ok = stdinRunner(language, t.Input); !ok {
+			response["output"] = fmt.Sprintf("<input can only be given in %s; send a unit_test instead>",
+				strings.Join(stdioHarnessLanguages, ", "))
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	ticket, err := NewRunTicket(t.Ticket, defaultRunPriority, user_id, language)
+	if err != nil {
+		response["output"] = fmt.Sprintf("<%s>", err)
+		w.WriteHeader(400)
+		return
+	}
+	files, err := SupportFiles(logger, &summary)
+	if err != nil {
+		msg := fmt.Sprintf("failed to read attachments: %s", err)
+		logger.Printf(msg)
+		response["output"] = msg
+		w.WriteHeader(500)
+		return
+	}
+	if !allowRun(w, response, user_id) {
+		return
+	}
+	runner_response, ok := scheduleRun(logger, w, response, ticket, language, t.Code, unit_test, files)
+	if !ok {
+		return
+	}
+	response["success"] = runner_response.Success
+	response["output"] = runner_response.Output
+	response["problem_version"] = summary.Version
+}
+
+// Takes one of user_id's runs, or writes a 429. Called once the run is
+// valid and about to be scheduled, so that bad requests cost nothing.
+func allowRun(w http.ResponseWriter, response map[string]interface{}, user_id string) bool {
+	ok, retry_after := runRateLimiter.Allow(user_id)
+	if ok {
+		return true
+	}
+	seconds := int(retry_after.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response["output"] = fmt.Sprintf("<too many runs, try again in %d seconds>", seconds)
+	logger.Printf("rate limited %s", user_id)
+	w.WriteHeader(429)
+	return false
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Registrations are tiny; anything bigger isn't from a runner.
+const maxRunnerRegistrationBytes = 64 * 1024
+
+// Runners may long-poll for a job this long; SQS won't wait longer anyway.
+const maxClaimWait = 20 * time.Second
+
+// Job results carry the program's output, which runners cap at 10 KiB.
+const maxJobResultBytes = 1024 * 1024
+
+// Shared by the runner-facing handlers: checks the token and decodes the
+// body into v, writing the error response if either fails.
+func decodeRunnerRequest(w http.ResponseWriter, r *http.Request, response map[string]interface{},
+	max_bytes int64, v interface{}) bool {
+	if !checkRunnerToken(r, *runnerRegistryToken) {
+		response["error"] = "missing or wrong X-Runner-Token, or registration is switched off."
+		logger.Printf(response["error"].(string))
+		w.WriteHeader(403)
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, max_bytes)).Decode(v); err != nil {
+		response["error"] = "could not decode JSON POST request."
+		logger.Printf("%s %s", response["error"], err)
+		w.WriteHeader(400)
+		return false
+	}
+	return true
+}
+
+func registerRunner(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_runners.registerRunner() entry.")
+	defer logger.Println("handler_runners.registerRunner() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	var reg RunnerRegistration
+	if !decodeRunnerRequest(w, r, response, maxRunnerRegistrationBytes, &reg) {
+		return
+	}
+	logger.Printf("runner %s registering. languages: %v, capacity: %d, version: %s",
+		reg.URL, reg.Languages, reg.Capacity, reg.Version)
+	if err := runnerClient.Register(reg); err != nil {
+		response["error"] = err.Error()
+		logger.Printf(response["error"].(string))
+		w.WriteHeader(400)
+		return
+	}
+	response["success"] = true
+	response["heartbeat_interval_seconds"] = int(runnerHeartbeatInterval.Seconds())
+}
+
+// A 404 tells the runner it has been evicted and must register again.
+func runnerHeartbeat(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_runners.runnerHeartbeat() entry.")
+	defer logger.Println("handler_runners.runnerHeartbeat() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	var reg RunnerRegistration
+	if !decodeRunnerRequest(w, r, response, maxRunnerRegistrationBytes, &reg) {
+		return
+	}
+	if err := runnerClient.Heartbeat(reg.URL, reg.InFlight); err != nil {
+		response["error"] = err.Error()
+		logger.Printf(response["error"].(string))
+		w.WriteHeader(404)
+		return
+	}
+	response["success"] = true
+}
+
+func deregisterRunner(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_runners.deregisterRunner() entry.")
+	defer logger.Println("handler_runners.deregisterRunner() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	var reg RunnerRegistration
+	if !decodeRunnerRequest(w, r, response, maxRunnerRegistrationBytes, &reg) {
+		return
+	}
+	if err := runnerClient.Deregister(reg.URL); err != nil {
+		response["error"] = err.Error()
+		logger.Printf(response["error"].(string))
+		w.WriteHeader(404)
+		return
+	}
+	response["success"] = true
+}
+
+type claim_job_struct struct {
+	// Unique to each of the runner's pull workers.
+	Worker      string   `json:"worker"`
+	Languages   []string `json:"languages"`
+	WaitSeconds int      `json:"wait_seconds"`
+}
+
+type finish_job_struct struct {
+	Id       string                 `json:"id"`
+	Receipt  string                 `json:"receipt"`
+	Response runner_response_struct `json:"response"`
+	Error    string                 `json:"error"`
+}
+
+// Long-polls for a job in one of the runner's languages. The response has
+// no job if none turned up within wait_seconds.
+func claimJob(w http.ResponseWriter, r *http.Request) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Println("handler_runners.claimJob() entry.")
+	defer logger.Println("handler_runners.claimJob() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if !requireJobQueue(w, response) {
+		return
+	}
+	var t claim_job_struct
+	if !decodeRunnerRequest(w, r, response, maxRunnerRegistrationBytes, &t) {
+		return
+	}
+	wait := time.Duration(t.WaitSeconds) * time.Second
+	if wait > maxClaimWait {
+		wait = maxClaimWait
+	}
+	job, err := jobDispatcher.Claim(t.Worker, t.Languages, wait)
+	if err != nil {
+		response["error"] = err.Error()
+		logger.Printf("failed to claim job: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	response["success"] = true
+	if job != nil {
+		logger.Printf("claimed job %s, attempt %d", job.Id, job.Attempts)
+		response["job"] = job
+	}
+}
+
+func completeJob(w http.ResponseWriter, r *http.Request) {
+	finishJob(w, r, true)
+}
+
+func failJob(w http.ResponseWriter, r *http.Request) {
+	finishJob(w, r, false)
+}
+
+// A 409 tells the runner its visibility timeout passed and the job is
+// someone else's now.
+func finishJob(w http.ResponseWriter, r *http.Request, succeeded bool) {
+	commonHandlerSetup(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = getLogger(getLogPill())
+	logger.Printf("handler_runners.finishJob() entry. succeeded: %t", succeeded)
+	defer logger.Println("handler_runners.finishJob() exit.")
+
+	response := map[string]interface{}{}
+	defer writeJSONResponse(logger, response, w)
+	response["success"] = false
+	if !requireJobQueue(w, response) {
+		return
+	}
+	var t finish_job_struct
+	if !decodeRunnerRequest(w, r, response, maxJobResultBytes, &t) {
+		return
+	}
+	var err error
+	if succeeded {
+		err = jobDispatcher.Complete(logger, t.Id, t.Receipt, t.Response)
+	} else {
+		err = jobDispatcher.Fail(logger, t.Id, t.Receipt, t.Error)
+	}
+	switch err.(type) {
+	case nil:
+		response["success"] = true
+	case JobNotFoundError:
+		response["error"] = err.Error()
+		w.WriteHeader(404)
+	case StaleReceiptError:
+		response["error"] = err.Error()
+		w.WriteHeader(409)
+	default:
+		response["error"] = err.Error()
+		logger.Printf("failed to finish job %s: %s", t.Id, err)
+		w.WriteHeader(500)
+	}
+}
+
+func requireJobQueue(w http.ResponseWriter, response map[string]interface{}) bool {
+	if jobDispatcher == nil {
+		response["error"] = "the evaluator is not dispatching through a job queue."
+		w.WriteHeader(404)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Problems only change by being re-put as a new version, so a response for
+// a problem version can be revalidated rather than refetched. The ETag is
+// the version plus a hash of the body, since a few fields such as the state
+// and acceptance rate change without a new version and the body also
+// depends on the locale. It is weak because the same body may be sent
+// gzipped or not.
+func problemETag(version int, body []byte) string {
+	return fmt.Sprintf(`W/"%d-%s"`, version, sha256Hex(body)[:16])
+}
+
+// Writes body with ETag and Last-Modified headers, or a bodiless 304 if the
+// request's If-None-Match or If-Modified-Since shows the client already has
+// it. Responses about problems that aren't public must not be kept by shared
+// caches such as the CDN.
+func writeCacheableResponse(logger *log.Logger, w http.ResponseWriter, r *http.Request, version int,
+	last_modified *time.Time, public bool, body []byte) {
+	logger.Printf("writeCacheableResponse entry. version: %d, public: %t", version, public)
+	defer logger.Printf("writeCacheableResponse exit.")
+
+	etag := problemETag(version, body)
+	w.Header().Set("ETag", etag)
+	if last_modified != nil {
+		w.Header().Set("Last-Modified", last_modified.UTC().Format(http.TimeFormat))
+	}
+	if public {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Content-Language")
+
+	if isNotModified(r, etag, last_modified) {
+		logger.Printf("not modified since etag %s", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	io.WriteString(w, string(body))
+}
+
+// If-None-Match wins over If-Modified-Since when both are sent, as RFC 7232
+// requires.
+func isNotModified(r *http.Request, etag string, last_modified *time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if if_none_match := r.Header.Get("If-None-Match"); if_none_match != "" {
+		return etagListMatches(if_none_match, etag)
+	}
+	if_modified_since := r.Header.Get("If-Modified-Since")
+	if if_modified_since == "" || last_modified == nil {
+		return false
+	}
+	since, err := http.ParseTime(if_modified_since)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have whole seconds.
+	return !last_modified.Truncate(time.Second).After(since)
+}
+
+// Weak comparison, so that W/"x" matches "x".
+func etagListMatches(list string, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
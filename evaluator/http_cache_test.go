@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagListMatches(t *testing.T) {
+	etag := `W/"3-0123456789abcdef"`
+	tests := []struct {
+		name  string
+		list  string
+		match bool
+	}{
+		{"same", `W/"3-0123456789abcdef"`, true},
+		{"strong form of weak tag", `"3-0123456789abcdef"`, true},
+		{"in a list", `"1-aaaa", W/"3-0123456789abcdef"`, true},
+		{"wildcard", `*`, true},
+		{"other version", `W/"2-0123456789abcdef"`, false},
+		{"other body", `W/"3-fedcba9876543210"`, false},
+		{"none in list", `"1-aaaa", "2-bbbb"`, false},
+	}
+	for _, test := range tests {
+		if match := etagListMatches(test.list, etag); match != test.match {
+			t.Errorf("%s: etagListMatches(%s) = %v, want %v", test.name, test.list, match, test.match)
+		}
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	body := []byte(`{"id": "two_sum"}`)
+	etag := problemETag(3, body)
+	modified := time.Date(2020, 3, 1, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name              string
+		method            string
+		if_none_match     string
+		if_modified_since string
+		last_modified     *time.Time
+		not_modified      bool
+	}{
+		{"no conditions", "GET", "", "", &modified, false},
+		{"etag matches", "GET", etag, "", &modified, true},
+		{"etag matches on HEAD", "HEAD", etag, "", &modified, true},
+		{"etag of another body", "GET", problemETag(3, []byte("{}")), "", &modified, false},
+		{"etag of another version", "GET", problemETag(4, body), "", &modified, false},
+		{"not a GET", "POST", etag, "", &modified, false},
+		{"modified since wins only without etag", "GET", `"other"`, "Sun, 01 Mar 2020 12:00:00 GMT", &modified, false},
+		{"not modified since", "GET", "", "Sun, 01 Mar 2020 12:00:00 GMT", &modified, true},
+		{"modified since", "GET", "", "Sun, 01 Mar 2020 11:59:59 GMT", &modified, false},
+		{"unparseable date", "GET", "", "yesterday", &modified, false},
+		{"no last modified", "GET", "", "Sun, 01 Mar 2020 12:00:00 GMT", nil, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/evaluator/get_problem_summary/two_sum", nil)
+		if test.if_none_match != "" {
+			r.Header.Set("If-None-Match", test.if_none_match)
+		}
+		if test.if_modified_since != "" {
+			r.Header.Set("If-Modified-Since", test.if_modified_since)
+		}
+		if not_modified := isNotModified(r, etag, test.last_modified); not_modified != test.not_modified {
+			t.Errorf("%s: isNotModified = %v, want %v", test.name, not_modified, test.not_modified)
+		}
+	}
+}
+
+func TestWriteCacheableResponse(t *testing.T) {
+	body := []byte(`{"id": "two_sum"}`)
+	etag := problemETag(3, body)
+	tests := []struct {
+		name          string
+		if_none_match string
+		public        bool
+		status        int
+		cache_control string
+		body          string
+	}{
+		{"public", "", true, http.StatusOK, "public, no-cache", string(body)},
+		{"private", "", false, http.StatusOK, "private, no-cache", string(body)},
+		{"revalidated", etag, true, http.StatusNotModified, "public, no-cache", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/evaluator/get_problem_summary/two_sum", nil)
+		if test.if_none_match != "" {
+			r.Header.Set("If-None-Match", test.if_none_match)
+		}
+		w := httptest.NewRecorder()
+		writeCacheableResponse(logger, w, r, 3, nil, test.public, body)
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Errorf("%s: ETag = %s, want %s", test.name, got, etag)
+		}
+		if got := w.Header().Get("Cache-Control"); got != test.cache_control {
+			t.Errorf("%s: Cache-Control = %s, want %s", test.name, got, test.cache_control)
+		}
+		if got := w.Body.String(); got != test.body {
+			t.Errorf("%s: body = %q, want %q", test.name, got, test.body)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Puts evaluations on the job queue and waits for a runner to report back.
+// Submitters wait in this process, so a runner must report to the evaluator
+// that enqueued the job: with more than one evaluator behind a load
+// balancer, results for another evaluator's jobs are acked and dropped.
+type JobDispatcher struct {
+	Queue JobQueue
+	// How long a runner has to finish a job before it is given to another.
+	Visibility  time.Duration
+	MaxAttempts int
+	// How long a submitter waits, including time in the queue.
+	Deadline time.Duration
+	// Before a failed job is tried again.
+	RetryDelay time.Duration
+
+	mutex   sync.Mutex
+	waiters map[string]chan jobOutcome
+	// Pull workers by ID, and when each last claimed a job.
+	workers map[string]time.Time
+}
+
+type jobOutcome struct {
+	response *runner_response_struct
+	err      error
+}
+
+// Set up in main when -dispatch is queue.
+var jobDispatcher *JobDispatcher
+
+func NewJobDispatcher(queue JobQueue, visibility time.Duration, max_attempts int, deadline time.Duration) *JobDispatcher {
+	d := &JobDispatcher{
+		Queue:       queue,
+		Visibility:  visibility,
+		MaxAttempts: max_attempts,
+		Deadline:    deadline,
+		RetryDelay:  2 * time.Second,
+		waiters:     make(map[string]chan jobOutcome),
+		workers:     make(map[string]time.Time),
+	}
+	queue.OnDeadLetter(d.deadLettered)
+	return d
+}
+
+// Like RunnerClient.Run, through the queue.
+func (d *JobDispatcher) Run(logger *log.Logger, language string, code string, unit_test string,
+	files map[string][]byte) (*runner_response_struct, error) {
+	job := &Job{
+		Id:          newJobId(),
+		Language:    language,
+		Code:        code,
+		UnitTest:    unit_test,
+		Files:       files,
+		MaxAttempts: d.MaxAttempts,
+		EnqueuedAt:  time.Now(),
+	}
+	job.Deadline = job.EnqueuedAt.Add(d.Deadline)
+	logger.Printf("JobDispatcher.Run entry. language: %s, job: %s", language, job.Id)
+	defer logger.Printf("JobDispatcher.Run exit. job: %s", job.Id)
+
+	outcome := make(chan jobOutcome, 1)
+	d.mutex.Lock()
+	d.waiters[job.Id] = outcome
+	d.mutex.Unlock()
+	defer func() {
+		d.mutex.Lock()
+		delete(d.waiters, job.Id)
+		d.mutex.Unlock()
+	}()
+
+	if err := d.Queue.Enqueue(job); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(d.Deadline)
+	defer timer.Stop()
+	select {
+	case o := <-outcome:
+		return o.response, o.err
+	case <-timer.C:
+		// The queue drops the job if it is still there. If a runner has
+		// it already, its result is dropped.
+		return nil, RunnerUnavailableError{language, 0,
+			fmt.Errorf("job %s didn't finish within %s", job.Id, d.Deadline)}
+	}
+}
+
+// worker identifies the runner's pull worker, so that run slots can be
+// counted; it is seen when the claim starts and when it returns.
+func (d *JobDispatcher) Claim(worker string, languages []string, wait time.Duration) (*Job, error) {
+	d.sawWorker(worker)
+	defer d.sawWorker(worker)
+	return d.Queue.Receive(languages, d.Visibility, wait)
+}
+
+func (d *JobDispatcher) sawWorker(worker string) {
+	if worker == "" {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.workers[worker] = time.Now()
+}
+
+// Pull workers seen lately. A live worker claims again at most one claim
+// wait after its last claim returned, or one visibility timeout if it got
+// a job, so anything quieter than both together is gone.
+func (d *JobDispatcher) Workers() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for worker, seen := range d.workers {
+		if time.Since(seen) > d.Visibility+maxClaimWait {
+			delete(d.workers, worker)
+		}
+	}
+	return len(d.workers)
+}
+
+// Acks the job and hands its result to the submitter, if it's still
+// waiting. A stale receipt means another runner has the job now, so this
+// result is ignored in favour of that one.
+func (d *JobDispatcher) Complete(logger *log.Logger, job_id string, receipt string, response runner_response_struct) error {
+	logger.Printf("JobDispatcher.Complete entry. job: %s", job_id)
+	defer logger.Printf("JobDispatcher.Complete exit.")
+	if err := d.Queue.Ack(job_id, receipt); err != nil {
+		return err
+	}
+	if !d.deliver(job_id, jobOutcome{&response, nil}) {
+		logger.Printf("nobody is waiting for job %s any more", job_id)
+	}
+	return nil
+}
+
+func (d *JobDispatcher) Fail(logger *log.Logger, job_id string, receipt string, reason string) error {
+	logger.Printf("JobDispatcher.Fail entry. job: %s, reason: %s", job_id, reason)
+	defer logger.Printf("JobDispatcher.Fail exit.")
+	return d.Queue.Fail(job_id, receipt, reason, d.RetryDelay)
+}
+
+func (d *JobDispatcher) deadLettered(job Job) {
+	logger.Printf("job %s dead-lettered after %d attempts: %s", job.Id, job.Attempts, job.LastError)
+	d.deliver(job.Id, jobOutcome{nil, RunnerUnavailableError{job.Language, job.Attempts, errors.New(job.LastError)}})
+}
+
+// Returns false if nobody is waiting for the job.
+func (d *JobDispatcher) deliver(job_id string, outcome jobOutcome) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	waiter, present := d.waiters[job_id]
+	if present == false {
+		return false
+	}
+	select {
+	case waiter <- outcome:
+	default:
+	}
+	return true
+}
+
+// Submitters waiting in this process.
+func (d *JobDispatcher) Waiting() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.waiters)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// With -dispatch queue, evaluations are put on a job queue rather than
+// posted to a runner, and runners pull jobs off it when they have a free
+// slot. A burst of submissions then waits in the queue instead of piling up
+// on the runners.
+//
+// A job received by a runner is invisible to other runners until it is
+// acknowledged, failed, or its visibility timeout passes, after which it is
+// received again. Each receive counts as an attempt; a job received more
+// than MaxAttempts times is moved to the dead letters instead.
+
+type Job struct {
+	Id       string            `json:"id"`
+	Language string            `json:"language"`
+	Code     string            `json:"code"`
+	UnitTest string            `json:"unit_test"`
+	Files    map[string][]byte `json:"files,omitempty"`
+	// Receives so far, including the current one.
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	// When the submitter stops waiting. Jobs still queued then are dropped
+	// rather than run for nobody.
+	Deadline time.Time `json:"deadline,omitempty"`
+
+	// Identifies one receive of the job. Acks and failures must present it,
+	// so that a runner whose visibility timeout passed can't ack a job
+	// another runner has since received.
+	Receipt string `json:"receipt,omitempty"`
+}
+
+type JobQueue interface {
+	Enqueue(job *Job) error
+	// Waits up to wait for a job in one of languages, returning nil if
+	// there is none. The job stays invisible for visibility. Jobs past
+	// their deadline are deleted, not returned.
+	Receive(languages []string, visibility time.Duration, wait time.Duration) (*Job, error)
+	// The job succeeded; it is deleted.
+	Ack(job_id string, receipt string) error
+	// The job failed; it will be received again after delay, unless it
+	// has run out of attempts.
+	Fail(job_id string, receipt string, reason string, delay time.Duration) error
+	Stats() (JobQueueStats, error)
+	DeadLetters(limit int) ([]Job, error)
+	// Called with each job moved to the dead letters.
+	OnDeadLetter(fn func(job Job))
+}
+
+type JobQueueStats struct {
+	Kind        string         `json:"kind"`
+	Queued      map[string]int `json:"queued"`
+	InFlight    int            `json:"in_flight"`
+	DeadLetters int            `json:"dead_letters"`
+}
+
+type JobNotFoundError struct {
+	Id string
+}
+
+func (e JobNotFoundError) Error() string {
+	return fmt.Sprintf("job '%s' not found", e.Id)
+}
+
+// The receipt is from an earlier receive, whose visibility timeout has
+// passed.
+type StaleReceiptError struct {
+	Id string
+}
+
+func (e StaleReceiptError) Error() string {
+	return fmt.Sprintf("receipt for job '%s' is stale", e.Id)
+}
+
+func NewJobQueue(kind string, sqs_queue_url_prefix string, sqs_region string) (JobQueue, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryJobQueue(), nil
+	case "sqs":
+		if sqs_queue_url_prefix == "" {
+			return nil, fmt.Errorf("the sqs job queue needs a queue URL prefix")
+		}
+		return &SQSJobQueue{
+			QueueURLPrefix: sqs_queue_url_prefix,
+			Region:         sqs_region,
+			Credentials:    awsCredentialsFromEnvironment(),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown job queue '%s'", kind)
+}
+
+func (job *Job) overdue(now time.Time) bool {
+	return !job.Deadline.IsZero() && now.After(job.Deadline)
+}
+
+func newJobId() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Dead letters kept by MemoryJobQueue, oldest dropped first.
+const maxMemoryDeadLetters = 1000
+
+// A JobQueue inside the evaluator process. Jobs are lost if it restarts, in
+// which case their submitters' requests fail too.
+type MemoryJobQueue struct {
+	mutex     sync.Mutex
+	waiting   []*memoryJob
+	in_flight map[string]*memoryJob
+	dead      []Job
+	// Closed and replaced whenever a job becomes available, to wake
+	// receivers.
+	notify         chan struct{}
+	on_dead_letter func(job Job)
+}
+
+type memoryJob struct {
+	job Job
+	// While waiting, when the job may be received; while in flight, when
+	// its visibility timeout passes.
+	visible_at time.Time
+}
+
+func NewMemoryJobQueue() *MemoryJobQueue {
+	return &MemoryJobQueue{
+		in_flight: make(map[string]*memoryJob),
+		notify:    make(chan struct{}),
+	}
+}
+
+func (q *MemoryJobQueue) Enqueue(job *Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.waiting = append(q.waiting, &memoryJob{job: *job, visible_at: job.EnqueuedAt})
+	q.wake()
+	return nil
+}
+
+func (q *MemoryJobQueue) Receive(languages []string, visibility time.Duration, wait time.Duration) (*Job, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		q.mutex.Lock()
+		now := time.Now()
+		q.requeueExpired(now)
+		mj, dead := q.next(languages, now)
+		var next_visible time.Time
+		if mj != nil {
+			mj.job.Receipt = randomHex(16)
+			mj.visible_at = now.Add(visibility)
+			q.in_flight[mj.job.Id] = mj
+		} else {
+			next_visible = q.nextVisible(languages, now)
+		}
+		notify := q.notify
+		q.mutex.Unlock()
+		q.deadLettered(dead)
+
+		if mj != nil {
+			job := mj.job
+			return &job, nil
+		}
+		remaining := deadline.Sub(now)
+		if remaining <= 0 {
+			return nil, nil
+		}
+		if !next_visible.IsZero() && next_visible.Sub(now) < remaining {
+			remaining = next_visible.Sub(now)
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Removes and returns the first visible job in languages, counting the
+// receive as an attempt. Jobs out of attempts are removed and returned as
+// dead instead, and overdue jobs are just removed. Callers hold the mutex.
+func (q *MemoryJobQueue) next(languages []string, now time.Time) (*memoryJob, []Job) {
+	dead := make([]Job, 0)
+	for i := 0; i < len(q.waiting); i++ {
+		mj := q.waiting[i]
+		if mj.visible_at.After(now) || !containsString(languages, mj.job.Language) {
+			continue
+		}
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		i -= 1
+		if mj.job.overdue(now) {
+			continue
+		}
+		mj.job.Attempts += 1
+		if mj.job.Attempts > mj.job.MaxAttempts {
+			if mj.job.LastError == "" {
+				mj.job.LastError = "visibility timeout passed on every attempt"
+			}
+			q.bury(mj.job)
+			dead = append(dead, mj.job)
+			continue
+		}
+		return mj, dead
+	}
+	return nil, dead
+}
+
+// Puts jobs whose visibility timeout has passed back in the queue. Callers
+// hold the mutex.
+func (q *MemoryJobQueue) requeueExpired(now time.Time) {
+	for id, mj := range q.in_flight {
+		if mj.visible_at.After(now) {
+			continue
+		}
+		delete(q.in_flight, id)
+		mj.job.Receipt = ""
+		mj.job.LastError = "visibility timeout passed"
+		q.waiting = append(q.waiting, mj)
+	}
+}
+
+// When the next waiting or in-flight job in languages becomes receivable,
+// or zero if none will. Callers hold the mutex.
+func (q *MemoryJobQueue) nextVisible(languages []string, now time.Time) time.Time {
+	var next time.Time
+	consider := func(mj *memoryJob) {
+		if mj.visible_at.After(now) && containsString(languages, mj.job.Language) &&
+			(next.IsZero() || mj.visible_at.Before(next)) {
+			next = mj.visible_at
+		}
+	}
+	for _, mj := range q.waiting {
+		consider(mj)
+	}
+	for _, mj := range q.in_flight {
+		consider(mj)
+	}
+	return next
+}
+
+// Returns the in-flight job with id if receipt is its current one. Callers
+// hold the mutex.
+func (q *MemoryJobQueue) received(job_id string, receipt string) (*memoryJob, error) {
+	mj, present := q.in_flight[job_id]
+	if present == false {
+		for _, waiting := range q.waiting {
+			if waiting.job.Id == job_id {
+				return nil, StaleReceiptError{job_id}
+			}
+		}
+		return nil, JobNotFoundError{job_id}
+	}
+	if mj.job.Receipt != receipt {
+		return nil, StaleReceiptError{job_id}
+	}
+	return mj, nil
+}
+
+func (q *MemoryJobQueue) Ack(job_id string, receipt string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, err := q.received(job_id, receipt); err != nil {
+		return err
+	}
+	delete(q.in_flight, job_id)
+	return nil
+}
+
+func (q *MemoryJobQueue) Fail(job_id string, receipt string, reason string, delay time.Duration) error {
+	q.mutex.Lock()
+	mj, err := q.received(job_id, receipt)
+	if err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	delete(q.in_flight, job_id)
+	mj.job.Receipt = ""
+	mj.job.LastError = reason
+	if mj.job.Attempts >= mj.job.MaxAttempts {
+		q.bury(mj.job)
+		q.mutex.Unlock()
+		q.deadLettered([]Job{mj.job})
+		return nil
+	}
+	mj.visible_at = time.Now().Add(delay)
+	q.waiting = append(q.waiting, mj)
+	q.wake()
+	q.mutex.Unlock()
+	return nil
+}
+
+func (q *MemoryJobQueue) Stats() (JobQueueStats, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.requeueExpired(time.Now())
+	stats := JobQueueStats{
+		Kind:        "memory",
+		Queued:      make(map[string]int),
+		InFlight:    len(q.in_flight),
+		DeadLetters: len(q.dead),
+	}
+	now := time.Now()
+	for _, mj := range q.waiting {
+		if !mj.job.overdue(now) {
+			stats.Queued[mj.job.Language] += 1
+		}
+	}
+	return stats, nil
+}
+
+// Newest first.
+func (q *MemoryJobQueue) DeadLetters(limit int) ([]Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	jobs := make([]Job, 0, limit)
+	for i := len(q.dead) - 1; i >= 0 && len(jobs) < limit; i-- {
+		jobs = append(jobs, q.dead[i])
+	}
+	return jobs, nil
+}
+
+func (q *MemoryJobQueue) OnDeadLetter(fn func(job Job)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.on_dead_letter = fn
+}
+
+// Attachments can be large and are in the blob store anyway, so dead
+// letters don't keep them. Callers hold the mutex.
+func (q *MemoryJobQueue) bury(job Job) {
+	job.Files = nil
+	q.dead = append(q.dead, job)
+	if len(q.dead) > maxMemoryDeadLetters {
+		q.dead = q.dead[len(q.dead)-maxMemoryDeadLetters:]
+	}
+}
+
+// Callers must not hold the mutex, since the callback may be slow.
+func (q *MemoryJobQueue) deadLettered(jobs []Job) {
+	q.mutex.Lock()
+	fn := q.on_dead_letter
+	q.mutex.Unlock()
+	if fn == nil {
+		return
+	}
+	for _, job := range jobs {
+		fn(job)
+	}
+}
+
+// Callers hold the mutex.
+func (q *MemoryJobQueue) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
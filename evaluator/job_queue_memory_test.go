@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func enqueueTestJob(q *MemoryJobQueue, id string, language string, max_attempts int) {
+	q.Enqueue(&Job{
+		Id:          id,
+		Language:    language,
+		MaxAttempts: max_attempts,
+		EnqueuedAt:  time.Now(),
+	})
+}
+
+func TestMemoryJobQueueReceive(t *testing.T) {
+	tests := []struct {
+		name      string
+		languages []string
+		received  string
+	}{
+		{"first in order", []string{"python", "java"}, "a"},
+		{"only listed languages", []string{"java"}, "b"},
+		{"none in language", []string{"ruby"}, ""},
+	}
+	for _, test := range tests {
+		q := NewMemoryJobQueue()
+		enqueueTestJob(q, "a", "python", 3)
+		enqueueTestJob(q, "b", "java", 3)
+		job, err := q.Receive(test.languages, time.Minute, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		received := ""
+		if job != nil {
+			received = job.Id
+		}
+		if received != test.received {
+			t.Errorf("%s: received %q, want %q", test.name, received, test.received)
+		}
+	}
+}
+
+func TestMemoryJobQueueVisibility(t *testing.T) {
+	tests := []struct {
+		name string
+		// Ages the in-flight receipt past its visibility timeout.
+		expire bool
+		// How the first receiver finishes: "ack", "fail" or "".
+		finish      string
+		received    bool
+		ack_err     error
+		in_flight   int
+		queued      int
+		dead_letter int
+	}{
+		{"invisible while in flight", false, "", false, nil, 1, 0, 0},
+		{"received again after timeout", true, "", true, StaleReceiptError{"a"}, 1, 0, 0},
+		{"acked job is gone", false, "ack", false, JobNotFoundError{"a"}, 0, 0, 0},
+		{"failed job is received again", false, "fail", true, StaleReceiptError{"a"}, 1, 0, 0},
+	}
+	for _, test := range tests {
+		q := NewMemoryJobQueue()
+		enqueueTestJob(q, "a", "python", 3)
+		first, _ := q.Receive([]string{"python"}, time.Minute, 0)
+		if test.expire {
+			q.in_flight["a"].visible_at = time.Now().Add(-time.Second)
+		}
+		switch test.finish {
+		case "ack":
+			if err := q.Ack("a", first.Receipt); err != nil {
+				t.Fatalf("%s: Ack: %s", test.name, err)
+			}
+		case "fail":
+			if err := q.Fail("a", first.Receipt, "container broke", 0); err != nil {
+				t.Fatalf("%s: Fail: %s", test.name, err)
+			}
+		}
+		second, _ := q.Receive([]string{"python"}, time.Minute, 0)
+		if (second != nil) != test.received {
+			t.Errorf("%s: received again = %v, want %v", test.name, second != nil, test.received)
+		}
+		if second != nil && second.Attempts != 2 {
+			t.Errorf("%s: attempts = %d, want 2", test.name, second.Attempts)
+		}
+		// The first receipt is no good once the job has moved on.
+		if test.finish != "" || second != nil {
+			if err := q.Ack("a", first.Receipt); err != test.ack_err {
+				t.Errorf("%s: Ack with first receipt = %v, want %v", test.name, err, test.ack_err)
+			}
+		}
+		stats, _ := q.Stats()
+		if stats.InFlight != test.in_flight || stats.Queued["python"] != test.queued ||
+			stats.DeadLetters != test.dead_letter {
+			t.Errorf("%s: stats = %+v, want %d in flight, %d queued, %d dead", test.name, stats,
+				test.in_flight, test.queued, test.dead_letter)
+		}
+	}
+}
+
+func TestMemoryJobQueueDeadLetters(t *testing.T) {
+	tests := []struct {
+		name string
+		// Per receive, "fail" or "expire"; each is one attempt.
+		attempts   []string
+		dead       bool
+		last_error string
+	}{
+		{"attempts left", []string{"fail", "fail"}, false, ""},
+		{"failed on the last attempt", []string{"fail", "fail", "fail"}, true, "container broke"},
+		{"timed out on every attempt", []string{"expire", "expire", "expire"}, true, "visibility timeout passed"},
+	}
+	for _, test := range tests {
+		q := NewMemoryJobQueue()
+		buried := make([]Job, 0)
+		q.OnDeadLetter(func(job Job) { buried = append(buried, job) })
+		enqueueTestJob(q, "a", "python", 3)
+		for _, attempt := range test.attempts {
+			job, _ := q.Receive([]string{"python"}, time.Minute, 0)
+			if job == nil {
+				t.Fatalf("%s: job not received for attempt", test.name)
+			}
+			if attempt == "fail" {
+				q.Fail(job.Id, job.Receipt, "container broke", 0)
+			} else {
+				q.in_flight[job.Id].visible_at = time.Now().Add(-time.Second)
+			}
+		}
+		// Jobs that ran out of attempts on a timeout are buried when next
+		// received.
+		job, _ := q.Receive([]string{"python"}, time.Minute, 0)
+		if test.dead && job != nil {
+			t.Errorf("%s: dead job received", test.name)
+		}
+		if !test.dead && job == nil {
+			t.Errorf("%s: job with attempts left not received", test.name)
+		}
+		if (len(buried) == 1) != test.dead {
+			t.Errorf("%s: %d dead-lettered, want dead %v", test.name, len(buried), test.dead)
+		}
+		if test.dead && len(buried) == 1 && buried[0].LastError != test.last_error {
+			t.Errorf("%s: last error = %q, want %q", test.name, buried[0].LastError, test.last_error)
+		}
+		dead_letters, _ := q.DeadLetters(10)
+		if (len(dead_letters) == 1) != test.dead {
+			t.Errorf("%s: DeadLetters = %d jobs, want dead %v", test.name, len(dead_letters), test.dead)
+		}
+	}
+}
+
+func TestMemoryJobQueueDropsOverdueJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Time
+		received bool
+	}{
+		{"no deadline", time.Time{}, true},
+		{"before deadline", time.Now().Add(time.Minute), true},
+		{"past deadline", time.Now().Add(-time.Second), false},
+	}
+	for _, test := range tests {
+		q := NewMemoryJobQueue()
+		q.Enqueue(&Job{Id: "a", Language: "python", MaxAttempts: 3, EnqueuedAt: time.Now(), Deadline: test.deadline})
+		job, _ := q.Receive([]string{"python"}, time.Minute, 0)
+		if (job != nil) != test.received {
+			t.Errorf("%s: received = %v, want %v", test.name, job != nil, test.received)
+		}
+		stats, _ := q.Stats()
+		if stats.Queued["python"] != 0 || stats.DeadLetters != 0 {
+			t.Errorf("%s: stats = %+v, want nothing queued or dead", test.name, stats)
+		}
+	}
+}
+
+func TestMemoryJobQueueReceiveWaits(t *testing.T) {
+	q := NewMemoryJobQueue()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		enqueueTestJob(q, "a", "python", 3)
+	}()
+	job, _ := q.Receive([]string{"python"}, time.Minute, time.Second)
+	if job == nil || job.Id != "a" {
+		t.Errorf("Receive = %+v, want job a enqueued while waiting", job)
+	}
+}
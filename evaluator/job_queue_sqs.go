@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQS won't take bigger message bodies.
+const maxSQSMessageBytes = 256 * 1024
+
+// A JobQueue on Amazon SQS, or anything that speaks its query API such as a
+// local ElasticMQ. Each language has its own queue, QueueURLPrefix followed
+// by the language, e.g. .../evaluator-jobs-python, and dead letters go to
+// QueueURLPrefix followed by "dead_letters". The queues must already exist.
+//
+// Attempts are SQS's ApproximateReceiveCount. Dead-lettering is done here
+// rather than with a redrive policy so that the evaluator hears about it.
+type SQSJobQueue struct {
+	QueueURLPrefix string
+	Region         string
+	Credentials    awsCredentials
+
+	mutex sync.Mutex
+	// Jobs this process has handed out, by receipt, so that a failure on
+	// the last attempt can be dead-lettered at once.
+	received       map[string]sqsReceivedJob
+	languages      map[string]bool
+	on_dead_letter func(job Job)
+}
+
+type sqsReceivedJob struct {
+	job Job
+	// When the visibility timeout passes and the receipt is useless.
+	expires time.Time
+}
+
+type sqsAttribute struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+type sqsMessage struct {
+	MessageId     string         `xml:"MessageId"`
+	ReceiptHandle string         `xml:"ReceiptHandle"`
+	Body          string         `xml:"Body"`
+	Attributes    []sqsAttribute `xml:"Attribute"`
+}
+
+type sqsReceiveMessageResponse struct {
+	Messages []sqsMessage `xml:"ReceiveMessageResult>Message"`
+}
+
+type sqsGetQueueAttributesResponse struct {
+	Attributes []sqsAttribute `xml:"GetQueueAttributesResult>Attribute"`
+}
+
+type sqsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+type SQSError struct {
+	Action     string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e SQSError) Error() string {
+	return fmt.Sprintf("SQS %s returned HTTP %d: %s: %s", e.Action, e.StatusCode, e.Code, e.Message)
+}
+
+func (q *SQSJobQueue) queueURL(language string) string {
+	return q.QueueURLPrefix + language
+}
+
+func (q *SQSJobQueue) deadLetterQueueURL() string {
+	return q.QueueURLPrefix + "dead_letters"
+}
+
+// Receipts are the language, so that the right queue can be found, and
+// SQS's receipt handle.
+func sqsReceipt(language string, receipt_handle string) string {
+	return language + "|" + receipt_handle
+}
+
+func parseSQSReceipt(job_id string, receipt string) (string, string, error) {
+	parts := strings.SplitN(receipt, "|", 2)
+	if len(parts) != 2 {
+		return "", "", StaleReceiptError{job_id}
+	}
+	return parts[0], parts[1], nil
+}
+
+func (q *SQSJobQueue) call(queue_url string, action string, params url.Values, result interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", "2012-11-05")
+	body := []byte(params.Encode())
+	u, err := url.Parse(queue_url)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	canonical_uri := u.EscapedPath()
+	if canonical_uri == "" {
+		canonical_uri = "/"
+	}
+	signAWSv4(request, "sqs", q.Region, q.Credentials, canonical_uri, body, time.Now().UTC())
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	response_body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var error_response sqsErrorResponse
+		xml.Unmarshal(response_body, &error_response)
+		return SQSError{action, resp.StatusCode, error_response.Code, error_response.Message}
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(response_body, result)
+}
+
+func (q *SQSJobQueue) send(queue_url string, job Job) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if len(encoded) > maxSQSMessageBytes {
+		return fmt.Errorf("job %s is %d bytes, more than SQS's limit of %d", job.Id, len(encoded), maxSQSMessageBytes)
+	}
+	params := url.Values{}
+	params.Set("MessageBody", string(encoded))
+	return q.call(queue_url, "SendMessage", params, nil)
+}
+
+func (q *SQSJobQueue) Enqueue(job *Job) error {
+	q.sawLanguage(job.Language)
+	return q.send(q.queueURL(job.Language), *job)
+}
+
+// SQS can only long-poll one queue at a time, so the wait is shared between
+// the languages' queues.
+func (q *SQSJobQueue) Receive(languages []string, visibility time.Duration, wait time.Duration) (*Job, error) {
+	if len(languages) == 0 {
+		return nil, nil
+	}
+	wait_seconds := int(wait.Seconds()) / len(languages)
+	if wait_seconds > 20 {
+		wait_seconds = 20
+	}
+	for _, language := range languages {
+		q.sawLanguage(language)
+		job, err := q.receiveFrom(language, visibility, wait_seconds)
+		if err != nil || job != nil {
+			return job, err
+		}
+	}
+	return nil, nil
+}
+
+func (q *SQSJobQueue) receiveFrom(language string, visibility time.Duration, wait_seconds int) (*Job, error) {
+	for {
+		params := url.Values{}
+		params.Set("MaxNumberOfMessages", "1")
+		params.Set("VisibilityTimeout", strconv.Itoa(int(visibility.Seconds())))
+		params.Set("WaitTimeSeconds", strconv.Itoa(wait_seconds))
+		params.Set("AttributeName.1", "ApproximateReceiveCount")
+		var response sqsReceiveMessageResponse
+		if err := q.call(q.queueURL(language), "ReceiveMessage", params, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Messages) == 0 {
+			return nil, nil
+		}
+		message := response.Messages[0]
+		var job Job
+		if err := json.Unmarshal([]byte(message.Body), &job); err != nil {
+			return nil, fmt.Errorf("undecodable job in SQS message %s: %s", message.MessageId, err)
+		}
+		for _, attribute := range message.Attributes {
+			if attribute.Name == "ApproximateReceiveCount" {
+				job.Attempts, _ = strconv.Atoi(attribute.Value)
+			}
+		}
+		if job.overdue(time.Now()) {
+			params := url.Values{}
+			params.Set("ReceiptHandle", message.ReceiptHandle)
+			if err := q.call(q.queueURL(language), "DeleteMessage", params, nil); err != nil {
+				return nil, err
+			}
+			wait_seconds = 0
+			continue
+		}
+		if job.Attempts > job.MaxAttempts {
+			if job.LastError == "" {
+				job.LastError = "visibility timeout passed on every attempt"
+			}
+			if err := q.bury(language, message.ReceiptHandle, job); err != nil {
+				return nil, err
+			}
+			// Only wait once.
+			wait_seconds = 0
+			continue
+		}
+		job.Receipt = sqsReceipt(language, message.ReceiptHandle)
+		q.remember(job, visibility)
+		return &job, nil
+	}
+}
+
+func (q *SQSJobQueue) Ack(job_id string, receipt string) error {
+	language, receipt_handle, err := parseSQSReceipt(job_id, receipt)
+	if err != nil {
+		return err
+	}
+	q.forget(receipt)
+	params := url.Values{}
+	params.Set("ReceiptHandle", receipt_handle)
+	return q.sqsReceiptError(job_id, q.call(q.queueURL(language), "DeleteMessage", params, nil))
+}
+
+func (q *SQSJobQueue) Fail(job_id string, receipt string, reason string, delay time.Duration) error {
+	language, receipt_handle, err := parseSQSReceipt(job_id, receipt)
+	if err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	received, present := q.received[receipt]
+	q.mutex.Unlock()
+	q.forget(receipt)
+	if present == true && received.job.Attempts >= received.job.MaxAttempts {
+		received.job.LastError = reason
+		return q.sqsReceiptError(job_id, q.bury(language, receipt_handle, received.job))
+	}
+	// The reason is only logged: SQS messages can't be changed.
+	params := url.Values{}
+	params.Set("ReceiptHandle", receipt_handle)
+	params.Set("VisibilityTimeout", strconv.Itoa(int(delay.Seconds())))
+	return q.sqsReceiptError(job_id, q.call(q.queueURL(language), "ChangeMessageVisibility", params, nil))
+}
+
+// Copies the job to the dead letter queue, then deletes it from its own.
+func (q *SQSJobQueue) bury(language string, receipt_handle string, job Job) error {
+	job.Receipt = ""
+	files := job.Files
+	job.Files = nil
+	if err := q.send(q.deadLetterQueueURL(), job); err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("ReceiptHandle", receipt_handle)
+	if err := q.call(q.queueURL(language), "DeleteMessage", params, nil); err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	fn := q.on_dead_letter
+	q.mutex.Unlock()
+	if fn != nil {
+		job.Files = files
+		fn(job)
+	}
+	return nil
+}
+
+func (q *SQSJobQueue) sqsReceiptError(job_id string, err error) error {
+	if sqs_error, ok := err.(SQSError); ok && strings.HasPrefix(sqs_error.Code, "ReceiptHandleIsInvalid") {
+		return StaleReceiptError{job_id}
+	}
+	return err
+}
+
+// Counts are approximate, as SQS's are, and only cover languages this
+// process has seen.
+func (q *SQSJobQueue) Stats() (JobQueueStats, error) {
+	stats := JobQueueStats{Kind: "sqs", Queued: make(map[string]int)}
+	for _, language := range q.seenLanguages() {
+		queued, in_flight, err := q.queueCounts(q.queueURL(language))
+		if err != nil {
+			return stats, err
+		}
+		stats.Queued[language] = queued
+		stats.InFlight += in_flight
+	}
+	dead, _, err := q.queueCounts(q.deadLetterQueueURL())
+	if err != nil {
+		return stats, err
+	}
+	stats.DeadLetters = dead
+	return stats, nil
+}
+
+func (q *SQSJobQueue) queueCounts(queue_url string) (int, int, error) {
+	params := url.Values{}
+	params.Set("AttributeName.1", "ApproximateNumberOfMessages")
+	params.Set("AttributeName.2", "ApproximateNumberOfMessagesNotVisible")
+	var response sqsGetQueueAttributesResponse
+	if err := q.call(queue_url, "GetQueueAttributes", params, &response); err != nil {
+		return 0, 0, err
+	}
+	queued, in_flight := 0, 0
+	for _, attribute := range response.Attributes {
+		switch attribute.Name {
+		case "ApproximateNumberOfMessages":
+			queued, _ = strconv.Atoi(attribute.Value)
+		case "ApproximateNumberOfMessagesNotVisible":
+			in_flight, _ = strconv.Atoi(attribute.Value)
+		}
+	}
+	return queued, in_flight, nil
+}
+
+// Peeks at up to 10 dead letters, in no particular order, without hiding
+// them from the next look.
+func (q *SQSJobQueue) DeadLetters(limit int) ([]Job, error) {
+	if limit > 10 {
+		limit = 10
+	}
+	params := url.Values{}
+	params.Set("MaxNumberOfMessages", strconv.Itoa(limit))
+	params.Set("VisibilityTimeout", "0")
+	var response sqsReceiveMessageResponse
+	if err := q.call(q.deadLetterQueueURL(), "ReceiveMessage", params, &response); err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(response.Messages))
+	for _, message := range response.Messages {
+		var job Job
+		if err := json.Unmarshal([]byte(message.Body), &job); err == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (q *SQSJobQueue) OnDeadLetter(fn func(job Job)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.on_dead_letter = fn
+}
+
+// Also drops jobs whose receipts have expired, e.g. because their runner
+// died.
+func (q *SQSJobQueue) remember(job Job, visibility time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.received == nil {
+		q.received = make(map[string]sqsReceivedJob)
+	}
+	now := time.Now()
+	for receipt, received := range q.received {
+		if now.After(received.expires) {
+			delete(q.received, receipt)
+		}
+	}
+	q.received[job.Receipt] = sqsReceivedJob{job, now.Add(visibility)}
+}
+
+func (q *SQSJobQueue) forget(receipt string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.received, receipt)
+}
+
+func (q *SQSJobQueue) sawLanguage(language string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.languages == nil {
+		q.languages = make(map[string]bool)
+	}
+	q.languages[language] = true
+}
+
+func (q *SQSJobQueue) seenLanguages() []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	languages := make([]string, 0, len(q.languages))
+	for language := range q.languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
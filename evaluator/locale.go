@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/smugmug/godynamo/types/attributevalue"
+)
+
+// The locale of a problem's Title, Description and Hints unless its TOML
+// sets Locale.
+const defaultLocale = "en"
+
+// Locales are lower case language codes with an optional upper case region,
+// e.g. "es" or "pt-BR".
+var localeRegexp = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// A problem statement in another human language. Anything left out falls
+// back to the problem's own Title, Description and Hints. Description is
+// keyed by programming language like Problem.Description.
+type translation struct {
+	Title       string                 `json:"title,omitempty"`
+	Description map[string]description `json:"description,omitempty"`
+	Hints       []string               `json:"hints,omitempty"`
+}
+
+func (p *Problem) validateTranslations() error {
+	if p.Locale != "" && !localeRegexp.MatchString(p.Locale) {
+		return ProblemValidationError{p.Id, fmt.Sprintf("Locale '%s' is not a locale such as 'en' or 'pt-BR'", p.Locale)}
+	}
+	for locale := range p.Translations {
+		if !localeRegexp.MatchString(locale) {
+			return ProblemValidationError{p.Id, fmt.Sprintf("translation '%s' is not a locale such as 'en' or 'pt-BR'", locale)}
+		}
+		if locale == p.GetLocale() {
+			return ProblemValidationError{p.Id, fmt.Sprintf("translation '%s' is the problem's own Locale", locale)}
+		}
+	}
+	return nil
+}
+
+// The locale of the problem's own Title, Description and Hints.
+func (p *Problem) GetLocale() string {
+	if p.Locale == "" {
+		return defaultLocale
+	}
+	return p.Locale
+}
+
+// Every locale the problem can be shown in, its own first.
+func (p *Problem) AvailableLocales() []string {
+	locales := []string{p.GetLocale()}
+	for _, locale := range sortedKeys(p.Translations) {
+		locales = append(locales, locale)
+	}
+	return locales
+}
+
+// Like GetDescription, for the translation into locale. Returns false if
+// that translation has no description for the language.
+func (p *Problem) GetTranslatedDescription(locale string, language string) (string, bool) {
+	t, present := p.Translations[locale]
+	if present == false {
+		return "", false
+	}
+	if value, present := t.Description[language]; present == true {
+		return value.Markdown, true
+	}
+	if value, present := t.Description["_all"]; present == true {
+		return value.Markdown, true
+	}
+	return "", false
+}
+
+// Picks the first of preferences the problem is available in, matching
+// "es-MX" to "es" if there is no "es-MX", else the problem's own locale.
+func (p *Problem) NegotiateLocale(preferences []string) string {
+	available := p.AvailableLocales()
+	for _, preference := range preferences {
+		for _, locale := range available {
+			if strings.EqualFold(locale, preference) {
+				return locale
+			}
+		}
+		language := strings.SplitN(preference, "-", 2)[0]
+		for _, locale := range available {
+			if strings.EqualFold(locale, language) {
+				return locale
+			}
+		}
+	}
+	return p.GetLocale()
+}
+
+// Replaces Title, Description and Hints with their translation into the
+// negotiated locale, field by field, and drops the other translations.
+// Locale becomes the locale served and Locales lists every one available.
+func (p *Problem) Localise(preferences []string) {
+	p.Locales = p.AvailableLocales()
+	locale := p.NegotiateLocale(preferences)
+	if t, present := p.Translations[locale]; present == true {
+		if t.Title != "" {
+			p.Title = t.Title
+		}
+		for language := range p.Description {
+			if markdown, present := p.GetTranslatedDescription(locale, language); present == true {
+				p.Description[language] = description{Markdown: markdown}
+			}
+		}
+		if len(t.Hints) > 0 {
+			p.Hints = t.Hints
+		}
+	}
+	p.Locale = locale
+	p.Translations = nil
+}
+
+// The locales the client asked for, best first: the locale query parameter,
+// then Accept-Language by quality.
+func requestLocales(r *http.Request) []string {
+	preferences := make([]string, 0)
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		preferences = append(preferences, locale)
+	}
+	type weighted struct {
+		locale  string
+		quality float64
+	}
+	accepted := make([]weighted, 0)
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.TrimSpace(fields[0])
+		if locale == "" || locale == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, parameter := range fields[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				if value, err := strconv.ParseFloat(parameter[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, weighted{locale, quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+	for _, a := range accepted {
+		preferences = append(preferences, a.locale)
+	}
+	return preferences
+}
+
+// Responses differ by locale, so caches must key on Accept-Language too.
+func setContentLanguage(w http.ResponseWriter, locale string) {
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+// problem_summary keeps only the translated titles, as a map of locale to
+// title.
+func titleTranslationsToAttributeValue(translations map[string]translation) *attributevalue.AttributeValue {
+	av := attributevalue.NewAttributeValue()
+	for locale, t := range translations {
+		if t.Title != "" {
+			av.InsertM(locale, &attributevalue.AttributeValue{S: t.Title})
+		}
+	}
+	return av
+}
+
+func attributeValueToTitleTranslations(av *attributevalue.AttributeValue) map[string]translation {
+	translations := make(map[string]translation)
+	for locale, title := range av.M {
+		translations[locale] = translation{Title: title.S}
+	}
+	return translations
+}
+
+// problem_details and problem_history keep, for one programming language,
+// each translation's hints and the description for that language, JSON
+// encoded and compressed. Titles live in problem_summary.
+func encodeTranslations(logger *log.Logger, problem *Problem, language string) (string, error) {
+	translations := make(map[string]translation)
+	for locale, t := range problem.Translations {
+		language_translation := translation{Hints: t.Hints}
+		if markdown, present := problem.GetTranslatedDescription(locale, language); present == true {
+			language_translation.Description = map[string]description{language: {Markdown: markdown}}
+		}
+		translations[locale] = language_translation
+	}
+	encoded, err := json.Marshal(translations)
+	if err != nil {
+		return "", err
+	}
+	return compressToBase64(logger, string(encoded))
+}
+
+func decodeTranslations(logger *log.Logger, compressed string) (map[string]translation, error) {
+	decoded, err := decompressFromBase64(logger, compressed)
+	if err != nil {
+		return nil, err
+	}
+	translations := make(map[string]translation)
+	if err := json.Unmarshal([]byte(decoded), &translations); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
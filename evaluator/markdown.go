@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"runsomecode.com/markdown"
+)
+
+// Renders the markdown of a problem description, caching by version.
+func RenderProblemDescription(logger *log.Logger, problem_id string, language string, locale string,
+	version int, text string) string {
+	logger.Printf("RenderProblemDescription entry. problem_id: %s, language: %s, locale: %s, version: %d",
+		problem_id, language, locale, version)
+	defer logger.Printf("RenderProblemDescription exit.")
+
+	// Every put of a problem bumps its version, so entries never go stale.
+	key := fmt.Sprintf("%s#%s#%s#%d", problem_id, language, locale, version)
+	if cached, ok := renderedMarkdownCache.Get(key, version); ok {
+		return cached.(string)
+	}
+	rendered := markdown.Render(logger, text)
+	renderedMarkdownCache.Put(key, rendered, version)
+	return rendered
+}
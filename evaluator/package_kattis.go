@@ -0,0 +1,595 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Import and export of the Kattis problem package format, see
+// https://www.kattis.com/problem-package-format/. A package is a directory:
+//
+//	problem.yaml                        name, credits, limits, ...
+//	problem_statement/problem.en.md     or problem.en.tex / problem.tex
+//	problem_statement/problem.<xx>.md   translations, e.g. problem.es.md
+//	data/sample/*.in, data/sample/*.ans
+//	data/secret/**/*.in, data/secret/**/*.ans
+//	submissions/accepted/*              reference solutions
+//
+// Test data maps onto TestData, which generates the unit tests. Packages
+// with custom output validators or interactive judging aren't supported.
+
+const (
+	kattisFormatVersion = "2023-07-draft"
+
+	// Imported problems need a category for the catalogue. Authors can
+	// change it before loading.
+	importedProblemCategory = "Imported"
+)
+
+var (
+	kattisLanguageExtensions = map[string]string{
+		"python": ".py",
+		"java":   ".java",
+	}
+	kattisStatementFilenames = []string{
+		"problem.en.md", "problem.md", "problem.en.tex", "problem.tex",
+	}
+	kattisTranslationRegexp = regexp.MustCompile(`^problem\.([a-z]{2,3}(?:-[A-Z]{2})?)\.(md|tex)$`)
+)
+
+type KattisPackageError struct {
+	Path   string
+	Reason string
+}
+
+func (e KattisPackageError) Error() string {
+	return fmt.Sprintf("Kattis package '%s' can't be used: %s", e.Path, e.Reason)
+}
+
+// The fields of problem.yaml we map. Older packages use name, author and
+// keywords as plain strings, newer ones as maps and lists, so those are
+// decoded loosely.
+type kattisProblemYaml struct {
+	ProblemFormatVersion string           `yaml:"problem_format_version,omitempty"`
+	Name                 interface{}      `yaml:"name,omitempty"`
+	Author               string           `yaml:"author,omitempty"`
+	Credits              interface{}      `yaml:"credits,omitempty"`
+	Source               string           `yaml:"source,omitempty"`
+	License              string           `yaml:"license,omitempty"`
+	Keywords             interface{}      `yaml:"keywords,omitempty"`
+	Validation           string           `yaml:"validation,omitempty"`
+	Type                 string           `yaml:"type,omitempty"`
+	Limits               kattisLimitsYaml `yaml:"limits,omitempty"`
+}
+
+type kattisLimitsYaml struct {
+	TimeLimit float64 `yaml:"time_limit,omitempty"`
+	Memory    int     `yaml:"memory,omitempty"`
+}
+
+// Imports the package at package_dir as ./problems/<id>.toml, for review
+// and -load-problems like any other problem.
+func ImportPackage(package_dir string) error {
+	logger.Printf("ImportPackage entry. package_dir: %s", package_dir)
+	defer logger.Printf("ImportPackage exit.")
+	problem, err := ImportKattisPackage(logger, package_dir)
+	if err != nil {
+		logger.Printf("failed to import package: %s", err)
+		return err
+	}
+	toml_filepath := filepath.Join("problems", problem.Id+".toml")
+	if _, err := os.Stat(toml_filepath); err == nil {
+		err = KattisPackageError{package_dir, fmt.Sprintf("%s already exists", toml_filepath)}
+		logger.Printf("failed to import package: %s", err)
+		return err
+	}
+	if err := WriteProblem(toml_filepath, problem); err != nil {
+		logger.Printf("failed to write problem: %s", err)
+		return err
+	}
+	// Make sure what we wrote loads.
+	if _, err := ParseProblem(toml_filepath); err != nil {
+		logger.Printf("imported problem does not parse: %s", err)
+		return err
+	}
+	logger.Printf("imported %s with %d test cases.", toml_filepath, len(problem.TestData))
+	return nil
+}
+
+// Exports the problem TOML at toml_filepath into package_dir, or a
+// directory named after the problem id if package_dir is empty.
+func ExportPackage(toml_filepath string, package_dir string) error {
+	logger.Printf("ExportPackage entry. toml_filepath: %s, package_dir: %s", toml_filepath, package_dir)
+	defer logger.Printf("ExportPackage exit.")
+	problem, err := ParseProblem(toml_filepath)
+	if err != nil {
+		logger.Printf("failed to load problem: %s", err)
+		return err
+	}
+	if package_dir == "" {
+		package_dir = problem.Id
+	}
+	if err := ExportKattisPackage(logger, problem, package_dir); err != nil {
+		logger.Printf("failed to export package: %s", err)
+		return err
+	}
+	logger.Printf("exported %s to %s.", problem.Id, package_dir)
+	return nil
+}
+
+// Reads the package at package_dir into a new draft problem. The problem id
+// is the directory name.
+func ImportKattisPackage(logger *log.Logger, package_dir string) (*Problem, error) {
+	logger.Printf("ImportKattisPackage entry. package_dir: %s", package_dir)
+	defer logger.Printf("ImportKattisPackage exit.")
+
+	problem_id := strings.Replace(strings.ToLower(filepath.Base(filepath.Clean(package_dir))), "-", "_", -1)
+	if !problemIdRegexp.MatchString(problem_id) {
+		return nil, KattisPackageError{package_dir, fmt.Sprintf("directory name '%s' is not a valid problem id", problem_id)}
+	}
+
+	var metadata kattisProblemYaml
+	contents, err := ioutil.ReadFile(filepath.Join(package_dir, "problem.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(contents, &metadata); err != nil {
+		return nil, KattisPackageError{package_dir, fmt.Sprintf("invalid problem.yaml: %s", err)}
+	}
+	if (metadata.Validation != "" && metadata.Validation != "default") ||
+		(metadata.Type != "" && metadata.Type != "pass-fail") {
+		return nil, KattisPackageError{package_dir, "only default validation of pass-fail problems is supported"}
+	}
+	if _, err := os.Stat(filepath.Join(package_dir, "output_validators")); err == nil {
+		return nil, KattisPackageError{package_dir, "custom output validators are not supported"}
+	}
+
+	now := time.Now().UTC()
+	problem := Problem{
+		Id:               problem_id,
+		Version:          1,
+		Title:            yamlLocalisedString(metadata.Name),
+		Category:         importedProblemCategory,
+		State:            StateDraft,
+		Authors:          kattisAuthors(metadata),
+		Tags:             yamlStringList(metadata.Keywords),
+		Source:           metadata.Source,
+		License:          metadata.License,
+		TimeLimitSeconds: metadata.Limits.TimeLimit,
+		MemoryLimitMB:    metadata.Limits.Memory,
+		CreationDate:     &now,
+		LastUpdatedDate:  &now,
+		Description:      make(map[string]description),
+		Solution:         make(map[string]solution),
+	}
+	for i, tag := range problem.Tags {
+		problem.Tags[i] = strings.ToLower(tag)
+	}
+	// Packages built with problemtools record the computed time limit here.
+	if problem.TimeLimitSeconds == 0 {
+		if contents, err := ioutil.ReadFile(filepath.Join(package_dir, ".timelimit")); err == nil {
+			if value, err := strconv.ParseFloat(strings.TrimSpace(string(contents)), 64); err == nil {
+				problem.TimeLimitSeconds = value
+			}
+		}
+	}
+
+	for _, kind := range []string{"sample", "secret"} {
+		kind_test_data, err := readKattisTestData(filepath.Join(package_dir, "data", kind), kind == "sample")
+		if err != nil {
+			return nil, err
+		}
+		problem.TestData = append(problem.TestData, kind_test_data...)
+	}
+	if len(problem.TestData) == 0 {
+		return nil, KattisPackageError{package_dir, "no test data under data/sample or data/secret"}
+	}
+
+	statement, title, err := readKattisStatement(package_dir)
+	if err != nil {
+		return nil, err
+	}
+	if problem.Title == "" {
+		problem.Title = title
+	}
+	if problem.Title == "" {
+		problem.Title = problem_id
+	}
+	problem.Description["_all"] = description{Markdown: appendKattisSamples(statement, problem.TestData)}
+	problem.Translations, err = readKattisTranslations(package_dir, metadata.Name, problem.TestData)
+	if err != nil {
+		return nil, err
+	}
+
+	for language, solution_code := range readKattisSubmissions(logger, filepath.Join(package_dir, "submissions", "accepted")) {
+		problem.Solution[language] = solution{Code: solution_code}
+		problem.SupportedLanguages = append(problem.SupportedLanguages, language)
+	}
+	sort.Strings(problem.SupportedLanguages)
+	if len(problem.SupportedLanguages) == 0 {
+		// Without a reference solution offer every language we can judge.
+		problem.SupportedLanguages = append([]string{}, stdioHarnessLanguages...)
+	}
+
+	problem.GenerateTests()
+	if err := problem.Validate(); err != nil {
+		return nil, err
+	}
+	return &problem, nil
+}
+
+// Writes problem as a Kattis package into package_dir, which must not exist.
+func ExportKattisPackage(logger *log.Logger, problem *Problem, package_dir string) error {
+	logger.Printf("ExportKattisPackage entry. problem.Id: %s, package_dir: %s", problem.Id, package_dir)
+	defer logger.Printf("ExportKattisPackage exit.")
+
+	if _, err := os.Stat(package_dir); err == nil {
+		return KattisPackageError{package_dir, "directory already exists"}
+	}
+	if len(problem.TestData) == 0 {
+		// Our unit tests call functions in the solution, which Kattis
+		// can't do, so the package won't be judgeable without data.
+		logger.Printf("problem %s has no TestData, the package will have no test data.", problem.Id)
+	}
+
+	metadata := kattisProblemYaml{
+		ProblemFormatVersion: kattisFormatVersion,
+		Name:                 kattisName(problem),
+		Source:               problem.Source,
+		License:              problem.License,
+		Limits: kattisLimitsYaml{
+			TimeLimit: problem.TimeLimitSeconds,
+			Memory:    problem.MemoryLimitMB,
+		},
+	}
+	if len(problem.Authors) > 0 {
+		metadata.Credits = map[string][]string{"authors": problem.Authors}
+	}
+	if len(problem.Tags) > 0 {
+		metadata.Keywords = problem.Tags
+	}
+	contents, err := yaml.Marshal(&metadata)
+	if err != nil {
+		return err
+	}
+	if err := writeKattisFile(filepath.Join(package_dir, "problem.yaml"), string(contents)); err != nil {
+		return err
+	}
+
+	markdown, _ := problem.GetDescription("_all")
+	if markdown == "" {
+		for _, language := range sortedKeys(problem.Description) {
+			markdown = problem.Description[language].Markdown
+			break
+		}
+	}
+	statement_filename := fmt.Sprintf("problem.%s.md", problem.GetLocale())
+	if err := writeKattisFile(filepath.Join(package_dir, "problem_statement", statement_filename), markdown); err != nil {
+		return err
+	}
+	for _, locale := range sortedKeys(problem.Translations) {
+		markdown, present := problem.GetTranslatedDescription(locale, "_all")
+		if present == false {
+			continue
+		}
+		statement_filename := fmt.Sprintf("problem.%s.md", locale)
+		if err := writeKattisFile(filepath.Join(package_dir, "problem_statement", statement_filename), markdown); err != nil {
+			return err
+		}
+	}
+
+	for i, test_case := range problem.TestData {
+		kind := "secret"
+		if test_case.Sample {
+			kind = "sample"
+		}
+		// Names may contain characters that aren't safe in a filename.
+		name := fmt.Sprintf("%03d", i+1)
+		base := filepath.Join(package_dir, "data", kind, name)
+		if err := writeKattisFile(base+".in", test_case.Input); err != nil {
+			return err
+		}
+		if err := writeKattisFile(base+".ans", test_case.Output); err != nil {
+			return err
+		}
+	}
+
+	for language, solution := range problem.Solution {
+		extension, present := kattisLanguageExtensions[language]
+		if present == false {
+			logger.Printf("no Kattis file extension for language %s, skipping its solution.", language)
+			continue
+		}
+		// The runner compiles Java as Solution.java, so keep the class name.
+		filename := "solution" + extension
+		if language == "java" {
+			filename = "Solution.java"
+		}
+		if err := writeKattisFile(filepath.Join(package_dir, "submissions", "accepted", filename), solution.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKattisFile(path string, contents string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(contents), 0644)
+}
+
+// Reads every <name>.in with a matching <name>.ans under dir, recursively,
+// in name order. A missing dir has no test data.
+func readKattisTestData(dir string, sample bool) ([]test_data, error) {
+	test_data_list := make([]test_data, 0)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return test_data_list, nil
+	}
+	input_paths := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() && strings.HasSuffix(path, ".in") {
+			input_paths = append(input_paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(input_paths)
+	for _, input_path := range input_paths {
+		base := strings.TrimSuffix(input_path, ".in")
+		input, err := ioutil.ReadFile(input_path)
+		if err != nil {
+			return nil, err
+		}
+		output, err := ioutil.ReadFile(base + ".ans")
+		if err != nil {
+			return nil, KattisPackageError{input_path, "no matching .ans file"}
+		}
+		relative, _ := filepath.Rel(filepath.Dir(dir), base)
+		test_data_list = append(test_data_list, test_data{
+			Name:   filepath.ToSlash(relative),
+			Input:  string(input),
+			Output: string(output),
+			Sample: sample,
+		})
+	}
+	return test_data_list, nil
+}
+
+// Returns the statement as Markdown and the title found in it, if any.
+// LaTeX statements are converted for the common problemtools macros only.
+func readKattisStatement(package_dir string) (string, string, error) {
+	for _, filename := range kattisStatementFilenames {
+		contents, err := ioutil.ReadFile(filepath.Join(package_dir, "problem_statement", filename))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", "", err
+		}
+		if strings.HasSuffix(filename, ".tex") {
+			return latexToMarkdown(string(contents))
+		}
+		markdown := string(contents)
+		if match := markdownTitleRegexp.FindStringSubmatch(markdown); match != nil {
+			return markdown, strings.TrimSpace(match[1]), nil
+		}
+		return markdown, "", nil
+	}
+	return "", "", KattisPackageError{package_dir, "no problem statement found"}
+}
+
+var (
+	markdownTitleRegexp = regexp.MustCompile(`(?m)^#{1,2}\s+(.+)$`)
+	latexReplacements   = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`(?m)^\s*%.*\n`), ""},
+		{regexp.MustCompile(`\\problemname\{([^}]*)\}`), "## $1"},
+		{regexp.MustCompile(`\\section\*?\{([^}]*)\}`), "### $1"},
+		{regexp.MustCompile(`\\subsection\*?\{([^}]*)\}`), "#### $1"},
+		{regexp.MustCompile(`\\illustration\{[^}]*\}\{[^}]*\}\{[^}]*\}`), ""},
+		{regexp.MustCompile(`\\(begin|end)\{(itemize|enumerate|center)\}`), ""},
+		{regexp.MustCompile(`\\item\s*`), "- "},
+		{regexp.MustCompile(`\\textbf\{([^}]*)\}`), "**$1**"},
+		{regexp.MustCompile(`\\(emph|textit)\{([^}]*)\}`), "*$2*"},
+		{regexp.MustCompile(`\\texttt\{([^}]*)\}`), "`$1`"},
+		{regexp.MustCompile(`\n{3,}`), "\n\n"},
+	}
+	latexTitleRegexp = regexp.MustCompile(`\\problemname\{([^}]*)\}`)
+)
+
+func latexToMarkdown(latex string) (string, string, error) {
+	title := ""
+	if match := latexTitleRegexp.FindStringSubmatch(latex); match != nil {
+		title = strings.TrimSpace(match[1])
+	}
+	markdown := latex
+	for _, r := range latexReplacements {
+		markdown = r.pattern.ReplaceAllString(markdown, r.replacement)
+	}
+	return strings.TrimSpace(markdown) + "\n", title, nil
+}
+
+// Reads every statement other than the English one as a translation, with
+// its title from problem.yaml or the statement. Markdown wins over LaTeX
+// for the same locale.
+func readKattisTranslations(package_dir string, names interface{}, test_data_list []test_data) (map[string]translation, error) {
+	translations := make(map[string]translation)
+	files, err := ioutil.ReadDir(filepath.Join(package_dir, "problem_statement"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		match := kattisTranslationRegexp.FindStringSubmatch(f.Name())
+		if match == nil || match[1] == defaultLocale {
+			continue
+		}
+		locale, format := match[1], match[2]
+		if _, present := translations[locale]; present == true && format == "tex" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(package_dir, "problem_statement", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		markdown, title := string(contents), ""
+		if format == "tex" {
+			markdown, title, _ = latexToMarkdown(markdown)
+		} else if match := markdownTitleRegexp.FindStringSubmatch(markdown); match != nil {
+			title = strings.TrimSpace(match[1])
+		}
+		if names, ok := names.(map[interface{}]interface{}); ok {
+			if name, ok := names[locale].(string); ok {
+				title = name
+			}
+		}
+		translations[locale] = translation{
+			Title:       title,
+			Description: map[string]description{"_all": {Markdown: appendKattisSamples(markdown, test_data_list)}},
+		}
+	}
+	return translations, nil
+}
+
+// problem.yaml's name is a plain string unless there are translated titles,
+// then a map of locale to title.
+func kattisName(problem *Problem) interface{} {
+	names := map[string]string{problem.GetLocale(): problem.Title}
+	for locale, t := range problem.Translations {
+		if t.Title != "" {
+			names[locale] = t.Title
+		}
+	}
+	if len(names) == 1 {
+		return problem.Title
+	}
+	return names
+}
+
+// Kattis renders samples from data/sample itself, so statements don't
+// include them. Our descriptions are shown as-is, so add them.
+func appendKattisSamples(markdown string, test_data_list []test_data) string {
+	number := 0
+	for _, test_case := range test_data_list {
+		if !test_case.Sample {
+			continue
+		}
+		number += 1
+		markdown += fmt.Sprintf("\n### Sample Input %d\n\n```\n%s```\n\n### Sample Output %d\n\n```\n%s```\n",
+			number, withTrailingNewline(test_case.Input), number, withTrailingNewline(test_case.Output))
+	}
+	return markdown
+}
+
+func withTrailingNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
+
+// Returns one accepted submission per language we can judge, the first by
+// filename. Java classes are renamed to Solution for the runner.
+func readKattisSubmissions(logger *log.Logger, dir string) map[string]string {
+	submissions := make(map[string]string)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logger.Printf("no accepted submissions in %s: %s", dir, err)
+		return submissions
+	}
+	for _, f := range files {
+		for language, extension := range kattisLanguageExtensions {
+			if f.IsDir() || filepath.Ext(f.Name()) != extension {
+				continue
+			}
+			if _, present := submissions[language]; present == true {
+				continue
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				logger.Printf("failed to read submission %s: %s", f.Name(), err)
+				continue
+			}
+			code := string(contents)
+			if language == "java" {
+				class_name := regexp.QuoteMeta(strings.TrimSuffix(f.Name(), extension))
+				code = regexp.MustCompile(`\bclass\s+`+class_name+`\b`).ReplaceAllString(code, "class Solution")
+			}
+			submissions[language] = code
+		}
+	}
+	return submissions
+}
+
+// problem.yaml allows either a plain string or a map of language to string.
+func yamlLocalisedString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[interface{}]interface{}:
+		if en, ok := v["en"].(string); ok {
+			return en
+		}
+		for _, localised := range v {
+			if s, ok := localised.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// problem.yaml allows either a space-separated string or a list.
+func yamlStringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+var authorSeparatorRegexp = regexp.MustCompile(`\s*(,|\band\b)\s*`)
+
+// Authors come from credits.authors in newer packages and from a single
+// "A, B and C" author string in older ones.
+func kattisAuthors(metadata kattisProblemYaml) []string {
+	var authors []string
+	switch credits := metadata.Credits.(type) {
+	case string:
+		authors = []string{credits}
+	case map[interface{}]interface{}:
+		if s, ok := credits["authors"].(string); ok {
+			authors = []string{s}
+		} else {
+			authors = yamlStringList(credits["authors"])
+		}
+	}
+	if len(authors) == 0 && metadata.Author != "" {
+		authors = authorSeparatorRegexp.Split(strings.TrimSpace(metadata.Author), -1)
+	}
+	return authors
+}
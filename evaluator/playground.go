@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// The playground runs any snippet in any language the runners run, without a
+// problem. Snippets can be saved with their output under a permalink, for
+// sharing in lectures and office hours. Saved snippets are JSON in the blob
+// store and never change.
+
+// The languages we can run a whole program in. Only those with a standard
+// input/output harness can be given input.
+var playgroundLanguages = []string{"c", "cpp", "python", "ruby", "javascript", "java"}
+
+type PlaygroundLanguage struct {
+	Language string `json:"language"`
+	// Whether snippets can be given stdin.
+	Stdin bool `json:"stdin"`
+}
+
+const maxPlaygroundCodeBytes = 64 * 1024
+
+type PlaygroundSnippet struct {
+	Id       string `json:"id"`
+	Language string `json:"language"`
+	Code     string `json:"code"`
+	Stdin    string `json:"stdin,omitempty"`
+	Success  bool   `json:"success"`
+	Output   string `json:"output"`
+	// Empty if saved by someone who wasn't logged in.
+	UserId      string    `json:"user_id,omitempty"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+type PlaygroundSnippetNotFoundError struct {
+	Id string
+}
+
+func (e PlaygroundSnippetNotFoundError) Error() string {
+	return fmt.Sprintf("playground snippet '%s' not found", e.Id)
+}
+
+func playgroundSnippetKey(id string) string {
+	return fmt.Sprintf("playground/%s.json", id)
+}
+
+// The unit test that runs code as a whole program. The runner compiles C and
+// C++ unit tests with the code and runs Ruby code directly, so those need no
+// test at all, and nodeunit only has to load the JavaScript.
+func playgroundUnitTest(language string, stdin string) (string, error) {
+	if unit_test, ok := stdinRunner(language, stdin); ok {
+		return unit_test, nil
+	}
+	if stdin != "" {
+		return "", fmt.Errorf("%s snippets can't be given stdin, only %s ones can",
+			language, strings.Join(stdioHarnessLanguages, " and "))
+	}
+	switch language {
+	case "c", "cpp", "ruby":
+		return "", nil
+	case "javascript":
+		return "require('./foo');\n", nil
+	}
+	return "", fmt.Errorf("the playground can't run %s", language)
+}
+
+// Languages in playgroundLanguages that some healthy runner says it runs.
+// Runners listed in -runners are taken to run all of them.
+func PlaygroundLanguages() []PlaygroundLanguage {
+	fleet := make(map[string]bool)
+	for _, lc := range runnerClient.FleetCapacity() {
+		if lc.Healthy > 0 {
+			fleet[lc.Language] = true
+		}
+	}
+	languages := make([]PlaygroundLanguage, 0)
+	for _, language := range playgroundLanguages {
+		if fleet[language] || fleet["*"] {
+			languages = append(languages, PlaygroundLanguage{
+				Language: language,
+				Stdin:    containsString(stdioHarnessLanguages, language),
+			})
+		}
+	}
+	return languages
+}
+
+func findPlaygroundLanguage(language string) (PlaygroundLanguage, bool) {
+	for _, pl := range PlaygroundLanguages() {
+		if pl.Language == language {
+			return pl, true
+		}
+	}
+	return PlaygroundLanguage{}, false
+}
+
+func SavePlaygroundSnippet(logger *log.Logger, snippet *PlaygroundSnippet) error {
+	logger.Printf("SavePlaygroundSnippet entry. language: %s", snippet.Language)
+	defer logger.Printf("SavePlaygroundSnippet exit. id: %s", snippet.Id)
+	snippet.Id = randomHex(8)
+	snippet.CreatedDate = time.Now().UTC()
+	encoded, err := json.Marshal(snippet)
+	if err != nil {
+		return err
+	}
+	return blobStore.Put(playgroundSnippetKey(snippet.Id), encoded)
+}
+
+func GetPlaygroundSnippet(logger *log.Logger, id string) (*PlaygroundSnippet, error) {
+	logger.Printf("GetPlaygroundSnippet entry. id: %s", id)
+	defer logger.Printf("GetPlaygroundSnippet exit.")
+	data, err := blobStore.Get(playgroundSnippetKey(id))
+	if _, ok := err.(BlobNotFoundError); ok {
+		return nil, PlaygroundSnippetNotFoundError{id}
+	} else if err != nil {
+		return nil, err
+	}
+	var snippet PlaygroundSnippet
+	if err := json.Unmarshal(data, &snippet); err != nil {
+		return nil, err
+	}
+	return &snippet, nil
+}
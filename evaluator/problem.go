@@ -1,19 +1,35 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/BurntSushi/toml"
 	"github.com/smugmug/godynamo/types/item"
 )
 
+type ProblemValidationError struct {
+	Id     string
+	Reason string
+}
+
+func (e ProblemValidationError) Error() string {
+	return fmt.Sprintf("problem ID '%s' is invalid: %s", e.Id, e.Reason)
+}
+
 type ProblemNotFoundError struct {
 	Id string
 }
@@ -27,17 +43,44 @@ type Problem struct {
 	Version            int                     `json:"version"`
 	Title              string                  `json:"title,omitempty"`
 	Category           string                  `json:"category,omitempty"`
+	Difficulty         string                  `json:"difficulty,omitempty"`
+	Tags               []string                `json:"tags,omitempty"`
+	EstimatedMinutes   int                     `json:"estimated_minutes,omitempty"`
+	Prerequisites      []string                `json:"prerequisites,omitempty"`
+	Related            []string                `json:"related,omitempty"`
+	Authors            []string                `json:"authors,omitempty"`
 	SupportedLanguages []string                `json:"supported_languages,omitempty"`
+	AcceptanceRate     float64                 `json:"acceptance_rate,omitempty"`
+	TimeLimitSeconds   float64                 `json:"time_limit_seconds,omitempty"`
+	MemoryLimitMB      int                     `json:"memory_limit_mb,omitempty"`
+	Source             string                  `json:"source,omitempty"`
+	License            string                  `json:"license,omitempty"`
+	State              string                  `json:"state,omitempty"`
+	SubmittedBy        string                  `json:"submitted_by,omitempty"`
+	Approvals          []Approval              `json:"approvals,omitempty"`
 	CreationDate       *time.Time              `json:"creation_date,omitempty"`
 	LastUpdatedDate    *time.Time              `json:"last_updated_date,omitempty"`
+	Locale             string                  `json:"locale,omitempty"`
+	Locales            []string                `json:"locales,omitempty"`
 	Description        map[string]description  `json:"description,omitempty"`
+	Hints              []string                `json:"hints,omitempty"`
+	Translations       map[string]translation  `json:"translations,omitempty"`
 	InitialCode        map[string]initial_code `json:"initial_code,omitempty"`
 	UnitTest           map[string]unit_test    `json:"unit_test,omitempty"`
 	Solution           map[string]solution     `json:"solution,omitempty"`
+	TestData           []test_data             `json:"test_data,omitempty"`
+	Signature          *signature              `json:"signature,omitempty"`
+	TestCases          []function_case         `json:"test_cases,omitempty"`
+	Attachments        []attachment            `json:"attachments,omitempty"`
+	// Authors' notes on solving the problem, kept in the TOML but not yet
+	// stored or served.
+	Approach map[string]description `json:"-"`
 }
 
+// HTML is rendered from Markdown when the problem is served, never stored.
 type description struct {
 	Markdown string `json:"markdown,omitempty"`
+	HTML     string `json:"html,omitempty"`
 }
 
 type initial_code struct {
@@ -52,6 +95,113 @@ type solution struct {
 	Code string `json:"code,omitempty"`
 }
 
+// A test case that feeds Input to the program on standard input and expects
+// Output on standard output, compared token by token. Sample cases are also
+// shown in the description. Large inputs and outputs can instead be read
+// from the attachments named by InputFile and OutputFile.
+type test_data struct {
+	Name       string `json:"name"`
+	Input      string `json:"input"`
+	Output     string `json:"output"`
+	InputFile  string `json:"input_file,omitempty" toml:"input_file"`
+	OutputFile string `json:"output_file,omitempty" toml:"output_file"`
+	Sample     bool   `json:"sample,omitempty"`
+}
+
+// The same as problem_id in the routes, so that every problem can be
+// fetched and "<id>#<language>" keys split one way only.
+var problemIdRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func validateProblemId(id string) error {
+	if id == "" {
+		return ProblemValidationError{id, "Id is required"}
+	}
+	if !problemIdRegexp.MatchString(id) {
+		return ProblemValidationError{id, "Id may only have lower case letters, digits and underscores"}
+	}
+	return nil
+}
+
+// Checks the fields of a problem that don't depend on other problems.
+func (p *Problem) Validate() error {
+	if err := validateProblemId(p.Id); err != nil {
+		return err
+	}
+	if p.State != "" && !containsString(problemStates, p.State) {
+		return ProblemValidationError{p.Id, fmt.Sprintf("unknown State '%s'", p.State)}
+	}
+	if p.Difficulty != "" {
+		if _, present := difficultyRanks[p.Difficulty]; present == false {
+			return ProblemValidationError{p.Id, fmt.Sprintf("unknown Difficulty '%s'", p.Difficulty)}
+		}
+	}
+	if p.EstimatedMinutes < 0 {
+		return ProblemValidationError{p.Id, "EstimatedMinutes must not be negative"}
+	}
+	if p.TimeLimitSeconds < 0 || p.MemoryLimitMB < 0 {
+		return ProblemValidationError{p.Id, "TimeLimitSeconds and MemoryLimitMB must not be negative"}
+	}
+	test_data_names := make(map[string]bool)
+	for _, test_case := range p.TestData {
+		if test_case.Name == "" || test_data_names[test_case.Name] {
+			return ProblemValidationError{p.Id, fmt.Sprintf("TestData names must be non-empty and unique, got '%s'", test_case.Name)}
+		}
+		test_data_names[test_case.Name] = true
+	}
+	if err := p.validateFunctionTests(); err != nil {
+		return err
+	}
+	if err := p.validateAttachments(); err != nil {
+		return err
+	}
+	if err := p.validateTranslations(); err != nil {
+		return err
+	}
+	for _, tag := range p.Tags {
+		if tag == "" || strings.ToLower(tag) != tag {
+			return ProblemValidationError{p.Id, fmt.Sprintf("Tags must be non-empty and lower case, got '%s'", tag)}
+		}
+	}
+	return nil
+}
+
+// Checks that every problem referenced by Prerequisites and Related is in
+// known_ids, and that a problem doesn't reference itself.
+func (p *Problem) ValidateReferences(known_ids map[string]bool) error {
+	for _, references := range [][]string{p.Prerequisites, p.Related} {
+		for _, id := range references {
+			if id == p.Id {
+				return ProblemValidationError{p.Id, "problem references itself"}
+			}
+			if known_ids[id] == false {
+				return ProblemValidationError{p.Id, fmt.Sprintf("referenced problem '%s' does not exist", id)}
+			}
+		}
+	}
+	return nil
+}
+
+// Validates problems as a set. known_ids are problems that already exist
+// elsewhere, e.g. in DynamoDB; problems may also reference each other.
+func ValidateProblems(problems []*Problem, known_ids map[string]bool) error {
+	ids := make(map[string]bool)
+	for id := range known_ids {
+		ids[id] = true
+	}
+	for _, problem := range problems {
+		ids[problem.Id] = true
+	}
+	for _, problem := range problems {
+		if err := problem.Validate(); err != nil {
+			return err
+		}
+		if err := problem.ValidateReferences(ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *Problem) GetDescription(language string) (string, bool) {
 	value, present := p.Description[language]
 	if present == true {
@@ -117,13 +267,237 @@ func ParseProblem(filepath string) (*Problem, error) {
 	logger.Printf("ParseProblem entry. filepath: %s", filepath)
 	defer logger.Printf("ParseProblem exit.")
 	var problem Problem
-	if _, err := toml.DecodeFile(filepath, &problem); err != nil {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		log.Printf("could not read TOML filepath %s: %s", filepath, err)
+		return nil, err
+	}
+	if err := DecodeProblemTOML(string(data), &problem); err != nil {
 		log.Printf("could not decode TOML filepath %s: %s", filepath, err)
 		return nil, err
 	}
+	// Attachment paths are relative to the TOML.
+	for i, a := range problem.Attachments {
+		if a.Path != "" && !path.IsAbs(a.Path) {
+			problem.Attachments[i].Path = path.Join(path.Dir(filepath), a.Path)
+		}
+	}
+	problem.GenerateTests()
+	if err := problem.Validate(); err != nil {
+		log.Printf("invalid problem in TOML filepath %s: %s", filepath, err)
+		return nil, err
+	}
 	return &problem, nil
 }
 
+// Decodes a problem from TOML. Keys that match no field are an error rather
+// than being dropped, so that a misspelt key can't lose e.g. a test case's
+// input_file.
+func DecodeProblemTOML(data string, problem *Problem) error {
+	md, err := toml.Decode(data, problem)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return ProblemValidationError{problem.Id, fmt.Sprintf("unknown keys: %s", strings.Join(keys, ", "))}
+	}
+	return nil
+}
+
+// Writes problem as TOML in the same layout as the hand-written problems.
+// TestData or a Signature is written instead of the unit tests and initial
+// code generated from them, since ParseProblem generates those again.
+func WriteProblem(filepath string, problem *Problem) error {
+	logger.Printf("WriteProblem entry. filepath: %s, problem.Id: %s", filepath, problem.Id)
+	defer logger.Printf("WriteProblem exit.")
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Id = %s\n", tomlString(problem.Id))
+	fmt.Fprintf(&buffer, "Version = %d\n\n", problem.Version)
+	fmt.Fprintf(&buffer, "Title = %s\n", tomlString(problem.Title))
+	fmt.Fprintf(&buffer, "Category = %s\n", tomlString(problem.Category))
+	fmt.Fprintf(&buffer, "SupportedLanguages = %s\n", tomlStringArray(problem.SupportedLanguages))
+	if problem.CreationDate != nil {
+		fmt.Fprintf(&buffer, "CreationDate = %s\n", problem.CreationDate.UTC().Format(time.RFC3339))
+	}
+	if problem.LastUpdatedDate != nil {
+		fmt.Fprintf(&buffer, "LastUpdatedDate = %s\n", problem.LastUpdatedDate.UTC().Format(time.RFC3339))
+	}
+	if problem.State != "" {
+		fmt.Fprintf(&buffer, "State = %s\n", tomlString(problem.State))
+	}
+	buffer.WriteString("\n")
+	if problem.Difficulty != "" {
+		fmt.Fprintf(&buffer, "Difficulty = %s\n", tomlString(problem.Difficulty))
+	}
+	fmt.Fprintf(&buffer, "Tags = %s\n", tomlStringArray(problem.Tags))
+	if problem.EstimatedMinutes > 0 {
+		fmt.Fprintf(&buffer, "EstimatedMinutes = %d\n", problem.EstimatedMinutes)
+	}
+	fmt.Fprintf(&buffer, "Prerequisites = %s\n", tomlStringArray(problem.Prerequisites))
+	fmt.Fprintf(&buffer, "Related = %s\n", tomlStringArray(problem.Related))
+	fmt.Fprintf(&buffer, "Authors = %s\n", tomlStringArray(problem.Authors))
+	if problem.Source != "" {
+		fmt.Fprintf(&buffer, "Source = %s\n", tomlString(problem.Source))
+	}
+	if problem.License != "" {
+		fmt.Fprintf(&buffer, "License = %s\n", tomlString(problem.License))
+	}
+	if problem.TimeLimitSeconds > 0 {
+		// Always with a decimal point, since TOML won't decode an integer
+		// into a float.
+		time_limit := strconv.FormatFloat(problem.TimeLimitSeconds, 'f', -1, 64)
+		if !strings.Contains(time_limit, ".") {
+			time_limit += ".0"
+		}
+		fmt.Fprintf(&buffer, "TimeLimitSeconds = %s\n", time_limit)
+	}
+	if problem.MemoryLimitMB > 0 {
+		fmt.Fprintf(&buffer, "MemoryLimitMB = %d\n", problem.MemoryLimitMB)
+	}
+	if problem.Locale != "" {
+		fmt.Fprintf(&buffer, "Locale = %s\n", tomlString(problem.Locale))
+	}
+	if len(problem.Hints) > 0 {
+		fmt.Fprintf(&buffer, "Hints = %s\n", tomlStringArray(problem.Hints))
+	}
+
+	buffer.WriteString("\n[Description]\n")
+	for _, language := range sortedKeys(problem.Description) {
+		fmt.Fprintf(&buffer, "\n[Description.%s]\nmarkdown = %s\n", language, tomlString(problem.Description[language].Markdown))
+	}
+	if len(problem.Approach) > 0 {
+		buffer.WriteString("\n[Approach]\n")
+		for _, language := range sortedKeys(problem.Approach) {
+			fmt.Fprintf(&buffer, "\n[Approach.%s]\nmarkdown = %s\n", language, tomlString(problem.Approach[language].Markdown))
+		}
+	}
+	for _, locale := range sortedKeys(problem.Translations) {
+		t := problem.Translations[locale]
+		fmt.Fprintf(&buffer, "\n[Translations.%s]\n", locale)
+		if t.Title != "" {
+			fmt.Fprintf(&buffer, "title = %s\n", tomlString(t.Title))
+		}
+		if len(t.Hints) > 0 {
+			fmt.Fprintf(&buffer, "hints = %s\n", tomlStringArray(t.Hints))
+		}
+		for _, language := range sortedKeys(t.Description) {
+			fmt.Fprintf(&buffer, "\n[Translations.%s.description.%s]\nmarkdown = %s\n",
+				locale, language, tomlString(t.Description[language].Markdown))
+		}
+	}
+	generated := len(problem.TestData) > 0 || problem.Signature != nil
+	if !generated {
+		buffer.WriteString("\n[InitialCode]\n")
+		for _, language := range sortedKeys(problem.InitialCode) {
+			fmt.Fprintf(&buffer, "\n[InitialCode.%s]\ncode = %s\n", language, tomlString(problem.InitialCode[language].Code))
+		}
+		buffer.WriteString("\n[UnitTest]\n")
+		for _, language := range sortedKeys(problem.UnitTest) {
+			fmt.Fprintf(&buffer, "\n[UnitTest.%s]\ncode = %s\n", language, tomlString(problem.UnitTest[language].Code))
+		}
+	}
+	buffer.WriteString("\n[Solution]\n")
+	for _, language := range sortedKeys(problem.Solution) {
+		fmt.Fprintf(&buffer, "\n[Solution.%s]\ncode = %s\n", language, tomlString(problem.Solution[language].Code))
+	}
+	for _, test_case := range problem.TestData {
+		fmt.Fprintf(&buffer, "\n[[TestData]]\nname = %s\n", tomlString(test_case.Name))
+		if test_case.Sample {
+			buffer.WriteString("sample = true\n")
+		}
+		fmt.Fprintf(&buffer, "input = %s\noutput = %s\n", tomlString(test_case.Input), tomlString(test_case.Output))
+		if test_case.InputFile != "" {
+			fmt.Fprintf(&buffer, "input_file = %s\n", tomlString(test_case.InputFile))
+		}
+		if test_case.OutputFile != "" {
+			fmt.Fprintf(&buffer, "output_file = %s\n", tomlString(test_case.OutputFile))
+		}
+	}
+	for _, a := range problem.Attachments {
+		fmt.Fprintf(&buffer, "\n[[Attachments]]\nname = %s\nkind = %s\n", tomlString(a.Name), tomlString(a.Kind))
+		if a.Path != "" {
+			fmt.Fprintf(&buffer, "path = %s\n", tomlString(a.Path))
+		} else {
+			fmt.Fprintf(&buffer, "key = %s\nsize = %d\n", tomlString(a.Key), a.Size)
+		}
+	}
+	if problem.Signature != nil {
+		fmt.Fprintf(&buffer, "\n[Signature]\nfunction = %s\nreturns = %s\n",
+			tomlString(problem.Signature.Function), tomlString(problem.Signature.Returns))
+		if len(problem.Signature.Names) > 0 {
+			buffer.WriteString("\n[Signature.names]\n")
+			for _, language := range sortedKeys(problem.Signature.Names) {
+				fmt.Fprintf(&buffer, "%s = %s\n", language, tomlString(problem.Signature.Names[language]))
+			}
+		}
+		for _, param := range problem.Signature.Params {
+			fmt.Fprintf(&buffer, "\n[[Signature.params]]\nname = %s\ntype = %s\n", tomlString(param.Name), tomlString(param.Type))
+		}
+	}
+	for _, test_case := range problem.TestCases {
+		fmt.Fprintf(&buffer, "\n[[TestCases]]\nargs = %s\nexpected = %s\n", tomlString(test_case.Args), tomlString(test_case.Expected))
+	}
+	return ioutil.WriteFile(filepath, buffer.Bytes(), 0644)
+}
+
+// TOML string for s: a multi-line literal string where possible, as in the
+// hand-written problems, otherwise a basic string with escapes.
+func tomlString(s string) string {
+	literal_ok := utf8.ValidString(s) && !strings.Contains(s, "'''") && !strings.HasSuffix(s, "'")
+	for _, r := range s {
+		if (r < 0x20 && r != '\n' && r != '\t') || r == 0x7f {
+			literal_ok = false
+		}
+	}
+	if literal_ok && strings.Contains(s, "\n") {
+		return "'''\n" + s + "'''"
+	}
+	var buffer bytes.Buffer
+	buffer.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		case r == '\n':
+			buffer.WriteString("\\n")
+		case r == '\r':
+			buffer.WriteString("\\r")
+		case r == '\t':
+			buffer.WriteString("\\t")
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buffer, "\\u%04x", r)
+		default:
+			// Invalid UTF-8 arrives here as utf8.RuneError.
+			buffer.WriteRune(r)
+		}
+	}
+	buffer.WriteByte('"')
+	return buffer.String()
+}
+
+func tomlStringArray(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, tomlString(value))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // An Item is a returned attributebaluemap from godynamo. This function
 // deserializes an Item into a Problem. Note that we've split up a Problem
 // into multiple tables. Hence the item will be incomplete and the parts
@@ -149,9 +523,91 @@ func ItemToProblem(logger *log.Logger, input_id string, item item.Item) (Problem
 	if category, present := item["category"]; present == true {
 		problem.Category = category.S
 	}
+	if locale, present := item["locale"]; present == true {
+		problem.Locale = locale.S
+	}
+	if title_translations, present := item["title_translations"]; present == true {
+		problem.Translations = attributeValueToTitleTranslations(title_translations)
+	}
+	if difficulty, present := item["difficulty"]; present == true {
+		problem.Difficulty = difficulty.S
+	}
+	if tags, present := item["tags"]; present == true {
+		problem.Tags = tags.SS
+	}
+	if estimated_minutes, present := item["estimated_minutes"]; present == true {
+		value, err := strconv.Atoi(estimated_minutes.N)
+		if err != nil {
+			logger.Printf("failed to deserialize estimated_minutes for problem: %s", err)
+			return problem, err
+		}
+		problem.EstimatedMinutes = value
+	}
+	if prerequisites, present := item["prerequisites"]; present == true {
+		problem.Prerequisites = prerequisites.SS
+	}
+	if related, present := item["related"]; present == true {
+		problem.Related = related.SS
+	}
+	if authors, present := item["authors"]; present == true {
+		// A list rather than a set, since credit order matters.
+		problem.Authors = make([]string, 0, len(authors.L))
+		for _, author := range authors.L {
+			problem.Authors = append(problem.Authors, author.S)
+		}
+	}
 	if supported_languages, present := item["supported_languages"]; present == true {
 		problem.SupportedLanguages = supported_languages.SS
 	}
+	if state, present := item["state"]; present == true {
+		problem.State = state.S
+	} else if _, present := item["supported_languages"]; present == true {
+		// Summaries written before problems had a lifecycle were live.
+		problem.State = StatePublished
+	}
+	if submitted_by, present := item["submitted_by"]; present == true {
+		problem.SubmittedBy = submitted_by.S
+	}
+	if approvals, present := item["approvals"]; present == true {
+		value, err := attributeValueToApprovals(approvals)
+		if err != nil {
+			logger.Printf("failed to deserialize approvals for problem: %s", err)
+			return problem, err
+		}
+		problem.Approvals = value
+	}
+	if attachments, present := item["attachments"]; present == true {
+		value, err := attributeValueToAttachments(attachments)
+		if err != nil {
+			logger.Printf("failed to deserialize attachments for problem: %s", err)
+			return problem, err
+		}
+		problem.Attachments = value
+	}
+	if acceptance_rate, present := item["acceptance_rate"]; present == true {
+		value, err := strconv.ParseFloat(acceptance_rate.N, 64)
+		if err != nil {
+			logger.Printf("failed to deserialize acceptance_rate for problem: %s", err)
+			return problem, err
+		}
+		problem.AcceptanceRate = value
+	}
+	if time_limit_seconds, present := item["time_limit_seconds"]; present == true {
+		value, err := strconv.ParseFloat(time_limit_seconds.N, 64)
+		if err != nil {
+			logger.Printf("failed to deserialize time_limit_seconds for problem: %s", err)
+			return problem, err
+		}
+		problem.TimeLimitSeconds = value
+	}
+	if memory_limit_mb, present := item["memory_limit_mb"]; present == true {
+		value, err := strconv.Atoi(memory_limit_mb.N)
+		if err != nil {
+			logger.Printf("failed to deserialize memory_limit_mb for problem: %s", err)
+			return problem, err
+		}
+		problem.MemoryLimitMB = value
+	}
 	if creation_date, present := item["creation_date"]; present == true {
 		creation_date_object, err := time.Parse(time.RFC3339, creation_date.S)
 		if err != nil {
@@ -181,6 +637,20 @@ func ItemToProblem(logger *log.Logger, input_id string, item item.Item) (Problem
 		problem.Description = make(map[string]description)
 		problem.Description[language] = description{Markdown: description_decoded}
 	}
+	if hints, present := item["hints"]; present == true {
+		problem.Hints = make([]string, 0, len(hints.L))
+		for _, hint := range hints.L {
+			problem.Hints = append(problem.Hints, hint.S)
+		}
+	}
+	if translations_encoded, present := item["translations"]; present == true {
+		value, err := decodeTranslations(logger, translations_encoded.B)
+		if err != nil {
+			logger.Printf("failed to decompress/decode translations: %s", err)
+			return problem, err
+		}
+		problem.Translations = value
+	}
 	if initial_code_encoded, present := item["initial_code"]; present == true {
 		initial_code_decoded, err := decompressFromBase64(logger, initial_code_encoded.B)
 		if err != nil {
@@ -207,6 +677,14 @@ func ItemToProblem(logger *log.Logger, input_id string, item item.Item) (Problem
 		problem.UnitTest = make(map[string]unit_test)
 		problem.UnitTest[language] = unit_test{Code: unit_test_decoded}
 	}
+	if test_data_encoded, present := item["test_data"]; present == true {
+		value, err := decodeTestData(logger, test_data_encoded.B)
+		if err != nil {
+			logger.Printf("failed to decompress/decode test_data: %s", err)
+			return problem, err
+		}
+		problem.TestData = value
+	}
 	if solution_encoded, present := item["solution"]; present == true {
 		solution_decoded, err := decompressFromBase64(logger, solution_encoded.B)
 		if err != nil {
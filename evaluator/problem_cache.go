@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Problems are read far more often than they change, so the handlers read
+// them through these caches rather than from DynamoDB on every request.
+//
+// Summaries expire after the TTL, which bounds how stale a problem loaded
+// by another process (e.g. -load-problems) can be. Details and unit tests
+// are only used if their version matches the current summary's, so they
+// follow the summary. Puts and workflow transitions through this process
+// invalidate the problem straight away. If DynamoDB can't be reached,
+// whatever is cached is served however old it is.
+var (
+	problemSummaryCache   *LRUCache
+	problemDetailsCache   *LRUCache
+	unitTestCache         *LRUCache
+	catalogueCache        *LRUCache
+	renderedMarkdownCache *LRUCache
+)
+
+func init() {
+	InitializeCaches(1000, time.Minute)
+	InitializeVerdictCache(10000, 24*time.Hour)
+}
+
+func InitializeCaches(size int, ttl time.Duration) {
+	problemSummaryCache = NewLRUCache("problem_summary", size, ttl)
+	problemDetailsCache = NewLRUCache("problem_details", size, 0)
+	unitTestCache = NewLRUCache("unit_test", size, 0)
+	catalogueCache = NewLRUCache("catalogue", size, ttl)
+	// Keyed by version as well, so never stale.
+	renderedMarkdownCache = NewLRUCache("rendered_markdown", size, 0)
+}
+
+func AllCacheStats() []CacheStats {
+	stats := []CacheStats{
+		problemSummaryCache.Stats(),
+		problemDetailsCache.Stats(),
+		unitTestCache.Stats(),
+		catalogueCache.Stats(),
+		renderedMarkdownCache.Stats(),
+	}
+	if verdictCache != nil {
+		stats = append(stats, verdictCache.Stats())
+	}
+	return stats
+}
+
+// Drops everything cached about problem_id, or about every problem if it is
+// empty. The catalogue is always dropped since any page may list it.
+func InvalidateProblem(logger *log.Logger, problem_id string) {
+	logger.Printf("InvalidateProblem entry. problem_id: %s", problem_id)
+	defer logger.Printf("InvalidateProblem exit.")
+	if problem_id == "" {
+		problemSummaryCache.InvalidatePrefix("")
+		problemDetailsCache.InvalidatePrefix("")
+		unitTestCache.InvalidatePrefix("")
+	} else {
+		problemSummaryCache.Invalidate(problem_id)
+		problemDetailsCache.InvalidatePrefix(problem_id + "#")
+		unitTestCache.InvalidatePrefix(problem_id + "#")
+	}
+	// Verdicts would be refused once the version changes anyway; this just
+	// frees their room.
+	if verdictCache != nil {
+		prefix := ""
+		if problem_id != "" {
+			prefix = problem_id + "#"
+		}
+		verdictCache.InvalidatePrefix(prefix)
+	}
+	catalogueCache.InvalidatePrefix("")
+}
+
+func CachedProblemSummary(logger *log.Logger, problem_id string) (Problem, error) {
+	logger.Printf("CachedProblemSummary entry. problem_id: %s", problem_id)
+	defer logger.Printf("CachedProblemSummary exit.")
+	if cached, ok := problemSummaryCache.Get(problem_id, anyVersion); ok {
+		return copyProblem(cached.(Problem)), nil
+	}
+	problem, err := GetProblemSummary(logger, problem_id)
+	if err != nil {
+		if cached, ok := staleOnError(logger, problemSummaryCache, problem_id, err); ok {
+			return copyProblem(cached.(Problem)), nil
+		}
+		return problem, err
+	}
+	problemSummaryCache.Put(problem_id, problem, problem.Version)
+	return copyProblem(problem), nil
+}
+
+// version is the current version from the summary; details of any other
+// version are re-read.
+func CachedProblemDetails(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	logger.Printf("CachedProblemDetails entry. problem_id: %s, language: %s, version: %d",
+		problem_id, language, version)
+	defer logger.Printf("CachedProblemDetails exit.")
+	key := fmt.Sprintf("%s#%s", problem_id, language)
+	if cached, ok := problemDetailsCache.Get(key, version); ok {
+		return copyProblem(cached.(Problem)), nil
+	}
+	problem, err := GetProblemDetails(logger, problem_id, language)
+	if err != nil {
+		if cached, ok := staleOnError(logger, problemDetailsCache, key, err); ok {
+			return copyProblem(cached.(Problem)), nil
+		}
+		return problem, err
+	}
+	problemDetailsCache.Put(key, problem, problem.Version)
+	return copyProblem(problem), nil
+}
+
+// Old versions never change, so they are cached under their version.
+func CachedProblemDetailsAtVersion(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	logger.Printf("CachedProblemDetailsAtVersion entry. problem_id: %s, language: %s, version: %d",
+		problem_id, language, version)
+	defer logger.Printf("CachedProblemDetailsAtVersion exit.")
+	key := fmt.Sprintf("%s#%s@%d", problem_id, language, version)
+	if cached, ok := problemDetailsCache.Get(key, version); ok {
+		return copyProblem(cached.(Problem)), nil
+	}
+	problem, err := GetProblemDetailsAtVersion(logger, problem_id, language, version)
+	if err != nil {
+		if cached, ok := staleOnError(logger, problemDetailsCache, key, err); ok {
+			return copyProblem(cached.(Problem)), nil
+		}
+		return problem, err
+	}
+	problemDetailsCache.Put(key, problem, version)
+	return copyProblem(problem), nil
+}
+
+// Like CachedProblemDetails, for the unit test.
+func CachedProblemUnitTest(logger *log.Logger, problem_id string, language string, version int) (Problem, error) {
+	logger.Printf("CachedProblemUnitTest entry. problem_id: %s, language: %s, version: %d",
+		problem_id, language, version)
+	defer logger.Printf("CachedProblemUnitTest exit.")
+	key := fmt.Sprintf("%s#%s", problem_id, language)
+	if cached, ok := unitTestCache.Get(key, version); ok {
+		return copyProblem(cached.(Problem)), nil
+	}
+	problem, err := GetProblemUnitTest(logger, problem_id, language)
+	if err != nil {
+		if cached, ok := staleOnError(logger, unitTestCache, key, err); ok {
+			return copyProblem(cached.(Problem)), nil
+		}
+		return problem, err
+	}
+	unitTestCache.Put(key, problem, problem.Version)
+	return copyProblem(problem), nil
+}
+
+type cataloguePage struct {
+	problems    []Problem
+	next_cursor string
+}
+
+func CachedProblemSummaries(logger *log.Logger, cq CatalogueQuery) ([]Problem, string, error) {
+	logger.Printf("CachedProblemSummaries entry. cq: %+v", cq)
+	defer logger.Printf("CachedProblemSummaries exit.")
+	key := fmt.Sprintf("%+v", cq)
+	if cached, ok := catalogueCache.Get(key, anyVersion); ok {
+		page := cached.(cataloguePage)
+		return copyProblems(page.problems), page.next_cursor, nil
+	}
+	problems, next_cursor, err := QueryProblemSummaries(logger, cq)
+	if err != nil {
+		// A bad cursor is the client's fault, not DynamoDB's.
+		if err == InvalidCatalogueCursor {
+			return problems, next_cursor, err
+		}
+		if cached, ok := staleOnError(logger, catalogueCache, key, err); ok {
+			page := cached.(cataloguePage)
+			return copyProblems(page.problems), page.next_cursor, nil
+		}
+		return problems, next_cursor, err
+	}
+	catalogueCache.Put(key, cataloguePage{problems, next_cursor}, anyVersion)
+	return copyProblems(problems), next_cursor, nil
+}
+
+// Serves the stale entry for key if err means DynamoDB failed, rather than
+// that the problem doesn't exist.
+func staleOnError(logger *log.Logger, cache *LRUCache, key string, err error) (interface{}, bool) {
+	if _, ok := err.(ProblemNotFoundError); ok {
+		return nil, false
+	}
+	cached, ok := cache.GetStale(key)
+	if ok {
+		logger.Printf("serving stale %s entry for %s after error: %s", cache.Name, key, err)
+	}
+	return cached, ok
+}
+
+// Handlers modify the problems they serve, e.g. to localise them, so each
+// gets its own copy of the maps.
+func copyProblem(problem Problem) Problem {
+	if problem.Description != nil {
+		descriptions := make(map[string]description, len(problem.Description))
+		for language, value := range problem.Description {
+			descriptions[language] = value
+		}
+		problem.Description = descriptions
+	}
+	if problem.InitialCode != nil {
+		initial_codes := make(map[string]initial_code, len(problem.InitialCode))
+		for language, value := range problem.InitialCode {
+			initial_codes[language] = value
+		}
+		problem.InitialCode = initial_codes
+	}
+	if problem.UnitTest != nil {
+		unit_tests := make(map[string]unit_test, len(problem.UnitTest))
+		for language, value := range problem.UnitTest {
+			unit_tests[language] = value
+		}
+		problem.UnitTest = unit_tests
+	}
+	if problem.Translations != nil {
+		translations := make(map[string]translation, len(problem.Translations))
+		for locale, value := range problem.Translations {
+			translations[locale] = value
+		}
+		problem.Translations = translations
+	}
+	return problem
+}
+
+func copyProblems(problems []Problem) []Problem {
+	copies := make([]Problem, 0, len(problems))
+	for _, problem := range problems {
+		copies = append(copies, copyProblem(problem))
+	}
+	return copies
+}
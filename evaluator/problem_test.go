@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Strings that need care in TOML: quotes, the literal string delimiters,
+// backslashes, carriage returns and other control characters.
+const awkwardText = "He said \"hi\" and left.\n'''\nC:\\temp\\foo\r\nbell \x07, tab\t, ends with '"
+
+func TestWriteProblemRoundTrip(t *testing.T) {
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	common := func() Problem {
+		return Problem{
+			Id:                 "two_sum",
+			Version:            4,
+			Title:              "Two sum",
+			Category:           "arrays",
+			SupportedLanguages: []string{"python", "java"},
+			Tags:               []string{"hash table"},
+			Prerequisites:      []string{"fizz_buzz"},
+			Related:            []string{"three_sum"},
+			Authors:            []string{"Ada"},
+			Description:        map[string]description{"python": {Markdown: "Find two numbers.\n"}},
+			Solution:           map[string]solution{"python": {Code: "def f():\n    pass\n"}},
+		}
+	}
+	tests := []struct {
+		name    string
+		problem func() Problem
+	}{
+		{"unit tests", func() Problem {
+			p := common()
+			p.InitialCode = map[string]initial_code{"python": {Code: "def two_sum(a, k):\n"}}
+			p.UnitTest = map[string]unit_test{"python": {Code: "import unittest\n"}}
+			return p
+		}},
+		{"every optional field", func() Problem {
+			p := common()
+			p.CreationDate = &created
+			p.LastUpdatedDate = &created
+			p.State = "published"
+			p.Difficulty = "easy"
+			p.EstimatedMinutes = 15
+			p.Source = "NWERC 2019"
+			p.License = "cc by-sa"
+			p.TimeLimitSeconds = 2
+			p.MemoryLimitMB = 256
+			p.Locale = "en"
+			p.Hints = []string{"Use a map."}
+			p.Approach = map[string]description{"python": {Markdown: "Remember what you've seen.\n"}}
+			p.Translations = map[string]translation{"pt-BR": {
+				Title:       "Soma de dois",
+				Hints:       []string{"Use um mapa."},
+				Description: map[string]description{"python": {Markdown: "Encontre dois números.\n"}},
+			}}
+			p.InitialCode = map[string]initial_code{"python": {Code: ""}}
+			p.UnitTest = map[string]unit_test{"python": {Code: "import unittest\n"}}
+			return p
+		}},
+		{"awkward strings", func() Problem {
+			p := common()
+			p.Title = awkwardText
+			p.Description = map[string]description{"python": {Markdown: awkwardText}}
+			p.InitialCode = map[string]initial_code{"python": {Code: awkwardText}}
+			p.UnitTest = map[string]unit_test{"python": {Code: "'''docstring'''\n"}}
+			return p
+		}},
+		{"stdio test data", func() Problem {
+			p := common()
+			p.TimeLimitSeconds = 0.5
+			p.TestData = []test_data{
+				{Name: "sample", Input: "1 2\n", Output: "3\n", Sample: true},
+				{Name: "large", InputFile: "large.in", OutputFile: "large.out"},
+			}
+			p.Attachments = []attachment{
+				{Name: "large.in", Kind: "test_data", Key: "attachments/two_sum/large.in", Size: 1048576},
+				{Name: "large.out", Kind: "test_data", Key: "attachments/two_sum/large.out", Size: 7},
+			}
+			return p
+		}},
+		{"function signature", func() Problem {
+			p := common()
+			p.Signature = &signature{
+				Function: "two_sum",
+				Returns:  "bool",
+				Params:   []parameter{{"a", "int[]"}, {"k", "int"}},
+				Names:    map[string]string{"java": "twoSum"},
+			}
+			p.TestCases = []function_case{{Args: "[1, 2], 3", Expected: "true"}, {Args: "[], 0", Expected: "false"}}
+			return p
+		}},
+	}
+	dir, err := ioutil.TempDir("", "problem_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range tests {
+		want := test.problem()
+		path := filepath.Join(dir, want.Id+".toml")
+		if err := WriteProblem(path, &want); err != nil {
+			t.Fatalf("%s: WriteProblem: %s", test.name, err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Problem
+		if err := DecodeProblemTOML(string(data), &got); err != nil {
+			t.Fatalf("%s: DecodeProblemTOML: %s\n%s", test.name, err, data)
+		}
+		// Decoded times have their own location.
+		for _, dates := range [][2]*time.Time{{want.CreationDate, got.CreationDate}, {want.LastUpdatedDate, got.LastUpdatedDate}} {
+			if (dates[0] == nil) != (dates[1] == nil) || (dates[0] != nil && !dates[0].Equal(*dates[1])) {
+				t.Errorf("%s: date = %v, want %v", test.name, dates[1], dates[0])
+			}
+		}
+		want.CreationDate, want.LastUpdatedDate = nil, nil
+		got.CreationDate, got.LastUpdatedDate = nil, nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: decoded\n%+v\nwant\n%+v\nfrom\n%s", test.name, got, want, data)
+		}
+	}
+}
+
+func TestDecodeProblemTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		err  string
+	}{
+		{"known keys", "Id = 'two_sum'\nVersion = 1\n[[TestData]]\nname = 'a'\ninput_file = 'a.in'\n", ""},
+		{"misspelt top-level key", "Id = 'two_sum'\nVersoin = 1\n", "unknown keys: Versoin"},
+		{"misspelt test case key", "Id = 'two_sum'\n[[TestData]]\nname = 'a'\ninputfile = 'a.in'\n", "unknown keys: TestData.inputfile"},
+		{"not TOML", "Id = \n", "Id"},
+	}
+	for _, test := range tests {
+		var problem Problem
+		err := DecodeProblemTOML(test.toml, &problem)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A token bucket per key, e.g. per user: each key may make Rate requests at
+// once and then one more every Per/Rate. A Rate of zero or less lets
+// everything through.
+type RateLimiter struct {
+	Rate int
+	Per  time.Duration
+
+	mutex   sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Buckets are swept once there are this many, dropping the full ones.
+const maxRateBuckets = 10000
+
+func NewRateLimiter(rate int, per time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Rate:    rate,
+		Per:     per,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// Takes a token for key. If there is none, returns false and how long until
+// there is.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.Rate <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	bucket, present := l.buckets[key]
+	if present == false {
+		if len(l.buckets) >= maxRateBuckets {
+			l.sweep(now)
+		}
+		bucket = &rateBucket{float64(l.Rate), now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens -= 1
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) * float64(l.Per) / float64(l.Rate))
+}
+
+func (l *RateLimiter) refill(bucket *rateBucket, now time.Time) float64 {
+	tokens := bucket.tokens + float64(now.Sub(bucket.updated))*float64(l.Rate)/float64(l.Per)
+	if tokens > float64(l.Rate) {
+		return float64(l.Rate)
+	}
+	return tokens
+}
+
+// Full buckets are the same as no bucket. Callers hold the mutex.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= float64(l.Rate) {
+			delete(l.buckets, key)
+		}
+	}
+}
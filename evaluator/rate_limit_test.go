@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int
+		per   time.Duration
+		taken int
+		// How long ago the bucket was last updated.
+		idle    time.Duration
+		allowed bool
+		// Roughly, when not allowed.
+		retry_after time.Duration
+	}{
+		{"full bucket", 3, time.Minute, 0, 0, true, 0},
+		{"last token", 3, time.Minute, 2, 0, true, 0},
+		{"empty", 3, time.Minute, 3, 0, false, 20 * time.Second},
+		{"partly refilled", 3, time.Minute, 3, 10 * time.Second, false, 10 * time.Second},
+		{"refilled one", 3, time.Minute, 3, 20 * time.Second, true, 0},
+		{"refill stops at rate", 3, time.Minute, 3, time.Hour, true, 0},
+		{"zero rate is unlimited", 0, time.Minute, 100, 0, true, 0},
+	}
+	for _, test := range tests {
+		l := NewRateLimiter(test.rate, test.per)
+		for i := 0; i < test.taken; i++ {
+			l.Allow("user")
+		}
+		if bucket, present := l.buckets["user"]; present == true {
+			bucket.updated = bucket.updated.Add(-test.idle)
+		}
+		allowed, retry_after := l.Allow("user")
+		if allowed != test.allowed {
+			t.Errorf("%s: allowed = %v, want %v", test.name, allowed, test.allowed)
+		}
+		if diff := retry_after - test.retry_after; diff < -time.Second || diff > time.Second {
+			t.Errorf("%s: retry after = %s, want about %s", test.name, retry_after, test.retry_after)
+		}
+	}
+}
+
+func TestRateLimiterRefillCapped(t *testing.T) {
+	l := NewRateLimiter(3, time.Minute)
+	l.Allow("user")
+	l.buckets["user"].updated = time.Now().Add(-time.Hour)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("user"); ok {
+			allowed += 1
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d runs after a long idle, want 3", allowed)
+	}
+}
+
+func TestRateLimiterKeysAreSeparate(t *testing.T) {
+	l := NewRateLimiter(1, time.Minute)
+	tests := []struct {
+		key     string
+		allowed bool
+	}{
+		{"a", true},
+		{"a", false},
+		{"b", true},
+		{"ip:203.0.113.5", true},
+		{"b", false},
+	}
+	for i, test := range tests {
+		if allowed, _ := l.Allow(test.key); allowed != test.allowed {
+			t.Errorf("%d %s: allowed = %v, want %v", i, test.key, allowed, test.allowed)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(2, time.Minute)
+	l.Allow("full")
+	l.buckets["full"].updated = time.Now().Add(-time.Hour)
+	l.Allow("used")
+	l.sweep(time.Now())
+	if _, present := l.buckets["full"]; present == true {
+		t.Errorf("sweep kept a full bucket")
+	}
+	if _, present := l.buckets["used"]; present == false {
+		t.Errorf("sweep dropped a bucket that isn't full")
+	}
+}
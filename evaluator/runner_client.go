@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner hosts come and go, so rather than one hardcoded URL the evaluator
+// knows a set of runner backends. It only sends work to backends that answer
+// /ping and run the language, prefers the one with the fewest requests in
+// flight for its capacity, retries on another backend when a request fails in
+// a way that running it again can't hurt, and stops sending to a backend
+// that keeps failing until it has had time to recover.
+
+type RunnerUnavailableError struct {
+	Language  string
+	Attempts  int
+	LastError error
+}
+
+func (e RunnerUnavailableError) Error() string {
+	if e.LastError == nil {
+		return fmt.Sprintf("no runner available for language '%s'", e.Language)
+	}
+	return fmt.Sprintf("no runner could run language '%s' after %d attempts, last error: %s",
+		e.Language, e.Attempts, e.LastError)
+}
+
+type RunnerClient struct {
+	// Deadline of one attempt, which must allow for the runner's own queue.
+	AttemptTimeout time.Duration
+	// Deadline of the whole call, across retries.
+	Deadline    time.Duration
+	MaxAttempts int
+	// Consecutive failures that open a backend's circuit, and how long it
+	// stays open before one trial request is let through.
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	mutex    sync.Mutex
+	backends []*RunnerBackend
+	client   *http.Client
+}
+
+type RunnerBackend struct {
+	URL                 string    `json:"url"`
+	Healthy             bool      `json:"healthy"`
+	InFlight            int       `json:"in_flight"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	CircuitOpenUntil    time.Time `json:"circuit_open_until,omitempty"`
+	LastChecked         time.Time `json:"last_checked,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	Requests            int64     `json:"requests"`
+	Failures            int64     `json:"failures"`
+
+	// Set by runners that register themselves, see fleet.go. A backend
+	// without Languages is assumed to run every language.
+	Languages      []string  `json:"languages,omitempty"`
+	Capacity       int       `json:"capacity,omitempty"`
+	Version        string    `json:"version,omitempty"`
+	Registered     bool      `json:"registered"`
+	RegisteredAt   time.Time `json:"registered_at,omitempty"`
+	LastHeartbeat  time.Time `json:"last_heartbeat,omitempty"`
+	RunnerInFlight int       `json:"runner_in_flight"`
+
+	// Set while the one trial request of a half-open circuit is in flight.
+	trial bool
+	// Listed in -runners, so never evicted.
+	static bool
+}
+
+// Backends that haven't said otherwise run one program at a time.
+func (b *RunnerBackend) capacity() int {
+	if b.Capacity < 1 {
+		return 1
+	}
+	return b.Capacity
+}
+
+func (b *RunnerBackend) runs(language string) bool {
+	if len(b.Languages) == 0 {
+		return true
+	}
+	for _, l := range b.Languages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
+
+// Set up in main from the -runners flags.
+var runnerClient *RunnerClient
+
+// Runners answer /ping straight away, even while running code.
+const runnerPingTimeout = 5 * time.Second
+
+func NewRunnerClient(urls []string, attempt_timeout time.Duration, deadline time.Duration) *RunnerClient {
+	c := &RunnerClient{
+		AttemptTimeout:   attempt_timeout,
+		Deadline:         deadline,
+		MaxAttempts:      3,
+		FailureThreshold: 3,
+		OpenDuration:     30 * time.Second,
+		client:           &http.Client{},
+	}
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url != "" {
+			c.AddBackend(url)
+		}
+	}
+	for _, b := range c.backends {
+		b.static = true
+	}
+	return c
+}
+
+// Backends start healthy so that work can be sent before the first health
+// check.
+func (c *RunnerClient) AddBackend(url string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	url = strings.TrimSuffix(url, "/")
+	for _, b := range c.backends {
+		if b.URL == url {
+			return
+		}
+	}
+	c.backends = append(c.backends, &RunnerBackend{URL: url, Healthy: true})
+}
+
+func (c *RunnerClient) RemoveBackend(url string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	url = strings.TrimSuffix(url, "/")
+	for i, b := range c.backends {
+		if b.URL == url {
+			c.backends = append(c.backends[:i], c.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// A copy of every backend's state, for the admin API.
+func (c *RunnerClient) Status() []RunnerBackend {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status := make([]RunnerBackend, 0, len(c.backends))
+	for _, b := range c.backends {
+		status = append(status, *b)
+	}
+	return status
+}
+
+// Runs code with unit_test on some runner backend.
+func (c *RunnerClient) Run(logger *log.Logger, language string, code string, unit_test string,
+	files map[string][]byte) (*runner_response_struct, error) {
+	logger.Printf("RunnerClient.Run entry. language: %s", language)
+	defer logger.Printf("RunnerClient.Run exit.")
+
+	data := make(map[string]interface{})
+	data["code"] = code
+	data["unit_test"] = unit_test
+	if len(files) > 0 {
+		data["files"] = files
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Deadline)
+	defer cancel()
+	tried := make(map[*RunnerBackend]bool)
+	var last_error error
+	attempts := 0
+	for attempts < c.MaxAttempts && ctx.Err() == nil {
+		backend := c.acquire(language, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+		attempts += 1
+		response, retryable, err := c.attempt(ctx, backend, language, body)
+		// Transport errors and 5xx count against the runner. A 4xx is the
+		// runner's answer about the request, so it counts as the runner
+		// working.
+		if retryable {
+			c.release(backend, err)
+		} else {
+			c.release(backend, nil)
+		}
+		if err == nil {
+			return response, nil
+		}
+		logger.Printf("attempt %d on runner %s failed: %s", attempts, backend.URL, err)
+		last_error = err
+		if !retryable {
+			return nil, err
+		}
+	}
+	if last_error == nil && ctx.Err() != nil {
+		last_error = ctx.Err()
+	}
+	return nil, RunnerUnavailableError{language, attempts, last_error}
+}
+
+// Returns whether a failure is worth retrying elsewhere. Runs are
+// sandboxed and leave nothing behind, so running the code twice is
+// harmless; only a runner's verdict on a bad request is final.
+func (c *RunnerClient) attempt(ctx context.Context, backend *RunnerBackend, language string,
+	body []byte) (*runner_response_struct, bool, error) {
+	attempt_ctx, cancel := context.WithTimeout(ctx, c.AttemptTimeout)
+	defer cancel()
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/run/%s", backend.URL, language), bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	request = request.WithContext(attempt_ctx)
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		response_body, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("runner returned HTTP %d: %s", resp.StatusCode, response_body)
+		return nil, resp.StatusCode >= 500, err
+	}
+	var response runner_response_struct
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, true, err
+	}
+	return &response, false, nil
+}
+
+// Picks the usable backend for language that hasn't been tried yet and has
+// the fewest requests in flight for its capacity, or nil if there is none.
+func (c *RunnerClient) acquire(language string, tried map[*RunnerBackend]bool) *RunnerBackend {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	var best *RunnerBackend
+	for _, b := range c.backends {
+		if tried[b] || !b.Healthy || !b.runs(language) || !c.closedOrHalfOpen(b, now) {
+			continue
+		}
+		// b.InFlight/b.capacity() < best.InFlight/best.capacity()
+		if best == nil || b.InFlight*best.capacity() < best.InFlight*b.capacity() {
+			best = b
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	if !best.CircuitOpenUntil.IsZero() {
+		best.trial = true
+	}
+	best.InFlight += 1
+	best.Requests += 1
+	return best
+}
+
+// A closed circuit takes any number of requests; a half-open one, whose
+// open period has passed, takes a single trial request.
+func (c *RunnerClient) closedOrHalfOpen(b *RunnerBackend, now time.Time) bool {
+	if b.CircuitOpenUntil.IsZero() {
+		return true
+	}
+	return now.After(b.CircuitOpenUntil) && !b.trial
+}
+
+func (c *RunnerClient) release(backend *RunnerBackend, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	backend.InFlight -= 1
+	backend.trial = false
+	if err == nil {
+		backend.ConsecutiveFailures = 0
+		backend.CircuitOpenUntil = time.Time{}
+		return
+	}
+	backend.Failures += 1
+	backend.ConsecutiveFailures += 1
+	backend.LastError = err.Error()
+	if backend.ConsecutiveFailures >= c.FailureThreshold {
+		backend.CircuitOpenUntil = time.Now().Add(c.OpenDuration)
+	}
+}
+
+// Pings every backend each interval until stop is closed. A backend that
+// doesn't answer "pong" gets no work until it does.
+func (c *RunnerClient) HealthCheck(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.checkBackends()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *RunnerClient) checkBackends() {
+	c.mutex.Lock()
+	backends := append([]*RunnerBackend{}, c.backends...)
+	c.mutex.Unlock()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b *RunnerBackend) {
+			defer wg.Done()
+			err := ping(b.URL, runnerPingTimeout)
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			b.Healthy = err == nil
+			b.LastChecked = time.Now()
+			if err != nil {
+				b.LastError = err.Error()
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+func ping(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url + "/ping")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "pong" {
+		return fmt.Errorf("ping returned HTTP %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// "closed", "open" or "half-open", going by what acquire would do.
+func circuitState(c *RunnerClient, b *RunnerBackend) string {
+	if b.CircuitOpenUntil.IsZero() {
+		return "closed"
+	}
+	if c.closedOrHalfOpen(b, time.Now()) {
+		return "half-open"
+	}
+	return "open"
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name string
+		// Each step acquires the backend and releases it with "ok" or
+		// "fail", or "wait"s out the open period.
+		steps    []string
+		state    string
+		failures int
+	}{
+		{"starts closed", nil, "closed", 0},
+		{"below threshold", []string{"fail", "fail"}, "closed", 2},
+		{"opens at threshold", []string{"fail", "fail", "fail"}, "open", 3},
+		{"success resets count", []string{"fail", "fail", "ok", "fail", "fail"}, "closed", 2},
+		{"half-open after open period", []string{"fail", "fail", "fail", "wait"}, "half-open", 3},
+		{"trial success closes", []string{"fail", "fail", "fail", "wait", "ok"}, "closed", 0},
+		{"trial failure reopens", []string{"fail", "fail", "fail", "wait", "fail"}, "open", 4},
+	}
+	for _, test := range tests {
+		c := NewRunnerClient([]string{"http://runner"}, time.Second, time.Second)
+		b := c.backends[0]
+		for _, step := range test.steps {
+			if step == "wait" {
+				b.CircuitOpenUntil = time.Now().Add(-time.Second)
+				continue
+			}
+			if c.acquire("python", map[*RunnerBackend]bool{}) != b {
+				t.Fatalf("%s: backend not acquired before %s", test.name, step)
+			}
+			if step == "fail" {
+				c.release(b, errors.New("connection refused"))
+			} else {
+				c.release(b, nil)
+			}
+		}
+		if state := circuitState(c, b); state != test.state {
+			t.Errorf("%s: state = %s, want %s", test.name, state, test.state)
+		}
+		if b.ConsecutiveFailures != test.failures {
+			t.Errorf("%s: consecutive failures = %d, want %d", test.name, b.ConsecutiveFailures, test.failures)
+		}
+	}
+}
+
+func TestHalfOpenCircuitTakesOneTrial(t *testing.T) {
+	c := NewRunnerClient([]string{"http://runner"}, time.Second, time.Second)
+	b := c.backends[0]
+	b.ConsecutiveFailures = c.FailureThreshold
+	b.CircuitOpenUntil = time.Now().Add(-time.Second)
+	if c.acquire("python", map[*RunnerBackend]bool{}) != b {
+		t.Fatalf("trial request not let through")
+	}
+	if c.acquire("python", map[*RunnerBackend]bool{}) != nil {
+		t.Errorf("second request let through while the trial is in flight")
+	}
+}
+
+func TestRunnerClientRunCountsOnlyRunnerFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		failures int
+		attempts int
+	}{
+		{"success", http.StatusOK, 0, 1},
+		{"bad request is final", http.StatusBadRequest, 0, 1},
+		{"server error is retried", http.StatusInternalServerError, 1, 2},
+	}
+	for _, test := range tests {
+		attempts := 0
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts += 1
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, `{"success": true, "output": "ok"}`)
+		}))
+		working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts += 1
+			fmt.Fprint(w, `{"success": true, "output": "ok"}`)
+		}))
+		c := NewRunnerClient([]string{failing.URL}, time.Second, 5*time.Second)
+		// Only reached on a retry, since acquire prefers the first backend
+		// when both are idle.
+		c.AddBackend(working.URL)
+		c.Run(logger, "python", "print(1)", "", nil)
+		if c.backends[0].ConsecutiveFailures != test.failures {
+			t.Errorf("%s: consecutive failures = %d, want %d", test.name, c.backends[0].ConsecutiveFailures, test.failures)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%s: attempts = %d, want %d", test.name, attempts, test.attempts)
+		}
+		failing.Close()
+		working.Close()
+	}
+}
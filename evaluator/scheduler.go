@@ -0,0 +1,460 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runs wait in the evaluator for a free runner slot rather than piling up
+// on the runners, so that the evaluator decides who goes next. The highest
+// priority class with anyone waiting always goes first. Within a class,
+// users take turns, one run each, so someone clicking "check" over and over
+// only delays their own runs.
+
+// Best first. "background" is another name for "rejudge".
+var runPriorities = []string{"contest", "submit", "practice", "rejudge"}
+
+// What runs from the browser get, and the best they may ask for. Better
+// classes need the -internal-token, which user_data sends.
+const defaultRunPriority = "practice"
+
+// Runs one user may have waiting in one class at once.
+const maxQueuedRunsPerUser = 10
+
+// Until some runs have finished.
+const defaultRunDuration = 5 * time.Second
+
+func runPriorityRank(priority string) (int, bool) {
+	if priority == "background" {
+		priority = "rejudge"
+	}
+	for rank, p := range runPriorities {
+		if p == priority {
+			return rank, true
+		}
+	}
+	return 0, false
+}
+
+type RunTicket struct {
+	Id       string `json:"id"`
+	Priority string `json:"priority"`
+	UserId   string `json:"-"`
+	Language string `json:"language"`
+
+	rank        int
+	enqueued_at time.Time
+	started_at  time.Time
+	granted     chan struct{}
+}
+
+type TooManyQueuedRunsError struct {
+	UserId string
+}
+
+func (e TooManyQueuedRunsError) Error() string {
+	return fmt.Sprintf("already %d runs waiting, wait for them to finish", maxQueuedRunsPerUser)
+}
+
+type QueueTimeoutError struct {
+	Waited time.Duration
+}
+
+func (e QueueTimeoutError) Error() string {
+	return fmt.Sprintf("no runner slot became free within %s", e.Waited)
+}
+
+type RunScheduler struct {
+	// How many runs may be in flight, asked again whenever it matters, as
+	// runners come and go.
+	Slots func() int
+
+	mutex   sync.Mutex
+	running int
+	// By rank, the users with runs waiting in the order they take turns,
+	// and each user's runs.
+	turns   [][]string
+	waiting []map[string][]*RunTicket
+	tickets map[string]*RunTicket
+	// Moving average of how long runs hold a slot, for estimates.
+	average_run time.Duration
+}
+
+// Set up in main.
+var runScheduler *RunScheduler
+
+func NewRunScheduler(slots func() int) *RunScheduler {
+	s := &RunScheduler{
+		Slots:       slots,
+		turns:       make([][]string, len(runPriorities)),
+		waiting:     make([]map[string][]*RunTicket, len(runPriorities)),
+		tickets:     make(map[string]*RunTicket),
+		average_run: defaultRunDuration,
+	}
+	for rank := range runPriorities {
+		s.waiting[rank] = make(map[string][]*RunTicket)
+	}
+	return s
+}
+
+// Runner slots in the fleet, at least one so that runs fail fast when no
+// runner is up rather than waiting for one. Through a job queue each pull
+// worker is a slot, whether or not its runner registered.
+func fleetSlots() int {
+	slots := 0
+	if jobDispatcher != nil {
+		slots = jobDispatcher.Workers()
+	} else {
+		for _, b := range runnerClient.Status() {
+			if b.Healthy {
+				slots += b.capacity()
+			}
+		}
+	}
+	if slots < 1 {
+		return 1
+	}
+	return slots
+}
+
+func NewRunTicket(id string, priority string, user_id string, language string) (*RunTicket, error) {
+	rank, ok := runPriorityRank(priority)
+	if !ok {
+		return nil, fmt.Errorf("priority must be one of %s", strings.Join(runPriorities, ", "))
+	}
+	if id == "" {
+		id = randomHex(16)
+	}
+	return &RunTicket{
+		Id:       id,
+		Priority: runPriorities[rank],
+		UserId:   user_id,
+		Language: language,
+		rank:     rank,
+		granted:  make(chan struct{}),
+	}, nil
+}
+
+// Waits up to timeout for a slot. Every successful Acquire must be
+// followed by a Release.
+func (s *RunScheduler) Acquire(logger *log.Logger, ticket *RunTicket, timeout time.Duration) error {
+	logger.Printf("RunScheduler.Acquire entry. ticket: %s, priority: %s, user: %s",
+		ticket.Id, ticket.Priority, ticket.UserId)
+	defer logger.Printf("RunScheduler.Acquire exit. ticket: %s", ticket.Id)
+
+	s.mutex.Lock()
+	if len(s.waiting[ticket.rank][ticket.UserId]) >= maxQueuedRunsPerUser {
+		s.mutex.Unlock()
+		return TooManyQueuedRunsError{ticket.UserId}
+	}
+	if _, present := s.tickets[ticket.Id]; present == true {
+		ticket.Id = randomHex(16)
+	}
+	ticket.enqueued_at = time.Now()
+	s.tickets[ticket.Id] = ticket
+	if len(s.waiting[ticket.rank][ticket.UserId]) == 0 {
+		s.turns[ticket.rank] = append(s.turns[ticket.rank], ticket.UserId)
+	}
+	s.waiting[ticket.rank][ticket.UserId] = append(s.waiting[ticket.rank][ticket.UserId], ticket)
+	s.dispatch()
+	s.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	// Slots can appear without a Release, e.g. when a runner registers.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticket.granted:
+			logger.Printf("ticket %s got a slot after %s", ticket.Id, ticket.started_at.Sub(ticket.enqueued_at))
+			return nil
+		case <-ticker.C:
+			s.mutex.Lock()
+			s.dispatch()
+			s.mutex.Unlock()
+		case <-timer.C:
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			select {
+			case <-ticket.granted:
+				return nil
+			default:
+			}
+			s.remove(ticket)
+			return QueueTimeoutError{timeout}
+		}
+	}
+}
+
+func (s *RunScheduler) Release(ticket *RunTicket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running -= 1
+	delete(s.tickets, ticket.Id)
+	// Weight recent runs, since the mix of languages and problems changes.
+	s.average_run = (s.average_run*9 + time.Since(ticket.started_at)) / 10
+	s.dispatch()
+}
+
+// How long the ticket waited for its slot.
+func (ticket *RunTicket) Waited() time.Duration {
+	return ticket.started_at.Sub(ticket.enqueued_at)
+}
+
+// Hands free slots to the next tickets. Callers hold the mutex.
+func (s *RunScheduler) dispatch() {
+	slots := s.Slots()
+	for s.running < slots {
+		ticket := s.next()
+		if ticket == nil {
+			return
+		}
+		s.running += 1
+		ticket.started_at = time.Now()
+		close(ticket.granted)
+	}
+}
+
+// Takes the next ticket: the best class, then the user whose turn it is,
+// who goes to the back. Callers hold the mutex.
+func (s *RunScheduler) next() *RunTicket {
+	for rank, turns := range s.turns {
+		if len(turns) == 0 {
+			continue
+		}
+		user_id := turns[0]
+		queue := s.waiting[rank][user_id]
+		ticket := queue[0]
+		s.turns[rank] = turns[1:]
+		if len(queue) > 1 {
+			s.waiting[rank][user_id] = queue[1:]
+			s.turns[rank] = append(s.turns[rank], user_id)
+		} else {
+			delete(s.waiting[rank], user_id)
+		}
+		return ticket
+	}
+	return nil
+}
+
+// Callers hold the mutex.
+func (s *RunScheduler) remove(ticket *RunTicket) {
+	delete(s.tickets, ticket.Id)
+	queue := s.waiting[ticket.rank][ticket.UserId]
+	for i, t := range queue {
+		if t == ticket {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		s.waiting[ticket.rank][ticket.UserId] = queue
+		return
+	}
+	delete(s.waiting[ticket.rank], ticket.UserId)
+	turns := s.turns[ticket.rank]
+	for i, user_id := range turns {
+		if user_id == ticket.UserId {
+			s.turns[ticket.rank] = append(turns[:i], turns[i+1:]...)
+			break
+		}
+	}
+}
+
+type QueuePosition struct {
+	Ticket   string `json:"ticket"`
+	Priority string `json:"priority"`
+	// "waiting" or "running".
+	State string `json:"state"`
+	// 1 is next. 0 once running.
+	Position             int `json:"position"`
+	EstimatedWaitSeconds int `json:"estimated_wait_seconds"`
+}
+
+// Where a ticket is in the queue, and roughly how long until it runs. The
+// estimate assumes nobody with a better class turns up meanwhile.
+func (s *RunScheduler) Position(ticket_id string) (QueuePosition, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ticket, present := s.tickets[ticket_id]
+	if present == false {
+		return QueuePosition{}, false
+	}
+	position := QueuePosition{Ticket: ticket.Id, Priority: ticket.Priority, State: "running"}
+	select {
+	case <-ticket.granted:
+		return position, true
+	default:
+	}
+	position.State = "waiting"
+	for i, t := range s.order() {
+		if t == ticket {
+			position.Position = i + 1
+			break
+		}
+	}
+	slots := s.Slots()
+	position.EstimatedWaitSeconds = s.estimateWait(position.Position, slots, slots-s.running)
+	return position, true
+}
+
+// Every waiting ticket in the order next would take them. Callers hold the
+// mutex.
+func (s *RunScheduler) order() []*RunTicket {
+	order := make([]*RunTicket, 0, len(s.tickets))
+	for rank, turns := range s.turns {
+		taken := make(map[string]int)
+		for left := true; left; {
+			left = false
+			for _, user_id := range turns {
+				queue := s.waiting[rank][user_id]
+				if taken[user_id] < len(queue) {
+					order = append(order, queue[taken[user_id]])
+					taken[user_id] += 1
+					left = true
+				}
+			}
+		}
+	}
+	return order
+}
+
+// Seconds until the run at position starts, if free slots start the first
+// runs straight away and the rest start a round of average runs later each.
+// Callers hold the mutex.
+func (s *RunScheduler) estimateWait(position int, slots int, free int) int {
+	if free < 0 {
+		free = 0
+	}
+	if position <= free {
+		return 0
+	}
+	rounds := (position - free + slots - 1) / slots
+	return int((time.Duration(rounds) * s.average_run).Seconds() + 0.5)
+}
+
+type SchedulerStats struct {
+	Slots   int            `json:"slots"`
+	Running int            `json:"running"`
+	Waiting map[string]int `json:"waiting"`
+	// Per class, distinct users waiting.
+	Users             map[string]int `json:"users"`
+	AverageRunSeconds float64        `json:"average_run_seconds"`
+	// Per class, for a run joining it now.
+	EstimatedWaitSeconds map[string]int `json:"estimated_wait_seconds"`
+}
+
+func (s *RunScheduler) Stats() SchedulerStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := SchedulerStats{
+		Slots:                s.Slots(),
+		Running:              s.running,
+		Waiting:              make(map[string]int),
+		Users:                make(map[string]int),
+		AverageRunSeconds:    s.average_run.Seconds(),
+		EstimatedWaitSeconds: make(map[string]int),
+	}
+	ahead := 0
+	for rank, priority := range runPriorities {
+		for _, queue := range s.waiting[rank] {
+			stats.Waiting[priority] += len(queue)
+		}
+		stats.Users[priority] = len(s.waiting[rank])
+		// A newcomer goes after every better class and one turn of each
+		// user in its own.
+		position := ahead + stats.Users[priority] + 1
+		stats.EstimatedWaitSeconds[priority] = s.estimateWait(position, stats.Slots, stats.Slots-s.running)
+		ahead += stats.Waiting[priority]
+	}
+	return stats
+}
+
+// user_data, which sends submissions and rejudges, proves itself with the
+// -internal-token the evaluator was started with.
+func isInternalCaller(r *http.Request) bool {
+	if *internalToken == "" {
+		return false
+	}
+	given := r.Header.Get("X-Internal-Token")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(*internalToken)) == 1
+}
+
+// Who a run is for, for taking turns: the user_id a trusted caller sends,
+// else the logged in user, else the client's address.
+func runUserId(r *http.Request, trusted bool, requested string) string {
+	if trusted && requested != "" {
+		return requested
+	}
+	if user_id, _, ok := getSessionUser(r); ok {
+		return user_id
+	}
+	return "ip:" + clientAddress(r)
+}
+
+// Set up in main from -trusted-proxies.
+var trustedProxies []*net.IPNet
+
+// Parses a comma-separated list of addresses and CIDR ranges.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address of the client that sent r. Each proxy appends the address it
+// got the request from to X-Forwarded-For, so the header is read from the
+// right and believed only while the hop was a trusted proxy. Anything left
+// of the first untrusted address the client may have written itself.
+func clientAddress(r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(address); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		address = hop
+	}
+	return address
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Queues a ticket the way Acquire does, without waiting for a slot.
+func queueTestTicket(t *testing.T, s *RunScheduler, id string, priority string, user_id string) *RunTicket {
+	ticket, err := NewRunTicket(id, priority, user_id, "python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket.enqueued_at = time.Now()
+	s.tickets[ticket.Id] = ticket
+	if len(s.waiting[ticket.rank][user_id]) == 0 {
+		s.turns[ticket.rank] = append(s.turns[ticket.rank], user_id)
+	}
+	s.waiting[ticket.rank][user_id] = append(s.waiting[ticket.rank][user_id], ticket)
+	return ticket
+}
+
+func TestRunSchedulerTakesTurns(t *testing.T) {
+	tests := []struct {
+		name string
+		// "id priority user" per ticket, in the order they arrive.
+		tickets []string
+		order   string
+	}{
+		{"one user in order", []string{"a1 practice a", "a2 practice a", "a3 practice a"}, "a1 a2 a3"},
+		{"users take turns", []string{"a1 practice a", "a2 practice a", "a3 practice a", "b1 practice b", "c1 practice c", "b2 practice b"},
+			"a1 b1 c1 a2 b2 a3"},
+		{"better class first", []string{"r1 rejudge a", "p1 practice b", "s1 submit c", "c1 contest d"}, "c1 s1 p1 r1"},
+		{"background is rejudge", []string{"r1 background a", "r2 rejudge b", "p1 practice a"}, "p1 r1 r2"},
+		{"turns within each class", []string{"p1 practice a", "p2 practice a", "s1 submit b", "p3 practice b", "s2 submit b"},
+			"s1 s2 p1 p3 p2"},
+	}
+	for _, test := range tests {
+		s := NewRunScheduler(func() int { return 0 })
+		for _, spec := range test.tickets {
+			fields := strings.Fields(spec)
+			queueTestTicket(t, s, fields[0], fields[1], fields[2])
+		}
+		planned := make([]string, 0)
+		for _, ticket := range s.order() {
+			planned = append(planned, ticket.Id)
+		}
+		if strings.Join(planned, " ") != test.order {
+			t.Errorf("%s: order() = %s, want %s", test.name, strings.Join(planned, " "), test.order)
+		}
+		taken := make([]string, 0)
+		for ticket := s.next(); ticket != nil; ticket = s.next() {
+			taken = append(taken, ticket.Id)
+		}
+		if strings.Join(taken, " ") != test.order {
+			t.Errorf("%s: next() took %s, want %s", test.name, strings.Join(taken, " "), test.order)
+		}
+	}
+}
+
+func TestRunSchedulerDispatchFillsSlots(t *testing.T) {
+	tests := []struct {
+		name    string
+		slots   int
+		running int
+		granted int
+	}{
+		{"free slots", 2, 0, 2},
+		{"one free", 2, 1, 1},
+		{"full", 2, 2, 0},
+		{"slots went away", 1, 3, 0},
+	}
+	for _, test := range tests {
+		s := NewRunScheduler(func() int { return test.slots })
+		s.running = test.running
+		tickets := []*RunTicket{
+			queueTestTicket(t, s, "a1", "practice", "a"),
+			queueTestTicket(t, s, "b1", "practice", "b"),
+			queueTestTicket(t, s, "c1", "practice", "c"),
+		}
+		s.dispatch()
+		granted := 0
+		for _, ticket := range tickets {
+			select {
+			case <-ticket.granted:
+				granted += 1
+			default:
+			}
+		}
+		if granted != test.granted {
+			t.Errorf("%s: granted %d, want %d", test.name, granted, test.granted)
+		}
+	}
+}
+
+func TestRunSchedulerEstimateWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		position int
+		slots    int
+		free     int
+		wait     int
+	}{
+		{"free slot", 1, 2, 1, 0},
+		{"all fit in free slots", 2, 2, 2, 0},
+		{"next when full", 1, 2, 0, 5},
+		{"second round", 3, 2, 0, 10},
+		{"after free slots", 3, 2, 1, 5},
+		{"over-full counts as full", 1, 1, -2, 5},
+	}
+	for _, test := range tests {
+		s := NewRunScheduler(func() int { return test.slots })
+		if wait := s.estimateWait(test.position, test.slots, test.free); wait != test.wait {
+			t.Errorf("%s: estimateWait = %d, want %d", test.name, wait, test.wait)
+		}
+	}
+}
+
+func TestRunSchedulerPosition(t *testing.T) {
+	s := NewRunScheduler(func() int { return 1 })
+	s.running = 1
+	queueTestTicket(t, s, "a1", "practice", "a")
+	queueTestTicket(t, s, "a2", "practice", "a")
+	queueTestTicket(t, s, "b1", "practice", "b")
+	queueTestTicket(t, s, "s1", "submit", "c")
+	tests := []struct {
+		ticket   string
+		position int
+		wait     int
+	}{
+		{"s1", 1, 5},
+		{"a1", 2, 10},
+		{"b1", 3, 15},
+		{"a2", 4, 20},
+	}
+	for _, test := range tests {
+		position, ok := s.Position(test.ticket)
+		if !ok {
+			t.Fatalf("%s: not found", test.ticket)
+		}
+		if position.State != "waiting" || position.Position != test.position ||
+			position.EstimatedWaitSeconds != test.wait {
+			t.Errorf("%s: Position = %+v, want waiting at %d, %d seconds", test.ticket, position, test.position, test.wait)
+		}
+	}
+	if _, ok := s.Position("missing"); ok {
+		t.Errorf("Position found a ticket that was never queued")
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	proxies, err := ParseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies = proxies
+	defer func() { trustedProxies = nil }()
+	tests := []struct {
+		name            string
+		remote_addr     string
+		x_forwarded_for string
+		address         string
+	}{
+		{"direct", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"untrusted sender's header ignored", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"through nginx", "127.0.0.1:4000", "203.0.113.5", "203.0.113.5"},
+		{"spoofed left of the client", "127.0.0.1:4000", "198.51.100.1, 203.0.113.5", "203.0.113.5"},
+		{"through two trusted proxies", "127.0.0.1:4000", "203.0.113.5, 10.1.2.3", "203.0.113.5"},
+		{"trusted proxy without header", "127.0.0.1:4000", "", "127.0.0.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/evaluator/run/two_sum/python", nil)
+		r.RemoteAddr = test.remote_addr
+		if test.x_forwarded_for != "" {
+			r.Header.Set("X-Forwarded-For", test.x_forwarded_for)
+		}
+		if address := clientAddress(r); address != test.address {
+			t.Errorf("%s: clientAddress = %s, want %s", test.name, address, test.address)
+		}
+	}
+}
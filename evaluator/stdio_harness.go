@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Languages we can generate a standard input/output harness for. The
+// harness runs the user's whole program in-process once per test case, with
+// standard input and output redirected, since the sandbox doesn't allow
+// starting another process.
+//
+// The harness only runs the program. It reads the cases from
+// test_data.cases, which stdioSandboxFiles writes next to it, and prints one
+// line per case with how the run ended and a digest of the output tokens.
+// The evaluator compares the digests with those of the expected outputs in
+// JudgeStdioOutput, so expected outputs never enter the sandbox. The
+// runner's output is capped, hence digests rather than the outputs.
+var stdioHarnessLanguages = []string{"python", "java"}
+
+const (
+	// Sandbox files generated from TestData start with this, so attachment
+	// names may not.
+	stdioFilePrefix    = "test_data."
+	stdioCasesFilename = stdioFilePrefix + "cases"
+	stdioResultPrefix  = "@@stdio "
+	// Hex digits of the SHA-256 digest the harnesses print.
+	stdioDigestLength = 16
+	// How much of a failed sample's expected output the report shows.
+	maxSampleReportBytes = 200
+)
+
+// How a case ended, as printed by the harnesses.
+const (
+	stdioStatusOK                  = "ok"
+	stdioStatusRuntimeError        = "runtime_error"
+	stdioStatusTimeLimitExceeded   = "time_limit_exceeded"
+	stdioStatusMemoryLimitExceeded = "memory_limit_exceeded"
+)
+
+const pythonStdioInitialCode = `import sys
+
+# Read the input from sys.stdin and print the answer.
+for line in sys.stdin:
+    pass
+`
+
+// Time limits use SIGALRM. ru_maxrss only grows, so the first case that
+// takes the process past the memory limit is the one that fails.
+const pythonStdioUnitTest = `import base64
+import hashlib
+import os
+import resource
+import signal
+import sys
+import traceback
+
+try:
+    from StringIO import StringIO
+except ImportError:
+    from io import StringIO
+
+DIRECTORY = os.path.dirname(os.path.abspath(__file__))
+SOURCE_FILEPATH = os.path.join(DIRECTORY, "foo.py")
+
+
+class TimeLimitExceeded(BaseException):
+    pass
+
+
+def on_alarm(signum, frame):
+    raise TimeLimitExceeded()
+
+
+def read_file(name):
+    with open(os.path.join(DIRECTORY, name), "rb") as f:
+        return f.read().decode("utf-8")
+
+
+def digest(output):
+    if not isinstance(output, bytes):
+        output = output.encode("utf-8")
+    return hashlib.sha256(b" ".join(output.split())).hexdigest()[:%d]
+
+
+def run(stdin, time_limit):
+    saved_stdin, saved_stdout = sys.stdin, sys.stdout
+    sys.stdin, sys.stdout = StringIO(stdin), StringIO()
+    status = "ok"
+    try:
+        with open(SOURCE_FILEPATH) as f:
+            code = compile(f.read(), SOURCE_FILEPATH, "exec")
+        if time_limit > 0:
+            signal.setitimer(signal.ITIMER_REAL, time_limit)
+        try:
+            exec(code, {"__name__": "__main__"})
+        except SystemExit:
+            pass
+        except TimeLimitExceeded:
+            status = "time_limit_exceeded"
+        except MemoryError:
+            status = "memory_limit_exceeded"
+        except Exception:
+            traceback.print_exc()
+            status = "runtime_error"
+        finally:
+            signal.setitimer(signal.ITIMER_REAL, 0)
+        return status, sys.stdout.getvalue()
+    finally:
+        sys.stdin, sys.stdout = saved_stdin, saved_stdout
+
+
+def main():
+    signal.signal(signal.SIGALRM, on_alarm)
+    lines = read_file("test_data.cases").splitlines()
+    time_limit, memory_limit_mb = lines[0].split()
+    time_limit, memory_limit_kb = float(time_limit), int(memory_limit_mb) * 1024
+    for index, name in enumerate(lines[1:]):
+        status, output = run(read_file(name), time_limit)
+        if status == "ok" and memory_limit_kb > 0 and \
+                resource.getrusage(resource.RUSAGE_SELF).ru_maxrss > memory_limit_kb:
+            status = "memory_limit_exceeded"
+        sys.stdout.write("@@stdio %%d %%s %%s\n" %% (index, status, digest(output)))
+        sys.stdout.flush()
+
+if __name__ == '__main__':
+    main()
+`
+
+const javaStdioInitialCode = `import java.io.*;
+import java.util.*;
+
+public class Solution {
+    public static void main(String[] args) throws IOException {
+        BufferedReader reader = new BufferedReader(new InputStreamReader(System.in));
+        // Read the input from System.in and print the answer to System.out.
+    }
+}
+`
+
+// Each case loads Solution in a fresh class loader, so static state such as
+// a cached reader doesn't carry over, and runs it in its own thread so the
+// time limit can be enforced. A thread that runs out of time can't be
+// stopped safely, so the remaining cases are skipped. Memory is the peak
+// heap use during the case, summed over the heap pools, so it errs high.
+const javaStdioUnitTest = `import java.io.*;
+import java.lang.management.*;
+import java.lang.reflect.*;
+import java.net.*;
+import java.nio.file.*;
+import java.security.*;
+import java.util.*;
+
+public class SolutionTest {
+    private static class ExitException extends SecurityException {
+    }
+
+    private static volatile boolean running = false;
+
+    private static File directory() throws Exception {
+        return new File(SolutionTest.class.getProtectionDomain().getCodeSource().getLocation().toURI());
+    }
+
+    private static String digest(byte[] output) throws Exception {
+        String[] tokens = new String(output, "UTF-8").trim().split("\\s+");
+        String joined = tokens.length == 1 && tokens[0].isEmpty() ? "" : String.join(" ", tokens);
+        byte[] hash = MessageDigest.getInstance("SHA-256").digest(joined.getBytes("UTF-8"));
+        StringBuilder hex = new StringBuilder();
+        for (byte b : hash) {
+            hex.append(String.format("%%02x", b));
+        }
+        return hex.substring(0, %d);
+    }
+
+    private static long heapUsed(boolean peak) {
+        long used = 0;
+        for (MemoryPoolMXBean pool : ManagementFactory.getMemoryPoolMXBeans()) {
+            if (pool.getType() == MemoryType.HEAP) {
+                used += peak ? pool.getPeakUsage().getUsed() : pool.getUsage().getUsed();
+            }
+        }
+        return used;
+    }
+
+    private static void resetPeakHeapUsed() {
+        for (MemoryPoolMXBean pool : ManagementFactory.getMemoryPoolMXBeans()) {
+            if (pool.getType() == MemoryType.HEAP) {
+                pool.resetPeakUsage();
+            }
+        }
+    }
+
+    // Returns the status, and fills in output.
+    private static String run(final URL directory, byte[] input, ByteArrayOutputStream output,
+                              double time_limit, long memory_limit) throws Exception {
+        final String[] status = {"ok"};
+        Thread thread = new Thread(new Runnable() {
+            public void run() {
+                try {
+                    ClassLoader loader = new URLClassLoader(new URL[]{directory}, ClassLoader.getSystemClassLoader().getParent());
+                    Method main = Class.forName("Solution", true, loader).getMethod("main", String[].class);
+                    main.invoke(null, (Object) new String[0]);
+                } catch (InvocationTargetException e) {
+                    Throwable cause = e.getCause();
+                    if (cause instanceof ExitException) {
+                        return;
+                    } else if (cause instanceof OutOfMemoryError) {
+                        status[0] = "memory_limit_exceeded";
+                    } else {
+                        cause.printStackTrace();
+                        status[0] = "runtime_error";
+                    }
+                } catch (Throwable e) {
+                    e.printStackTrace();
+                    status[0] = "runtime_error";
+                }
+            }
+        });
+        thread.setDaemon(true);
+        InputStream saved_in = System.in;
+        PrintStream saved_out = System.out;
+        System.setIn(new ByteArrayInputStream(input));
+        System.setOut(new PrintStream(output, true, "UTF-8"));
+        System.gc();
+        long baseline = heapUsed(false);
+        resetPeakHeapUsed();
+        running = true;
+        try {
+            thread.start();
+            thread.join(time_limit > 0 ? (long) (time_limit * 1000) : 0);
+        } finally {
+            running = false;
+            System.out.flush();
+            System.setIn(saved_in);
+            System.setOut(saved_out);
+        }
+        if (thread.isAlive()) {
+            return "time_limit_exceeded";
+        }
+        if (status[0].equals("ok") && memory_limit > 0 && heapUsed(true) - baseline > memory_limit) {
+            return "memory_limit_exceeded";
+        }
+        return status[0];
+    }
+
+    public static void main(String[] args) throws Exception {
+        // Solution calling System.exit ends its case, not the harness. JVMs
+        // without a security manager end the run instead.
+        try {
+            System.setSecurityManager(new SecurityManager() {
+                public void checkPermission(Permission permission) {
+                }
+
+                public void checkExit(int status) {
+                    if (running) {
+                        throw new ExitException();
+                    }
+                }
+            });
+        } catch (UnsupportedOperationException e) {
+        }
+        File directory = directory();
+        List<String> lines = Files.readAllLines(new File(directory, "test_data.cases").toPath(), java.nio.charset.StandardCharsets.UTF_8);
+        String[] limits = lines.get(0).trim().split(" ");
+        double time_limit = Double.parseDouble(limits[0]);
+        long memory_limit = Long.parseLong(limits[1]) * 1024 * 1024;
+        for (int i = 1; i < lines.size(); i++) {
+            byte[] input = Files.readAllBytes(new File(directory, lines.get(i)).toPath());
+            ByteArrayOutputStream output = new ByteArrayOutputStream();
+            String status = run(directory.toURI().toURL(), input, output, time_limit, memory_limit);
+            System.out.println("@@stdio " + (i - 1) + " " + status + " " + digest(output.toByteArray()));
+            System.out.flush();
+            if (status.equals("time_limit_exceeded")) {
+                break;
+            }
+        }
+        System.exit(0);
+    }
+}
+`
+
+// Fills in the unit test and initial code for every language we have a
+// harness for, unless the problem already provides them. Does nothing if the
+// problem has no TestData. If SupportedLanguages is empty it becomes every
+// language with a harness.
+func (p *Problem) GenerateStdioTests() {
+	if len(p.TestData) == 0 {
+		return
+	}
+	if len(p.SupportedLanguages) == 0 {
+		p.SupportedLanguages = append([]string{}, stdioHarnessLanguages...)
+	}
+	if p.UnitTest == nil {
+		p.UnitTest = make(map[string]unit_test)
+	}
+	if p.InitialCode == nil {
+		p.InitialCode = make(map[string]initial_code)
+	}
+	for _, language := range p.SupportedLanguages {
+		if _, present := p.UnitTest[language]; present == false {
+			if code, ok := stdioUnitTest(language); ok {
+				p.UnitTest[language] = unit_test{Code: code}
+			}
+		}
+		if _, present := p.InitialCode[language]; present == false {
+			if code, ok := stdioInitialCode(language); ok {
+				p.InitialCode[language] = initial_code{Code: code}
+			}
+		}
+	}
+}
+
+func stdioInitialCode(language string) (string, bool) {
+	switch language {
+	case "python":
+		return pythonStdioInitialCode, true
+	case "java":
+		return javaStdioInitialCode, true
+	}
+	return "", false
+}
+
+func stdioUnitTest(language string) (string, bool) {
+	switch language {
+	case "python":
+		return fmt.Sprintf(pythonStdioUnitTest, stdioDigestLength), true
+	case "java":
+		return fmt.Sprintf(javaStdioUnitTest, stdioDigestLength), true
+	}
+	return "", false
+}
+
+// The files the harness reads: test_data.cases, with the time and memory
+// limits (0 for none) on the first line and then the input file of each
+// case, and a test_data.<n>.in for each input given inline.
+func stdioSandboxFiles(p *Problem) map[string][]byte {
+	files := make(map[string][]byte)
+	var cases bytes.Buffer
+	fmt.Fprintf(&cases, "%s %d\n", strconv.FormatFloat(p.TimeLimitSeconds, 'f', -1, 64), p.MemoryLimitMB)
+	for i, test_case := range p.TestData {
+		name := test_case.InputFile
+		if name == "" {
+			name = fmt.Sprintf("%s%d.in", stdioFilePrefix, i)
+			files[name] = []byte(test_case.Input)
+		}
+		fmt.Fprintf(&cases, "%s\n", name)
+	}
+	files[stdioCasesFilename] = cases.Bytes()
+	return files
+}
+
+// The digest the harnesses print for output: SHA-256 of its tokens joined
+// by single spaces. Tokens are split on ASCII whitespace, as in the
+// harnesses.
+func stdioDigest(output []byte) string {
+	digest := sha256.Sum256(bytes.Join(bytes.Fields(output), []byte(" ")))
+	return hex.EncodeToString(digest[:])[:stdioDigestLength]
+}
+
+type stdioResult struct {
+	status string
+	digest string
+}
+
+// Checks the lines the harness printed against the expected outputs of
+// p.TestData and replaces the runner's output with a report that starts
+// with the failed cases. Anything else the run printed, such as errors,
+// follows the report. A run that reported no cases at all, e.g. because it
+// didn't compile, is returned as it is.
+func JudgeStdioOutput(logger *log.Logger, p *Problem, runner_response runner_response_struct) (runner_response_struct, error) {
+	results := make(map[int]stdioResult)
+	var other bytes.Buffer
+	for _, line := range strings.SplitAfter(runner_response.Output, "\n") {
+		fields := strings.Fields(line)
+		if !strings.HasPrefix(line, stdioResultPrefix) || len(fields) != 4 {
+			other.WriteString(line)
+			continue
+		}
+		index, err := strconv.Atoi(fields[1])
+		if err != nil {
+			other.WriteString(line)
+			continue
+		}
+		if _, present := results[index]; present == false {
+			results[index] = stdioResult{status: fields[2], digest: fields[3]}
+		}
+	}
+	if len(results) == 0 {
+		return runner_response, nil
+	}
+
+	var report bytes.Buffer
+	judged := runner_response_struct{
+		RunSeconds:  runner_response.RunSeconds,
+		TimedOut:    runner_response.TimedOut,
+		Killed:      runner_response.Killed,
+		RunnerError: runner_response.RunnerError,
+	}
+	passed := 0
+	for i, test_case := range p.TestData {
+		result, present := results[i]
+		if present == false {
+			fmt.Fprintf(&report, "test case %s: not run\n", test_case.Name)
+			continue
+		}
+		switch result.status {
+		case stdioStatusTimeLimitExceeded:
+			judged.TimedOut = true
+		case stdioStatusMemoryLimitExceeded:
+			judged.OutOfMemory = true
+		}
+		if result.status != stdioStatusOK {
+			fmt.Fprintf(&report, "test case %s: %s\n", test_case.Name, strings.Replace(result.status, "_", " ", -1))
+			continue
+		}
+		expected := []byte(test_case.Output)
+		if test_case.OutputFile != "" {
+			a, _ := p.GetAttachment(test_case.OutputFile)
+			data, err := ReadAttachment(logger, a)
+			if err != nil {
+				return runner_response, err
+			}
+			expected = data
+		}
+		if result.digest != stdioDigest(expected) {
+			fmt.Fprintf(&report, "test case %s: wrong answer\n", test_case.Name)
+			if test_case.Sample {
+				// Sample outputs are in the description anyway.
+				tokens := string(bytes.Join(bytes.Fields(expected), []byte(" ")))
+				if len(tokens) > maxSampleReportBytes {
+					tokens = tokens[:maxSampleReportBytes] + "..."
+				}
+				fmt.Fprintf(&report, "    expected: %s\n", tokens)
+			}
+			continue
+		}
+		passed += 1
+	}
+	fmt.Fprintf(&report, "passed %d of %d test cases.\n", passed, len(p.TestData))
+	if other.Len() > 0 {
+		report.WriteString("\n")
+		report.Write(other.Bytes())
+	}
+	judged.Success = runner_response.Success && passed == len(p.TestData)
+	judged.Output = report.String()
+	return judged, nil
+}
+
+func javaStringLiteral(s string) string {
+	var buffer bytes.Buffer
+	buffer.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buffer.WriteByte('\\')
+			buffer.WriteRune(r)
+		case r == '\n':
+			buffer.WriteString("\\n")
+		case r == '\r':
+			buffer.WriteString("\\r")
+		case r == '\t':
+			buffer.WriteString("\\t")
+		case r < 0x20 || r > 0x7e:
+			// Java strings are UTF-16, so write a surrogate pair above the BMP.
+			if r > 0xffff {
+				r -= 0x10000
+				fmt.Fprintf(&buffer, "\\u%04x\\u%04x", 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+			} else {
+				fmt.Fprintf(&buffer, "\\u%04x", r)
+			}
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+	buffer.WriteByte('"')
+	return buffer.String()
+}
+
+// TestData is stored compressed with the unit test, for JudgeStdioOutput.
+func encodeTestData(logger *log.Logger, cases []test_data) (string, error) {
+	encoded, err := json.Marshal(cases)
+	if err != nil {
+		return "", err
+	}
+	return compressToBase64(logger, string(encoded))
+}
+
+func decodeTestData(logger *log.Logger, compressed string) ([]test_data, error) {
+	decoded, err := decompressFromBase64(logger, compressed)
+	if err != nil {
+		return nil, err
+	}
+	cases := make([]test_data, 0)
+	if err := json.Unmarshal([]byte(decoded), &cases); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// Custom runs (see handler_run.go) feed the user's own input to their whole
+// program and show what it prints, instead of checking it against test
+// cases. Like the harness above, they run the program in-process.
+
+const pythonStdinRunner = `import base64
+import os
+import sys
+
+try:
+    from StringIO import StringIO
+except ImportError:
+    from io import StringIO
+
+SOURCE_FILEPATH = os.path.join(os.path.dirname(os.path.abspath(__file__)), "foo.py")
+
+sys.stdin = StringIO(base64.b64decode("%s").decode("utf-8"))
+with open(SOURCE_FILEPATH) as f:
+    code = compile(f.read(), SOURCE_FILEPATH, "exec")
+exec(code, {"__name__": "__main__"})
+`
+
+const javaStdinRunner = `import java.io.*;
+
+public class SolutionTest {
+    public static void main(String[] args) throws Exception {
+        System.setIn(new ByteArrayInputStream(%s.getBytes("UTF-8")));
+        Solution.main(args);
+    }
+}
+`
+
+// The unit test that runs the program with input as its standard input, for
+// the languages we have a harness for.
+func stdinRunner(language string, input string) (string, bool) {
+	switch language {
+	case "python":
+		return fmt.Sprintf(pythonStdinRunner, base64.StdEncoding.EncodeToString([]byte(input))), true
+	case "java":
+		return fmt.Sprintf(javaStdinRunner, javaStringLiteral(input)), true
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStdioDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		same   string
+		equal  bool
+	}{
+		{"identical", "3\n", "3\n", true},
+		{"trailing newline", "3\n", "3", true},
+		{"spaces between tokens", "1 2  3\n", "1\t2\n3", true},
+		{"leading blank lines", "\n\n1 2\n", "1 2\n", true},
+		{"windows line endings", "1\r\n2\r\n", "1\n2\n", true},
+		{"other token", "1 2\n", "1 3\n", false},
+		{"joined tokens", "12\n", "1 2\n", false},
+		{"case matters", "Yes\n", "YES\n", false},
+	}
+	for _, test := range tests {
+		digest := stdioDigest([]byte(test.output))
+		if len(digest) != stdioDigestLength {
+			t.Errorf("%s: digest %s has %d hex digits, want %d", test.name, digest, len(digest), stdioDigestLength)
+		}
+		if equal := digest == stdioDigest([]byte(test.same)); equal != test.equal {
+			t.Errorf("%s: digests of %q and %q equal = %v, want %v", test.name, test.output, test.same, equal, test.equal)
+		}
+	}
+}
+
+func stdioTestProblem() *Problem {
+	return &Problem{
+		Id: "add",
+		TestData: []test_data{
+			{Name: "sample", Input: "1 2\n", Output: "3\n", Sample: true},
+			{Name: "large", Input: "100 200\n", Output: "300\n"},
+		},
+	}
+}
+
+// The line a harness prints for case index.
+func stdioLine(index int, status string, output string) string {
+	return fmt.Sprintf("%s%d %s %s\n", stdioResultPrefix, index, status, stdioDigest([]byte(output)))
+}
+
+func TestJudgeStdioOutput(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        string
+		success       bool
+		timed_out     bool
+		out_of_memory bool
+		report        []string
+	}{
+		{"all pass", stdioLine(0, "ok", "3") + stdioLine(1, "ok", "300"), true, false, false,
+			[]string{"passed 2 of 2 test cases."}},
+		{"wrong answer shows sample", stdioLine(0, "ok", "4") + stdioLine(1, "ok", "300"), false, false, false,
+			[]string{"test case sample: wrong answer", "expected: 3", "passed 1 of 2 test cases."}},
+		{"hidden case not shown", stdioLine(0, "ok", "3") + stdioLine(1, "ok", "299"), false, false, false,
+			[]string{"test case large: wrong answer", "passed 1 of 2 test cases."}},
+		{"case not run", stdioLine(0, "ok", "3"), false, false, false,
+			[]string{"test case large: not run", "passed 1 of 2 test cases."}},
+		{"time limit", stdioLine(0, "ok", "3") + stdioLine(1, stdioStatusTimeLimitExceeded, ""), false, true, false,
+			[]string{"test case large: time limit exceeded"}},
+		{"memory limit", stdioLine(0, stdioStatusMemoryLimitExceeded, "") + stdioLine(1, "ok", "300"), false, false, true,
+			[]string{"test case sample: memory limit exceeded"}},
+		{"other output follows", "debugging\n" + stdioLine(0, "ok", "3") + stdioLine(1, "ok", "300"), true, false, false,
+			[]string{"passed 2 of 2 test cases.\n\ndebugging"}},
+		{"first line per case wins", stdioLine(0, "ok", "3") + stdioLine(0, "ok", "4") + stdioLine(1, "ok", "300"), true, false, false,
+			[]string{"passed 2 of 2 test cases."}},
+	}
+	for _, test := range tests {
+		judged, err := JudgeStdioOutput(logger, stdioTestProblem(),
+			runner_response_struct{Success: true, Output: test.output, RunSeconds: 0.5})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if judged.Success != test.success {
+			t.Errorf("%s: success = %v, want %v", test.name, judged.Success, test.success)
+		}
+		if judged.TimedOut != test.timed_out || judged.OutOfMemory != test.out_of_memory {
+			t.Errorf("%s: timed out = %v and out of memory = %v, want %v and %v", test.name,
+				judged.TimedOut, judged.OutOfMemory, test.timed_out, test.out_of_memory)
+		}
+		if judged.RunSeconds != 0.5 {
+			t.Errorf("%s: run seconds = %v, want 0.5", test.name, judged.RunSeconds)
+		}
+		for _, line := range test.report {
+			if !strings.Contains(judged.Output, line) {
+				t.Errorf("%s: report %q doesn't have %q", test.name, judged.Output, line)
+			}
+		}
+		if strings.Contains(judged.Output, "expected: 300") {
+			t.Errorf("%s: report %q shows a hidden case's output", test.name, judged.Output)
+		}
+	}
+}
+
+func TestJudgeStdioOutputWithoutResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		response runner_response_struct
+	}{
+		{"compile error", runner_response_struct{Output: "SyntaxError: invalid syntax\n"}},
+		{"runner timed out", runner_response_struct{Output: "<process ran for too long. output is below>\n", TimedOut: true}},
+	}
+	for _, test := range tests {
+		judged, err := JudgeStdioOutput(logger, stdioTestProblem(), test.response)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if judged != test.response {
+			t.Errorf("%s: JudgeStdioOutput = %+v, want the response unchanged", test.name, judged)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Students often submit the same code again. A run's verdict only depends on
+// the code, the language, the problem version (which covers its unit tests
+// and attachments) and its limits, so an identical submission gets the
+// earlier verdict without a runner, unless it failed in a way that might
+// not happen again. Rejudges always run, and refresh the entry. Entries are
+// stored with the problem version and only used while it is current, so a
+// new version invalidates them.
+var verdictCache *LRUCache
+
+// A size of zero switches the cache off.
+func InitializeVerdictCache(size int, ttl time.Duration) {
+	verdictCache = nil
+	if size > 0 {
+		verdictCache = NewLRUCache("verdict", size, ttl)
+	}
+}
+
+func verdictKey(problem *Problem, language string, code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("%s#%s#%s#%d#%s", problem.Id, language,
+		strconv.FormatFloat(problem.TimeLimitSeconds, 'f', -1, 64), problem.MemoryLimitMB,
+		hex.EncodeToString(hash[:]))
+}
+
+func CachedVerdict(problem *Problem, language string, code string) (runner_response_struct, bool) {
+	if verdictCache == nil {
+		return runner_response_struct{}, false
+	}
+	cached, ok := verdictCache.Get(verdictKey(problem, language, code), problem.Version)
+	if !ok {
+		return runner_response_struct{}, false
+	}
+	return cached.(runner_response_struct), true
+}
+
+// Whether an identical submission is sure to get verdict again. Accepted
+// code is, and so are rejections except those that depend on how busy the
+// runner was or on the runner itself rather than on the code: running out
+// of time or memory, being killed, and the runner failing to set the run up.
+func isVerdictRepeatable(verdict runner_response_struct) bool {
+	if verdict.Success {
+		return true
+	}
+	return !verdict.TimedOut && !verdict.Killed && !verdict.RunnerError && !verdict.OutOfMemory
+}
+
+func PutVerdict(problem *Problem, language string, code string, verdict runner_response_struct) {
+	if verdictCache == nil || !isVerdictRepeatable(verdict) {
+		return
+	}
+	verdictCache.Put(verdictKey(problem, language, code), verdict, problem.Version)
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestIsVerdictRepeatable(t *testing.T) {
+	tests := []struct {
+		name       string
+		verdict    runner_response_struct
+		repeatable bool
+	}{
+		{"accepted", runner_response_struct{Success: true}, true},
+		{"wrong answer", runner_response_struct{Output: "FAIL: test_add"}, true},
+		// Only the flags count, not what the code printed.
+		{"prints a time-out message", runner_response_struct{Output: "<process ran for too long"}, true},
+		{"timed out", runner_response_struct{TimedOut: true}, false},
+		{"killed", runner_response_struct{Killed: true}, false},
+		{"runner error", runner_response_struct{RunnerError: true}, false},
+		{"out of memory", runner_response_struct{OutOfMemory: true}, false},
+	}
+	for _, test := range tests {
+		if repeatable := isVerdictRepeatable(test.verdict); repeatable != test.repeatable {
+			t.Errorf("%s: isVerdictRepeatable = %v, want %v", test.name, repeatable, test.repeatable)
+		}
+	}
+}
+
+func TestVerdictCache(t *testing.T) {
+	problem := Problem{Id: "two_sum", Version: 2, TimeLimitSeconds: 1, MemoryLimitMB: 64}
+	tests := []struct {
+		name     string
+		verdict  runner_response_struct
+		problem  Problem
+		language string
+		code     string
+		found    bool
+	}{
+		{"same code", runner_response_struct{Success: true}, problem, "python", "print(1)", true},
+		{"rejected", runner_response_struct{Output: "FAIL"}, problem, "python", "print(1)", true},
+		{"not repeatable", runner_response_struct{TimedOut: true}, problem, "python", "print(1)", false},
+		{"other code", runner_response_struct{Success: true}, problem, "python", "print(2)", false},
+		{"other language", runner_response_struct{Success: true}, problem, "ruby", "print(1)", false},
+		{"new version", runner_response_struct{Success: true},
+			Problem{Id: "two_sum", Version: 3, TimeLimitSeconds: 1, MemoryLimitMB: 64}, "python", "print(1)", false},
+		{"other time limit", runner_response_struct{Success: true},
+			Problem{Id: "two_sum", Version: 2, TimeLimitSeconds: 2, MemoryLimitMB: 64}, "python", "print(1)", false},
+		{"other memory limit", runner_response_struct{Success: true},
+			Problem{Id: "two_sum", Version: 2, TimeLimitSeconds: 1, MemoryLimitMB: 128}, "python", "print(1)", false},
+	}
+	defer InitializeVerdictCache(0, 0)
+	for _, test := range tests {
+		InitializeVerdictCache(10, 0)
+		PutVerdict(&problem, "python", "print(1)", test.verdict)
+		verdict, found := CachedVerdict(&test.problem, test.language, test.code)
+		if found != test.found {
+			t.Errorf("%s: found = %v, want %v", test.name, found, test.found)
+		}
+		if found && verdict != test.verdict {
+			t.Errorf("%s: verdict = %+v, want %+v", test.name, verdict, test.verdict)
+		}
+	}
+}
+
+func TestVerdictCacheOff(t *testing.T) {
+	InitializeVerdictCache(0, 0)
+	problem := Problem{Id: "two_sum", Version: 1}
+	PutVerdict(&problem, "python", "print(1)", runner_response_struct{Success: true})
+	if _, found := CachedVerdict(&problem, "python", "print(1)"); found {
+		t.Errorf("verdict cached with the cache off")
+	}
+}
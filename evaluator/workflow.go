@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	update "github.com/smugmug/godynamo/endpoints/update_item"
+	"github.com/smugmug/godynamo/types/attributevalue"
+)
+
+// Problem lifecycle. Only published problems are listed in the catalogue or
+// visible to regular users; retired problems can still be evaluated so that
+// existing solutions can be rejudged.
+const (
+	StateDraft     = "draft"
+	StateInReview  = "in_review"
+	StatePublished = "published"
+	StateRetired   = "retired"
+
+	// Approvals needed, from someone other than the submitter, to publish.
+	requiredApprovals = 1
+)
+
+var (
+	problemStates = []string{StateDraft, StateInReview, StatePublished, StateRetired}
+
+	// Roles that can see and work on problems in any state.
+	staffRoles = []string{RoleAdmin, RoleAuthor, RoleReviewer}
+)
+
+type ProblemStateError struct {
+	Id     string
+	State  string
+	Action string
+}
+
+func (e ProblemStateError) Error() string {
+	return fmt.Sprintf("problem ID '%s' in state '%s' can't be %s", e.Id, e.State, e.Action)
+}
+
+type Approval struct {
+	UserId  string    `json:"user_id"`
+	Comment string    `json:"comment,omitempty"`
+	Date    time.Time `json:"date"`
+}
+
+// A transition moves a problem from one of From to To. Roles may perform it.
+type transition struct {
+	From  []string
+	To    string
+	Roles []string
+}
+
+var transitions = map[string]transition{
+	"submit":          {[]string{StateDraft}, StateInReview, []string{RoleAdmin, RoleAuthor}},
+	"approve":         {[]string{StateInReview}, StateInReview, []string{RoleAdmin, RoleReviewer}},
+	"request_changes": {[]string{StateInReview}, StateDraft, []string{RoleAdmin, RoleReviewer}},
+	"publish":         {[]string{StateInReview}, StatePublished, []string{RoleAdmin, RoleReviewer}},
+	"retire":          {[]string{StatePublished}, StateRetired, []string{RoleAdmin, RoleAuthor}},
+	"republish":       {[]string{StateRetired}, StatePublished, []string{RoleAdmin}},
+	// Takes a problem out of the catalogue to be edited and reviewed again.
+	"revise": {[]string{StatePublished, StateRetired}, StateDraft, []string{RoleAdmin, RoleAuthor}},
+}
+
+// Whether a user with role may see a problem in its current state.
+func isProblemVisible(problem *Problem, role string) bool {
+	return problem.State == StatePublished || hasRole(role, staffRoles...)
+}
+
+// Whether a user with role may evaluate code against the problem.
+func isProblemEvaluable(problem *Problem, role string) bool {
+	return problem.State == StatePublished || problem.State == StateRetired ||
+		hasRole(role, staffRoles...)
+}
+
+// Only drafts may be edited, so that every change to a live problem is
+// reviewed before users see it. Published and retired problems go back to
+// draft with the revise action first.
+func checkProblemEditable(problem *Problem) error {
+	if problem.State != StateDraft {
+		return ProblemStateError{problem.Id, problem.State, "edited outside draft; revise it first"}
+	}
+	return nil
+}
+
+// Applies action to problem_id on behalf of user_id. The update is
+// conditional on the state read here so that concurrent transitions can't
+// both succeed.
+func TransitionProblem(logger *log.Logger, problem_id string, action string, user_id string, role string,
+	comment string) (*Problem, error) {
+	logger.Printf("workflow.TransitionProblem() entry. problem_id: %s, action: %s, user_id: %s",
+		problem_id, action, user_id)
+	defer logger.Printf("workflow.TransitionProblem() exit.")
+
+	t, present := transitions[action]
+	if present == false {
+		return nil, ProblemValidationError{problem_id, fmt.Sprintf("unknown action '%s'", action)}
+	}
+	problem, err := GetProblemSummary(logger, problem_id)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(t.From, problem.State) {
+		return nil, ProblemStateError{problem_id, problem.State, action}
+	}
+	if !hasRole(role, t.Roles...) {
+		return nil, ProblemStateError{problem_id, problem.State, action + " by role " + role}
+	}
+
+	update1 := update.NewUpdateItem()
+	update1.TableName = "problem_summary"
+	update1.Key["id"] = &attributevalue.AttributeValue{S: problem_id}
+	update1.ConditionExpression = "#state = :from"
+	update1.ExpressionAttributeNames["#state"] = "state"
+	update1.ExpressionAttributeValues[":from"] = &attributevalue.AttributeValue{S: problem.State}
+	update1.ExpressionAttributeValues[":to"] = &attributevalue.AttributeValue{S: t.To}
+	sets := []string{"#state = :to"}
+	removes := []string{}
+
+	switch action {
+	case "submit":
+		sets = append(sets, "submitted_by = :user")
+		update1.ExpressionAttributeValues[":user"] = &attributevalue.AttributeValue{S: user_id}
+		removes = append(removes, "approvals")
+		problem.SubmittedBy = user_id
+		problem.Approvals = nil
+	case "approve":
+		if user_id == problem.SubmittedBy {
+			return nil, ProblemStateError{problem_id, problem.State, "approved by its submitter"}
+		}
+		for _, approval := range problem.Approvals {
+			if approval.UserId == user_id {
+				return nil, ProblemStateError{problem_id, problem.State, "approved twice by the same user"}
+			}
+		}
+		problem.Approvals = append(problem.Approvals, Approval{user_id, comment, time.Now().UTC()})
+		approvals, err := approvalsToAttributeValue(problem.Approvals)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "approvals = :approvals")
+		update1.ExpressionAttributeValues[":approvals"] = approvals
+	case "request_changes", "revise":
+		removes = append(removes, "approvals")
+		problem.Approvals = nil
+	case "publish":
+		if len(problem.Approvals) < requiredApprovals {
+			return nil, ProblemStateError{problem_id, problem.State,
+				fmt.Sprintf("published with %d of %d approvals", len(problem.Approvals), requiredApprovals)}
+		}
+	}
+	// Only published problems carry the attributes the catalogue GSIs are
+	// keyed on.
+	if t.To == StatePublished {
+		sets = append(sets, "catalogue = :all")
+		update1.ExpressionAttributeValues[":all"] = &attributevalue.AttributeValue{S: catalogueAll}
+		// Empty strings can't key a GSI.
+		if problem.Category != "" {
+			sets = append(sets, "catalogue_category = category")
+		} else {
+			removes = append(removes, "catalogue_category")
+		}
+	} else {
+		removes = append(removes, "catalogue", "catalogue_category")
+	}
+	update1.UpdateExpression = "SET " + strings.Join(sets, ", ")
+	if len(removes) > 0 {
+		update1.UpdateExpression += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	body, code, err := update1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("update failed %d %v %s\n", code, err, body)
+		if strings.Contains(string(body), "ConditionalCheckFailedException") {
+			return nil, ProblemStateError{problem_id, problem.State, action + " concurrently"}
+		}
+		if err == nil {
+			err = fmt.Errorf("update of problem_summary returned HTTP %d", code)
+		}
+		return nil, err
+	}
+	InvalidateProblem(logger, problem_id)
+	problem.State = t.To
+	return &problem, nil
+}
+
+// Approvals are stored as a list of JSON strings.
+func approvalsToAttributeValue(approvals []Approval) (*attributevalue.AttributeValue, error) {
+	av := attributevalue.NewAttributeValue()
+	for _, approval := range approvals {
+		encoded, err := json.Marshal(approval)
+		if err != nil {
+			return nil, err
+		}
+		av.L = append(av.L, &attributevalue.AttributeValue{S: string(encoded)})
+	}
+	return av, nil
+}
+
+func attributeValueToApprovals(av *attributevalue.AttributeValue) ([]Approval, error) {
+	approvals := make([]Approval, 0, len(av.L))
+	for _, encoded := range av.L {
+		var approval Approval
+		if err := json.Unmarshal([]byte(encoded.S), &approval); err != nil {
+			return approvals, err
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, nil
+}
@@ -10,7 +10,7 @@
  * Controller of the onlinejudgeApp
  */
 angular.module('onlinejudgeApp')
-  .controller('AttemptCtrl', function ($scope, $state, $stateParams, problemService, evaluateService, languageService) {
+  .controller('AttemptCtrl', function ($scope, $state, $stateParams, $interval, problemService, evaluateService, languageService) {
     var indentSizes = languageService.getIndentSizes();
     var codemirrorModes = languageService.getCodemirrorModes();
     function setupEditor(language) {
@@ -43,12 +43,17 @@ angular.module('onlinejudgeApp')
       .then(function(problem) {
         /*jshint camelcase: false */
         $scope.problem = problem;
-        $scope.description = marked(
-          $scope.problem.description[$scope.language].markdown,
-          {
-            sanitize: true,
-            smartypants: true
-          });
+        var description = $scope.problem.description[$scope.language];
+        if (description.html) {
+          $scope.description = description.html;
+        } else {
+          $scope.description = marked(
+            description.markdown,
+            {
+              sanitize: true,
+              smartypants: true
+            });
+        }
         $scope.descriptionRendered = true;
         $scope.initialCode = $scope.problem.initial_code[$scope.language].code;
       });
@@ -71,12 +76,50 @@ angular.module('onlinejudgeApp')
     ];
 
     $scope.output = '';
+    // Shows where the run is in the evaluator's queue until it starts.
+    function watchQueue(ticket) {
+      return $interval(function() {
+        evaluateService.queuePosition(ticket)
+          .then(function(result) {
+            /*jshint camelcase: false */
+            if (result.success === true && result.queue.state === 'waiting') {
+              $scope.output = '<queued: position ' + result.queue.position +
+                ', about ' + result.queue.estimated_wait_seconds + 's>';
+            } else if (result.success === true) {
+              $scope.output = '<running>';
+            }
+          });
+      }, 2000);
+    }
     $scope.checkCode = function(problemId, language, code) {
       $scope.checkCodeLoading = true;
-      evaluateService.evaluateAttempt(problemId, language, code)
+      var ticket = evaluateService.newTicket();
+      var watcher = watchQueue(ticket);
+      evaluateService.evaluateAttempt(problemId, language, code, ticket)
         .then(function(result) {
+          $interval.cancel(watcher);
           $scope.output = result.output;
           $scope.checkCodeLoading = false;
+        }, function(result) {
+          $interval.cancel(watcher);
+          $scope.output = (result && result.output) || '<could not run your code>';
+          $scope.checkCodeLoading = false;
+        });
+    };
+    $scope.customInput = '';
+    $scope.runCode = function(problemId, language, code, input) {
+      $scope.runCodeLoading = true;
+      var ticket = evaluateService.newTicket();
+      var watcher = watchQueue(ticket);
+      evaluateService.runAttempt(problemId, language, code, input, ticket)
+        .then(function(result) {
+          $interval.cancel(watcher);
+          $scope.output = result.output;
+          $scope.runCodeLoading = false;
+        }, function(result) {
+          $interval.cancel(watcher);
+          $scope.output = (result && result.output) || '<could not run your code>';
+          $scope.runCodeLoading = false;
         });
     };
     $scope.clearOutput = function() {
@@ -87,8 +130,11 @@ angular.module('onlinejudgeApp')
     };
     $scope.submitCode = function(problemId, language, code) {
       $scope.submitCodeLoading = true;
-      evaluateService.submitAttempt(problemId, language, code)
+      var ticket = evaluateService.newTicket();
+      var watcher = watchQueue(ticket);
+      evaluateService.submitAttempt(problemId, language, code, ticket)
         .then(function(result) {
+          $interval.cancel(watcher);
           console.log('AttemptCtrl call to evaluateService.submitAttempt successful.');
           console.log(result);
           if (result.success === true) {
@@ -98,6 +144,7 @@ angular.module('onlinejudgeApp')
           }
           $scope.submitCodeLoading = false;
         }, function(result) {
+          $interval.cancel(watcher);
           console.log('AttemptCtrl call to evaluateService.submitAttempt failed.');
           console.log(result);
           $scope.submitCodeLoading = false;
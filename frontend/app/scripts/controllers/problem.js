@@ -14,12 +14,13 @@ angular.module('onlinejudgeApp')
     $scope.data = {
       selectedLanguage: null,
       problems: [],
+      stats: {},
     };
     $scope.languages = languageService.getLanguages();
     $scope.languageValueToText = languageService.getLanguageValueToText();
     $scope.languageSelected = function(language) {
       $scope.data.selectedLanguage = language;
-      problemService.getProblemSummaries()
+      problemService.getProblemSummaries({language: language})
         .then(function(problems) {
           problems = _.sortBy(problems, function(problem) {
               return problem.title;
@@ -32,12 +33,17 @@ angular.module('onlinejudgeApp')
           $scope.data.problemsByCategory = _.groupBy(problems, 'category');
           $scope.data.categories = _.sortBy(_.unique(_.map(problems, function(o) { return o.category; })));
         });
+      problemService.getProblemStats(language)
+        .then(function(stats) {
+          $scope.data.stats = stats;
+        });
     };
     $scope.clearSelectedLanguage = function() {
       $scope.data.selectedLanguage = null;
       $scope.data.problems = [];
       $scope.data.problemsByCategory = {};
       $scope.data.categories = [];
+      $scope.data.stats = {};
     };
     $scope.$on('$stateChangeSuccess', function(event, toState, toParams, fromState, fromParams){
       if ($scope.state.current.name === 'problem.languageSelected') {
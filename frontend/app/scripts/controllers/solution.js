@@ -19,7 +19,7 @@ angular.module('onlinejudgeApp')
     $scope.languageValueToText = languageService.getLanguageValueToText();
     $scope.languageSelected = function(language) {
       $scope.data.selectedLanguage = language;
-      problemService.getProblemSummaries()
+      problemService.getProblemSummaries({language: language})
         .then(function(problems) {
           problems = _.sortBy(problems, function(problem) {
               return problem.title;
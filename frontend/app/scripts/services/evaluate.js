@@ -9,10 +9,21 @@
  */
 angular.module('onlinejudgeApp')
   .factory('evaluateService', function($http, $q, configService) {
-    var evaluateAttempt = function(problem, language, code) {
+    // Chosen here so that the queue position can be polled while the run
+    // waits.
+    var newTicket = function() {
+      var ticket = '';
+      for (var i = 0; i < 32; i++) {
+        ticket += Math.floor(Math.random() * 16).toString(16);
+      }
+      return ticket;
+    };
+
+    var evaluateAttempt = function(problem, language, code, ticket) {
       var deferred = $q.defer();
       var data = {
         'code': code,
+        'ticket': ticket,
       };
       var url = configService.backendBaseUrl() + '/evaluator/evaluate/' + problem + '/' + language;
       $http({
@@ -32,12 +43,13 @@ angular.module('onlinejudgeApp')
       return deferred.promise;
     };
 
-    var submitAttempt = function(problemId, language, code) {
+    var submitAttempt = function(problemId, language, code, ticket) {
       var deferred = $q.defer();
       var data = {
         'problem_id': problemId,
         'language': language,
         'code': code,
+        'ticket': ticket,
       };
       var url = configService.backendBaseUrl() + '/user_data/solution/submit';
       $http({
@@ -57,9 +69,53 @@ angular.module('onlinejudgeApp')
       return deferred.promise;
     };
 
+    // Runs the code with input as its standard input, without submitting it.
+    var runAttempt = function(problem, language, code, input, ticket) {
+      var deferred = $q.defer();
+      var data = {
+        'code': code,
+        'input': input,
+        'ticket': ticket,
+      };
+      var url = configService.backendBaseUrl() + '/evaluator/run/' + problem + '/' + language;
+      $http({
+        url: url,
+        method: 'POST',
+        data: JSON.stringify(data),
+        headers: {'Content-Type': 'application/json'}
+      }).success(function(response) {
+        console.log('evaluateService.runAttempt success.');
+        deferred.resolve(response);
+      }).error(function(msg, code) {
+        console.log('evaluateService.runAttempt error.');
+        console.log(msg, code);
+        deferred.reject(msg);
+      });
+      return deferred.promise;
+    };
+
+    var queuePosition = function(ticket) {
+      var deferred = $q.defer();
+      var url = configService.backendBaseUrl() + '/evaluator/queue/' + ticket;
+      $http({
+        url: url,
+        method: 'GET',
+      }).success(function(response) {
+        deferred.resolve(response);
+      }).error(function(msg, code) {
+        console.log('evaluateService.queuePosition error.');
+        console.log(msg, code);
+        deferred.reject(msg);
+      });
+      return deferred.promise;
+    };
+
     return {
+      newTicket: newTicket,
       evaluateAttempt: evaluateAttempt,
       submitAttempt: submitAttempt,
+      runAttempt: runAttempt,
+      queuePosition: queuePosition,
     };
 
   });
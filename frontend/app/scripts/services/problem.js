@@ -10,11 +10,41 @@
 angular.module('onlinejudgeApp')
   .factory('problemService', function($http, $q, configService) {
 
-    var getProblemSummaries = function() {
+    // The evaluator pages the catalogue; follow next_cursor until we have
+    // every problem matching params (e.g. {language: 'python'}).
+    var getProblemSummaries = function(params) {
       var deferred = $q.defer();
-      $http.get(configService.backendBaseUrl() + '/evaluator/get_problem_summaries')
+      var problems = [];
+      var getPage = function(cursor) {
+        var pageParams = angular.extend({limit: 100}, params);
+        if (cursor) {
+          pageParams.cursor = cursor;
+        }
+        $http.get(configService.backendBaseUrl() + '/evaluator/get_problem_summaries', {params: pageParams})
+        .success(function(response) {
+          problems = problems.concat(response.problems);
+          /*jshint camelcase: false */
+          if (response.next_cursor) {
+            getPage(response.next_cursor);
+          } else {
+            deferred.resolve(problems);
+          }
+        }).error(function(msg, code) {
+          deferred.reject(msg);
+          console.log(msg, code);
+        });
+      };
+      getPage(null);
+      return deferred.promise;
+    };
+
+    // Submission statistics from user_data, by problem id, e.g. solvers and
+    // acceptance_rate. Problems nobody has submitted to are left out.
+    var getProblemStats = function(language) {
+      var deferred = $q.defer();
+      $http.get(configService.backendBaseUrl() + '/user_data/problem_stats', {params: {language: language}})
       .success(function(response) {
-        deferred.resolve(response);
+        deferred.resolve(response.problems);
       }).error(function(msg, code) {
         deferred.reject(msg);
         console.log(msg, code);
@@ -37,6 +67,7 @@ angular.module('onlinejudgeApp')
 
     return {
       getProblemSummaries: getProblemSummaries,
+      getProblemStats: getProblemStats,
       getProblemDescriptionAndInitialCode: getProblemDescriptionAndInitialCode,
     };
 });
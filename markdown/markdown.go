@@ -0,0 +1,187 @@
+// Package markdown renders the Markdown of problem descriptions and
+// solution write-ups, for both the evaluator and user_data.
+package markdown
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chroma_html "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+)
+
+const (
+	extensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS
+
+	htmlFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+)
+
+var (
+	// Code, which may contain dollar signs, is matched first and left alone.
+	// Math is $$display$$ or $inline$, where inline math can't start or end
+	// with a space so that "$5 and $10" stays text. \$ is a literal dollar.
+	mathRegexp = regexp.MustCompile("(?s)" + strings.Join([]string{
+		"(?m:^)```.*?(?m:^)```",
+		"(?m:^)~~~.*?(?m:^)~~~",
+		"``.+?``",
+		"`[^`]+`",
+		`\\\$`,
+		`\$\$.+?\$\$`,
+		`\$[^\s$](?:[^$\n]*[^\s$\\])?\$`,
+	}, "|"))
+
+	codeOpenTagRegexp  = regexp.MustCompile(`^<(pre|code)[\s>]`)
+	codeCloseTagRegexp = regexp.MustCompile(`^</(pre|code)>`)
+
+	policy = newPolicy()
+)
+
+// Syntax highlighting marks tokens up with chroma's classes, and math with
+// "math inline" or "math display" for KaTeX to typeset in the browser.
+func newPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^[A-Za-z0-9_ -]+$`)).OnElements("pre", "code", "span")
+	return policy
+}
+
+// Highlights fenced code blocks with chroma, and otherwise renders like
+// blackfriday's HTML renderer.
+type highlightingRenderer struct {
+	blackfriday.Renderer
+}
+
+func (r *highlightingRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	fields := strings.Fields(lang)
+	if len(fields) == 0 || lexers.Get(fields[0]) == nil {
+		r.Renderer.BlockCode(out, text, lang)
+		return
+	}
+	iterator, err := chroma.Coalesce(lexers.Get(fields[0])).Tokenise(nil, string(text))
+	if err != nil {
+		r.Renderer.BlockCode(out, text, lang)
+		return
+	}
+	var highlighted bytes.Buffer
+	formatter := chroma_html.New(chroma_html.WithClasses(true))
+	if err := formatter.Format(&highlighted, styles.Fallback, iterator); err != nil {
+		r.Renderer.BlockCode(out, text, lang)
+		return
+	}
+	out.Write(highlighted.Bytes())
+}
+
+// Renders markdown to HTML that is safe to insert into a page. Raw HTML in
+// the markdown is sanitised away rather than trusted.
+func Render(logger *log.Logger, markdown string) string {
+	logger.Printf("markdown.Render entry. len(markdown): %d", len(markdown))
+	defer logger.Printf("markdown.Render exit.")
+
+	// Math is swapped for placeholders before rendering so that markdown
+	// doesn't turn its underscores and asterisks into emphasis.
+	placeholder_prefix := newMathPlaceholderPrefix()
+	math := make([]mathSpan, 0)
+	markdown = mathRegexp.ReplaceAllStringFunc(markdown, func(match string) string {
+		switch {
+		case strings.HasPrefix(match, "`") || strings.HasPrefix(match, "~"):
+			return match
+		case match == `\$`:
+			return match
+		case strings.HasPrefix(match, "$$"):
+			math = append(math, mathSpan{match, fmt.Sprintf(`<span class="math display">\[%s\]</span>`,
+				html.EscapeString(match[2:len(match)-2]))})
+		default:
+			math = append(math, mathSpan{match, fmt.Sprintf(`<span class="math inline">\(%s\)</span>`,
+				html.EscapeString(match[1:len(match)-1]))})
+		}
+		return fmt.Sprintf("%s%dx", placeholder_prefix, len(math)-1)
+	})
+
+	renderer := &highlightingRenderer{blackfriday.HtmlRenderer(htmlFlags, "", "")}
+	rendered := blackfriday.Markdown([]byte(markdown), renderer, extensions)
+	sanitised := string(policy.SanitizeBytes(rendered))
+	return restoreMath(sanitised, placeholder_prefix, math)
+}
+
+type mathSpan struct {
+	// As written in the markdown, e.g. $x^2$.
+	source string
+	html   string
+}
+
+// Puts the math back into sanitised HTML. Only text outside code becomes
+// math markup. Placeholders inside tags, e.g. in a link's href, and in
+// indented code blocks get the math back as it was written.
+func restoreMath(sanitised string, placeholder_prefix string, math []mathSpan) string {
+	if len(math) == 0 {
+		return sanitised
+	}
+	placeholder_regexp := regexp.MustCompile(regexp.QuoteMeta(placeholder_prefix) + `(\d+)x`)
+	replace := func(s string, markup bool) string {
+		return placeholder_regexp.ReplaceAllStringFunc(s, func(match string) string {
+			i, err := strconv.Atoi(placeholder_regexp.FindStringSubmatch(match)[1])
+			if err != nil || i >= len(math) {
+				return match
+			}
+			if markup {
+				return math[i].html
+			}
+			return html.EscapeString(math[i].source)
+		})
+	}
+
+	// The sanitiser escapes < and > in text and attribute values, so every
+	// < starts a tag and the next > ends it.
+	var out bytes.Buffer
+	code_depth := 0
+	for len(sanitised) > 0 {
+		text_end := strings.IndexByte(sanitised, '<')
+		if text_end < 0 {
+			text_end = len(sanitised)
+		}
+		out.WriteString(replace(sanitised[:text_end], code_depth == 0))
+		sanitised = sanitised[text_end:]
+		if len(sanitised) == 0 {
+			break
+		}
+		tag_end := strings.IndexByte(sanitised, '>') + 1
+		if tag_end == 0 {
+			tag_end = len(sanitised)
+		}
+		tag := sanitised[:tag_end]
+		switch {
+		case codeOpenTagRegexp.MatchString(tag):
+			code_depth += 1
+		case codeCloseTagRegexp.MatchString(tag) && code_depth > 0:
+			code_depth -= 1
+		}
+		out.WriteString(replace(tag, false))
+		sanitised = sanitised[tag_end:]
+	}
+	return out.String()
+}
+
+// Random, so that no markdown can contain a placeholder by accident.
+func newMathPlaceholderPrefix() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return "math" + hex.EncodeToString(random) + "x"
+}
@@ -83,6 +83,8 @@ server {
     # evaluator
     location ^~ /evaluator {
         proxy_pass http://localhost:8081;
+        # The evaluator rate limits by client address, see -trusted-proxies.
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
     }
 
     # user_data
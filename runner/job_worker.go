@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// With -pull, the runner doesn't wait for the evaluator to post runs to it
+// but claims jobs from the evaluator's job queue whenever it has a free
+// slot, runs them and reports the result. A job the runner can't finish,
+// e.g. because the container broke, is failed so that another runner can
+// try it.
+
+// How long a claim waits for a job before asking again.
+const claimWaitSeconds = 20
+
+// Long enough for a claim's long poll.
+var jobClient = &http.Client{Timeout: (claimWaitSeconds + 10) * time.Second}
+
+type job_struct struct {
+	Id       string            `json:"id"`
+	Language string            `json:"language"`
+	Code     string            `json:"code"`
+	UnitTest string            `json:"unit_test"`
+	Files    map[string][]byte `json:"files,omitempty"`
+	Attempts int               `json:"attempts"`
+	Receipt  string            `json:"receipt"`
+}
+
+type claim_response_struct struct {
+	Success bool        `json:"success"`
+	Error   string      `json:"error"`
+	Job     *job_struct `json:"job"`
+}
+
+func postJSON(registry string, token string, path string, body interface{}, result interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", registry+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("X-Runner-Token", token)
+	resp, err := jobClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var response registry_response_struct
+		json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("evaluator returned HTTP %d: %s", resp.StatusCode, response.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// The evaluator counts workers as run slots. IDs are random so that no two
+// runners' workers share one.
+func newWorkerId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		logger.Panicf("failed to make worker ID: %s", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Claims and runs jobs one at a time until stop is closed. Run one per
+// slot.
+func jobWorker(registry string, token string, stop chan struct{}) {
+	worker := newWorkerId()
+	logger.Printf("jobWorker() entry. registry: %s, worker: %s", registry, worker)
+	defer logger.Println("jobWorker() exit.")
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		var claim claim_response_struct
+		err := postJSON(registry, token, "/evaluator/jobs/claim", map[string]interface{}{
+			"worker":       worker,
+			"languages":    runnerLanguages,
+			"wait_seconds": claimWaitSeconds,
+		}, &claim)
+		if err != nil {
+			logger.Printf("failed to claim job from %s: %s", registry, err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-stop:
+				return
+			}
+			continue
+		}
+		if claim.Job != nil {
+			runClaimedJob(registry, token, claim.Job)
+		}
+	}
+}
+
+func runClaimedJob(registry string, token string, job *job_struct) {
+	logger = getLogger(getLogPill())
+	logger.Printf("runClaimedJob() entry. job: %s, language: %s, attempt: %d", job.Id, job.Language, job.Attempts)
+	defer logger.Println("runClaimedJob() exit.")
+
+	response := map[string]interface{}{}
+	if err := runJobSafely(job, response); err != nil {
+		logger.Printf("job %s failed: %s", job.Id, err)
+		err = postJSON(registry, token, "/evaluator/jobs/fail", map[string]interface{}{
+			"id":      job.Id,
+			"receipt": job.Receipt,
+			"error":   err.Error(),
+		}, nil)
+		if err != nil {
+			logger.Printf("failed to report failure of job %s: %s", job.Id, err)
+		}
+		return
+	}
+	err := postJSON(registry, token, "/evaluator/jobs/complete", map[string]interface{}{
+		"id":       job.Id,
+		"receipt":  job.Receipt,
+		"response": response,
+	}, nil)
+	if err != nil {
+		logger.Printf("failed to report result of job %s: %s", job.Id, err)
+	}
+}
+
+// runJob panics when the container is broken, which over HTTP just drops
+// the connection. Here it fails the job instead.
+func runJobSafely(job *job_struct, response map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runner panicked: %v", r)
+		}
+	}()
+	runJob(logger, job.Language, run_handler_struct{
+		Code:     job.Code,
+		UnitTest: job.UnitTest,
+		Files:    job.Files,
+	}, response)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// With -registry set, the runner registers itself with the evaluator on
+// startup, sends a heartbeat every interval the evaluator asks for, and
+// deregisters when it shuts down. If the evaluator has evicted it, e.g.
+// after a network partition, the heartbeat gets a 404 and it registers
+// again.
+
+var (
+	// Set at build time with -ldflags "-X main.runnerVersion=...".
+	runnerVersion = "dev"
+	// Must match the /run/ handlers registered in main.
+	runnerLanguages = []string{"c", "cpp", "python", "ruby", "javascript", "java"}
+	// Until the evaluator says otherwise.
+	defaultHeartbeatInterval = 10 * time.Second
+	registryClient           = &http.Client{Timeout: 10 * time.Second}
+)
+
+type registration_struct struct {
+	URL       string   `json:"url"`
+	Languages []string `json:"languages,omitempty"`
+	Capacity  int      `json:"capacity,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	InFlight  int      `json:"in_flight"`
+}
+
+type registry_response_struct struct {
+	Success                  bool   `json:"success"`
+	Error                    string `json:"error"`
+	HeartbeatIntervalSeconds int    `json:"heartbeat_interval_seconds"`
+}
+
+// Returned for a 404 from the heartbeat endpoint.
+type NotRegisteredError struct{}
+
+func (e NotRegisteredError) Error() string {
+	return "evaluator doesn't know this runner"
+}
+
+func postToRegistry(registry string, token string, path string, body registration_struct) (*registry_response_struct, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("POST", registry+path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("X-Runner-Token", token)
+	resp, err := registryClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NotRegisteredError{}
+	}
+	var response registry_response_struct
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("evaluator returned HTTP %d and undecodable body: %s", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || !response.Success {
+		return nil, fmt.Errorf("evaluator returned HTTP %d: %s", resp.StatusCode, response.Error)
+	}
+	return &response, nil
+}
+
+func register(registry string, token string, advertise_url string) (time.Duration, error) {
+	response, err := postToRegistry(registry, token, "/evaluator/runners/register", registration_struct{
+		URL:       advertise_url,
+		Languages: runnerLanguages,
+		Capacity:  maxOutstandingRequests,
+		Version:   runnerVersion,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if response.HeartbeatIntervalSeconds > 0 {
+		return time.Duration(response.HeartbeatIntervalSeconds) * time.Second, nil
+	}
+	return defaultHeartbeatInterval, nil
+}
+
+// Registers, then sends heartbeats until stop is closed. Never gives up:
+// the evaluator may simply not be up yet.
+func registryLoop(registry string, token string, advertise_url string, stop chan struct{}) {
+	logger.Printf("registryLoop() entry. registry: %s, advertise_url: %s", registry, advertise_url)
+	defer logger.Println("registryLoop() exit.")
+
+	registered := false
+	interval := defaultHeartbeatInterval
+	for {
+		if !registered {
+			heartbeat_interval, err := register(registry, token, advertise_url)
+			if err != nil {
+				logger.Printf("failed to register with %s: %s", registry, err)
+			} else {
+				logger.Printf("registered with %s, heartbeat interval %s", registry, heartbeat_interval)
+				registered = true
+				interval = heartbeat_interval
+			}
+		} else {
+			_, err := postToRegistry(registry, token, "/evaluator/runners/heartbeat", registration_struct{
+				URL:      advertise_url,
+				InFlight: len(handlerSemaphore),
+			})
+			if _, ok := err.(NotRegisteredError); ok {
+				logger.Printf("evaluator %s evicted us, registering again", registry)
+				registered = false
+				continue
+			} else if err != nil {
+				logger.Printf("failed to send heartbeat to %s: %s", registry, err)
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func deregister(registry string, token string, advertise_url string) {
+	logger.Println("deregister() entry.")
+	defer logger.Println("deregister() exit.")
+	_, err := postToRegistry(registry, token, "/evaluator/runners/deregister", registration_struct{URL: advertise_url})
+	if err != nil {
+		logger.Printf("failed to deregister from %s: %s", registry, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,8 +16,11 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -31,6 +35,20 @@ var (
 	lxcMutex               = &sync.Mutex{}
 	ephemeralImageName     = ""
 	lxcPath                = "/home/ubuntu/.local/share/lxc/"
+	listenAddress          = flag.String("listen", "localhost:8080", "Address to serve on")
+	registryURL            = flag.String("registry", "", "Base URL of the evaluator to register with, e.g. https://runsomecode.com; off if empty")
+	registryToken          = flag.String("registry-token", "", "Shared secret matching the evaluator's -runner-registry-token")
+	advertiseURL           = flag.String("advertise-url", "", "Base URL the evaluator should send runs to, e.g. http://10.0.0.5:8080")
+	pull                   = flag.Bool("pull", false, "Claim jobs from the evaluator at -registry instead of waiting for it to post runs")
+	supportFileNameRegexp  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	// Files runCommand writes, which support files must not replace.
+	reservedFileNames = map[string]bool{
+		"program.c": true, "program_test.c": true, "program.cpp": true,
+		"program_test.cpp": true, "catch.hpp": true, "a.out": true,
+		"foo.py": true, "foo_test.py": true, "foo.rb": true, "foo_test.rb": true,
+		"Solution.java": true, "SolutionTest.java": true, "hamcrest-core-1.3.jar": true,
+		"junit-4.12.jar": true, "foo.js": true, "foo_test.js": true,
+	}
 )
 
 func getLogger(prefix string) *log.Logger {
@@ -80,9 +98,10 @@ func makeGzipHandler(fn http.HandlerFunc) http.HandlerFunc {
 }
 
 type run_handler_struct struct {
-	Code      string `json:"code,omitempty"`
-	UnitTest  string `json:"unit_test,omitempty"`
-	Recaptcha string `json:"recaptcha,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	UnitTest  string            `json:"unit_test,omitempty"`
+	Files     map[string][]byte `json:"files,omitempty"`
+	Recaptcha string            `json:"recaptcha,omitempty"`
 }
 
 type verify_recaptcha_struct struct {
@@ -151,6 +170,7 @@ func runHandler(language string, w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&t)
 	if err != nil {
 		response["success"] = false
+		response["runner_error"] = true
 		response["output"] = "<could not decode JSON POST request>"
 		logger.Panicf("Could not decode JSON POST request")
 	}
@@ -173,6 +193,15 @@ func runHandler(language string, w http.ResponseWriter, r *http.Request) {
 	   }
 	*/
 
+	runJob(logger, language, t, response)
+}
+
+// Runs t in the LXC container and fills in response. Shared by runHandler
+// and, in -pull mode, the job workers.
+func runJob(logger *log.Logger, language string, t run_handler_struct, response map[string]interface{}) {
+	logger.Printf("runJob() entry. language: %s", language)
+	defer logger.Println("runJob() exit.")
+
 	// Put data into the channel which acts like a semaphore. Once it reaches
 	// it's capacity it will block here; hence only "maxOutstandingRequests"
 	// permitted.
@@ -195,6 +224,12 @@ func runHandler(language string, w http.ResponseWriter, r *http.Request) {
 	defer os.Remove(outputFile.Name())
 
 	cmd := runCommand(language, codeFile.Name(), unitTestFile.Name())
+	if err := prepareSupportFiles(logger, t.Files); err != nil {
+		response["success"] = false
+		response["runner_error"] = true
+		response["output"] = fmt.Sprintf("<could not prepare support files: %s>", err)
+		return
+	}
 	runCode(cmd, outputFile, logger, response)
 
 	if _, ok := response["success"]; !ok {
@@ -258,6 +293,25 @@ func prepareCodeFile(logger *log.Logger, code string) *os.File {
 	return codeFile
 }
 
+// Writes problem attachments such as large test inputs next to the code.
+func prepareSupportFiles(logger *log.Logger, files map[string][]byte) error {
+	logger.Println("prepareSupportFiles() entry.")
+	defer logger.Println("prepareSupportFiles() exit.")
+	for name, data := range files {
+		if !supportFileNameRegexp.MatchString(name) || reservedFileNames[name] {
+			return errors.New(fmt.Sprintf("invalid support file name '%s'", name))
+		}
+		destFilepath := "/tmp/foo/" + name
+		if err := ioutil.WriteFile(destFilepath, data, 0777); err != nil {
+			return err
+		}
+		if err := os.Chmod(destFilepath, 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func prepareOutputFile(logger *log.Logger) *os.File {
 	logger.Println("prepareOutputFile() entry.")
 	outputFile, err := ioutil.TempFile("", "run-output")
@@ -280,6 +334,7 @@ func runCode(cmd *exec.Cmd, outputFile *os.File, logger *log.Logger, response ma
 		logger.Panicf("failed to run command: %s", err)
 	}
 
+	started := time.Now()
 	done := make(chan error, 1)
 	go func() {
 		done <- cmd.Wait()
@@ -294,16 +349,24 @@ func runCode(cmd *exec.Cmd, outputFile *os.File, logger *log.Logger, response ma
 		msg := "<process ran for too long. output is below>\n"
 		logger.Println(msg)
 		response["success"] = false
+		response["timed_out"] = true
 		outputBuffer.WriteString(msg)
 	case err := <-done:
 		if err != nil {
 			msg := fmt.Sprintf("<process finished with error: %s. output is below>\n", err)
 			logger.Println(msg)
 			response["success"] = false
+			// E.g. by the OOM killer, which depends on what else ran.
+			if exit_err, ok := err.(*exec.ExitError); ok {
+				if status, ok := exit_err.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+					response["killed"] = true
+				}
+			}
 			outputBuffer.WriteString(msg)
 		}
 	}
 
+	response["run_seconds"] = time.Since(started).Seconds()
 	logger.Println("runCode() finished file.")
 
 	logger.Println("runCode() returning output...")
@@ -313,6 +376,7 @@ func runCode(cmd *exec.Cmd, outputFile *os.File, logger *log.Logger, response ma
 	if err != nil {
 		logger.Println(err)
 		response["success"] = false
+		response["runner_error"] = true
 		outputBuffer.WriteString(err.Error())
 	}
 	outputBuffer.Write(output)
@@ -335,9 +399,24 @@ func copyPrepareFile(source_filepath string, dest_filepath string) error {
 	return nil
 }
 
+// Removes everything a previous run left in /tmp/foo, including support
+// files, which exec.Command wouldn't glob for rm.
+func clearSandboxDirectory() error {
+	names, err := filepath.Glob("/tmp/foo/*")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func runCommand(language string, code_filepath string, unittest_filepath string) *exec.Cmd {
-	if err := exec.Command("rm", "-f", "/tmp/foo/*").Run(); err != nil {
-		logger.Panicf("failed to clean up old out files in /tmp/foo")
+	if err := clearSandboxDirectory(); err != nil {
+		logger.Panicf("failed to clean up old out files in /tmp/foo: %s", err)
 	}
 	switch language {
 	case "c":
@@ -451,6 +530,7 @@ func restartLxcContainer() {
 }
 
 func main() {
+	flag.Parse()
 	rand.Seed(time.Now().UTC().UnixNano())
 	ephemeralImageName = getEphemeralImageName()
 	logger.Println("main() entry. ephemeralImageName: ", ephemeralImageName)
@@ -470,5 +550,27 @@ func main() {
 	mux.HandleFunc("/run/javascript", makeGzipHandler(runJavaScriptHandler))
 	mux.HandleFunc("/run/java", makeGzipHandler(runJavaHandler))
 
-	graceful.Run("localhost:8080", 5*time.Second, mux)
+	if *registryURL != "" && *advertiseURL == "" && !*pull {
+		logger.Fatalf("-registry needs -advertise-url, -pull or both")
+	}
+	if *pull && *registryURL == "" {
+		logger.Fatalf("-pull needs -registry")
+	}
+	if *registryURL != "" && *advertiseURL != "" {
+		stop_registry := make(chan struct{})
+		go registryLoop(*registryURL, *registryToken, *advertiseURL, stop_registry)
+		defer func() {
+			close(stop_registry)
+			deregister(*registryURL, *registryToken, *advertiseURL)
+		}()
+	}
+	if *pull {
+		stop_workers := make(chan struct{})
+		for i := 0; i < maxOutstandingRequests; i++ {
+			go jobWorker(*registryURL, *registryToken, stop_workers)
+		}
+		defer close(stop_workers)
+	}
+
+	graceful.Run(*listenAddress, 5*time.Second, mux)
 }
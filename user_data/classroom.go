@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/rand"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+// Classrooms are read into memory when user_data starts, like contests, so
+// that invite codes can be looked up without an index. Members and
+// assignments stay in DynamoDB.
+
+// Invite codes are typed in by hand, so leave out letters and digits that
+// look alike.
+const (
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteCodeLength   = 8
+)
+
+func newInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+type Classrooms struct {
+	mutex      sync.Mutex
+	classrooms map[string]*Classroom
+}
+
+var classrooms = NewClassrooms()
+
+func NewClassrooms() *Classrooms {
+	return &Classrooms{classrooms: make(map[string]*Classroom)}
+}
+
+func (c *Classrooms) Load(logger *log.Logger) error {
+	logger.Printf("Classrooms.Load() entry.")
+	defer logger.Printf("Classrooms.Load() exit.")
+	all, err := GetAllClassrooms(logger)
+	if err != nil {
+		logger.Printf("failed to read classrooms: %s", err)
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, classroom := range all {
+		c.classrooms[classroom.ClassroomId] = classroom
+	}
+	return nil
+}
+
+// Returns a copy, so callers can change it and Save it.
+func (c *Classrooms) Get(classroom_id string) (Classroom, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	classroom, present := c.classrooms[classroom_id]
+	if present == false {
+		return Classroom{}, ClassroomNotFoundError{classroom_id}
+	}
+	return *classroom, nil
+}
+
+// The classroom an invite code is for, and the role it joins with.
+func (c *Classrooms) ByInviteCode(invite_code string) (Classroom, string, error) {
+	invite_code = strings.ToUpper(strings.TrimSpace(invite_code))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, classroom := range c.classrooms {
+		if role := classroom.InviteRole(invite_code); role != "" {
+			return *classroom, role, nil
+		}
+	}
+	return Classroom{}, "", InviteCodeNotFoundError{invite_code}
+}
+
+// Stores a new or changed classroom. A new one gets its ID here, and new
+// classrooms or ones without codes get new invite codes.
+func (c *Classrooms) Save(logger *log.Logger, classroom Classroom) (Classroom, error) {
+	if classroom.ClassroomId == "" {
+		new_uuid, err := uuid.NewV4()
+		if err != nil {
+			logger.Printf("failed to create new UUID.")
+			return classroom, err
+		}
+		classroom.ClassroomId = new_uuid.String()
+		classroom.CreationDate = time.Now().UTC()
+	}
+	for _, code := range []*string{&classroom.StudentInviteCode, &classroom.InstructorInviteCode} {
+		if *code != "" {
+			continue
+		}
+		new_code, err := newInviteCode()
+		if err != nil {
+			logger.Printf("failed to create invite code: %s", err)
+			return classroom, err
+		}
+		*code = new_code
+	}
+	if err := PutClassroom(logger, &classroom); err != nil {
+		return classroom, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.classrooms[classroom.ClassroomId] = &classroom
+	return classroom, nil
+}
+
+// Whether viewer_id is an instructor of a classroom student_id is a student
+// in, so may follow their progress and submissions.
+func IsInstructorOf(logger *log.Logger, viewer_id string, student_id string) (bool, error) {
+	memberships, err := GetClassroomMembers(logger, "", viewer_id)
+	if err != nil {
+		return false, err
+	}
+	for _, membership := range memberships {
+		if membership.Role != ClassroomInstructor {
+			continue
+		}
+		student, err := GetClassroomMember(logger, membership.ClassroomId, student_id)
+		if err != nil {
+			return false, err
+		}
+		if student != nil && student.Role == ClassroomStudent {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+const (
+	ProgressNotAttempted = "not_attempted"
+	ProgressAttempted    = "attempted"
+	ProgressSolved       = "solved"
+	ProgressSolvedLate   = "solved_late"
+)
+
+type AssignmentProblemProgress struct {
+	ProblemId string `json:"problem_id"`
+	Language  string `json:"language,omitempty"`
+	Status    string `json:"status"`
+	// Judged submissions while the assignment was open.
+	Attempts int `json:"attempts"`
+	// The accept that earned Credit.
+	SolvedDate   *time.Time `json:"solved_date,omitempty"`
+	SubmissionId string     `json:"submission_id,omitempty"`
+	Credit       int        `json:"credit"`
+}
+
+type AssignmentProgress struct {
+	AssignmentId string `json:"assignment_id"`
+	UserId       string `json:"user_id"`
+	Nickname     string `json:"nickname,omitempty"`
+	Solved       int    `json:"solved"`
+	// The average credit of the problems, out of 100.
+	Score    int                         `json:"score"`
+	Problems []AssignmentProblemProgress `json:"problems"`
+}
+
+// Works out a student's progress from their submissions, which must be
+// oldest first. Each problem gets the best credit of its accepts.
+func BuildAssignmentProgress(assignment *Assignment, member *ClassroomMember, submissions []*Submission) AssignmentProgress {
+	progress := AssignmentProgress{
+		AssignmentId: assignment.AssignmentId,
+		UserId:       member.UserId,
+		Nickname:     member.Nickname,
+		Problems:     make([]AssignmentProblemProgress, 0, len(assignment.Problems)),
+	}
+	close_date := assignment.CloseDate()
+	total := 0
+	for _, problem := range assignment.Problems {
+		problem_progress := AssignmentProblemProgress{
+			ProblemId: problem.ProblemId,
+			Language:  problem.Language,
+			Status:    ProgressNotAttempted,
+		}
+		for _, submission := range submissions {
+			if submission.ProblemId != problem.ProblemId || submission.Verdict == VerdictError ||
+				(problem.Language != "" && submission.Language != problem.Language) ||
+				submission.SubmittedDate.Before(assignment.OpenDate) ||
+				(!close_date.IsZero() && submission.SubmittedDate.After(close_date)) {
+				continue
+			}
+			problem_progress.Attempts += 1
+			if problem_progress.Status == ProgressNotAttempted {
+				problem_progress.Status = ProgressAttempted
+			}
+			if submission.Verdict != VerdictAccepted {
+				continue
+			}
+			credit := assignment.Credit(submission.SubmittedDate)
+			if problem_progress.SolvedDate != nil && credit <= problem_progress.Credit {
+				continue
+			}
+			solved_date := submission.SubmittedDate
+			problem_progress.SolvedDate = &solved_date
+			problem_progress.SubmissionId = submission.SubmissionId
+			problem_progress.Credit = credit
+			problem_progress.Status = ProgressSolved
+			if solved_date.After(assignment.DueDate) {
+				problem_progress.Status = ProgressSolvedLate
+			}
+		}
+		if problem_progress.SolvedDate != nil {
+			progress.Solved += 1
+		}
+		total += problem_progress.Credit
+		progress.Problems = append(progress.Problems, problem_progress)
+	}
+	if len(assignment.Problems) > 0 {
+		progress.Score = total / len(assignment.Problems)
+	}
+	return progress
+}
+
+// The progress on assignment of each of members, by nickname.
+func GetAssignmentProgress(logger *log.Logger, assignment *Assignment, members []*ClassroomMember) ([]AssignmentProgress, error) {
+	all := make([]AssignmentProgress, 0, len(members))
+	for _, member := range members {
+		submissions, err := GetUserSubmissionsBetween(logger, member.UserId, assignment.OpenDate, assignment.CloseDate())
+		if err != nil {
+			return all, err
+		}
+		all = append(all, BuildAssignmentProgress(assignment, member, submissions))
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Nickname != all[j].Nickname {
+			return all[i].Nickname < all[j].Nickname
+		}
+		return all[i].UserId < all[j].UserId
+	})
+	return all, nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+// Contests are read into memory when user_data starts and kept there as
+// admins change them, so that every submit can check for a running contest
+// without a read. Scoreboards are built from the contest's submissions and
+// kept for a few seconds, or until the next contest submission.
+
+const scoreboardTTL = 10 * time.Second
+
+type ScoreboardCell struct {
+	ProblemId string `json:"problem_id"`
+	Label     string `json:"label"`
+	Solved    bool   `json:"solved"`
+	// Rejected submissions, up to the accept if there was one.
+	Attempts int `json:"attempts"`
+	// Minutes from the start to the accept.
+	SolvedMinute int  `json:"solved_minute,omitempty"`
+	FirstToSolve bool `json:"first_to_solve,omitempty"`
+	// With IOI scoring, the best of the user's submissions: all the
+	// problem's points for an accept, or its share of the tests passed.
+	Points int `json:"points,omitempty"`
+	// Submissions made during the freeze, whose verdicts aren't shown.
+	Pending int `json:"pending,omitempty"`
+}
+
+type ScoreboardRow struct {
+	// Users who are tied share a rank.
+	Rank     int    `json:"rank"`
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname,omitempty"`
+	Solved   int    `json:"solved"`
+	// Penalty minutes with ICPC scoring, points with IOI scoring.
+	Penalty  int              `json:"penalty,omitempty"`
+	Score    int              `json:"score,omitempty"`
+	Problems []ScoreboardCell `json:"problems"`
+}
+
+type Scoreboard struct {
+	ContestId string          `json:"contest_id"`
+	Scoring   string          `json:"scoring"`
+	Frozen    bool            `json:"frozen"`
+	Rows      []ScoreboardRow `json:"rows"`
+	BuiltDate time.Time       `json:"built_date"`
+}
+
+type Contests struct {
+	mutex    sync.Mutex
+	contests map[string]*Contest
+	// By contest_id and whether they're frozen.
+	scoreboards map[string]*Scoreboard
+}
+
+var contests = NewContests()
+
+func NewContests() *Contests {
+	return &Contests{
+		contests:    make(map[string]*Contest),
+		scoreboards: make(map[string]*Scoreboard),
+	}
+}
+
+func (c *Contests) Load(logger *log.Logger) error {
+	logger.Printf("Contests.Load() entry.")
+	defer logger.Printf("Contests.Load() exit.")
+	all, err := GetAllContests(logger)
+	if err != nil {
+		logger.Printf("failed to read contests: %s", err)
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, contest := range all {
+		c.contests[contest.ContestId] = contest
+	}
+	return nil
+}
+
+// Returns a copy, so callers can change it and Save it.
+func (c *Contests) Get(contest_id string) (Contest, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	contest, present := c.contests[contest_id]
+	if present == false {
+		return Contest{}, ContestNotFoundError{contest_id}
+	}
+	return *contest, nil
+}
+
+// Every contest, latest start first.
+func (c *Contests) List() []Contest {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	all := make([]Contest, 0, len(c.contests))
+	for _, contest := range c.contests {
+		all = append(all, *contest)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].StartDate.After(all[j].StartDate)
+	})
+	return all
+}
+
+// Stores a new or changed contest. A new one gets its ID here.
+func (c *Contests) Save(logger *log.Logger, contest Contest) (Contest, error) {
+	if contest.ContestId == "" {
+		new_uuid, err := uuid.NewV4()
+		if err != nil {
+			logger.Printf("failed to create new UUID.")
+			return contest, err
+		}
+		contest.ContestId = new_uuid.String()
+	}
+	contest.LastUpdatedDate = time.Now().UTC()
+	if err := PutContest(logger, &contest); err != nil {
+		return contest, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.contests[contest.ContestId] = &contest
+	c.invalidate(contest.ContestId)
+	return contest, nil
+}
+
+// The contest running at now that has problem_id in it, if any. Practice
+// submissions to it wait until the contest is over, and so do its
+// solutions.
+func (c *Contests) RunningWithProblem(problem_id string, now time.Time) (Contest, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, contest := range c.contests {
+		if _, present := contest.Problem(problem_id); present && contest.Status(now) == ContestRunning {
+			return *contest, true
+		}
+	}
+	return Contest{}, false
+}
+
+// Checks a submission naming contest_id. Returns true if it counts towards
+// the contest, or false if the contest is over and it's practice.
+func (c *Contests) CheckSubmission(logger *log.Logger, contest_id string, user_id string, problem_id string,
+	language string, now time.Time) (bool, error) {
+	contest, err := c.Get(contest_id)
+	if err != nil {
+		return false, err
+	}
+	if _, present := contest.Problem(problem_id); present == false {
+		return false, ContestSubmissionError{contest_id, "problem " + problem_id + " isn't in it"}
+	}
+	switch contest.Status(now) {
+	case ContestUpcoming:
+		return false, ContestSubmissionError{contest_id, "it hasn't started"}
+	case ContestEnded:
+		return false, nil
+	}
+	if !contest.AllowsLanguage(language) {
+		return false, ContestSubmissionError{contest_id, language + " isn't allowed"}
+	}
+	registration, err := GetContestRegistration(logger, contest_id, user_id)
+	if err != nil {
+		return false, err
+	}
+	if registration == nil {
+		return false, ContestSubmissionError{contest_id, "you haven't registered"}
+	}
+	return true, nil
+}
+
+// Drops contest_id's scoreboards, after a submission or registration.
+func (c *Contests) Invalidate(contest_id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.invalidate(contest_id)
+}
+
+func (c *Contests) invalidate(contest_id string) {
+	delete(c.scoreboards, scoreboardKey(contest_id, true))
+	delete(c.scoreboards, scoreboardKey(contest_id, false))
+}
+
+func scoreboardKey(contest_id string, frozen bool) string {
+	if frozen {
+		return contest_id + "#frozen"
+	}
+	return contest_id
+}
+
+// The scoreboard of contest_id at now. unfrozen shows every verdict even
+// during the freeze, for admins.
+func (c *Contests) Scoreboard(logger *log.Logger, contest_id string, now time.Time, unfrozen bool) (*Scoreboard, error) {
+	contest, err := c.Get(contest_id)
+	if err != nil {
+		return nil, err
+	}
+	frozen := contest.Frozen(now) && !unfrozen
+	key := scoreboardKey(contest_id, frozen)
+	c.mutex.Lock()
+	scoreboard, present := c.scoreboards[key]
+	c.mutex.Unlock()
+	if present == true && now.Sub(scoreboard.BuiltDate) < scoreboardTTL {
+		return scoreboard, nil
+	}
+
+	registrations, err := GetContestRegistrations(logger, contest_id)
+	if err != nil {
+		return nil, err
+	}
+	submissions, err := GetContestSubmissions(logger, contest_id)
+	if err != nil {
+		return nil, err
+	}
+	scoreboard = BuildScoreboard(&contest, registrations, submissions, frozen)
+	scoreboard.BuiltDate = now
+	c.mutex.Lock()
+	c.scoreboards[key] = scoreboard
+	c.mutex.Unlock()
+	return scoreboard, nil
+}
+
+// Ranks every registered user from their submissions, which must be oldest
+// first. If frozen, submissions from the freeze on are only counted as
+// pending. Submissions the evaluator couldn't judge don't count.
+func BuildScoreboard(contest *Contest, registrations []*ContestRegistration, submissions []*Submission,
+	frozen bool) *Scoreboard {
+	scoreboard := &Scoreboard{
+		ContestId: contest.ContestId,
+		Scoring:   contest.Scoring,
+		Frozen:    frozen,
+		Rows:      make([]ScoreboardRow, 0, len(registrations)),
+	}
+	columns := make(map[string]int)
+	for i, problem := range contest.Problems {
+		columns[problem.ProblemId] = i
+	}
+	rows := make(map[string]int)
+	for _, registration := range registrations {
+		row := ScoreboardRow{
+			UserId:   registration.UserId,
+			Nickname: registration.Nickname,
+			Problems: make([]ScoreboardCell, len(contest.Problems)),
+		}
+		for i, problem := range contest.Problems {
+			row.Problems[i] = ScoreboardCell{ProblemId: problem.ProblemId, Label: problem.Label}
+		}
+		rows[registration.UserId] = len(scoreboard.Rows)
+		scoreboard.Rows = append(scoreboard.Rows, row)
+	}
+
+	freeze_date := contest.FreezeDate()
+	// problem_id -> the user who solved it first.
+	first_solves := make(map[string]string)
+	for _, submission := range submissions {
+		r, registered := rows[submission.UserId]
+		column, in_contest := columns[submission.ProblemId]
+		if !registered || !in_contest || submission.Verdict == VerdictError ||
+			submission.SubmittedDate.Before(contest.StartDate) || !submission.SubmittedDate.Before(contest.EndDate) {
+			continue
+		}
+		cell := &scoreboard.Rows[r].Problems[column]
+		if cell.Solved {
+			continue
+		}
+		if frozen && !submission.SubmittedDate.Before(freeze_date) {
+			cell.Pending += 1
+			continue
+		}
+		if submission.Verdict != VerdictAccepted {
+			cell.Attempts += 1
+			if contest.Scoring == ContestScoringIOI {
+				if points := partialPoints(contest.Problems[column].Points, submission); points > cell.Points {
+					cell.Points = points
+				}
+			}
+			continue
+		}
+		cell.Solved = true
+		cell.SolvedMinute = int(submission.SubmittedDate.Sub(contest.StartDate) / time.Minute)
+		cell.Points = contest.Problems[column].Points
+		if _, present := first_solves[submission.ProblemId]; present == false {
+			first_solves[submission.ProblemId] = submission.UserId
+			cell.FirstToSolve = true
+		}
+	}
+
+	for r := range scoreboard.Rows {
+		row := &scoreboard.Rows[r]
+		for i := range row.Problems {
+			cell := &row.Problems[i]
+			if contest.Scoring == ContestScoringIOI {
+				row.Score += cell.Points
+			}
+			if !cell.Solved {
+				continue
+			}
+			row.Solved += 1
+			if contest.Scoring != ContestScoringIOI {
+				row.Penalty += cell.SolvedMinute + cell.Attempts*contest.PenaltyMinutes
+			}
+		}
+	}
+
+	// Best first. Ties are listed by nickname.
+	better := func(a, b *ScoreboardRow) bool {
+		if contest.Scoring == ContestScoringIOI {
+			return a.Score > b.Score
+		}
+		if a.Solved != b.Solved {
+			return a.Solved > b.Solved
+		}
+		return a.Penalty < b.Penalty
+	}
+	sort.SliceStable(scoreboard.Rows, func(i, j int) bool {
+		a, b := &scoreboard.Rows[i], &scoreboard.Rows[j]
+		if better(a, b) || better(b, a) {
+			return better(a, b)
+		}
+		if a.Nickname != b.Nickname {
+			return a.Nickname < b.Nickname
+		}
+		return a.UserId < b.UserId
+	})
+	for i := range scoreboard.Rows {
+		if i > 0 && !better(&scoreboard.Rows[i-1], &scoreboard.Rows[i]) {
+			scoreboard.Rows[i].Rank = scoreboard.Rows[i-1].Rank
+		} else {
+			scoreboard.Rows[i].Rank = i + 1
+		}
+	}
+	return scoreboard
+}
+
+// The share of points a rejected submission earns for the tests it passed,
+// rounded down. Submissions whose output doesn't count its tests earn none.
+func partialPoints(points int, submission *Submission) int {
+	if submission.Tests <= 0 {
+		return 0
+	}
+	return points * submission.TestsPassed / submission.Tests
+}
@@ -13,7 +13,9 @@ import (
 	desc "github.com/smugmug/godynamo/endpoints/describe_table"
 	"github.com/smugmug/godynamo/endpoints/list_tables"
 	"github.com/smugmug/godynamo/types/attributedefinition"
+	"github.com/smugmug/godynamo/types/globalsecondaryindex"
 	"github.com/smugmug/godynamo/types/keydefinition"
+	"github.com/smugmug/godynamo/types/projection"
 )
 
 var (
@@ -22,7 +24,8 @@ var (
 	DeleteTableTimeOut           = errors.New("Delete table time out.")
 	tables                       = []string{
 		"user", "user_email_to_id", "user_nickname_to_id",
-		"solution", "user_vote"}
+		"solution", "user_vote", "submission", "contest", "contest_registration",
+		"classroom", "classroom_member", "assignment"}
 )
 
 func CreateTables(logger *log.Logger) error {
@@ -43,6 +46,24 @@ func CreateTables(logger *log.Logger) error {
 	if err := createUserVoteTable(logger, "user_vote"); err != nil {
 		logger.Printf("could not create user_vote table: %s", err)
 	}
+	if err := createSubmissionTable(logger, "submission"); err != nil {
+		logger.Printf("could not create submission table: %s", err)
+	}
+	if err := createContestTable(logger, "contest"); err != nil {
+		logger.Printf("could not create contest table: %s", err)
+	}
+	if err := createContestRegistrationTable(logger, "contest_registration"); err != nil {
+		logger.Printf("could not create contest_registration table: %s", err)
+	}
+	if err := createClassroomTable(logger, "classroom"); err != nil {
+		logger.Printf("could not create classroom table: %s", err)
+	}
+	if err := createClassroomMemberTable(logger, "classroom_member"); err != nil {
+		logger.Printf("could not create classroom_member table: %s", err)
+	}
+	if err := createAssignmentTable(logger, "assignment"); err != nil {
+		logger.Printf("could not create assignment table: %s", err)
+	}
 	time.Sleep(5 * time.Second)
 	for _, table := range tables {
 		logger.Printf("checking for ACTIVE status for table %s...", table)
@@ -364,3 +385,266 @@ func createUserVoteTable(logger *log.Logger, table_name string) error {
 
 	return nil
 }
+
+func createSubmissionTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createSubmissionTable() entry.")
+	defer logger.Printf("createSubmissionTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 5
+	create1.ProvisionedThroughput.WriteCapacityUnits = 5
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "submission_id", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "user_problem", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "user_id", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "contest_id", AttributeType: ep.S},
+		attributedefinition.AttributeDefinition{AttributeName: "submitted_date", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "submission_id", KeyType: ep.HASH})
+
+	// GSIs backing the submission lists, see db_orm_submission.go, and
+	// contest scoreboards, see db_orm_contest.go.
+	for index_name, hash_key := range map[string]string{
+		submissionUserProblemIndex: "user_problem",
+		submissionUserIndex:        "user_id",
+		submissionContestIndex:     "contest_id",
+	} {
+		gsi := globalsecondaryindex.NewGlobalSecondaryIndex()
+		gsi.IndexName = index_name
+		gsi.KeySchema = append(gsi.KeySchema,
+			keydefinition.KeyDefinition{AttributeName: hash_key, KeyType: ep.HASH})
+		gsi.KeySchema = append(gsi.KeySchema,
+			keydefinition.KeyDefinition{AttributeName: "submitted_date", KeyType: ep.RANGE})
+		gsi.Projection.ProjectionType = projection.ALL
+		gsi.ProvisionedThroughput.ReadCapacityUnits = 5
+		gsi.ProvisionedThroughput.WriteCapacityUnits = 5
+		create1.GlobalSecondaryIndexes = append(create1.GlobalSecondaryIndexes, *gsi)
+	}
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func createContestTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createContestTable() entry.")
+	defer logger.Printf("createContestTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 1
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "contest_id", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "contest_id", KeyType: ep.HASH})
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func createContestRegistrationTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createContestRegistrationTable() entry.")
+	defer logger.Printf("createContestRegistrationTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 5
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "contest_id", AttributeType: ep.S})
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "user_id", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "contest_id", KeyType: ep.HASH})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "user_id", KeyType: ep.RANGE})
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func createClassroomTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createClassroomTable() entry.")
+	defer logger.Printf("createClassroomTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 1
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "classroom_id", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "classroom_id", KeyType: ep.HASH})
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func createClassroomMemberTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createClassroomMemberTable() entry.")
+	defer logger.Printf("createClassroomMemberTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 5
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "classroom_id", AttributeType: ep.S})
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "user_id", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "classroom_id", KeyType: ep.HASH})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "user_id", KeyType: ep.RANGE})
+
+	// The classrooms a user is in, see db_orm_classroom.go.
+	gsi := globalsecondaryindex.NewGlobalSecondaryIndex()
+	gsi.IndexName = classroomMemberUserIndex
+	gsi.KeySchema = append(gsi.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "user_id", KeyType: ep.HASH})
+	gsi.KeySchema = append(gsi.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "classroom_id", KeyType: ep.RANGE})
+	gsi.Projection.ProjectionType = projection.ALL
+	gsi.ProvisionedThroughput.ReadCapacityUnits = 5
+	gsi.ProvisionedThroughput.WriteCapacityUnits = 1
+	create1.GlobalSecondaryIndexes = append(create1.GlobalSecondaryIndexes, *gsi)
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func createAssignmentTable(logger *log.Logger, table_name string) error {
+	logger.Printf("createAssignmentTable() entry.")
+	defer logger.Printf("createAssignmentTable() exit.")
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if exists, err = doesTableExist(logger, table_name); err != nil {
+		logger.Printf("unable to check for table existence")
+		return CannotCheckForTableExistence
+	}
+	if exists == true {
+		logger.Printf("table %s already exists.", table_name)
+		return TableAlreadyExists
+	}
+
+	create1 := create_table.NewCreateTable()
+	create1.TableName = table_name
+	create1.ProvisionedThroughput.ReadCapacityUnits = 5
+	create1.ProvisionedThroughput.WriteCapacityUnits = 1
+
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "classroom_id", AttributeType: ep.S})
+	create1.AttributeDefinitions = append(create1.AttributeDefinitions,
+		attributedefinition.AttributeDefinition{AttributeName: "assignment_id", AttributeType: ep.S})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "classroom_id", KeyType: ep.HASH})
+	create1.KeySchema = append(create1.KeySchema,
+		keydefinition.KeyDefinition{AttributeName: "assignment_id", KeyType: ep.RANGE})
+
+	if err := executeCreateTable(logger, create1); err != nil {
+		logger.Printf("failed to create table: %s", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	ep "github.com/smugmug/godynamo/endpoint"
+	delete_item "github.com/smugmug/godynamo/endpoints/delete_item"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+	"github.com/smugmug/godynamo/endpoints/query"
+	"github.com/smugmug/godynamo/endpoints/scan"
+	"github.com/smugmug/godynamo/types/attributevalue"
+	"github.com/smugmug/godynamo/types/condition"
+)
+
+// GSI of classroom_member listing the classrooms a user is in.
+const classroomMemberUserIndex = "user_id-classroom_id"
+
+func PutClassroom(logger *log.Logger, classroom *Classroom) error {
+	logger.Printf("db_orm_classroom.PutClassroom() entry. classroom.ClassroomId: %s", classroom.ClassroomId)
+	defer logger.Printf("db_orm_classroom.PutClassroom() exit.")
+
+	put1 := put.NewPutItem()
+	put1.TableName = "classroom"
+	put1.Item["classroom_id"] = &attributevalue.AttributeValue{S: classroom.ClassroomId}
+	put1.Item["name"] = &attributevalue.AttributeValue{S: classroom.Name}
+	if classroom.Organisation != "" {
+		put1.Item["organisation"] = &attributevalue.AttributeValue{S: classroom.Organisation}
+	}
+	if classroom.Description != "" {
+		put1.Item["description"] = &attributevalue.AttributeValue{S: classroom.Description}
+	}
+	put1.Item["student_invite_code"] = &attributevalue.AttributeValue{S: classroom.StudentInviteCode}
+	put1.Item["instructor_invite_code"] = &attributevalue.AttributeValue{S: classroom.InstructorInviteCode}
+	put1.Item["created_by"] = &attributevalue.AttributeValue{S: classroom.CreatedBy}
+	put1.Item["creation_date"] = &attributevalue.AttributeValue{S: classroom.CreationDate.UTC().Format(time.RFC3339)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of classroom %s returned HTTP %d", classroom.ClassroomId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns every classroom.
+func GetAllClassrooms(logger *log.Logger) ([]*Classroom, error) {
+	logger.Printf("db_orm_classroom.GetAllClassrooms() entry.")
+	defer logger.Printf("db_orm_classroom.GetAllClassrooms() exit.")
+
+	classrooms := make([]*Classroom, 0)
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		s := scan.NewScan()
+		s.TableName = "classroom"
+		if exclusive_start_key != nil {
+			s.ExclusiveStartKey = exclusive_start_key
+		}
+		return s.EndpointReq()
+	})
+	if err != nil {
+		return classrooms, err
+	}
+	for _, item := range items {
+		classroom, err := ItemToClassroom(logger, "", item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return classrooms, err
+		}
+		classrooms = append(classrooms, classroom)
+	}
+	return classrooms, nil
+}
+
+func PutClassroomMember(logger *log.Logger, member *ClassroomMember) error {
+	logger.Printf("db_orm_classroom.PutClassroomMember() entry. classroom_id: %s, user_id: %s, role: %s",
+		member.ClassroomId, member.UserId, member.Role)
+	defer logger.Printf("db_orm_classroom.PutClassroomMember() exit.")
+
+	put1 := put.NewPutItem()
+	put1.TableName = "classroom_member"
+	put1.Item["classroom_id"] = &attributevalue.AttributeValue{S: member.ClassroomId}
+	put1.Item["user_id"] = &attributevalue.AttributeValue{S: member.UserId}
+	if member.Nickname != "" {
+		put1.Item["nickname"] = &attributevalue.AttributeValue{S: member.Nickname}
+	}
+	put1.Item["role"] = &attributevalue.AttributeValue{S: member.Role}
+	put1.Item["joined_date"] = &attributevalue.AttributeValue{S: member.JoinedDate.UTC().Format(time.RFC3339)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of member of classroom %s returned HTTP %d", member.ClassroomId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns nil, without an error, if user_id isn't in the classroom.
+func GetClassroomMember(logger *log.Logger, classroom_id string, user_id string) (*ClassroomMember, error) {
+	logger.Printf("db_orm_classroom.GetClassroomMember() entry. classroom_id: %s, user_id: %s", classroom_id, user_id)
+	defer logger.Printf("db_orm_classroom.GetClassroomMember() exit.")
+
+	get1 := get.NewGetItem()
+	get1.TableName = "classroom_member"
+	get1.Key["classroom_id"] = &attributevalue.AttributeValue{S: classroom_id}
+	get1.Key["user_id"] = &attributevalue.AttributeValue{S: user_id}
+	resp, err := executeGetItem(logger, get1)
+	if err != nil {
+		logger.Printf("failed to execute get item: %s", err)
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("get of member of classroom %s failed", classroom_id)
+	}
+	if _, present := resp.Item["user_id"]; present == false {
+		return nil, nil
+	}
+	return ItemToClassroomMember(logger, resp.Item)
+}
+
+func DeleteClassroomMember(logger *log.Logger, classroom_id string, user_id string) error {
+	logger.Printf("db_orm_classroom.DeleteClassroomMember() entry. classroom_id: %s, user_id: %s", classroom_id, user_id)
+	defer logger.Printf("db_orm_classroom.DeleteClassroomMember() exit.")
+
+	delete1 := delete_item.NewDeleteItem()
+	delete1.TableName = "classroom_member"
+	delete1.Key["classroom_id"] = &attributevalue.AttributeValue{S: classroom_id}
+	delete1.Key["user_id"] = &attributevalue.AttributeValue{S: user_id}
+	body, code, err := delete1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("delete failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("delete of member of classroom %s returned HTTP %d", classroom_id, code)
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns the members of classroom_id, or if classroom_id is empty, the
+// memberships of user_id in every classroom.
+func GetClassroomMembers(logger *log.Logger, classroom_id string, user_id string) ([]*ClassroomMember, error) {
+	logger.Printf("db_orm_classroom.GetClassroomMembers() entry. classroom_id: %s, user_id: %s", classroom_id, user_id)
+	defer logger.Printf("db_orm_classroom.GetClassroomMembers() exit.")
+
+	members := make([]*ClassroomMember, 0)
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		q := query.NewQuery()
+		q.TableName = "classroom_member"
+		q.Select = ep.SELECT_ALL
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.ComparisonOperator = query.OP_EQ
+		if classroom_id != "" {
+			kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: classroom_id}
+			q.KeyConditions["classroom_id"] = kc
+		} else {
+			q.IndexName = classroomMemberUserIndex
+			kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: user_id}
+			q.KeyConditions["user_id"] = kc
+		}
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+		return q.EndpointReq()
+	})
+	if err != nil {
+		return members, err
+	}
+	for _, item := range items {
+		member, err := ItemToClassroomMember(logger, item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return members, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func PutAssignment(logger *log.Logger, assignment *Assignment) error {
+	logger.Printf("db_orm_classroom.PutAssignment() entry. classroom_id: %s, assignment_id: %s",
+		assignment.ClassroomId, assignment.AssignmentId)
+	defer logger.Printf("db_orm_classroom.PutAssignment() exit.")
+
+	problems, err := json.Marshal(assignment.Problems)
+	if err != nil {
+		logger.Printf("failed to encode problems of assignment %s: %s", assignment.AssignmentId, err)
+		return err
+	}
+	put1 := put.NewPutItem()
+	put1.TableName = "assignment"
+	put1.Item["classroom_id"] = &attributevalue.AttributeValue{S: assignment.ClassroomId}
+	put1.Item["assignment_id"] = &attributevalue.AttributeValue{S: assignment.AssignmentId}
+	put1.Item["title"] = &attributevalue.AttributeValue{S: assignment.Title}
+	if assignment.Description != "" {
+		put1.Item["description"] = &attributevalue.AttributeValue{S: assignment.Description}
+	}
+	put1.Item["problems"] = &attributevalue.AttributeValue{S: string(problems)}
+	put1.Item["open_date"] = &attributevalue.AttributeValue{S: assignment.OpenDate.UTC().Format(time.RFC3339)}
+	put1.Item["due_date"] = &attributevalue.AttributeValue{S: assignment.DueDate.UTC().Format(time.RFC3339)}
+	put1.Item["late_policy"] = &attributevalue.AttributeValue{S: assignment.LatePolicy}
+	put1.Item["late_penalty_percent"] = &attributevalue.AttributeValue{N: strconv.Itoa(assignment.LatePenaltyPercent)}
+	if !assignment.LateCutoffDate.IsZero() {
+		put1.Item["late_cutoff_date"] = &attributevalue.AttributeValue{S: assignment.LateCutoffDate.UTC().Format(time.RFC3339)}
+	}
+	put1.Item["created_by"] = &attributevalue.AttributeValue{S: assignment.CreatedBy}
+	put1.Item["last_updated_date"] = &attributevalue.AttributeValue{S: assignment.LastUpdatedDate.UTC().Format(time.RFC3339)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of assignment %s returned HTTP %d", assignment.AssignmentId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+func GetAssignment(logger *log.Logger, classroom_id string, assignment_id string) (*Assignment, error) {
+	logger.Printf("db_orm_classroom.GetAssignment() entry. classroom_id: %s, assignment_id: %s", classroom_id, assignment_id)
+	defer logger.Printf("db_orm_classroom.GetAssignment() exit.")
+
+	get1 := get.NewGetItem()
+	get1.TableName = "assignment"
+	get1.Key["classroom_id"] = &attributevalue.AttributeValue{S: classroom_id}
+	get1.Key["assignment_id"] = &attributevalue.AttributeValue{S: assignment_id}
+	resp, err := executeGetItem(logger, get1)
+	if err != nil {
+		logger.Printf("failed to execute get item: %s", err)
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("get of assignment %s failed", assignment_id)
+	}
+	return ItemToAssignment(logger, assignment_id, resp.Item)
+}
+
+func GetAssignments(logger *log.Logger, classroom_id string) ([]*Assignment, error) {
+	logger.Printf("db_orm_classroom.GetAssignments() entry. classroom_id: %s", classroom_id)
+	defer logger.Printf("db_orm_classroom.GetAssignments() exit.")
+
+	assignments := make([]*Assignment, 0)
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		q := query.NewQuery()
+		q.TableName = "assignment"
+		q.Select = ep.SELECT_ALL
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: classroom_id}
+		kc.ComparisonOperator = query.OP_EQ
+		q.KeyConditions["classroom_id"] = kc
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+		return q.EndpointReq()
+	})
+	if err != nil {
+		return assignments, err
+	}
+	for _, item := range items {
+		assignment, err := ItemToAssignment(logger, "", item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return assignments, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, nil
+}
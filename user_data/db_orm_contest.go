@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	ep "github.com/smugmug/godynamo/endpoint"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+	"github.com/smugmug/godynamo/endpoints/query"
+	"github.com/smugmug/godynamo/endpoints/scan"
+	"github.com/smugmug/godynamo/types/attributevalue"
+	"github.com/smugmug/godynamo/types/condition"
+	"github.com/smugmug/godynamo/types/item"
+)
+
+// GSI of the submission table over the submissions made during a contest,
+// oldest first. Practice submissions have no contest_id so aren't in it.
+const submissionContestIndex = "contest_id-submitted_date"
+
+func PutContest(logger *log.Logger, contest *Contest) error {
+	logger.Printf("db_orm_contest.PutContest() entry. contest.ContestId: %s", contest.ContestId)
+	defer logger.Printf("db_orm_contest.PutContest() exit.")
+
+	problems, err := json.Marshal(contest.Problems)
+	if err != nil {
+		logger.Printf("failed to encode problems of contest %s: %s", contest.ContestId, err)
+		return err
+	}
+	put1 := put.NewPutItem()
+	put1.TableName = "contest"
+	put1.Item["contest_id"] = &attributevalue.AttributeValue{S: contest.ContestId}
+	put1.Item["title"] = &attributevalue.AttributeValue{S: contest.Title}
+	if contest.Description != "" {
+		put1.Item["description"] = &attributevalue.AttributeValue{S: contest.Description}
+	}
+	put1.Item["start_date"] = &attributevalue.AttributeValue{S: contest.StartDate.UTC().Format(time.RFC3339)}
+	put1.Item["end_date"] = &attributevalue.AttributeValue{S: contest.EndDate.UTC().Format(time.RFC3339)}
+	put1.Item["scoring"] = &attributevalue.AttributeValue{S: contest.Scoring}
+	put1.Item["penalty_minutes"] = &attributevalue.AttributeValue{N: strconv.Itoa(contest.PenaltyMinutes)}
+	put1.Item["freeze_minutes"] = &attributevalue.AttributeValue{N: strconv.Itoa(contest.FreezeMinutes)}
+	put1.Item["revealed"] = &attributevalue.AttributeValue{BOOL: contest.Revealed}
+	put1.Item["problems"] = &attributevalue.AttributeValue{S: string(problems)}
+	// DynamoDB doesn't store empty sets.
+	if len(contest.Languages) > 0 {
+		put1.Item["languages"] = &attributevalue.AttributeValue{SS: contest.Languages}
+	}
+	if contest.CreatedBy != "" {
+		put1.Item["created_by"] = &attributevalue.AttributeValue{S: contest.CreatedBy}
+	}
+	put1.Item["last_updated_date"] = &attributevalue.AttributeValue{S: contest.LastUpdatedDate.UTC().Format(time.RFC3339)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of contest %s returned HTTP %d", contest.ContestId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns every contest. There are few enough to scan.
+func GetAllContests(logger *log.Logger) ([]*Contest, error) {
+	logger.Printf("db_orm_contest.GetAllContests() entry.")
+	defer logger.Printf("db_orm_contest.GetAllContests() exit.")
+
+	contests := make([]*Contest, 0)
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		s := scan.NewScan()
+		s.TableName = "contest"
+		if exclusive_start_key != nil {
+			s.ExclusiveStartKey = exclusive_start_key
+		}
+		return s.EndpointReq()
+	})
+	if err != nil {
+		return contests, err
+	}
+	for _, item := range items {
+		contest, err := ItemToContest(logger, "", item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return contests, err
+		}
+		contests = append(contests, contest)
+	}
+	return contests, nil
+}
+
+func PutContestRegistration(logger *log.Logger, registration *ContestRegistration) error {
+	logger.Printf("db_orm_contest.PutContestRegistration() entry. contest_id: %s, user_id: %s",
+		registration.ContestId, registration.UserId)
+	defer logger.Printf("db_orm_contest.PutContestRegistration() exit.")
+
+	put1 := put.NewPutItem()
+	put1.TableName = "contest_registration"
+	put1.Item["contest_id"] = &attributevalue.AttributeValue{S: registration.ContestId}
+	put1.Item["user_id"] = &attributevalue.AttributeValue{S: registration.UserId}
+	if registration.Nickname != "" {
+		put1.Item["nickname"] = &attributevalue.AttributeValue{S: registration.Nickname}
+	}
+	put1.Item["registered_date"] = &attributevalue.AttributeValue{S: registration.RegisteredDate.UTC().Format(time.RFC3339)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of registration to contest %s returned HTTP %d", registration.ContestId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+// Returns nil, without an error, if user_id hasn't registered.
+func GetContestRegistration(logger *log.Logger, contest_id string, user_id string) (*ContestRegistration, error) {
+	logger.Printf("db_orm_contest.GetContestRegistration() entry. contest_id: %s, user_id: %s", contest_id, user_id)
+	defer logger.Printf("db_orm_contest.GetContestRegistration() exit.")
+
+	get1 := get.NewGetItem()
+	get1.TableName = "contest_registration"
+	get1.Key["contest_id"] = &attributevalue.AttributeValue{S: contest_id}
+	get1.Key["user_id"] = &attributevalue.AttributeValue{S: user_id}
+	resp, err := executeGetItem(logger, get1)
+	if err != nil {
+		logger.Printf("failed to execute get item: %s", err)
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("get of registration to contest %s failed", contest_id)
+	}
+	if _, present := resp.Item["user_id"]; present == false {
+		return nil, nil
+	}
+	return ItemToContestRegistration(logger, resp.Item)
+}
+
+func GetContestRegistrations(logger *log.Logger, contest_id string) ([]*ContestRegistration, error) {
+	logger.Printf("db_orm_contest.GetContestRegistrations() entry. contest_id: %s", contest_id)
+	defer logger.Printf("db_orm_contest.GetContestRegistrations() exit.")
+
+	registrations := make([]*ContestRegistration, 0)
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		q := query.NewQuery()
+		q.TableName = "contest_registration"
+		q.Select = ep.SELECT_ALL
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: contest_id}
+		kc.ComparisonOperator = query.OP_EQ
+		q.KeyConditions["contest_id"] = kc
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+		return q.EndpointReq()
+	})
+	if err != nil {
+		return registrations, err
+	}
+	for _, item := range items {
+		registration, err := ItemToContestRegistration(logger, item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return registrations, err
+		}
+		registrations = append(registrations, registration)
+	}
+	return registrations, nil
+}
+
+// Returns every submission made during contest_id, oldest first.
+func GetContestSubmissions(logger *log.Logger, contest_id string) ([]*Submission, error) {
+	logger.Printf("db_orm_contest.GetContestSubmissions() entry. contest_id: %s", contest_id)
+	defer logger.Printf("db_orm_contest.GetContestSubmissions() exit.")
+
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		q := query.NewQuery()
+		q.TableName = "submission"
+		q.IndexName = submissionContestIndex
+		q.Select = ep.SELECT_ALL
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: contest_id}
+		kc.ComparisonOperator = query.OP_EQ
+		q.KeyConditions["contest_id"] = kc
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+		return q.EndpointReq()
+	})
+	if err != nil {
+		return make([]*Submission, 0), err
+	}
+	return ItemsToSubmissions(logger, items)
+}
+
+// Calls request with each LastEvaluatedKey in turn until there are no more
+// pages, and returns the items of all of them.
+func scanOrQueryAll(logger *log.Logger,
+	request func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error)) ([]item.Item, error) {
+	var (
+		items               = make([]item.Item, 0)
+		exclusive_start_key attributevalue.AttributeValueMap
+	)
+	for {
+		body, code, err := request(exclusive_start_key)
+		if err != nil || code != http.StatusOK {
+			logger.Printf("query/scan failed %d %v %s\n", code, err, body)
+			if err == nil {
+				err = fmt.Errorf("query/scan returned HTTP %d", code)
+			}
+			return items, err
+		}
+		// Query and Scan responses share Items and LastEvaluatedKey.
+		var resp scan.Response
+		if um_err := json.Unmarshal([]byte(body), &resp); um_err != nil {
+			logger.Printf("unmarshal Response: %v", um_err)
+			return items, um_err
+		}
+		items = append(items, resp.Items...)
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		exclusive_start_key = resp.LastEvaluatedKey
+	}
+	return items, nil
+}
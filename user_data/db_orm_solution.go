@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +15,8 @@ import (
 	get "github.com/smugmug/godynamo/endpoints/get_item"
 	put "github.com/smugmug/godynamo/endpoints/put_item"
 	"github.com/smugmug/godynamo/endpoints/query"
+	scan "github.com/smugmug/godynamo/endpoints/scan"
+	update "github.com/smugmug/godynamo/endpoints/update_item"
 	"github.com/smugmug/godynamo/types/attributevalue"
 	"github.com/smugmug/godynamo/types/condition"
 	"github.com/smugmug/godynamo/types/item"
@@ -217,6 +220,7 @@ func getPutSolutionIntoSolution(logger *log.Logger, solution *Solution) (*item.I
 	put_item := item.NewItem()
 	put_item["solution_id"] = &attributevalue.AttributeValue{S: solution.SolutionId}
 	put_item["problem_id"] = &attributevalue.AttributeValue{S: solution.ProblemId}
+	put_item["problem_version"] = &attributevalue.AttributeValue{N: strconv.Itoa(solution.ProblemVersion)}
 	put_item["user_id"] = &attributevalue.AttributeValue{S: solution.UserId}
 	put_item["nickname"] = &attributevalue.AttributeValue{S: solution.Nickname}
 	compressed_description, err := CompressToBase64(logger, solution.Description)
@@ -225,6 +229,12 @@ func getPutSolutionIntoSolution(logger *log.Logger, solution *Solution) (*item.I
 		return &put_item, err
 	}
 	put_item["description"] = &attributevalue.AttributeValue{B: compressed_description}
+	compressed_description_html, err := CompressToBase64(logger, solution.DescriptionHTML)
+	if err != nil {
+		logger.Printf("failed to compress description_html for solution.SolutionId %s!", solution.SolutionId)
+		return &put_item, err
+	}
+	put_item["description_html"] = &attributevalue.AttributeValue{B: compressed_description_html}
 	compressed_code, err := CompressToBase64(logger, solution.Code)
 	if err != nil {
 		logger.Printf("failed to compress code for solution.SolutionId %s!", solution.SolutionId)
@@ -237,3 +247,102 @@ func getPutSolutionIntoSolution(logger *log.Logger, solution *Solution) (*item.I
 	put_item["last_updated_date"] = &attributevalue.AttributeValue{S: solution.LastUpdatedDate.Format(time.RFC3339)}
 	return &put_item, nil
 }
+
+// Returns every solution for problem_id (<problem id>#<language>), following
+// LastEvaluatedKey across pages. If problem_id is empty the whole solution
+// table is scanned.
+func GetAllSolutions(logger *log.Logger, problem_id string) ([]*Solution, error) {
+	logger.Printf("db_orm_solution.GetAllSolutions() entry. problem_id: %s", problem_id)
+	defer logger.Printf("db_orm_solution.GetAllSolutions() exit.")
+
+	var (
+		solutions           = make([]*Solution, 0)
+		exclusive_start_key attributevalue.AttributeValueMap
+	)
+
+	for {
+		var (
+			body []byte
+			code int
+			err  error
+		)
+		if problem_id != "" {
+			q := query.NewQuery()
+			q.TableName = "solution"
+			q.Select = ep.SELECT_ALL
+			kc := condition.NewCondition()
+			kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+			kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: problem_id}
+			kc.ComparisonOperator = query.OP_EQ
+			q.KeyConditions["problem_id"] = kc
+			if exclusive_start_key != nil {
+				q.ExclusiveStartKey = exclusive_start_key
+			}
+			body, code, err = q.EndpointReq()
+		} else {
+			s := scan.NewScan()
+			s.TableName = "solution"
+			if exclusive_start_key != nil {
+				s.ExclusiveStartKey = exclusive_start_key
+			}
+			body, code, err = s.EndpointReq()
+		}
+		if err != nil || code != http.StatusOK {
+			logger.Printf("query/scan failed %d %v %s\n", code, err, body)
+			if err == nil {
+				err = fmt.Errorf("query/scan of solution returned HTTP %d", code)
+			}
+			return solutions, err
+		}
+
+		// Query and Scan responses share Items and LastEvaluatedKey.
+		var resp scan.Response
+		if um_err := json.Unmarshal([]byte(body), &resp); um_err != nil {
+			logger.Printf("unmarshal Response: %v", um_err)
+			return solutions, um_err
+		}
+		page, err := ItemsToSolutions(logger, resp.Items)
+		if err != nil {
+			logger.Printf("error while converting items to solutions: %s", err)
+			return solutions, err
+		}
+		solutions = append(solutions, page...)
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		exclusive_start_key = resp.LastEvaluatedKey
+	}
+	return solutions, nil
+}
+
+// Records the verdict of a rejudge on the solution item, leaving the original
+// submission and votes untouched.
+func UpdateSolutionRejudge(logger *log.Logger, solution *Solution) error {
+	logger.Printf("db_orm_solution.UpdateSolutionRejudge() entry. solution.SolutionId: %s", solution.SolutionId)
+	defer logger.Printf("db_orm_solution.UpdateSolutionRejudge() exit.")
+
+	if solution.RejudgeSuccess == nil || solution.RejudgeDate == nil {
+		return errors.New("solution has no rejudge verdict to record")
+	}
+
+	update1 := update.NewUpdateItem()
+	update1.TableName = "solution"
+	update1.Key["problem_id"] = &attributevalue.AttributeValue{S: solution.ProblemId}
+	update1.Key["user_id"] = &attributevalue.AttributeValue{S: solution.UserId}
+	update1.UpdateExpression = "SET rejudge_job_id = :j, rejudge_success = :s, " +
+		"rejudge_problem_version = :v, rejudge_date = :d"
+	update1.ExpressionAttributeValues[":j"] = &attributevalue.AttributeValue{S: solution.RejudgeJobId}
+	update1.ExpressionAttributeValues[":s"] = &attributevalue.AttributeValue{BOOL: *solution.RejudgeSuccess}
+	update1.ExpressionAttributeValues[":v"] = &attributevalue.AttributeValue{N: strconv.Itoa(solution.RejudgeProblemVersion)}
+	update1.ExpressionAttributeValues[":d"] = &attributevalue.AttributeValue{S: solution.RejudgeDate.Format(time.RFC3339)}
+
+	body, code, err := update1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("update failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("update of solution %s returned HTTP %d", solution.SolutionId, code)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	ep "github.com/smugmug/godynamo/endpoint"
+	get "github.com/smugmug/godynamo/endpoints/get_item"
+	put "github.com/smugmug/godynamo/endpoints/put_item"
+	"github.com/smugmug/godynamo/endpoints/query"
+	"github.com/smugmug/godynamo/endpoints/scan"
+	"github.com/smugmug/godynamo/types/attributevalue"
+	"github.com/smugmug/godynamo/types/condition"
+)
+
+// GSIs of the submission table, both newest first.
+const (
+	submissionUserProblemIndex = "user_problem-submitted_date"
+	submissionUserIndex        = "user_id-submitted_date"
+)
+
+var (
+	InvalidSubmissionCursor = errors.New("invalid submission cursor.")
+)
+
+func PutSubmission(logger *log.Logger, submission *Submission) error {
+	logger.Printf("db_orm_submission.PutSubmission() entry. submission.SubmissionId: %s", submission.SubmissionId)
+	defer logger.Printf("db_orm_submission.PutSubmission() exit.")
+
+	put1 := put.NewPutItem()
+	put1.TableName = "submission"
+	put1.Item["submission_id"] = &attributevalue.AttributeValue{S: submission.SubmissionId}
+	put1.Item["user_id"] = &attributevalue.AttributeValue{S: submission.UserId}
+	put1.Item["user_problem"] = &attributevalue.AttributeValue{
+		S: submissionUserProblem(submission.UserId, submission.ProblemId, submission.Language)}
+	if submission.Nickname != "" {
+		put1.Item["nickname"] = &attributevalue.AttributeValue{S: submission.Nickname}
+	}
+	put1.Item["problem_id"] = &attributevalue.AttributeValue{S: submission.ProblemId}
+	put1.Item["language"] = &attributevalue.AttributeValue{S: submission.Language}
+	put1.Item["problem_version"] = &attributevalue.AttributeValue{N: strconv.Itoa(submission.ProblemVersion)}
+	if submission.ContestId != "" {
+		put1.Item["contest_id"] = &attributevalue.AttributeValue{S: submission.ContestId}
+	}
+	compressed_code, err := CompressToBase64(logger, submission.Code)
+	if err != nil {
+		logger.Printf("failed to compress code for submission %s!", submission.SubmissionId)
+		return err
+	}
+	put1.Item["code"] = &attributevalue.AttributeValue{B: compressed_code}
+	put1.Item["verdict"] = &attributevalue.AttributeValue{S: submission.Verdict}
+	compressed_output, err := CompressToBase64(logger, submission.Output)
+	if err != nil {
+		logger.Printf("failed to compress output for submission %s!", submission.SubmissionId)
+		return err
+	}
+	put1.Item["output"] = &attributevalue.AttributeValue{B: compressed_output}
+	if submission.Tests > 0 {
+		put1.Item["tests"] = &attributevalue.AttributeValue{N: strconv.Itoa(submission.Tests)}
+		put1.Item["tests_passed"] = &attributevalue.AttributeValue{N: strconv.Itoa(submission.TestsPassed)}
+	}
+	put1.Item["queue_wait_seconds"] = &attributevalue.AttributeValue{
+		N: strconv.FormatFloat(submission.QueueWaitSeconds, 'f', 3, 64)}
+	put1.Item["run_seconds"] = &attributevalue.AttributeValue{
+		N: strconv.FormatFloat(submission.RunSeconds, 'f', 3, 64)}
+	if submission.Cached {
+		put1.Item["cached"] = &attributevalue.AttributeValue{BOOL: true}
+	}
+	put1.Item["submitted_date"] = &attributevalue.AttributeValue{S: submission.SubmittedDate.UTC().Format(submissionDateFormat)}
+	put1.Item["judged_date"] = &attributevalue.AttributeValue{S: submission.JudgedDate.UTC().Format(submissionDateFormat)}
+
+	body, code, err := put1.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("put failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("put of submission %s returned HTTP %d", submission.SubmissionId, code)
+		}
+		return err
+	}
+	return nil
+}
+
+func GetSubmission(logger *log.Logger, submission_id string) (*Submission, error) {
+	logger.Printf("db_orm_submission.GetSubmission() entry. submission_id: %s", submission_id)
+	defer logger.Printf("db_orm_submission.GetSubmission() exit.")
+
+	get1 := get.NewGetItem()
+	get1.TableName = "submission"
+	get1.Key["submission_id"] = &attributevalue.AttributeValue{S: submission_id}
+	resp, err := executeGetItem(logger, get1)
+	if err != nil {
+		logger.Printf("failed to execute get item: %s", err)
+		return nil, err
+	}
+	// executeGetItem returns neither for a non-200.
+	if resp == nil {
+		return nil, fmt.Errorf("get of submission %s failed", submission_id)
+	}
+	return ItemToSubmission(logger, submission_id, resp.Item)
+}
+
+// Returns one page of the user's submissions, newest first, to one problem
+// in one language, or to every problem if problem_id is empty, and the
+// cursor for the next page ("" if this was the last page).
+func QuerySubmissions(logger *log.Logger, user_id string, problem_id string, language string,
+	limit int, cursor string) ([]*Submission, string, error) {
+	logger.Printf("db_orm_submission.QuerySubmissions() entry. user_id: %s, problem_id: %s, language: %s",
+		user_id, problem_id, language)
+	defer logger.Printf("db_orm_submission.QuerySubmissions() exit.")
+
+	q := query.NewQuery()
+	q.TableName = "submission"
+	q.Select = ep.SELECT_ALL
+	q.Limit = uint64(limit)
+	descending := false
+	q.ScanIndexForward = &descending
+	kc := condition.NewCondition()
+	kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+	kc.ComparisonOperator = query.OP_EQ
+	hash_key := "user_id"
+	q.IndexName = submissionUserIndex
+	kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: user_id}
+	if problem_id != "" {
+		hash_key = "user_problem"
+		q.IndexName = submissionUserProblemIndex
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{
+			S: submissionUserProblem(user_id, problem_id, language)}
+	}
+	q.KeyConditions[hash_key] = kc
+	if cursor != "" {
+		exclusive_start_key, err := decodeSubmissionCursor(cursor, hash_key)
+		if err != nil {
+			return nil, "", err
+		}
+		q.ExclusiveStartKey = exclusive_start_key
+	}
+
+	body, code, err := q.EndpointReq()
+	if err != nil || code != http.StatusOK {
+		logger.Printf("query failed %d %v %s\n", code, err, body)
+		if err == nil {
+			err = fmt.Errorf("query of %s returned HTTP %d", q.IndexName, code)
+		}
+		return nil, "", err
+	}
+	var resp query.Response
+	if um_err := json.Unmarshal([]byte(body), &resp); um_err != nil {
+		logger.Printf("unmarshal Response: %v", um_err)
+		return nil, "", um_err
+	}
+	submissions, err := ItemsToSubmissions(logger, resp.Items)
+	if err != nil {
+		logger.Printf("error while converting items to submissions: %s", err)
+		return submissions, "", err
+	}
+	next_cursor := ""
+	if len(resp.LastEvaluatedKey) > 0 {
+		if next_cursor, err = encodeSubmissionCursor(resp.LastEvaluatedKey); err != nil {
+			return submissions, "", err
+		}
+	}
+	return submissions, next_cursor, nil
+}
+
+// Returns every submission without its code, for counting problem
+// statistics.
+func GetAllSubmissionVerdicts(logger *log.Logger) ([]*Submission, error) {
+	logger.Printf("db_orm_submission.GetAllSubmissionVerdicts() entry.")
+	defer logger.Printf("db_orm_submission.GetAllSubmissionVerdicts() exit.")
+
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		s := scan.NewScan()
+		s.TableName = "submission"
+		// language and output are reserved words.
+		s.ProjectionExpression = "submission_id, user_id, problem_id, #language, verdict, #output, run_seconds"
+		s.ExpressionAttributeNames["#language"] = "language"
+		s.ExpressionAttributeNames["#output"] = "output"
+		if exclusive_start_key != nil {
+			s.ExclusiveStartKey = exclusive_start_key
+		}
+		return s.EndpointReq()
+	})
+	if err != nil {
+		return make([]*Submission, 0), err
+	}
+	return ItemsToSubmissions(logger, items)
+}
+
+// Returns every submission of user_id made between from and to, oldest
+// first. A zero to means up to now.
+func GetUserSubmissionsBetween(logger *log.Logger, user_id string, from time.Time, to time.Time) ([]*Submission, error) {
+	logger.Printf("db_orm_submission.GetUserSubmissionsBetween() entry. user_id: %s", user_id)
+	defer logger.Printf("db_orm_submission.GetUserSubmissionsBetween() exit.")
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+	items, err := scanOrQueryAll(logger, func(exclusive_start_key attributevalue.AttributeValueMap) ([]byte, int, error) {
+		q := query.NewQuery()
+		q.TableName = "submission"
+		q.IndexName = submissionUserIndex
+		q.Select = ep.SELECT_ALL
+		kc := condition.NewCondition()
+		kc.AttributeValueList = make([]*attributevalue.AttributeValue, 1)
+		kc.AttributeValueList[0] = &attributevalue.AttributeValue{S: user_id}
+		kc.ComparisonOperator = query.OP_EQ
+		q.KeyConditions["user_id"] = kc
+		rc := condition.NewCondition()
+		rc.AttributeValueList = make([]*attributevalue.AttributeValue, 2)
+		rc.AttributeValueList[0] = &attributevalue.AttributeValue{S: from.UTC().Format(submissionDateFormat)}
+		rc.AttributeValueList[1] = &attributevalue.AttributeValue{S: to.UTC().Format(submissionDateFormat)}
+		rc.ComparisonOperator = query.OP_BETWEEN
+		q.KeyConditions["submitted_date"] = rc
+		if exclusive_start_key != nil {
+			q.ExclusiveStartKey = exclusive_start_key
+		}
+		return q.EndpointReq()
+	})
+	if err != nil {
+		return make([]*Submission, 0), err
+	}
+	return ItemsToSubmissions(logger, items)
+}
+
+func encodeSubmissionCursor(key attributevalue.AttributeValueMap) (string, error) {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+func decodeSubmissionCursor(cursor string, hash_key string) (attributevalue.AttributeValueMap, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, InvalidSubmissionCursor
+	}
+	key := make(attributevalue.AttributeValueMap)
+	if err := json.Unmarshal(decoded, &key); err != nil {
+		return nil, InvalidSubmissionCursor
+	}
+	for _, name := range []string{"submission_id", hash_key, "submitted_date"} {
+		if _, present := key[name]; present == false {
+			return nil, InvalidSubmissionCursor
+		}
+	}
+	return key, nil
+}
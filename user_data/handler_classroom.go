@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nu7hatch/gouuid"
+)
+
+// A classroom as one of its members sees it. Only instructors see the
+// members and invite codes.
+type classroomListing struct {
+	Classroom
+	// The viewer's role in it, or "" for admins who aren't members.
+	Role string `json:"role,omitempty"`
+}
+
+func newClassroomListing(classroom Classroom, role string) classroomListing {
+	listing := classroomListing{Classroom: classroom, Role: role}
+	if role != ClassroomInstructor {
+		listing.StudentInviteCode = ""
+		listing.InstructorInviteCode = ""
+	}
+	return listing
+}
+
+// Creates a classroom, with the user as its instructor, or changes the name,
+// organisation and description of one if classroom_id is set.
+func saveClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.saveClassroomHandler() entry.")
+	defer logger.Printf("handler_classroom.saveClassroomHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, nickname, _, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+
+	var request Classroom
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	if request.Name == "" {
+		err := InvalidClassroomError{"a classroom needs a name"}
+		logger.Printf(err.Error())
+		w.WriteHeader(400)
+		response["error"] = err.Error()
+		return
+	}
+
+	classroom := Classroom{CreatedBy: user_id}
+	if request.ClassroomId != "" {
+		existing, _, ok := requireClassroomRole(w, r, response, request.ClassroomId, ClassroomInstructor)
+		if !ok {
+			return
+		}
+		classroom = existing
+	}
+	classroom.Name = request.Name
+	classroom.Organisation = request.Organisation
+	classroom.Description = request.Description
+	saved, err := classrooms.Save(logger, classroom)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to save classroom: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	if request.ClassroomId == "" {
+		member := &ClassroomMember{
+			ClassroomId: saved.ClassroomId,
+			UserId:      user_id,
+			Nickname:    nickname,
+			Role:        ClassroomInstructor,
+			JoinedDate:  time.Now().UTC(),
+		}
+		if err := PutClassroomMember(logger, member); err != nil {
+			error_msg := fmt.Sprintf("failed to add instructor to classroom: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+	}
+	response["success"] = true
+	response["classroom"] = newClassroomListing(saved, ClassroomInstructor)
+}
+
+// The classrooms the user is in, by organisation then name.
+func listClassroomsHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.listClassroomsHandler() entry.")
+	defer logger.Printf("handler_classroom.listClassroomsHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, _, _, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	memberships, err := GetClassroomMembers(logger, "", user_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get classrooms: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	listings := make([]classroomListing, 0)
+	for _, membership := range memberships {
+		classroom, err := classrooms.Get(membership.ClassroomId)
+		if err != nil {
+			logger.Printf("user %s is in a missing classroom: %s", user_id, err)
+			continue
+		}
+		listing := newClassroomListing(classroom, membership.Role)
+		listing.Description = ""
+		listings = append(listings, listing)
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Organisation != listings[j].Organisation {
+			return listings[i].Organisation < listings[j].Organisation
+		}
+		return listings[i].Name < listings[j].Name
+	})
+	response["success"] = true
+	response["classrooms"] = listings
+}
+
+// A classroom and its assignments. Instructors also get its members.
+func getClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	classroom_id := mux.Vars(r)["classroom_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.getClassroomHandler() entry. classroom_id: %s", classroom_id)
+	defer logger.Printf("handler_classroom.getClassroomHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	classroom, role, ok := requireClassroomRole(w, r, response, classroom_id, ClassroomStudent)
+	if !ok {
+		return
+	}
+	listing := newClassroomListing(classroom, role)
+	assignments, err := GetAssignments(logger, classroom_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get assignments: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].DueDate.Before(assignments[j].DueDate)
+	})
+	if role == ClassroomInstructor || role == "" {
+		members, err := GetClassroomMembers(logger, classroom_id, "")
+		if err != nil {
+			error_msg := fmt.Sprintf("failed to get members: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Nickname < members[j].Nickname
+		})
+		listing = newClassroomListing(classroom, ClassroomInstructor)
+		listing.Role = role
+		response["members"] = members
+	}
+	response["success"] = true
+	response["classroom"] = listing
+	response["assignments"] = assignments
+}
+
+type joinClassroomRequest struct {
+	InviteCode string `json:"invite_code"`
+}
+
+// Joins the classroom an invite code is for, as a student or an instructor.
+// Joining again with an instructor code makes a student an instructor;
+// nobody is made a student by joining again.
+func joinClassroomHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.joinClassroomHandler() entry.")
+	defer logger.Printf("handler_classroom.joinClassroomHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, nickname, _, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	var request joinClassroomRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	classroom, role, err := classrooms.ByInviteCode(request.InviteCode)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	existing, err := GetClassroomMember(logger, classroom.ClassroomId, user_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to check membership: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	member := existing
+	if member == nil || (member.Role == ClassroomStudent && role == ClassroomInstructor) {
+		member = &ClassroomMember{
+			ClassroomId: classroom.ClassroomId,
+			UserId:      user_id,
+			Nickname:    nickname,
+			Role:        role,
+			JoinedDate:  time.Now().UTC(),
+		}
+		if err := PutClassroomMember(logger, member); err != nil {
+			error_msg := fmt.Sprintf("failed to join classroom: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+	}
+	response["success"] = true
+	response["classroom"] = newClassroomListing(classroom, member.Role)
+	response["member"] = member
+}
+
+// Replaces both invite codes, for when one has been shared too widely.
+// Members who joined with the old codes stay.
+func resetInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	classroom_id := mux.Vars(r)["classroom_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.resetInviteCodesHandler() entry. classroom_id: %s", classroom_id)
+	defer logger.Printf("handler_classroom.resetInviteCodesHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	classroom, _, ok := requireClassroomRole(w, r, response, classroom_id, ClassroomInstructor)
+	if !ok {
+		return
+	}
+	classroom.StudentInviteCode = ""
+	classroom.InstructorInviteCode = ""
+	saved, err := classrooms.Save(logger, classroom)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to save classroom: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["classroom"] = newClassroomListing(saved, ClassroomInstructor)
+}
+
+type removeClassroomMemberRequest struct {
+	// Empty to leave the classroom.
+	UserId string `json:"user_id"`
+}
+
+// Instructors remove members; anyone can leave.
+func removeClassroomMemberHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	classroom_id := mux.Vars(r)["classroom_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.removeClassroomMemberHandler() entry. classroom_id: %s", classroom_id)
+	defer logger.Printf("handler_classroom.removeClassroomMemberHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	var request removeClassroomMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	user_id, _, _, _ := GetSessionUser(r)
+	required_role := ClassroomInstructor
+	if request.UserId == "" || request.UserId == user_id {
+		request.UserId = user_id
+		required_role = ClassroomStudent
+	}
+	if _, _, ok := requireClassroomRole(w, r, response, classroom_id, required_role); !ok {
+		return
+	}
+	if err := DeleteClassroomMember(logger, classroom_id, request.UserId); err != nil {
+		error_msg := fmt.Sprintf("failed to remove member: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+}
+
+// Creates an assignment, or replaces one if assignment_id is set.
+func saveAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	classroom_id := mux.Vars(r)["classroom_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.saveAssignmentHandler() entry. classroom_id: %s", classroom_id)
+	defer logger.Printf("handler_classroom.saveAssignmentHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	if _, _, ok := requireClassroomRole(w, r, response, classroom_id, ClassroomInstructor); !ok {
+		return
+	}
+	user_id, _, _, _ := GetSessionUser(r)
+
+	var assignment Assignment
+	if err := json.NewDecoder(r.Body).Decode(&assignment); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	assignment.ClassroomId = classroom_id
+	assignment.CreatedBy = user_id
+	if assignment.AssignmentId != "" {
+		existing, err := GetAssignment(logger, classroom_id, assignment.AssignmentId)
+		if err != nil {
+			logger.Printf(err.Error())
+			w.WriteHeader(404)
+			response["error"] = err.Error()
+			return
+		}
+		assignment.CreatedBy = existing.CreatedBy
+	} else {
+		new_uuid, err := uuid.NewV4()
+		if err != nil {
+			error_msg := "failed to create new UUID."
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		assignment.AssignmentId = new_uuid.String()
+	}
+	if err := assignment.Validate(); err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(400)
+		response["error"] = err.Error()
+		return
+	}
+	for _, problem := range assignment.Problems {
+		if _, err := GetProblemInfo(logger, problem.ProblemId); err != nil {
+			error_msg := fmt.Sprintf("problem %s: %s", problem.ProblemId, err)
+			logger.Printf(error_msg)
+			w.WriteHeader(400)
+			response["error"] = error_msg
+			return
+		}
+	}
+	assignment.LastUpdatedDate = time.Now().UTC()
+	if err := PutAssignment(logger, &assignment); err != nil {
+		error_msg := fmt.Sprintf("failed to save assignment: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["assignment"] = assignment
+}
+
+// Every student's progress on an assignment for instructors, or a student's
+// own.
+func assignmentProgressHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	vars := mux.Vars(r)
+	classroom_id := vars["classroom_id"]
+	assignment_id := vars["assignment_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.assignmentProgressHandler() entry. classroom_id: %s, assignment_id: %s",
+		classroom_id, assignment_id)
+	defer logger.Printf("handler_classroom.assignmentProgressHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	_, role, ok := requireClassroomRole(w, r, response, classroom_id, ClassroomStudent)
+	if !ok {
+		return
+	}
+	assignment, err := GetAssignment(logger, classroom_id, assignment_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	var members []*ClassroomMember
+	if role == ClassroomStudent {
+		user_id, _, _, _ := GetSessionUser(r)
+		member, err := GetClassroomMember(logger, classroom_id, user_id)
+		if err != nil || member == nil {
+			error_msg := fmt.Sprintf("failed to get membership: %v", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		members = []*ClassroomMember{member}
+	} else {
+		all, err := GetClassroomMembers(logger, classroom_id, "")
+		if err != nil {
+			error_msg := fmt.Sprintf("failed to get members: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		for _, member := range all {
+			if member.Role == ClassroomStudent {
+				members = append(members, member)
+			}
+		}
+	}
+	progress, err := GetAssignmentProgress(logger, assignment, members)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get progress: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["assignment"] = assignment
+	response["progress"] = progress
+}
+
+// One student's progress on every assignment in the classroom, for their
+// instructors. The submission endpoints take ?user_id= to go through their
+// attempts.
+func studentProgressHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	vars := mux.Vars(r)
+	classroom_id := vars["classroom_id"]
+	user_id := vars["user_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_classroom.studentProgressHandler() entry. classroom_id: %s, user_id: %s", classroom_id, user_id)
+	defer logger.Printf("handler_classroom.studentProgressHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	if _, _, ok := requireClassroomRole(w, r, response, classroom_id, ClassroomInstructor); !ok {
+		return
+	}
+	member, err := GetClassroomMember(logger, classroom_id, user_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get membership: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	if member == nil {
+		error_msg := fmt.Sprintf("user %s is not in classroom %s.", user_id, classroom_id)
+		logger.Printf(error_msg)
+		w.WriteHeader(404)
+		response["error"] = error_msg
+		return
+	}
+	assignments, err := GetAssignments(logger, classroom_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get assignments: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].DueDate.Before(assignments[j].DueDate)
+	})
+	progress := make([]AssignmentProgress, 0, len(assignments))
+	for _, assignment := range assignments {
+		one, err := GetAssignmentProgress(logger, assignment, []*ClassroomMember{member})
+		if err != nil {
+			error_msg := fmt.Sprintf("failed to get progress: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		progress = append(progress, one...)
+	}
+	response["success"] = true
+	response["member"] = member
+	response["progress"] = progress
+}
+
+// Checks the user is logged in and has at least required_role in the
+// classroom, where an instructor can do anything a student can. Admins pass
+// with a role of "". Writes the error to the response if not.
+func requireClassroomRole(w http.ResponseWriter, r *http.Request, response map[string]interface{},
+	classroom_id string, required_role string) (Classroom, string, bool) {
+	user_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return Classroom{}, "", false
+	}
+	classroom, err := classrooms.Get(classroom_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return classroom, "", false
+	}
+	member, err := GetClassroomMember(logger, classroom_id, user_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to check membership: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return classroom, "", false
+	}
+	if HasRole(role, RoleAdmin) && (member == nil || member.Role != ClassroomInstructor) {
+		return classroom, "", true
+	}
+	if member == nil || (required_role == ClassroomInstructor && member.Role != ClassroomInstructor) {
+		error_msg := fmt.Sprintf("user %s may not do that in classroom %s.", user_id, classroom_id)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return classroom, "", false
+	}
+	return classroom, member.Role, true
+}
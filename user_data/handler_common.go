@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
@@ -14,11 +15,30 @@ import (
 	"github.com/gorilla/sessions"
 )
 
+// Shortest -session-secret-file accepted, in bytes.
+const minSessionSecretLength = 32
+
 var (
 	letters = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
-	store   = sessions.NewCookieStore([]byte("5bf9c796032240f1b846ffe751278a7108292c09c96d46468fd6ff2925bd643b"))
+	// Set by loadSessionStore. The evaluator reads the same secret to check
+	// the persona-session cookie.
+	store *sessions.CookieStore
 )
 
+// Reads the secret that signs session cookies from filepath.
+func loadSessionStore(filepath string) error {
+	contents, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	secret := strings.TrimSpace(string(contents))
+	if len(secret) < minSessionSecretLength {
+		return fmt.Errorf("session secret in %s is shorter than %d bytes", filepath, minSessionSecretLength)
+	}
+	store = sessions.NewCookieStore([]byte(secret))
+	return nil
+}
+
 func WriteJSONResponse(logger *log.Logger, response map[string]interface{}, w http.ResponseWriter) {
 	logger.Println("writeJSONResponse() entry")
 	defer logger.Println("writeJSONResponse() exit")
@@ -84,3 +104,25 @@ func GetCookieStore(r *http.Request, name string) (*sessions.Session, error) {
 	}
 	return session, err
 }
+
+// Returns the user ID, nickname and role stored in the persona-session cookie.
+// ok is false if the user is not logged in.
+func GetSessionUser(r *http.Request) (user_id string, nickname string, role string, ok bool) {
+	session, _ := GetCookieStore(r, "persona-session")
+	if user_id, ok = session.Values["user_id"].(string); !ok {
+		return
+	}
+	nickname, _ = session.Values["nickname"].(string)
+	role, _ = session.Values["role"].(string)
+	return
+}
+
+// Returns true if role is one of roles.
+func HasRole(role string, roles ...string) bool {
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
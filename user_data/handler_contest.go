@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// A contest as users see it. The problems of an upcoming contest are only
+// shown to admins.
+type contestListing struct {
+	Contest
+	Status string `json:"status"`
+	// Titles of the problems, by problem_id, where the evaluator knows them.
+	Titles map[string]string `json:"titles,omitempty"`
+}
+
+func newContestListing(contest Contest, now time.Time, admin bool) contestListing {
+	listing := contestListing{Contest: contest, Status: contest.Status(now)}
+	if listing.Status == ContestUpcoming && !admin {
+		listing.Problems = nil
+	}
+	return listing
+}
+
+func listContestsHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.listContestsHandler() entry.")
+	defer logger.Printf("handler_contest.listContestsHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+
+	_, _, role, _ := GetSessionUser(r)
+	now := time.Now()
+	listings := make([]contestListing, 0)
+	for _, contest := range contests.List() {
+		listing := newContestListing(contest, now, HasRole(role, RoleAdmin))
+		// Descriptions can be long; get the contest to see it.
+		listing.Description = ""
+		listings = append(listings, listing)
+	}
+	response["success"] = true
+	response["contests"] = listings
+}
+
+func getContestHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	contest_id := mux.Vars(r)["contest_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.getContestHandler() entry. contest_id: %s", contest_id)
+	defer logger.Printf("handler_contest.getContestHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	contest, err := contests.Get(contest_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	user_id, _, role, logged_in := GetSessionUser(r)
+	listing := newContestListing(contest, time.Now(), HasRole(role, RoleAdmin))
+	listing.Titles = make(map[string]string)
+	for _, problem := range listing.Problems {
+		info, err := GetProblemInfo(logger, problem.ProblemId)
+		if err != nil {
+			logger.Printf("failed to get problem %s for contest %s: %s", problem.ProblemId, contest_id, err)
+			continue
+		}
+		listing.Titles[problem.ProblemId] = info.Title
+	}
+	if logged_in {
+		registration, err := GetContestRegistration(logger, contest_id, user_id)
+		if err != nil {
+			error_msg := fmt.Sprintf("failed to check registration: %s", err)
+			logger.Printf(error_msg)
+			w.WriteHeader(500)
+			response["error"] = error_msg
+			return
+		}
+		response["registered"] = registration != nil
+	}
+	response["success"] = true
+	response["contest"] = listing
+}
+
+// Registration is open until the contest ends.
+func registerContestHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	contest_id := mux.Vars(r)["contest_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.registerContestHandler() entry. contest_id: %s", contest_id)
+	defer logger.Printf("handler_contest.registerContestHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, nickname, _, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	contest, err := contests.Get(contest_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	if contest.Status(time.Now()) == ContestEnded {
+		error_msg := fmt.Sprintf("contest %s has ended.", contest_id)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+	registration := &ContestRegistration{
+		ContestId:      contest_id,
+		UserId:         user_id,
+		Nickname:       nickname,
+		RegisteredDate: time.Now().UTC(),
+	}
+	if err := PutContestRegistration(logger, registration); err != nil {
+		error_msg := fmt.Sprintf("failed to register: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	contests.Invalidate(contest_id)
+	response["success"] = true
+	response["registration"] = registration
+}
+
+// Shows verdicts made during the freeze only to admins. Nobody sees the
+// scoreboard of an upcoming contest, since it would give its problems away.
+func contestScoreboardHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	contest_id := mux.Vars(r)["contest_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.contestScoreboardHandler() entry. contest_id: %s", contest_id)
+	defer logger.Printf("handler_contest.contestScoreboardHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	contest, err := contests.Get(contest_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	_, _, role, _ := GetSessionUser(r)
+	now := time.Now()
+	if contest.Status(now) == ContestUpcoming && !HasRole(role, RoleAdmin) {
+		error_msg := fmt.Sprintf("contest %s hasn't started.", contest_id)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+	scoreboard, err := contests.Scoreboard(logger, contest_id, now, HasRole(role, RoleAdmin))
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to build scoreboard: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["status"] = contest.Status(now)
+	response["scoreboard"] = scoreboard
+}
+
+// Creates a contest, or replaces one if contest_id is set.
+func saveContestHandler(w http.ResponseWriter, r *http.Request) {
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.saveContestHandler() entry. method: %s", r.Method)
+	defer logger.Printf("handler_contest.saveContestHandler() exit.")
+
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, ok := requireContestAdmin(w, r, response)
+	if !ok {
+		return
+	}
+
+	var contest Contest
+	if err := json.NewDecoder(r.Body).Decode(&contest); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	contest.CreatedBy = user_id
+	if contest.ContestId != "" {
+		existing, err := contests.Get(contest.ContestId)
+		if err != nil {
+			logger.Printf(err.Error())
+			w.WriteHeader(404)
+			response["error"] = err.Error()
+			return
+		}
+		contest.CreatedBy = existing.CreatedBy
+	}
+	if err := contest.Validate(); err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(400)
+		response["error"] = err.Error()
+		return
+	}
+	saved, err := contests.Save(logger, contest)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to save contest: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["contest"] = saved
+}
+
+// Lifts the freeze, for the end of contest announcement.
+func revealContestHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	contest_id := mux.Vars(r)["contest_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_contest.revealContestHandler() entry. contest_id: %s", contest_id)
+	defer logger.Printf("handler_contest.revealContestHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	if _, ok := requireContestAdmin(w, r, response); !ok {
+		return
+	}
+	contest, err := contests.Get(contest_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	}
+	contest.Revealed = true
+	saved, err := contests.Save(logger, contest)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to save contest: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["contest"] = saved
+}
+
+func requireContestAdmin(w http.ResponseWriter, r *http.Request, response map[string]interface{}) (string, bool) {
+	user_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return "", false
+	}
+	if !HasRole(role, RoleAdmin) {
+		error_msg := fmt.Sprintf("user %s with role '%s' may not manage contests.", user_id, role)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return "", false
+	}
+	return user_id, true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaderboardPageSize = 20
+	maxLeaderboardPageSize     = 100
+
+	// How many problems are looked up from the evaluator at once.
+	leaderboardLookupConcurrency = 8
+)
+
+// ?category= (default every category), ?window= (all, month or week,
+// default all), ?limit= and ?offset=. Logged in users also get their own
+// entry as "me", wherever it is on the board.
+func getLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	query := r.URL.Query()
+	category := query.Get("category")
+	window := query.Get("window")
+	if window == "" {
+		window = LeaderboardAllTime
+	}
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_leaderboard.getLeaderboardHandler() entry. category: %s, window: %s", category, window)
+	defer logger.Printf("handler_leaderboard.getLeaderboardHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	limit := defaultLeaderboardPageSize
+	offset := 0
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLeaderboardPageSize {
+			error_msg := fmt.Sprintf("limit must be between 1 and %d.", maxLeaderboardPageSize)
+			logger.Printf(error_msg)
+			w.WriteHeader(400)
+			response["error"] = error_msg
+			return
+		}
+		limit = parsed
+	}
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			error_msg := "offset must be a number, at least 0."
+			logger.Printf(error_msg)
+			w.WriteHeader(400)
+			response["error"] = error_msg
+			return
+		}
+		offset = parsed
+	}
+
+	board, err := leaderboards.Board(category, window, time.Now(), leaderboardProblemInfos(logger))
+	if _, ok := err.(LeaderboardNotReadyError); ok {
+		logger.Printf(err.Error())
+		w.WriteHeader(503)
+		response["error"] = err.Error()
+		return
+	} else if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(400)
+		response["error"] = err.Error()
+		return
+	}
+
+	page := make([]LeaderboardEntry, 0)
+	if offset < len(board) {
+		end := offset + limit
+		if end > len(board) {
+			end = len(board)
+		}
+		page = board[offset:end]
+	}
+	response["success"] = true
+	response["category"] = category
+	response["window"] = window
+	response["total"] = len(board)
+	response["entries"] = page
+	if offset+limit < len(board) {
+		response["next_offset"] = offset + limit
+	}
+	if user_id, _, _, ok := GetSessionUser(r); ok {
+		for _, entry := range board {
+			if entry.UserId == user_id {
+				response["me"] = entry
+				break
+			}
+		}
+	}
+}
+
+// Looks up every solved problem first, since Board can't wait on the
+// evaluator while it holds the lock. Problems the evaluator can't tell us
+// about count as uncategorised, and Board doesn't keep boards built with
+// them.
+func leaderboardProblemInfos(logger *log.Logger) func(problem_id string) (ProblemInfo, bool) {
+	var (
+		mutex  sync.Mutex
+		wait   sync.WaitGroup
+		infos  = make(map[string]ProblemInfo)
+		failed = make(map[string]bool)
+		tokens = make(chan struct{}, leaderboardLookupConcurrency)
+	)
+	for _, problem_id := range leaderboards.ProblemIds() {
+		wait.Add(1)
+		tokens <- struct{}{}
+		go func(problem_id string) {
+			defer wait.Done()
+			defer func() { <-tokens }()
+			info, err := GetProblemInfo(logger, problem_id)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				logger.Printf("failed to get problem %s for leaderboards: %s", problem_id, err)
+				failed[problem_id] = true
+			}
+			infos[problem_id] = info
+		}(problem_id)
+	}
+	wait.Wait()
+	return func(problem_id string) (ProblemInfo, bool) {
+		return infos[problem_id], failed[problem_id] == false
+	}
+}
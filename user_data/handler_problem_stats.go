@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Statistics of every problem with submissions, by problem_id, in
+// ?language= or over every language. Problems in a running contest are left
+// out until it ends.
+func listProblemStatsHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	language := r.URL.Query().Get("language")
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_problem_stats.listProblemStatsHandler() entry. language: %s", language)
+	defer logger.Printf("handler_problem_stats.listProblemStatsHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	summaries, err := problemStats.All(language)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(503)
+		response["error"] = err.Error()
+		return
+	}
+	now := time.Now()
+	for problem_id := range summaries {
+		if _, running := contests.RunningWithProblem(problem_id, now); running {
+			delete(summaries, problem_id)
+		}
+	}
+	response["success"] = true
+	response["problems"] = summaries
+}
+
+func getProblemStatsHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	problem_id := mux.Vars(r)["problem_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_problem_stats.getProblemStatsHandler() entry. problem_id: %s", problem_id)
+	defer logger.Printf("handler_problem_stats.getProblemStatsHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	if contest, running := contests.RunningWithProblem(problem_id, time.Now()); running {
+		error_msg := fmt.Sprintf("statistics of %s are hidden until contest %s ends.", problem_id, contest.ContestId)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+	overall, by_language, err := problemStats.Problem(problem_id)
+	if err != nil {
+		logger.Printf(err.Error())
+		w.WriteHeader(503)
+		response["error"] = err.Error()
+		return
+	}
+	response["success"] = true
+	response["stats"] = overall
+	response["languages"] = by_language
+}
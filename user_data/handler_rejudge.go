@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type rejudgeRequest struct {
+	ProblemId   string `json:"problem_id,omitempty"`
+	Language    string `json:"language,omitempty"`
+	UserId      string `json:"user_id,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+}
+
+func rejudgeStartHandler(w http.ResponseWriter, r *http.Request) {
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_rejudge.rejudgeStartHandler() entry. method: %s", r.Method)
+	defer logger.Printf("handler_rejudge.rejudgeStartHandler() exit.")
+
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	if !HasRole(role, RoleAdmin) {
+		error_msg := fmt.Sprintf("user %s with role '%s' may not start a rejudge.", user_id, role)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var request rejudgeRequest
+	if err := decoder.Decode(&request); err != nil {
+		error_msg := fmt.Sprintf("could not decode JSON post request: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+
+	selection := RejudgeSelection{
+		ProblemId: request.ProblemId,
+		Language:  request.Language,
+		UserId:    request.UserId,
+	}
+	job, err := StartRejudgeJob(logger, selection, request.Concurrency, user_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to start rejudge job: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(400)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["job"] = job.Snapshot()
+}
+
+func rejudgeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_rejudge.rejudgeStatusHandler() entry. method: %s", r.Method)
+	defer logger.Printf("handler_rejudge.rejudgeStatusHandler() exit.")
+
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	user_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	if !HasRole(role, RoleAdmin) {
+		error_msg := fmt.Sprintf("user %s with role '%s' may not view rejudge jobs.", user_id, role)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+
+	job_id := mux.Vars(r)["job_id"]
+	job, err := GetRejudgeJob(job_id)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to get rejudge job %s: %s", job_id, err)
+		logger.Printf(error_msg)
+		w.WriteHeader(404)
+		response["error"] = error_msg
+		return
+	}
+	response["success"] = true
+	response["job"] = job.Snapshot()
+}
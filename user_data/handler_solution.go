@@ -7,20 +7,35 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+
+	"runsomecode.com/markdown"
 )
 
+// Base URL of the evaluator's API.
+const evaluatorURL = "https://www.runsomecode.com/evaluator"
+
 type solutionSubmitRequest struct {
 	Code        string `json:"code"`
 	Description string `json:"description,omitempty"`
 	ProblemId   string `json:"problem_id"`
 	Language    string `json:"language"`
+	// Passed on to the evaluator, so the client can poll its queue
+	// position.
+	Ticket string `json:"ticket,omitempty"`
+	// Set to submit to a contest. After it ends this is practice.
+	ContestId string `json:"contest_id,omitempty"`
 }
 
 type evaluatorResponse struct {
-	Success bool   `json:"success,omitempty"`
-	Output  string `json:"output,omitempty"`
+	Success          bool    `json:"success,omitempty"`
+	Output           string  `json:"output,omitempty"`
+	ProblemVersion   int     `json:"problem_version,omitempty"`
+	QueueWaitSeconds float64 `json:"queue_wait_seconds,omitempty"`
+	RunSeconds       float64 `json:"run_seconds,omitempty"`
+	Cached           bool    `json:"cached,omitempty"`
 }
 
 type voteRequest struct {
@@ -47,6 +62,13 @@ func getSolutions(w http.ResponseWriter, r *http.Request) {
 	defer WriteJSONResponse(logger, response, w)
 	response["success"] = false
 
+	if contest, running := contests.RunningWithProblem(problem_id, time.Now()); running {
+		error_msg := fmt.Sprintf("solutions to %s are hidden until contest %s ends.", problem_id, contest.ContestId)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
 	problem_key := fmt.Sprintf("%s#%s", problem_id, language)
 	solutions, err := GetSolutions(logger, problem_key, "solution")
 	if err != nil {
@@ -160,30 +182,95 @@ func solutionSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Printf("problem_id: %s, language: %s", request.ProblemId, request.Language)
 
-	evaluator_response, err := sendSolutionToEvaluator(logger, &request)
+	// Submissions during a contest count towards it and are kept out of the
+	// solutions and leaderboards. Its problems can't be practiced until it
+	// ends.
+	in_contest := false
+	if request.ContestId != "" {
+		in_contest, err = contests.CheckSubmission(logger, request.ContestId, user_id_value,
+			request.ProblemId, request.Language, time.Now())
+	} else if contest, running := contests.RunningWithProblem(request.ProblemId, time.Now()); running {
+		err = ContestSubmissionError{contest.ContestId,
+			fmt.Sprintf("%s is in it until it ends; send its contest_id", request.ProblemId)}
+	}
+	if _, not_found := err.(ContestNotFoundError); not_found {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	} else if _, refused := err.(ContestSubmissionError); refused {
+		logger.Printf(err.Error())
+		w.WriteHeader(403)
+		response["error"] = err.Error()
+		return
+	} else if err != nil {
+		error_msg := fmt.Sprintf("failed to check contest: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+
+	submission, err := NewSubmission(logger)
+	if err != nil {
+		error_msg := fmt.Sprintf("failed to create new submission: %s", err)
+		logger.Printf(error_msg)
+		response["error"] = error_msg
+		return
+	}
+	submission.UserId = user_id_value
+	submission.Nickname = nickname_value
+	submission.ProblemId = request.ProblemId
+	submission.Language = request.Language
+	submission.Code = request.Code
+	if in_contest {
+		submission.ContestId = request.ContestId
+		response["contest_id"] = request.ContestId
+	}
+	response["submission_id"] = submission.SubmissionId
+
+	priority := "submit"
+	if in_contest {
+		priority = "contest"
+	}
+	evaluator_response, err := sendSolutionToEvaluator(logger, &request, priority, user_id_value)
+	// Failing to record the attempt shouldn't cost the user their verdict.
+	submission.Judge(evaluator_response, err)
+	if put_err := PutSubmission(logger, submission); put_err != nil {
+		logger.Printf("failed to record submission %s: %s", submission.SubmissionId, put_err)
+	}
+	problemStats.Record(submission)
+	if in_contest {
+		contests.Invalidate(request.ContestId)
+	}
 	if err != nil {
 		error_msg := fmt.Sprintf("evaluator failed to evaluate the solution: %s", err)
 		logger.Printf(error_msg)
 		response["error"] = error_msg
 		return
 	}
-	logger.Printf("evaluator returns success: %t", evaluator_response.Success)
+	logger.Printf("evaluator returns success: %t, problem_version: %d",
+		evaluator_response.Success, evaluator_response.ProblemVersion)
 	response["success"] = evaluator_response.Success
-	if evaluator_response.Success == false {
+	response["problem_version"] = evaluator_response.ProblemVersion
+	if evaluator_response.Success == false || in_contest {
 		return
 	}
 
-	if err := putOrUpdateSolution(logger, &request, user_id_value, nickname_value); err != nil {
+	if err := putOrUpdateSolution(logger, &request, user_id_value, nickname_value,
+		evaluator_response.ProblemVersion); err != nil {
 		error_msg := fmt.Sprintf("user_data failed to put or update solution: %s", err)
 		response["error"] = error_msg
 		logger.Printf(error_msg)
 		return
 	}
+	leaderboards.Record(user_id_value, nickname_value, request.ProblemId, request.Language, submission.JudgedDate)
 
 	return
 }
 
-func putOrUpdateSolution(logger *log.Logger, request *solutionSubmitRequest, user_id string, nickname string) error {
+func putOrUpdateSolution(logger *log.Logger, request *solutionSubmitRequest, user_id string, nickname string,
+	problem_version int) error {
 	logger.Printf("putOrUpdateSolution() entry.")
 	defer logger.Printf("putOrUpdateSolution() exit.")
 
@@ -194,10 +281,12 @@ func putOrUpdateSolution(logger *log.Logger, request *solutionSubmitRequest, use
 		return errors.New(error_msg)
 	}
 	solution.ProblemId = fmt.Sprintf("%s#%s", request.ProblemId, request.Language)
+	solution.ProblemVersion = problem_version
 	solution.UserId = user_id
 	solution.Nickname = nickname
 	solution.Code = request.Code
 	solution.Description = request.Description
+	solution.DescriptionHTML = markdown.Render(logger, request.Description)
 
 	existing_solution, err := GetSolutionForProblemAndUser(logger, solution.ProblemId, user_id)
 	if _, ok := err.(SolutionForProblemAndUserNotFoundError); ok {
@@ -219,15 +308,22 @@ func putOrUpdateSolution(logger *log.Logger, request *solutionSubmitRequest, use
 	return nil
 }
 
-func sendSolutionToEvaluator(logger *log.Logger, request *solutionSubmitRequest) (*evaluatorResponse, error) {
+// priority is the evaluator's scheduling class, e.g. "submit" or "rejudge",
+// and user_id whose turn the run takes.
+func sendSolutionToEvaluator(logger *log.Logger, request *solutionSubmitRequest, priority string,
+	user_id string) (*evaluatorResponse, error) {
 	var (
 		response evaluatorResponse
 	)
 
-	uri := fmt.Sprintf("https://www.runsomecode.com/evaluator/evaluate/%s/%s",
-		request.ProblemId, request.Language)
+	uri := fmt.Sprintf("%s/evaluate/%s/%s", evaluatorURL, request.ProblemId, request.Language)
 	data := make(map[string]string)
 	data["code"] = request.Code
+	data["priority"] = priority
+	data["user_id"] = user_id
+	if request.Ticket != "" {
+		data["ticket"] = request.Ticket
+	}
 	j, jerr := json.Marshal(data)
 	if jerr != nil {
 		logger.Printf("failed to encode JSON to send to evaluator: %s", jerr)
@@ -239,6 +335,9 @@ func sendSolutionToEvaluator(logger *log.Logger, request *solutionSubmitRequest)
 		return &response, jerr
 	}
 	post_request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if *evaluatorToken != "" {
+		post_request.Header.Set("X-Internal-Token", *evaluatorToken)
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(post_request)
@@ -249,7 +348,9 @@ func sendSolutionToEvaluator(logger *log.Logger, request *solutionSubmitRequest)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		error_msg := fmt.Sprintf("HTTP GET not 200: %s", resp)
+		// The evaluator explains itself in output.
+		json.NewDecoder(resp.Body).Decode(&response)
+		error_msg := fmt.Sprintf("evaluator returned HTTP %d: %s", resp.StatusCode, response.Output)
 		logger.Printf(error_msg)
 		return &response, errors.New(error_msg)
 	}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSubmissionPageSize = 20
+	maxSubmissionPageSize     = 100
+)
+
+// Users see their own submissions. Admins see everyone's, and instructors
+// their students', for going through a student's attempts.
+func canViewSubmissions(logger *log.Logger, viewer_id string, role string, owner_id string) bool {
+	if viewer_id == owner_id || HasRole(role, RoleAdmin) {
+		return true
+	}
+	instructor, err := IsInstructorOf(logger, viewer_id, owner_id)
+	if err != nil {
+		logger.Printf("failed to check whether %s is an instructor of %s: %s", viewer_id, owner_id, err)
+		return false
+	}
+	return instructor
+}
+
+// Lists the user's submissions, newest first, to one problem in one
+// language or, without them in the path, to every problem. ?user_id=
+// lists someone else's; ?limit= and ?cursor= page through them. The code
+// is left out, fetch a submission to get it.
+func listSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	vars := mux.Vars(r)
+	problem_id := vars["problem_id"]
+	language := vars["language"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_submission.listSubmissionsHandler() entry. problem_id: %s, language: %s", problem_id, language)
+	defer logger.Printf("handler_submission.listSubmissionsHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	viewer_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	user_id := r.URL.Query().Get("user_id")
+	if user_id == "" {
+		user_id = viewer_id
+	}
+	if !canViewSubmissions(logger, viewer_id, role, user_id) {
+		error_msg := fmt.Sprintf("user %s with role '%s' may not see the submissions of user %s.", viewer_id, role, user_id)
+		logger.Printf(error_msg)
+		w.WriteHeader(403)
+		response["error"] = error_msg
+		return
+	}
+	limit := defaultSubmissionPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSubmissionPageSize {
+			error_msg := fmt.Sprintf("limit must be between 1 and %d.", maxSubmissionPageSize)
+			logger.Printf(error_msg)
+			w.WriteHeader(400)
+			response["error"] = error_msg
+			return
+		}
+		limit = parsed
+	}
+
+	submissions, next_cursor, err := QuerySubmissions(logger, user_id, problem_id, language,
+		limit, r.URL.Query().Get("cursor"))
+	if err == InvalidSubmissionCursor {
+		logger.Printf(err.Error())
+		w.WriteHeader(400)
+		response["error"] = err.Error()
+		return
+	} else if err != nil {
+		error_msg := fmt.Sprintf("failed to get submissions: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	for _, submission := range submissions {
+		submission.Code = ""
+	}
+	response["success"] = true
+	response["submissions"] = submissions
+	response["next_cursor"] = next_cursor
+}
+
+func getSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	SetCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	submission_id := mux.Vars(r)["submission_id"]
+	logger = GetLogger(GetLogPill())
+	logger.Printf("handler_submission.getSubmissionHandler() entry. submission_id: %s", submission_id)
+	defer logger.Printf("handler_submission.getSubmissionHandler() exit.")
+
+	response := map[string]interface{}{}
+	defer WriteJSONResponse(logger, response, w)
+	response["success"] = false
+
+	viewer_id, _, role, ok := GetSessionUser(r)
+	if !ok {
+		error_msg := "user does not have a valid secure cookie set."
+		logger.Printf(error_msg)
+		w.WriteHeader(401)
+		response["error"] = error_msg
+		return
+	}
+	submission, err := GetSubmission(logger, submission_id)
+	if _, ok := err.(SubmissionIdNotFoundError); ok {
+		logger.Printf(err.Error())
+		w.WriteHeader(404)
+		response["error"] = err.Error()
+		return
+	} else if err != nil {
+		error_msg := fmt.Sprintf("failed to get submission: %s", err)
+		logger.Printf(error_msg)
+		w.WriteHeader(500)
+		response["error"] = error_msg
+		return
+	}
+	// As if it didn't exist, so that IDs can't be probed.
+	if !canViewSubmissions(logger, viewer_id, role, submission.UserId) {
+		logger.Printf("user %s may not see submission %s of user %s", viewer_id, submission_id, submission.UserId)
+		w.WriteHeader(404)
+		response["error"] = SubmissionIdNotFoundError{submission_id}.Error()
+		return
+	}
+	response["success"] = true
+	response["submission"] = submission
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Leaderboards rank users by the problems they have solved, from the solution
+// table. They are built in memory when user_data starts and updated as
+// solutions are accepted and rejudged, like rejudge job reports, so there is
+// nothing extra to store. A problem counts once however many languages it was
+// solved in, from the first time it was solved in any of them.
+
+// Points per solved problem by difficulty. Problems without a known
+// difficulty are worth the least.
+var leaderboardPoints = map[string]int{
+	"easy":   1,
+	"medium": 2,
+	"hard":   3,
+}
+
+const (
+	LeaderboardAllTime   = "all"
+	LeaderboardThisMonth = "month"
+	LeaderboardThisWeek  = "week"
+)
+
+type LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname,omitempty"`
+	Solved   int    `json:"solved"`
+	Score    int    `json:"score"`
+	// The first solve in the window, and the solve that reached the score,
+	// which breaks ties: whoever got there first ranks higher.
+	FirstSolveDate time.Time `json:"first_solve_date"`
+	LastSolveDate  time.Time `json:"last_solve_date"`
+}
+
+type LeaderboardNotReadyError struct{}
+
+func (e LeaderboardNotReadyError) Error() string {
+	return "leaderboards are still being built, try again in a minute"
+}
+
+type Leaderboards struct {
+	mutex sync.Mutex
+	ready bool
+	// user_id -> problem_id -> language -> when it was solved.
+	solves    map[string]map[string]map[string]time.Time
+	nicknames map[string]string
+	// Sorted boards by category, window and when the window started, until
+	// the next change.
+	boards map[string][]LeaderboardEntry
+}
+
+var leaderboards = NewLeaderboards()
+
+func NewLeaderboards() *Leaderboards {
+	return &Leaderboards{
+		solves:    make(map[string]map[string]map[string]time.Time),
+		nicknames: make(map[string]string),
+		boards:    make(map[string][]LeaderboardEntry),
+	}
+}
+
+// Reads every accepted solution. Until it's done, Board returns
+// LeaderboardNotReadyError.
+func (l *Leaderboards) Load(logger *log.Logger) error {
+	logger.Printf("Leaderboards.Load() entry.")
+	defer logger.Printf("Leaderboards.Load() exit.")
+	solutions, err := GetAllSolutions(logger, "")
+	if err != nil {
+		logger.Printf("failed to read solutions for leaderboards: %s", err)
+		return err
+	}
+	for _, solution := range solutions {
+		if !solution.CurrentSuccess() {
+			continue
+		}
+		parts := strings.SplitN(solution.ProblemId, "#", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		l.Record(solution.UserId, solution.Nickname, parts[0], parts[1], solution.CreationDate)
+	}
+	l.mutex.Lock()
+	l.ready = true
+	l.mutex.Unlock()
+	logger.Printf("leaderboards built from %d solutions", len(solutions))
+	return nil
+}
+
+// Notes that user_id solved problem_id in language at solved_date, unless
+// they already had.
+func (l *Leaderboards) Record(user_id string, nickname string, problem_id string, language string, solved_date time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if nickname != "" {
+		l.nicknames[user_id] = nickname
+	}
+	problems, present := l.solves[user_id]
+	if present == false {
+		problems = make(map[string]map[string]time.Time)
+		l.solves[user_id] = problems
+	}
+	languages, present := problems[problem_id]
+	if present == false {
+		languages = make(map[string]time.Time)
+		problems[problem_id] = languages
+	}
+	if earlier, present := languages[language]; present == true && !earlier.After(solved_date) {
+		return
+	}
+	languages[language] = solved_date
+	l.boards = make(map[string][]LeaderboardEntry)
+}
+
+// Takes back a solve, after a rejudge failed it.
+func (l *Leaderboards) Forget(user_id string, problem_id string, language string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	languages, present := l.solves[user_id][problem_id]
+	if present == false {
+		return
+	}
+	delete(languages, language)
+	if len(languages) == 0 {
+		delete(l.solves[user_id], problem_id)
+	}
+	l.boards = make(map[string][]LeaderboardEntry)
+}
+
+// The start of window containing now, in UTC; weeks start on Monday.
+func leaderboardWindowStart(window string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch window {
+	case LeaderboardAllTime:
+		return time.Time{}, nil
+	case LeaderboardThisMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case LeaderboardThisWeek:
+		return midnight.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7)), nil
+	}
+	return time.Time{}, fmt.Errorf("window must be %s, %s or %s", LeaderboardAllTime, LeaderboardThisMonth, LeaderboardThisWeek)
+}
+
+// The whole board for category ("" for every category) and window, best
+// first. infos gives each problem's category and difficulty, and whether
+// the evaluator could be asked; boards built without that aren't kept.
+func (l *Leaderboards) Board(category string, window string, now time.Time,
+	infos func(problem_id string) (ProblemInfo, bool)) ([]LeaderboardEntry, error) {
+	start, err := leaderboardWindowStart(window, now)
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.ready {
+		return nil, LeaderboardNotReadyError{}
+	}
+	key := fmt.Sprintf("%s#%s#%s", category, window, start.Format(time.RFC3339))
+	if board, present := l.boards[key]; present == true {
+		return board, nil
+	}
+
+	board := make([]LeaderboardEntry, 0)
+	complete := true
+	for user_id, problems := range l.solves {
+		entry := LeaderboardEntry{UserId: user_id, Nickname: l.nicknames[user_id]}
+		for problem_id, languages := range problems {
+			// When the problem was first solved, in any language.
+			var solved_date time.Time
+			for _, date := range languages {
+				if solved_date.IsZero() || date.Before(solved_date) {
+					solved_date = date
+				}
+			}
+			if solved_date.IsZero() || solved_date.Before(start) {
+				continue
+			}
+			info, ok := infos(problem_id)
+			if !ok {
+				complete = false
+			}
+			if category != "" && info.Category != category {
+				continue
+			}
+			points, present := leaderboardPoints[info.Difficulty]
+			if present == false {
+				points = 1
+			}
+			entry.Solved += 1
+			entry.Score += points
+			if entry.FirstSolveDate.IsZero() || solved_date.Before(entry.FirstSolveDate) {
+				entry.FirstSolveDate = solved_date
+			}
+			if solved_date.After(entry.LastSolveDate) {
+				entry.LastSolveDate = solved_date
+			}
+		}
+		if entry.Solved > 0 {
+			board = append(board, entry)
+		}
+	}
+	sort.Slice(board, func(i, j int) bool {
+		a, b := board[i], board[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Solved != b.Solved {
+			return a.Solved > b.Solved
+		}
+		if !a.LastSolveDate.Equal(b.LastSolveDate) {
+			return a.LastSolveDate.Before(b.LastSolveDate)
+		}
+		return a.UserId < b.UserId
+	})
+	for i := range board {
+		board[i].Rank = i + 1
+	}
+	if complete {
+		l.boards[key] = board
+	}
+	return board, nil
+}
+
+// The problems anyone has solved, to look up before building boards.
+func (l *Leaderboards) ProblemIds() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	seen := make(map[string]bool)
+	problem_ids := make([]string, 0)
+	for _, problems := range l.solves {
+		for problem_id := range problems {
+			if !seen[problem_id] {
+				seen[problem_id] = true
+				problem_ids = append(problem_ids, problem_id)
+			}
+		}
+	}
+	return problem_ids
+}
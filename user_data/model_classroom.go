@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/smugmug/godynamo/types/item"
+)
+
+// A classroom groups students with their instructors, who set assignments
+// and follow each student's progress. Whoever creates a classroom is its
+// first instructor. Others join with one of its invite codes, which decides
+// whether they join as a student or an instructor.
+
+const (
+	ClassroomInstructor = "instructor"
+	ClassroomStudent    = "student"
+)
+
+// What happens to problems solved after an assignment's due date.
+const (
+	// They don't count.
+	LatePolicyNone = "none"
+	// They lose LatePenaltyPercent for each day, or part of a day, late.
+	LatePolicyPenalty = "penalty"
+	// They count in full, but are marked late.
+	LatePolicyAccept = "accept"
+)
+
+type ClassroomNotFoundError struct {
+	ClassroomId string
+}
+
+func (e ClassroomNotFoundError) Error() string {
+	return fmt.Sprintf("classroom ID '%s' not found", e.ClassroomId)
+}
+
+type InviteCodeNotFoundError struct {
+	InviteCode string
+}
+
+func (e InviteCodeNotFoundError) Error() string {
+	return fmt.Sprintf("invite code '%s' not found", e.InviteCode)
+}
+
+type AssignmentNotFoundError struct {
+	AssignmentId string
+}
+
+func (e AssignmentNotFoundError) Error() string {
+	return fmt.Sprintf("assignment ID '%s' not found", e.AssignmentId)
+}
+
+type InvalidClassroomError struct {
+	Reason string
+}
+
+func (e InvalidClassroomError) Error() string {
+	return fmt.Sprintf("invalid classroom or assignment: %s", e.Reason)
+}
+
+type Classroom struct {
+	ClassroomId string `json:"classroom_id"`
+	Name        string `json:"name"`
+	// The school or team the class belongs to, for grouping classrooms.
+	Organisation string `json:"organisation,omitempty"`
+	Description  string `json:"description,omitempty"`
+	// Only shown to instructors.
+	StudentInviteCode    string    `json:"student_invite_code,omitempty"`
+	InstructorInviteCode string    `json:"instructor_invite_code,omitempty"`
+	CreatedBy            string    `json:"created_by,omitempty"`
+	CreationDate         time.Time `json:"creation_date"`
+}
+
+type ClassroomMember struct {
+	ClassroomId string    `json:"classroom_id"`
+	UserId      string    `json:"user_id"`
+	Nickname    string    `json:"nickname,omitempty"`
+	Role        string    `json:"role"`
+	JoinedDate  time.Time `json:"joined_date"`
+}
+
+type AssignmentProblem struct {
+	ProblemId string `json:"problem_id"`
+	// Empty if any language will do.
+	Language string `json:"language,omitempty"`
+}
+
+type Assignment struct {
+	ClassroomId  string              `json:"classroom_id"`
+	AssignmentId string              `json:"assignment_id"`
+	Title        string              `json:"title"`
+	Description  string              `json:"description,omitempty"`
+	Problems     []AssignmentProblem `json:"problems"`
+	// Solutions from before it opened don't count.
+	OpenDate           time.Time `json:"open_date"`
+	DueDate            time.Time `json:"due_date"`
+	LatePolicy         string    `json:"late_policy"`
+	LatePenaltyPercent int       `json:"late_penalty_percent,omitempty"`
+	// With a late policy, nothing counts after this. Zero if late solutions
+	// are accepted for ever.
+	LateCutoffDate  time.Time `json:"late_cutoff_date,omitempty"`
+	CreatedBy       string    `json:"created_by,omitempty"`
+	LastUpdatedDate time.Time `json:"last_updated_date"`
+}
+
+func (c Classroom) String() string {
+	var (
+		out []byte
+		err error
+	)
+	if out, err = json.MarshalIndent(c, "", "  "); err != nil {
+		log.Printf("could not marshal classroom to JSON: %s", err)
+		return "<could_not_marshal>"
+	}
+	return string(out)
+}
+
+// The role an invite code joins the classroom with, or "" if it's not one of
+// its codes.
+func (c *Classroom) InviteRole(invite_code string) string {
+	switch invite_code {
+	case "":
+		return ""
+	case c.StudentInviteCode:
+		return ClassroomStudent
+	case c.InstructorInviteCode:
+		return ClassroomInstructor
+	}
+	return ""
+}
+
+// Checks the assignment an instructor sent and fills in the defaults.
+func (a *Assignment) Validate() error {
+	if a.Title == "" {
+		return InvalidClassroomError{"an assignment needs a title"}
+	}
+	if len(a.Problems) == 0 {
+		return InvalidClassroomError{"an assignment needs at least one problem"}
+	}
+	seen := make(map[AssignmentProblem]bool)
+	for _, problem := range a.Problems {
+		if problem.ProblemId == "" || seen[problem] {
+			return InvalidClassroomError{"every problem needs a problem_id, and only once"}
+		}
+		seen[problem] = true
+	}
+	if a.DueDate.IsZero() || (!a.OpenDate.IsZero() && !a.DueDate.After(a.OpenDate)) {
+		return InvalidClassroomError{"due_date must be after open_date"}
+	}
+	switch a.LatePolicy {
+	case "":
+		a.LatePolicy = LatePolicyNone
+	case LatePolicyNone, LatePolicyAccept:
+	case LatePolicyPenalty:
+		if a.LatePenaltyPercent < 1 || a.LatePenaltyPercent > 100 {
+			return InvalidClassroomError{"late_penalty_percent must be between 1 and 100"}
+		}
+	default:
+		return InvalidClassroomError{fmt.Sprintf("late_policy must be %s, %s or %s",
+			LatePolicyNone, LatePolicyPenalty, LatePolicyAccept)}
+	}
+	if !a.LateCutoffDate.IsZero() && !a.LateCutoffDate.After(a.DueDate) {
+		return InvalidClassroomError{"late_cutoff_date must be after due_date"}
+	}
+	if a.LatePolicy != LatePolicyPenalty {
+		a.LatePenaltyPercent = 0
+	}
+	if a.LatePolicy == LatePolicyNone {
+		a.LateCutoffDate = time.Time{}
+	}
+	a.OpenDate = a.OpenDate.UTC()
+	a.DueDate = a.DueDate.UTC()
+	a.LateCutoffDate = a.LateCutoffDate.UTC()
+	return nil
+}
+
+// The last moment a solution can count, or zero if there is none.
+func (a *Assignment) CloseDate() time.Time {
+	if a.LatePolicy == LatePolicyNone {
+		return a.DueDate
+	}
+	return a.LateCutoffDate
+}
+
+// The percentage a solution accepted at solved_date earns.
+func (a *Assignment) Credit(solved_date time.Time) int {
+	if solved_date.Before(a.OpenDate) {
+		return 0
+	}
+	if !solved_date.After(a.DueDate) {
+		return 100
+	}
+	if close_date := a.CloseDate(); !close_date.IsZero() && solved_date.After(close_date) {
+		return 0
+	}
+	switch a.LatePolicy {
+	case LatePolicyAccept:
+		return 100
+	case LatePolicyPenalty:
+		days_late := int((solved_date.Sub(a.DueDate) + 24*time.Hour - 1) / (24 * time.Hour))
+		if credit := 100 - days_late*a.LatePenaltyPercent; credit > 0 {
+			return credit
+		}
+	}
+	return 0
+}
+
+func ItemToClassroom(logger *log.Logger, input_classroom_id string, item item.Item) (*Classroom, error) {
+	var classroom Classroom
+	classroom_id, present := item["classroom_id"]
+	if present == false {
+		return &classroom, ClassroomNotFoundError{input_classroom_id}
+	}
+	classroom.ClassroomId = classroom_id.S
+	for name, value := range map[string]*string{
+		"name":                   &classroom.Name,
+		"organisation":           &classroom.Organisation,
+		"description":            &classroom.Description,
+		"student_invite_code":    &classroom.StudentInviteCode,
+		"instructor_invite_code": &classroom.InstructorInviteCode,
+		"created_by":             &classroom.CreatedBy,
+	} {
+		if attribute, present := item[name]; present == true {
+			*value = attribute.S
+		}
+	}
+	if creation_date, present := item["creation_date"]; present == true {
+		creation_date_object, err := time.Parse(time.RFC3339, creation_date.S)
+		if err != nil {
+			logger.Printf("failed to parse creation_date: %s", err)
+			return &classroom, err
+		}
+		classroom.CreationDate = creation_date_object
+	}
+	return &classroom, nil
+}
+
+func ItemToClassroomMember(logger *log.Logger, item item.Item) (*ClassroomMember, error) {
+	var member ClassroomMember
+	for name, value := range map[string]*string{
+		"classroom_id": &member.ClassroomId,
+		"user_id":      &member.UserId,
+		"nickname":     &member.Nickname,
+		"role":         &member.Role,
+	} {
+		if attribute, present := item[name]; present == true {
+			*value = attribute.S
+		}
+	}
+	if joined_date, present := item["joined_date"]; present == true {
+		joined_date_object, err := time.Parse(time.RFC3339, joined_date.S)
+		if err != nil {
+			logger.Printf("failed to parse joined_date: %s", err)
+			return &member, err
+		}
+		member.JoinedDate = joined_date_object
+	}
+	return &member, nil
+}
+
+func ItemToAssignment(logger *log.Logger, input_assignment_id string, item item.Item) (*Assignment, error) {
+	var assignment Assignment
+	assignment_id, present := item["assignment_id"]
+	if present == false {
+		return &assignment, AssignmentNotFoundError{input_assignment_id}
+	}
+	assignment.AssignmentId = assignment_id.S
+	for name, value := range map[string]*string{
+		"classroom_id": &assignment.ClassroomId,
+		"title":        &assignment.Title,
+		"description":  &assignment.Description,
+		"late_policy":  &assignment.LatePolicy,
+		"created_by":   &assignment.CreatedBy,
+	} {
+		if attribute, present := item[name]; present == true {
+			*value = attribute.S
+		}
+	}
+	if late_penalty_percent, present := item["late_penalty_percent"]; present == true {
+		value, err := strconv.Atoi(late_penalty_percent.N)
+		if err != nil {
+			logger.Printf("failed to parse late_penalty_percent (%s) from assignment: %s", late_penalty_percent.N, err)
+			return &assignment, err
+		}
+		assignment.LatePenaltyPercent = value
+	}
+	for name, value := range map[string]*time.Time{
+		"open_date":         &assignment.OpenDate,
+		"due_date":          &assignment.DueDate,
+		"late_cutoff_date":  &assignment.LateCutoffDate,
+		"last_updated_date": &assignment.LastUpdatedDate,
+	} {
+		attribute, present := item[name]
+		if present == false {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, attribute.S)
+		if err != nil {
+			logger.Printf("failed to parse %s: %s", name, err)
+			return &assignment, err
+		}
+		*value = parsed
+	}
+	if problems, present := item["problems"]; present == true {
+		if err := json.Unmarshal([]byte(problems.S), &assignment.Problems); err != nil {
+			logger.Printf("failed to decode problems of assignment %s: %s", assignment.AssignmentId, err)
+			return &assignment, err
+		}
+	}
+	return &assignment, nil
+}
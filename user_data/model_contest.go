@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/smugmug/godynamo/types/item"
+)
+
+// A contest is a set of problems open for submissions between its start and
+// end dates to the users who registered for it, ranked on a scoreboard.
+
+const (
+	// Ranked by problems solved, then by penalty time: the minutes from the
+	// start to each accept plus PenaltyMinutes per rejected attempt before it.
+	ContestScoringICPC = "icpc"
+	// Ranked by the points of the problems solved; attempts cost nothing.
+	ContestScoringIOI = "ioi"
+)
+
+const (
+	ContestUpcoming = "upcoming"
+	ContestRunning  = "running"
+	ContestEnded    = "ended"
+)
+
+const (
+	defaultContestPenaltyMinutes = 20
+	defaultContestProblemPoints  = 100
+)
+
+type ContestNotFoundError struct {
+	ContestId string
+}
+
+func (e ContestNotFoundError) Error() string {
+	return fmt.Sprintf("contest ID '%s' not found", e.ContestId)
+}
+
+type InvalidContestError struct {
+	Reason string
+}
+
+func (e InvalidContestError) Error() string {
+	return fmt.Sprintf("invalid contest: %s", e.Reason)
+}
+
+// Why a submission can't count towards a contest.
+type ContestSubmissionError struct {
+	ContestId string
+	Reason    string
+}
+
+func (e ContestSubmissionError) Error() string {
+	return fmt.Sprintf("cannot submit to contest %s: %s", e.ContestId, e.Reason)
+}
+
+type ContestProblem struct {
+	ProblemId string `json:"problem_id"`
+	// Shown on the scoreboard, "A", "B" and so on by default.
+	Label string `json:"label"`
+	// Only used with IOI scoring.
+	Points int `json:"points,omitempty"`
+}
+
+type Contest struct {
+	ContestId      string    `json:"contest_id"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description,omitempty"`
+	StartDate      time.Time `json:"start_date"`
+	EndDate        time.Time `json:"end_date"`
+	Scoring        string    `json:"scoring"`
+	PenaltyMinutes int       `json:"penalty_minutes,omitempty"`
+	// The scoreboard stops showing verdicts this many minutes before the
+	// end, until an admin reveals it.
+	FreezeMinutes int              `json:"freeze_minutes,omitempty"`
+	Revealed      bool             `json:"revealed,omitempty"`
+	Problems      []ContestProblem `json:"problems,omitempty"`
+	// Empty allows every language.
+	Languages       []string  `json:"languages,omitempty"`
+	CreatedBy       string    `json:"created_by,omitempty"`
+	LastUpdatedDate time.Time `json:"last_updated_date"`
+}
+
+type ContestRegistration struct {
+	ContestId      string    `json:"contest_id"`
+	UserId         string    `json:"user_id"`
+	Nickname       string    `json:"nickname,omitempty"`
+	RegisteredDate time.Time `json:"registered_date"`
+}
+
+func (c Contest) String() string {
+	var (
+		out []byte
+		err error
+	)
+	if out, err = json.MarshalIndent(c, "", "  "); err != nil {
+		log.Printf("could not marshal contest to JSON: %s", err)
+		return "<could_not_marshal>"
+	}
+	return string(out)
+}
+
+func (c *Contest) Status(now time.Time) string {
+	switch {
+	case now.Before(c.StartDate):
+		return ContestUpcoming
+	case now.Before(c.EndDate):
+		return ContestRunning
+	}
+	return ContestEnded
+}
+
+func (c *Contest) FreezeDate() time.Time {
+	return c.EndDate.Add(-time.Duration(c.FreezeMinutes) * time.Minute)
+}
+
+// Whether the scoreboard hides verdicts of submissions from FreezeDate on.
+func (c *Contest) Frozen(now time.Time) bool {
+	return c.FreezeMinutes > 0 && !c.Revealed && !now.Before(c.FreezeDate())
+}
+
+func (c *Contest) Problem(problem_id string) (ContestProblem, bool) {
+	for _, problem := range c.Problems {
+		if problem.ProblemId == problem_id {
+			return problem, true
+		}
+	}
+	return ContestProblem{}, false
+}
+
+func (c *Contest) AllowsLanguage(language string) bool {
+	if len(c.Languages) == 0 {
+		return true
+	}
+	for _, allowed := range c.Languages {
+		if allowed == language {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the contest an admin sent and fills in the defaults.
+func (c *Contest) Validate() error {
+	if c.Title == "" {
+		return InvalidContestError{"title is required"}
+	}
+	if c.StartDate.IsZero() || !c.EndDate.After(c.StartDate) {
+		return InvalidContestError{"end_date must be after start_date"}
+	}
+	switch c.Scoring {
+	case "":
+		c.Scoring = ContestScoringICPC
+	case ContestScoringICPC, ContestScoringIOI:
+	default:
+		return InvalidContestError{fmt.Sprintf("scoring must be %s or %s", ContestScoringICPC, ContestScoringIOI)}
+	}
+	if c.PenaltyMinutes < 0 || c.FreezeMinutes < 0 {
+		return InvalidContestError{"penalty_minutes and freeze_minutes can't be negative"}
+	}
+	if c.Scoring == ContestScoringICPC && c.PenaltyMinutes == 0 {
+		c.PenaltyMinutes = defaultContestPenaltyMinutes
+	}
+	if time.Duration(c.FreezeMinutes)*time.Minute >= c.EndDate.Sub(c.StartDate) {
+		return InvalidContestError{"the freeze must be shorter than the contest"}
+	}
+	if len(c.Problems) == 0 {
+		return InvalidContestError{"a contest needs at least one problem"}
+	}
+	if len(c.Problems) > 26 {
+		return InvalidContestError{"a contest has at most 26 problems"}
+	}
+	problem_ids := make(map[string]bool)
+	labels := make(map[string]bool)
+	for i := range c.Problems {
+		problem := &c.Problems[i]
+		if problem.ProblemId == "" || problem_ids[problem.ProblemId] {
+			return InvalidContestError{fmt.Sprintf("problem %d needs a problem_id of its own", i+1)}
+		}
+		problem_ids[problem.ProblemId] = true
+		if problem.Label == "" {
+			problem.Label = string(rune('A' + i))
+		}
+		if labels[problem.Label] {
+			return InvalidContestError{fmt.Sprintf("label %s is used twice", problem.Label)}
+		}
+		labels[problem.Label] = true
+		if problem.Points < 0 {
+			return InvalidContestError{fmt.Sprintf("problem %s can't be worth negative points", problem.ProblemId)}
+		}
+		if c.Scoring == ContestScoringIOI && problem.Points == 0 {
+			problem.Points = defaultContestProblemPoints
+		}
+	}
+	c.StartDate = c.StartDate.UTC()
+	c.EndDate = c.EndDate.UTC()
+	return nil
+}
+
+func ItemToContest(logger *log.Logger, input_contest_id string, item item.Item) (*Contest, error) {
+	var contest Contest
+	contest_id, present := item["contest_id"]
+	if present == false {
+		return &contest, ContestNotFoundError{input_contest_id}
+	}
+	contest.ContestId = contest_id.S
+	if title, present := item["title"]; present == true {
+		contest.Title = title.S
+	}
+	if description, present := item["description"]; present == true {
+		contest.Description = description.S
+	}
+	if scoring, present := item["scoring"]; present == true {
+		contest.Scoring = scoring.S
+	}
+	if created_by, present := item["created_by"]; present == true {
+		contest.CreatedBy = created_by.S
+	}
+	if revealed, present := item["revealed"]; present == true {
+		contest.Revealed = revealed.BOOL
+	}
+	for name, value := range map[string]*int{
+		"penalty_minutes": &contest.PenaltyMinutes,
+		"freeze_minutes":  &contest.FreezeMinutes,
+	} {
+		attribute, present := item[name]
+		if present == false {
+			continue
+		}
+		parsed, err := strconv.Atoi(attribute.N)
+		if err != nil {
+			logger.Printf("failed to parse %s (%s) from contest: %s", name, attribute.N, err)
+			return &contest, err
+		}
+		*value = parsed
+	}
+	for name, value := range map[string]*time.Time{
+		"start_date":        &contest.StartDate,
+		"end_date":          &contest.EndDate,
+		"last_updated_date": &contest.LastUpdatedDate,
+	} {
+		attribute, present := item[name]
+		if present == false {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, attribute.S)
+		if err != nil {
+			logger.Printf("failed to parse %s: %s", name, err)
+			return &contest, err
+		}
+		*value = parsed
+	}
+	if problems, present := item["problems"]; present == true {
+		if err := json.Unmarshal([]byte(problems.S), &contest.Problems); err != nil {
+			logger.Printf("failed to decode problems of contest %s: %s", contest.ContestId, err)
+			return &contest, err
+		}
+	}
+	if languages, present := item["languages"]; present == true {
+		contest.Languages = languages.SS
+	}
+	return &contest, nil
+}
+
+func ItemToContestRegistration(logger *log.Logger, item item.Item) (*ContestRegistration, error) {
+	var registration ContestRegistration
+	if contest_id, present := item["contest_id"]; present == true {
+		registration.ContestId = contest_id.S
+	}
+	if user_id, present := item["user_id"]; present == true {
+		registration.UserId = user_id.S
+	}
+	if nickname, present := item["nickname"]; present == true {
+		registration.Nickname = nickname.S
+	}
+	if registered_date, present := item["registered_date"]; present == true {
+		registered_date_object, err := time.Parse(time.RFC3339, registered_date.S)
+		if err != nil {
+			logger.Printf("failed to parse registered_date: %s", err)
+			return &registration, err
+		}
+		registration.RegisteredDate = registered_date_object
+	}
+	return &registration, nil
+}
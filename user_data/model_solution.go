@@ -9,6 +9,8 @@ import (
 
 	"github.com/nu7hatch/gouuid"
 	"github.com/smugmug/godynamo/types/item"
+
+	"runsomecode.com/markdown"
 )
 
 type SolutionForProblemAndUserNotFoundError struct {
@@ -31,6 +33,7 @@ func (e SolutionIdNotFoundError) Error() string {
 type Solution struct {
 	SolutionId      string    `json:"solution_id"`
 	ProblemId       string    `json:"problem_id,omitempty"`
+	ProblemVersion  int       `json:"problem_version,omitempty"`
 	UserId          string    `json:"user_id,omitempty"`
 	Nickname        string    `json:"nickname,omitempty"`
 	Up              int64     `json:"up"`
@@ -38,8 +41,26 @@ type Solution struct {
 	EffectiveVote   int64     `json:"effective_vote"`
 	Code            string    `json:"code,omitempty"`
 	Description     string    `json:"description,omitempty"`
+	DescriptionHTML string    `json:"description_html,omitempty"`
 	CreationDate    time.Time `json:"creation_date,omitempty"`
 	LastUpdatedDate time.Time `json:"last_updated_date,omitempty"`
+
+	// Set by the most recent rejudge of this solution, if any. The original
+	// verdict is implicit: only accepted solutions are stored, judged against
+	// ProblemVersion.
+	RejudgeJobId          string     `json:"rejudge_job_id,omitempty"`
+	RejudgeSuccess        *bool      `json:"rejudge_success,omitempty"`
+	RejudgeProblemVersion int        `json:"rejudge_problem_version,omitempty"`
+	RejudgeDate           *time.Time `json:"rejudge_date,omitempty"`
+}
+
+// Returns the latest known verdict for the solution: the rejudge verdict if it
+// has been rejudged, else accepted.
+func (s *Solution) CurrentSuccess() bool {
+	if s.RejudgeSuccess != nil {
+		return *s.RejudgeSuccess
+	}
+	return true
 }
 
 func (s Solution) String() string {
@@ -79,6 +100,14 @@ func ItemToSolution(logger *log.Logger, input_problem_id string, input_user_id s
 	if problem_id, present := item["problem_id"]; present == true {
 		solution.ProblemId = problem_id.S
 	}
+	if problem_version, present := item["problem_version"]; present == true {
+		value, err := strconv.Atoi(problem_version.N)
+		if err != nil {
+			logger.Printf("failed to parse problem_version (%s) from solution: %s", problem_version.N, err)
+			return &solution, err
+		}
+		solution.ProblemVersion = value
+	}
 	if user_id, present := item["user_id"]; present == true {
 		solution.UserId = user_id.S
 	}
@@ -118,6 +147,17 @@ func ItemToSolution(logger *log.Logger, input_problem_id string, input_user_id s
 		}
 		solution.Description = description_decoded
 	}
+	if description_html_encoded, present := item["description_html"]; present == true {
+		description_html_decoded, err := DecompressFromBase64(logger, description_html_encoded.B)
+		if err != nil {
+			logger.Printf("failed to decompress/decode description_html: %s", err)
+			return &solution, err
+		}
+		solution.DescriptionHTML = description_html_decoded
+	} else if solution.Description != "" {
+		// Solutions stored before descriptions were rendered on submit.
+		solution.DescriptionHTML = markdown.Render(logger, solution.Description)
+	}
 	if creation_date, present := item["creation_date"]; present == true {
 		creation_date_object, err := time.Parse(time.RFC3339, creation_date.S)
 		if err != nil {
@@ -134,6 +174,29 @@ func ItemToSolution(logger *log.Logger, input_problem_id string, input_user_id s
 		}
 		solution.LastUpdatedDate = last_updated_date_object
 	}
+	if rejudge_job_id, present := item["rejudge_job_id"]; present == true {
+		solution.RejudgeJobId = rejudge_job_id.S
+	}
+	if rejudge_success, present := item["rejudge_success"]; present == true {
+		value := rejudge_success.BOOL
+		solution.RejudgeSuccess = &value
+	}
+	if rejudge_problem_version, present := item["rejudge_problem_version"]; present == true {
+		value, err := strconv.Atoi(rejudge_problem_version.N)
+		if err != nil {
+			logger.Printf("failed to parse rejudge_problem_version (%s) from solution: %s", rejudge_problem_version.N, err)
+			return &solution, err
+		}
+		solution.RejudgeProblemVersion = value
+	}
+	if rejudge_date, present := item["rejudge_date"]; present == true {
+		rejudge_date_object, err := time.Parse(time.RFC3339, rejudge_date.S)
+		if err != nil {
+			logger.Printf("failed to parse rejudge_date: %s", err)
+			return &solution, err
+		}
+		solution.RejudgeDate = &rejudge_date_object
+	}
 	return &solution, nil
 }
 
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+	"github.com/smugmug/godynamo/types/item"
+)
+
+// Every submit is recorded as a submission, whatever the verdict, whereas
+// the solution table only keeps each user's latest accepted solution.
+
+const (
+	VerdictAccepted = "accepted"
+	// The code ran but failed the unit test.
+	VerdictRejected = "rejected"
+	// The evaluator couldn't judge the code, e.g. no runner was free.
+	VerdictError = "error"
+)
+
+// Outputs are cut to this many bytes; the tail of a long traceback is rarely
+// needed to see what went wrong.
+const maxSubmissionOutputBytes = 4 * 1024
+
+// submitted_date is a range key, so it must sort as a string: RFC3339 with a
+// fixed number of fractional digits, in UTC.
+const submissionDateFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+type SubmissionIdNotFoundError struct {
+	SubmissionId string
+}
+
+func (e SubmissionIdNotFoundError) Error() string {
+	return fmt.Sprintf("submission ID '%s' not found", e.SubmissionId)
+}
+
+type Submission struct {
+	SubmissionId   string `json:"submission_id"`
+	UserId         string `json:"user_id"`
+	Nickname       string `json:"nickname,omitempty"`
+	ProblemId      string `json:"problem_id"`
+	Language       string `json:"language"`
+	ProblemVersion int    `json:"problem_version,omitempty"`
+	// Set if it was made during a contest and counts towards it.
+	ContestId string `json:"contest_id,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Verdict   string `json:"verdict"`
+	Output    string `json:"output,omitempty"`
+	// Of a rejected submission, if the output says; IOI contests give
+	// points for them.
+	Tests       int `json:"tests,omitempty"`
+	TestsPassed int `json:"tests_passed,omitempty"`
+	// Time spent waiting for a runner slot, then running.
+	QueueWaitSeconds float64   `json:"queue_wait_seconds"`
+	RunSeconds       float64   `json:"run_seconds"`
+	SubmittedDate    time.Time `json:"submitted_date"`
+	JudgedDate       time.Time `json:"judged_date"`
+	// The evaluator gave the verdict of identical code judged before, so
+	// nothing ran.
+	Cached bool `json:"cached,omitempty"`
+}
+
+func (s Submission) String() string {
+	var (
+		out []byte
+		err error
+	)
+	if out, err = json.MarshalIndent(s, "", "  "); err != nil {
+		log.Printf("could not marshal submission to JSON: %s", err)
+		return "<could_not_marshal>"
+	}
+	return string(out)
+}
+
+func NewSubmission(logger *log.Logger) (*Submission, error) {
+	var submission Submission
+	new_uuid, err := uuid.NewV4()
+	if err != nil {
+		logger.Printf("failed to create new UUID.")
+		return &submission, err
+	}
+	submission = Submission{
+		SubmissionId:  new_uuid.String(),
+		SubmittedDate: time.Now().UTC(),
+	}
+	return &submission, nil
+}
+
+// Sets the verdict and output from the evaluator's response, or from the
+// error if it didn't give one.
+func (s *Submission) Judge(evaluator_response *evaluatorResponse, err error) {
+	s.JudgedDate = time.Now().UTC()
+	s.QueueWaitSeconds = evaluator_response.QueueWaitSeconds
+	s.RunSeconds = evaluator_response.RunSeconds
+	s.Cached = evaluator_response.Cached
+	s.ProblemVersion = evaluator_response.ProblemVersion
+	switch {
+	case err != nil:
+		s.Verdict = VerdictError
+		s.Output = err.Error()
+	case evaluator_response.Success:
+		s.Verdict = VerdictAccepted
+		s.Output = evaluator_response.Output
+	default:
+		s.Verdict = VerdictRejected
+		s.Output = evaluator_response.Output
+		s.Tests, s.TestsPassed = countTests(s.Output)
+	}
+	if len(s.Output) > maxSubmissionOutputBytes {
+		s.Output = s.Output[:maxSubmissionOutputBytes] + "\n<output truncated>"
+	}
+}
+
+var (
+	// The evaluator's report for TestData problems.
+	stdioTestCountPattern = regexp.MustCompile(`passed (\d+) of (\d+) test cases\.`)
+	// Python's unittest, which lists each failure or error.
+	unittestCountPattern   = regexp.MustCompile(`(?m)^Ran (\d+) tests?`)
+	unittestFailurePattern = regexp.MustCompile(`(?m)^(?:FAIL|ERROR): `)
+	// JUnit.
+	junitCountPattern = regexp.MustCompile(`Tests run: (\d+),\s+Failures: (\d+)`)
+)
+
+// How many tests a rejected submission's output ran and passed, or 0, 0 if
+// it doesn't say. Read before the output is cut, since the counts come last.
+func countTests(output string) (int, int) {
+	if match := stdioTestCountPattern.FindStringSubmatch(output); match != nil {
+		passed, _ := strconv.Atoi(match[1])
+		tests, _ := strconv.Atoi(match[2])
+		return tests, passed
+	}
+	if match := unittestCountPattern.FindStringSubmatch(output); match != nil {
+		tests, _ := strconv.Atoi(match[1])
+		failed := len(unittestFailurePattern.FindAllString(output, -1))
+		if failed > tests {
+			failed = tests
+		}
+		return tests, tests - failed
+	}
+	if match := junitCountPattern.FindStringSubmatch(output); match != nil {
+		tests, _ := strconv.Atoi(match[1])
+		failed, _ := strconv.Atoi(match[2])
+		if failed > tests {
+			failed = tests
+		}
+		return tests, tests - failed
+	}
+	return 0, 0
+}
+
+// The key of the GSI listing a user's submissions to one problem.
+func submissionUserProblem(user_id string, problem_id string, language string) string {
+	return fmt.Sprintf("%s#%s#%s", user_id, problem_id, language)
+}
+
+func ItemToSubmission(logger *log.Logger, input_submission_id string, item item.Item) (*Submission, error) {
+	var submission Submission
+	submission_id, present := item["submission_id"]
+	if present == false {
+		return &submission, SubmissionIdNotFoundError{input_submission_id}
+	}
+	submission.SubmissionId = submission_id.S
+	if user_id, present := item["user_id"]; present == true {
+		submission.UserId = user_id.S
+	}
+	if nickname, present := item["nickname"]; present == true {
+		submission.Nickname = nickname.S
+	}
+	if problem_id, present := item["problem_id"]; present == true {
+		submission.ProblemId = problem_id.S
+	}
+	if language, present := item["language"]; present == true {
+		submission.Language = language.S
+	}
+	if contest_id, present := item["contest_id"]; present == true {
+		submission.ContestId = contest_id.S
+	}
+	if problem_version, present := item["problem_version"]; present == true {
+		value, err := strconv.Atoi(problem_version.N)
+		if err != nil {
+			logger.Printf("failed to parse problem_version (%s) from submission: %s", problem_version.N, err)
+			return &submission, err
+		}
+		submission.ProblemVersion = value
+	}
+	if code_encoded, present := item["code"]; present == true {
+		code_decoded, err := DecompressFromBase64(logger, code_encoded.B)
+		if err != nil {
+			logger.Printf("failed to decompress/decode code: %s", err)
+			return &submission, err
+		}
+		submission.Code = code_decoded
+	}
+	if verdict, present := item["verdict"]; present == true {
+		submission.Verdict = verdict.S
+	}
+	if output_encoded, present := item["output"]; present == true {
+		output_decoded, err := DecompressFromBase64(logger, output_encoded.B)
+		if err != nil {
+			logger.Printf("failed to decompress/decode output: %s", err)
+			return &submission, err
+		}
+		submission.Output = output_decoded
+	}
+	if tests, present := item["tests"]; present == true {
+		value, err := strconv.Atoi(tests.N)
+		if err != nil {
+			logger.Printf("failed to parse tests (%s) from submission: %s", tests.N, err)
+			return &submission, err
+		}
+		submission.Tests = value
+	}
+	if tests_passed, present := item["tests_passed"]; present == true {
+		value, err := strconv.Atoi(tests_passed.N)
+		if err != nil {
+			logger.Printf("failed to parse tests_passed (%s) from submission: %s", tests_passed.N, err)
+			return &submission, err
+		}
+		submission.TestsPassed = value
+	}
+	if queue_wait_seconds, present := item["queue_wait_seconds"]; present == true {
+		value, err := strconv.ParseFloat(queue_wait_seconds.N, 64)
+		if err != nil {
+			logger.Printf("failed to parse queue_wait_seconds (%s) from submission: %s", queue_wait_seconds.N, err)
+			return &submission, err
+		}
+		submission.QueueWaitSeconds = value
+	}
+	if run_seconds, present := item["run_seconds"]; present == true {
+		value, err := strconv.ParseFloat(run_seconds.N, 64)
+		if err != nil {
+			logger.Printf("failed to parse run_seconds (%s) from submission: %s", run_seconds.N, err)
+			return &submission, err
+		}
+		submission.RunSeconds = value
+	}
+	if cached, present := item["cached"]; present == true {
+		submission.Cached = cached.BOOL
+	}
+	if submitted_date, present := item["submitted_date"]; present == true {
+		submitted_date_object, err := time.Parse(time.RFC3339, submitted_date.S)
+		if err != nil {
+			logger.Printf("failed to parse submitted_date: %s", err)
+			return &submission, err
+		}
+		submission.SubmittedDate = submitted_date_object
+	}
+	if judged_date, present := item["judged_date"]; present == true {
+		judged_date_object, err := time.Parse(time.RFC3339, judged_date.S)
+		if err != nil {
+			logger.Printf("failed to parse judged_date: %s", err)
+			return &submission, err
+		}
+		submission.JudgedDate = judged_date_object
+	}
+	return &submission, nil
+}
+
+func ItemsToSubmissions(logger *log.Logger, items []item.Item) ([]*Submission, error) {
+	submissions := make([]*Submission, 0)
+	for _, item := range items {
+		submission, err := ItemToSubmission(logger, "", item)
+		if err != nil {
+			logger.Printf("error while parsing item: %s", err)
+			return submissions, err
+		}
+		submissions = append(submissions, submission)
+	}
+	return submissions, nil
+}
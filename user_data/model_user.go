@@ -26,6 +26,14 @@ func (e UserIdNotFoundError) Error() string {
 	return fmt.Sprintf("user id '%s' not found", e.UserId)
 }
 
+const (
+	RoleRegular   = "regular"
+	RoleModerator = "moderator"
+	RoleAuthor    = "author"
+	RoleReviewer  = "reviewer"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	UserId          string    `json:"user_id"`
 	Email           string    `json:"email,omitempty"`
@@ -58,7 +66,7 @@ func NewUser(logger *log.Logger) (User, error) {
 	}
 	user = User{
 		UserId:          new_uuid.String(),
-		Role:            "regular",
+		Role:            RoleRegular,
 		CreationDate:    time.Now(),
 		LastUpdatedDate: time.Now(),
 	}
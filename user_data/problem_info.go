@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// What user_data needs to know about a problem, from the evaluator's
+// problem summary.
+type ProblemInfo struct {
+	Id         string `json:"id"`
+	Title      string `json:"title,omitempty"`
+	Category   string `json:"category,omitempty"`
+	Difficulty string `json:"difficulty,omitempty"`
+}
+
+// Problems are re-read after this long, in case e.g. their category changed.
+// After a failed read the evaluator isn't asked again for a minute.
+const (
+	problemInfoTTL          = time.Hour
+	problemInfoRetryBackoff = time.Minute
+)
+
+var (
+	problemInfoMutex  sync.Mutex
+	problemInfoCache  = make(map[string]ProblemInfo)
+	problemInfoStored = make(map[string]time.Time)
+	problemInfoFailed = make(map[string]time.Time)
+	problemInfoClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func GetProblemInfo(logger *log.Logger, problem_id string) (ProblemInfo, error) {
+	problemInfoMutex.Lock()
+	info, present := problemInfoCache[problem_id]
+	stored := problemInfoStored[problem_id]
+	failed, has_failed := problemInfoFailed[problem_id]
+	problemInfoMutex.Unlock()
+	if present == true && time.Since(stored) < problemInfoTTL {
+		return info, nil
+	}
+	if has_failed && time.Since(failed) < problemInfoRetryBackoff {
+		if present == true {
+			return info, nil
+		}
+		return info, fmt.Errorf("evaluator failed recently for problem %s", problem_id)
+	}
+
+	logger.Printf("GetProblemInfo() reading problem_id %s from the evaluator.", problem_id)
+	resp, err := problemInfoClient.Get(fmt.Sprintf("%s/get_problem_summary/%s", evaluatorURL, problem_id))
+	if err != nil {
+		return staleProblemInfo(problem_id, info, present, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return staleProblemInfo(problem_id, info, present, fmt.Errorf("evaluator returned HTTP %d for problem %s", resp.StatusCode, problem_id))
+	}
+	var fresh ProblemInfo
+	if err := json.NewDecoder(resp.Body).Decode(&fresh); err != nil {
+		return staleProblemInfo(problem_id, info, present, err)
+	}
+	problemInfoMutex.Lock()
+	problemInfoCache[problem_id] = fresh
+	problemInfoStored[problem_id] = time.Now()
+	delete(problemInfoFailed, problem_id)
+	problemInfoMutex.Unlock()
+	return fresh, nil
+}
+
+// An old copy is better than none if the evaluator can't be reached.
+func staleProblemInfo(problem_id string, info ProblemInfo, present bool, err error) (ProblemInfo, error) {
+	problemInfoMutex.Lock()
+	problemInfoFailed[problem_id] = time.Now()
+	problemInfoMutex.Unlock()
+	if present == true {
+		return info, nil
+	}
+	return info, err
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Problem statistics are counted from the submission table, in memory, like
+// leaderboards: read when user_data starts and updated on every submit.
+// Authors use them to spot problems that are broken or too hard.
+
+// Acceptance rates are sent to the evaluator for the catalogue this often,
+// for the problems whose rate changed.
+const acceptanceRatePushInterval = 10 * time.Minute
+
+// Where runner output says which test failed, first match wins. The stdio
+// harnesses run every case in one test and name the case in the assertion,
+// so they are looked for first.
+var failedTestPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`test case (\S+)`),
+	// Python's unittest.
+	regexp.MustCompile(`(?m)^(?:FAIL|ERROR): (\w+)`),
+	// JUnit.
+	regexp.MustCompile(`(?m)^\d+\) (\w+)\(`),
+	// nodeunit.
+	regexp.MustCompile(`(?m)^✖ (\w+)`),
+}
+
+// The name of the first failed test in a rejected submission's output, or ""
+// if the output doesn't say.
+func failedTest(output string) string {
+	for _, pattern := range failedTestPatterns {
+		if match := pattern.FindStringSubmatch(output); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+type languageStats struct {
+	verdicts map[string]int
+	solvers  map[string]bool
+	// Of accepted submissions.
+	run_seconds  []float64
+	failed_tests map[string]int
+}
+
+func newLanguageStats() *languageStats {
+	return &languageStats{
+		verdicts:     make(map[string]int),
+		solvers:      make(map[string]bool),
+		run_seconds:  make([]float64, 0),
+		failed_tests: make(map[string]int),
+	}
+}
+
+type ProblemStatsSummary struct {
+	ProblemId string `json:"problem_id"`
+	// Empty when summing up every language.
+	Language    string `json:"language,omitempty"`
+	Submissions int    `json:"submissions"`
+	// Distinct users with an accepted submission.
+	Solvers int `json:"solvers"`
+	// Accepted over accepted and rejected submissions. Submissions the
+	// evaluator couldn't judge don't count.
+	AcceptanceRate   float64        `json:"acceptance_rate"`
+	Verdicts         map[string]int `json:"verdicts"`
+	MedianRunSeconds float64        `json:"median_run_seconds,omitempty"`
+	// The test rejected submissions failed most often, and how often.
+	MostFailedTest      string `json:"most_failed_test,omitempty"`
+	MostFailedTestCount int    `json:"most_failed_test_count,omitempty"`
+}
+
+type ProblemStatsNotReadyError struct{}
+
+func (e ProblemStatsNotReadyError) Error() string {
+	return "problem statistics are still being counted, try again in a minute"
+}
+
+type ProblemStats struct {
+	mutex sync.Mutex
+	ready bool
+	// problem_id -> language -> counts.
+	stats map[string]map[string]*languageStats
+	// The acceptance rates last sent to the evaluator.
+	pushed map[string]float64
+}
+
+var problemStats = NewProblemStats()
+
+func NewProblemStats() *ProblemStats {
+	return &ProblemStats{
+		stats:  make(map[string]map[string]*languageStats),
+		pushed: make(map[string]float64),
+	}
+}
+
+// Counts every submission. Until it's done, the summaries return
+// ProblemStatsNotReadyError.
+func (p *ProblemStats) Load(logger *log.Logger) error {
+	logger.Printf("ProblemStats.Load() entry.")
+	defer logger.Printf("ProblemStats.Load() exit.")
+	submissions, err := GetAllSubmissionVerdicts(logger)
+	if err != nil {
+		logger.Printf("failed to read submissions for problem statistics: %s", err)
+		return err
+	}
+	for _, submission := range submissions {
+		p.Record(submission)
+	}
+	p.mutex.Lock()
+	p.ready = true
+	p.mutex.Unlock()
+	logger.Printf("problem statistics counted from %d submissions", len(submissions))
+	return nil
+}
+
+func (p *ProblemStats) Record(submission *Submission) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	languages, present := p.stats[submission.ProblemId]
+	if present == false {
+		languages = make(map[string]*languageStats)
+		p.stats[submission.ProblemId] = languages
+	}
+	stats, present := languages[submission.Language]
+	if present == false {
+		stats = newLanguageStats()
+		languages[submission.Language] = stats
+	}
+	stats.verdicts[submission.Verdict] += 1
+	switch submission.Verdict {
+	case VerdictAccepted:
+		stats.solvers[submission.UserId] = true
+		// A cached verdict has no run time of its own.
+		if !submission.Cached {
+			stats.run_seconds = append(stats.run_seconds, submission.RunSeconds)
+		}
+	case VerdictRejected:
+		if test := failedTest(submission.Output); test != "" {
+			stats.failed_tests[test] += 1
+		}
+	}
+}
+
+// Sums up parts, which are all for problem_id.
+func summariseStats(problem_id string, language string, parts ...*languageStats) ProblemStatsSummary {
+	summary := ProblemStatsSummary{
+		ProblemId: problem_id,
+		Language:  language,
+		Verdicts:  make(map[string]int),
+	}
+	solvers := make(map[string]bool)
+	failed_tests := make(map[string]int)
+	run_seconds := make([]float64, 0)
+	for _, part := range parts {
+		for verdict, count := range part.verdicts {
+			summary.Verdicts[verdict] += count
+			summary.Submissions += count
+		}
+		for user_id := range part.solvers {
+			solvers[user_id] = true
+		}
+		for test, count := range part.failed_tests {
+			failed_tests[test] += count
+		}
+		run_seconds = append(run_seconds, part.run_seconds...)
+	}
+	summary.Solvers = len(solvers)
+	judged := summary.Verdicts[VerdictAccepted] + summary.Verdicts[VerdictRejected]
+	if judged > 0 {
+		summary.AcceptanceRate = float64(summary.Verdicts[VerdictAccepted]) / float64(judged)
+	}
+	if len(run_seconds) > 0 {
+		sort.Float64s(run_seconds)
+		middle := len(run_seconds) / 2
+		summary.MedianRunSeconds = run_seconds[middle]
+		if len(run_seconds)%2 == 0 {
+			summary.MedianRunSeconds = (run_seconds[middle-1] + run_seconds[middle]) / 2
+		}
+	}
+	for test, count := range failed_tests {
+		if count > summary.MostFailedTestCount ||
+			(count == summary.MostFailedTestCount && test < summary.MostFailedTest) {
+			summary.MostFailedTest = test
+			summary.MostFailedTestCount = count
+		}
+	}
+	return summary
+}
+
+// problem_id's statistics over every language, then in each language.
+func (p *ProblemStats) Problem(problem_id string) (ProblemStatsSummary, []ProblemStatsSummary, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.ready {
+		return ProblemStatsSummary{}, nil, ProblemStatsNotReadyError{}
+	}
+	parts := make([]*languageStats, 0)
+	by_language := make([]ProblemStatsSummary, 0)
+	for language, stats := range p.stats[problem_id] {
+		parts = append(parts, stats)
+		by_language = append(by_language, summariseStats(problem_id, language, stats))
+	}
+	sort.Slice(by_language, func(i, j int) bool {
+		return by_language[i].Language < by_language[j].Language
+	})
+	return summariseStats(problem_id, "", parts...), by_language, nil
+}
+
+// Every problem's statistics in language, or over every language if it's
+// empty, by problem_id. Problems without submissions are left out.
+func (p *ProblemStats) All(language string) (map[string]ProblemStatsSummary, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.ready {
+		return nil, ProblemStatsNotReadyError{}
+	}
+	summaries := make(map[string]ProblemStatsSummary)
+	for problem_id, languages := range p.stats {
+		parts := make([]*languageStats, 0)
+		for stats_language, stats := range languages {
+			if language == "" || stats_language == language {
+				parts = append(parts, stats)
+			}
+		}
+		if len(parts) > 0 {
+			summaries[problem_id] = summariseStats(problem_id, language, parts...)
+		}
+	}
+	return summaries, nil
+}
+
+// Sends the evaluator every acceptance rate that changed since it was last
+// sent, except for problems in a running contest. Needs -evaluator-token.
+func (p *ProblemStats) PushAcceptanceRates(logger *log.Logger) {
+	logger.Printf("ProblemStats.PushAcceptanceRates() entry.")
+	defer logger.Printf("ProblemStats.PushAcceptanceRates() exit.")
+	all, err := p.All("")
+	if err != nil {
+		logger.Printf("not pushing acceptance rates: %s", err)
+		return
+	}
+	now := time.Now()
+	for problem_id, summary := range all {
+		p.mutex.Lock()
+		pushed, present := p.pushed[problem_id]
+		p.mutex.Unlock()
+		if present == true && pushed == summary.AcceptanceRate {
+			continue
+		}
+		if _, running := contests.RunningWithProblem(problem_id, now); running {
+			continue
+		}
+		if err := sendAcceptanceRate(logger, problem_id, summary.AcceptanceRate); err != nil {
+			logger.Printf("failed to send acceptance rate of %s: %s", problem_id, err)
+			continue
+		}
+		p.mutex.Lock()
+		p.pushed[problem_id] = summary.AcceptanceRate
+		p.mutex.Unlock()
+	}
+}
+
+func sendAcceptanceRate(logger *log.Logger, problem_id string, acceptance_rate float64) error {
+	body, err := json.Marshal(map[string]float64{"acceptance_rate": acceptance_rate})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/admin/problems/%s/acceptance_rate", evaluatorURL, problem_id)
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Internal-Token", *evaluatorToken)
+	resp, err := problemInfoClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("evaluator returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Counts submissions, then keeps the evaluator's acceptance rates up to
+// date.
+func runProblemStats(logger *log.Logger) {
+	loadWithRetry(logger, "problem statistics", problemStats.Load)
+	if *evaluatorToken == "" {
+		logger.Printf("no -evaluator-token, so acceptance rates won't be sent to the evaluator")
+		return
+	}
+	for {
+		problemStats.PushAcceptanceRates(logger)
+		time.Sleep(acceptanceRatePushInterval)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+const (
+	RejudgeStateRunning  = "running"
+	RejudgeStateFinished = "finished"
+	// The job stopped before rejudging anything, see Error.
+	RejudgeStateFailed = "failed"
+
+	defaultRejudgeConcurrency = 2
+	maxRejudgeConcurrency     = 8
+	rejudgeOutputLimit        = 2 * 1024
+
+	// How long the report of a finished job is kept.
+	rejudgeJobRetention = 24 * time.Hour
+)
+
+var (
+	RejudgeJobNotFound = errors.New("Rejudge job not found.")
+	rejudgeJobs        = make(map[string]*RejudgeJob)
+	rejudgeJobsMutex   = &sync.RWMutex{}
+)
+
+// Which solutions a rejudge job covers. ProblemId and Language may be given
+// together to rejudge one problem in one language; any of the three may be
+// left empty to widen the selection. At least one must be set.
+type RejudgeSelection struct {
+	ProblemId string `json:"problem_id,omitempty"`
+	Language  string `json:"language,omitempty"`
+	UserId    string `json:"user_id,omitempty"`
+}
+
+type RejudgeResult struct {
+	SolutionId        string `json:"solution_id"`
+	ProblemId         string `json:"problem_id"`
+	UserId            string `json:"user_id"`
+	Nickname          string `json:"nickname,omitempty"`
+	OldSuccess        bool   `json:"old_success"`
+	OldProblemVersion int    `json:"old_problem_version,omitempty"`
+	NewSuccess        bool   `json:"new_success"`
+	NewProblemVersion int    `json:"new_problem_version,omitempty"`
+	Output            string `json:"output,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// A RejudgeJob is held in memory for the life of the user_data process. The
+// per-solution verdicts are also written to the solution table, so nothing
+// is lost except the report itself if the process restarts.
+type RejudgeJob struct {
+	JobId       string           `json:"job_id"`
+	Selection   RejudgeSelection `json:"selection"`
+	Concurrency int              `json:"concurrency"`
+	RequestedBy string           `json:"requested_by"`
+	State       string           `json:"state"`
+	Total       int              `json:"total"`
+	Completed   int              `json:"completed"`
+	Errors      int              `json:"errors"`
+	Flips       []*RejudgeResult `json:"flips"`
+	Error       string           `json:"error,omitempty"`
+	StartDate   time.Time        `json:"start_date"`
+	EndDate     *time.Time       `json:"end_date,omitempty"`
+
+	mutex sync.Mutex
+}
+
+// Returns a copy of the job that is safe to marshal while it is running.
+func (j *RejudgeJob) Snapshot() RejudgeJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return RejudgeJob{
+		JobId:       j.JobId,
+		Selection:   j.Selection,
+		Concurrency: j.Concurrency,
+		RequestedBy: j.RequestedBy,
+		State:       j.State,
+		Total:       j.Total,
+		Completed:   j.Completed,
+		Errors:      j.Errors,
+		Flips:       append([]*RejudgeResult{}, j.Flips...),
+		Error:       j.Error,
+		StartDate:   j.StartDate,
+		EndDate:     j.EndDate,
+	}
+}
+
+func (j *RejudgeJob) record(result *RejudgeResult) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.Completed += 1
+	if result.Error != "" {
+		j.Errors += 1
+		return
+	}
+	if result.OldSuccess != result.NewSuccess {
+		j.Flips = append(j.Flips, result)
+	}
+}
+
+// Creates a rejudge job, registers it and starts it in the background.
+func StartRejudgeJob(logger *log.Logger, selection RejudgeSelection, concurrency int, requested_by string) (*RejudgeJob, error) {
+	logger.Printf("rejudge.StartRejudgeJob() entry. selection: %+v, concurrency: %d", selection, concurrency)
+	defer logger.Printf("rejudge.StartRejudgeJob() exit.")
+
+	if selection.ProblemId == "" && selection.Language == "" && selection.UserId == "" {
+		return nil, errors.New("rejudge requires at least one of problem_id, language or user_id")
+	}
+	if concurrency <= 0 {
+		concurrency = defaultRejudgeConcurrency
+	}
+	if concurrency > maxRejudgeConcurrency {
+		concurrency = maxRejudgeConcurrency
+	}
+	new_uuid, err := uuid.NewV4()
+	if err != nil {
+		logger.Printf("failed to create new UUID.")
+		return nil, err
+	}
+	job := &RejudgeJob{
+		JobId:       new_uuid.String(),
+		Selection:   selection,
+		Concurrency: concurrency,
+		RequestedBy: requested_by,
+		State:       RejudgeStateRunning,
+		Flips:       make([]*RejudgeResult, 0),
+		StartDate:   time.Now().UTC(),
+	}
+	rejudgeJobsMutex.Lock()
+	pruneRejudgeJobs(job.StartDate)
+	rejudgeJobs[job.JobId] = job
+	rejudgeJobsMutex.Unlock()
+
+	go runRejudgeJob(GetLogger(GetLogPill()), job)
+	return job, nil
+}
+
+func GetRejudgeJob(job_id string) (*RejudgeJob, error) {
+	rejudgeJobsMutex.RLock()
+	defer rejudgeJobsMutex.RUnlock()
+	job, present := rejudgeJobs[job_id]
+	if present == false {
+		return nil, RejudgeJobNotFound
+	}
+	return job, nil
+}
+
+// Forgets jobs that finished more than rejudgeJobRetention before now. The
+// caller must hold rejudgeJobsMutex.
+func pruneRejudgeJobs(now time.Time) {
+	for job_id, job := range rejudgeJobs {
+		job.mutex.Lock()
+		expired := job.EndDate != nil && now.Sub(*job.EndDate) > rejudgeJobRetention
+		job.mutex.Unlock()
+		if expired {
+			delete(rejudgeJobs, job_id)
+		}
+	}
+}
+
+func runRejudgeJob(logger *log.Logger, job *RejudgeJob) {
+	logger.Printf("rejudge.runRejudgeJob() entry. job_id: %s", job.JobId)
+	defer logger.Printf("rejudge.runRejudgeJob() exit.")
+
+	defer func() {
+		end_date := time.Now().UTC()
+		job.mutex.Lock()
+		if job.State == RejudgeStateRunning {
+			job.State = RejudgeStateFinished
+		}
+		job.EndDate = &end_date
+		job.mutex.Unlock()
+	}()
+
+	solutions, err := selectSolutionsForRejudge(logger, job.Selection)
+	if err != nil {
+		logger.Printf("failed to select solutions for rejudge job %s: %s", job.JobId, err)
+		job.mutex.Lock()
+		job.State = RejudgeStateFailed
+		job.Error = fmt.Sprintf("failed to select solutions: %s", err)
+		job.mutex.Unlock()
+		return
+	}
+	job.mutex.Lock()
+	job.Total = len(solutions)
+	job.mutex.Unlock()
+	logger.Printf("rejudge job %s covers %d solutions", job.JobId, len(solutions))
+
+	// Same semaphore-as-channel approach as the runner; at most Concurrency
+	// solutions are with the evaluator at any time.
+	semaphore := make(chan int, job.Concurrency)
+	var wg sync.WaitGroup
+	for _, solution := range solutions {
+		semaphore <- 1
+		wg.Add(1)
+		go func(solution *Solution) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			job.record(rejudgeSolution(GetLogger(GetLogPill()), job.JobId, solution))
+		}(solution)
+	}
+	wg.Wait()
+}
+
+// Solutions are keyed by <problem id>#<language>. If both are known we can
+// query directly, otherwise scan and filter.
+func selectSolutionsForRejudge(logger *log.Logger, selection RejudgeSelection) ([]*Solution, error) {
+	problem_key := ""
+	if selection.ProblemId != "" && selection.Language != "" {
+		problem_key = fmt.Sprintf("%s#%s", selection.ProblemId, selection.Language)
+	}
+	solutions, err := GetAllSolutions(logger, problem_key)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]*Solution, 0)
+	for _, solution := range solutions {
+		parts := strings.SplitN(solution.ProblemId, "#", 2)
+		if len(parts) != 2 {
+			logger.Printf("skipping solution %s with malformed problem_id %s", solution.SolutionId, solution.ProblemId)
+			continue
+		}
+		if selection.ProblemId != "" && parts[0] != selection.ProblemId {
+			continue
+		}
+		if selection.Language != "" && parts[1] != selection.Language {
+			continue
+		}
+		if selection.UserId != "" && solution.UserId != selection.UserId {
+			continue
+		}
+		selected = append(selected, solution)
+	}
+	return selected, nil
+}
+
+func rejudgeSolution(logger *log.Logger, job_id string, solution *Solution) *RejudgeResult {
+	logger.Printf("rejudge.rejudgeSolution() entry. job_id: %s, solution_id: %s", job_id, solution.SolutionId)
+	defer logger.Printf("rejudge.rejudgeSolution() exit.")
+
+	result := &RejudgeResult{
+		SolutionId:        solution.SolutionId,
+		ProblemId:         solution.ProblemId,
+		UserId:            solution.UserId,
+		Nickname:          solution.Nickname,
+		OldSuccess:        solution.CurrentSuccess(),
+		OldProblemVersion: solution.ProblemVersion,
+	}
+	if solution.RejudgeSuccess != nil {
+		result.OldProblemVersion = solution.RejudgeProblemVersion
+	}
+
+	parts := strings.SplitN(solution.ProblemId, "#", 2)
+	request := &solutionSubmitRequest{
+		Code:      solution.Code,
+		ProblemId: parts[0],
+		Language:  parts[1],
+	}
+	evaluator_response, err := sendSolutionToEvaluator(logger, request, "rejudge", solution.UserId)
+	if err != nil {
+		result.Error = fmt.Sprintf("evaluator failed to evaluate the solution: %s", err)
+		logger.Printf(result.Error)
+		return result
+	}
+	result.NewSuccess = evaluator_response.Success
+	result.NewProblemVersion = evaluator_response.ProblemVersion
+	result.Output = evaluator_response.Output
+	if len(result.Output) > rejudgeOutputLimit {
+		result.Output = result.Output[:rejudgeOutputLimit] + "\n<too much output, truncated>\n"
+	}
+
+	rejudge_date := time.Now().UTC()
+	solution.RejudgeJobId = job_id
+	solution.RejudgeSuccess = &result.NewSuccess
+	solution.RejudgeProblemVersion = result.NewProblemVersion
+	solution.RejudgeDate = &rejudge_date
+	if err := UpdateSolutionRejudge(logger, solution); err != nil {
+		result.Error = fmt.Sprintf("failed to record rejudge verdict: %s", err)
+		logger.Printf(result.Error)
+		return result
+	}
+	if result.NewSuccess {
+		leaderboards.Record(solution.UserId, solution.Nickname, parts[0], parts[1], solution.CreationDate)
+	} else {
+		leaderboards.Forget(solution.UserId, parts[0], parts[1])
+	}
+	return result
+}
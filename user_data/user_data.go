@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"log"
 	"math/rand"
 	"net/http"
 	"time"
@@ -10,17 +12,54 @@ import (
 )
 
 var (
-	logger = GetLogger("logger")
+	logger            = GetLogger("logger")
+	evaluatorToken    = flag.String("evaluator-token", "", "The evaluator's -internal-token, so that submissions and rejudges get their priority")
+	sessionSecretFile = flag.String("session-secret-file", "/etc/session-secret", "File with the secret that signs session cookies; the evaluator must use the same one")
 )
 
+const (
+	loadRetryBackoff    = 5 * time.Second
+	maxLoadRetryBackoff = 5 * time.Minute
+)
+
+// Calls load until it succeeds, waiting twice as long after each failure.
+func loadWithRetry(logger *log.Logger, what string, load func(logger *log.Logger) error) {
+	backoff := loadRetryBackoff
+	for {
+		err := load(logger)
+		if err == nil {
+			return
+		}
+		logger.Printf("failed to load %s, retrying in %s: %s", what, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxLoadRetryBackoff {
+			backoff = maxLoadRetryBackoff
+		}
+	}
+}
+
 func main() {
 	logger.Println("main() entry.")
+	flag.Parse()
+	if err := loadSessionStore(*sessionSecretFile); err != nil {
+		logger.Fatalf("failed to load session secret: %s", err)
+	}
 
 	Initialize()
 	//DeleteTables(logger)
 	//CreateTables(logger)
 
 	rand.Seed(time.Now().UTC().UnixNano())
+	// Built in the background; the leaderboard API says so until it's done.
+	go loadWithRetry(logger, "leaderboards", leaderboards.Load)
+	go runProblemStats(logger)
+	if err := contests.Load(logger); err != nil {
+		logger.Fatalf("failed to load contests: %s", err)
+	}
+	if err := classrooms.Load(logger); err != nil {
+		logger.Fatalf("failed to load classrooms: %s", err)
+	}
 	r := mux.NewRouter()
 
 	r.HandleFunc("/user_data/auth/check", MakeGzipHandler(loginCheckHandler)).Methods("POST", "OPTIONS")
@@ -29,6 +68,29 @@ func main() {
 	r.HandleFunc("/user_data/solution/submit", MakeGzipHandler(solutionSubmitHandler)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/user_data/solution/get/{problem_id:[a-z0-9_-]+}/{language:[a-z0-9_]+}", MakeGzipHandler(getSolutions)).Methods("GET", "OPTIONS")
 	r.HandleFunc("/user_data/solution/vote", MakeGzipHandler(solutionVoteHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/submission/list", MakeGzipHandler(listSubmissionsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/submission/list/{problem_id:[a-z0-9_-]+}/{language:[a-z0-9_]+}", MakeGzipHandler(listSubmissionsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/submission/get/{submission_id:[a-z0-9-]+}", MakeGzipHandler(getSubmissionHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/leaderboard", MakeGzipHandler(getLeaderboardHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/problem_stats", MakeGzipHandler(listProblemStatsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/problem_stats/{problem_id:[a-z0-9_]+}", MakeGzipHandler(getProblemStatsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/contest/list", MakeGzipHandler(listContestsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/contest/{contest_id:[a-z0-9-]+}", MakeGzipHandler(getContestHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/contest/{contest_id:[a-z0-9-]+}/register", MakeGzipHandler(registerContestHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/contest/{contest_id:[a-z0-9-]+}/scoreboard", MakeGzipHandler(contestScoreboardHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/classroom", MakeGzipHandler(saveClassroomHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/list", MakeGzipHandler(listClassroomsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/join", MakeGzipHandler(joinClassroomHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}", MakeGzipHandler(getClassroomHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}/invite_codes/reset", MakeGzipHandler(resetInviteCodesHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}/member/remove", MakeGzipHandler(removeClassroomMemberHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}/assignment", MakeGzipHandler(saveAssignmentHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}/assignment/{assignment_id:[a-z0-9-]+}/progress", MakeGzipHandler(assignmentProgressHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/classroom/{classroom_id:[a-z0-9-]+}/student/{user_id:[a-z0-9-]+}/progress", MakeGzipHandler(studentProgressHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/user_data/admin/contest", MakeGzipHandler(saveContestHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/admin/contest/{contest_id:[a-z0-9-]+}/reveal", MakeGzipHandler(revealContestHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/admin/rejudge", MakeGzipHandler(rejudgeStartHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/user_data/admin/rejudge/{job_id:[a-z0-9-]+}", MakeGzipHandler(rejudgeStatusHandler)).Methods("GET", "OPTIONS")
 	http.Handle("/", r)
 
 	logger.Printf("Starting HTTP server...")